@@ -2,7 +2,7 @@ package allocator
 
 import (
 	"github.com/panzarasa/rain/internal/metainfo"
-	"github.com/panzarasa/rain/internal/storage"
+	"github.com/panzarasa/rain/storage"
 )
 
 // Allocator allocates files on the disk.
@@ -62,6 +62,11 @@ func (a *Allocator) Run(info *metainfo.Info, sto storage.Storage, progressC chan
 	var allocatedSize int64
 	a.Files = make([]File, len(info.Files))
 	for i, f := range info.Files {
+		if f.Padding {
+			a.Files[i] = File{Storage: paddingFile{}, Name: f.Path}
+			allocatedSize += f.Length
+			continue
+		}
 		var sf storage.File
 		var exists bool
 		sf, exists, a.Error = sto.Open(f.Path, f.Length)
@@ -86,3 +91,21 @@ func (a *Allocator) sendProgress(progressC chan Progress, size int64) {
 		return
 	}
 }
+
+// paddingFile is used in place of padding files in torrent. Reads return zeros and writes are discarded.
+type paddingFile struct{}
+
+func (paddingFile) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (paddingFile) WriteAt(p []byte, off int64) (int, error) {
+	return len(p), nil
+}
+
+func (paddingFile) Close() error {
+	return nil
+}
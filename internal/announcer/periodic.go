@@ -49,6 +49,7 @@ type PeriodicalAnnouncer struct {
 	newPeers      chan []*net.TCPAddr
 	backoff       backoff.BackOff
 	getTorrent    func() tracker.Torrent
+	onError       func(trk tracker.Tracker, err *AnnounceError)
 	lastAnnounce  time.Time
 	nextAnnounce  time.Time
 	HasAnnounced  bool
@@ -63,7 +64,8 @@ type PeriodicalAnnouncer struct {
 }
 
 // NewPeriodicalAnnouncer returns a new PeriodicalAnnouncer.
-func NewPeriodicalAnnouncer(trk tracker.Tracker, numWant int, minInterval time.Duration, getTorrent func() tracker.Torrent, completedC chan struct{}, newPeers chan []*net.TCPAddr, l logger.Logger) *PeriodicalAnnouncer {
+// onError is called from the announcer goroutine when an announce fails. It may be nil.
+func NewPeriodicalAnnouncer(trk tracker.Tracker, numWant int, minInterval time.Duration, getTorrent func() tracker.Torrent, completedC chan struct{}, newPeers chan []*net.TCPAddr, onError func(trk tracker.Tracker, err *AnnounceError), l logger.Logger) *PeriodicalAnnouncer {
 	return &PeriodicalAnnouncer{
 		Tracker:        trk,
 		status:         NotContactedYet,
@@ -74,6 +76,7 @@ func NewPeriodicalAnnouncer(trk tracker.Tracker, numWant int, minInterval time.D
 		completedC:     completedC,
 		newPeers:       newPeers,
 		getTorrent:     getTorrent,
+		onError:        onError,
 		needMorePeersC: make(chan struct{}, 1),
 		responseC:      make(chan *tracker.AnnounceResponse),
 		errC:           make(chan error),
@@ -193,6 +196,9 @@ func (a *PeriodicalAnnouncer) Run() {
 			} else {
 				a.log.Debugln("announce error:", a.lastError.Err.Error())
 			}
+			if a.onError != nil {
+				a.onError(a.Tracker, a.lastError)
+			}
 			interval := a.getNextIntervalFromError(a.lastError)
 			resetTimer(interval)
 		case <-a.needMorePeersC:
@@ -277,9 +283,9 @@ type AnnounceError struct {
 func (a *PeriodicalAnnouncer) newAnnounceError(err error) (e *AnnounceError) {
 	e = &AnnounceError{Err: err}
 	switch err {
-	case resolver.ErrNotIPv4Address:
+	case resolver.ErrNoAddress:
 		parsed, _ := url.Parse(a.Tracker.URL())
-		e.Message = "tracker has no IPv4 address: " + parsed.Hostname()
+		e.Message = "tracker has no IP address: " + parsed.Hostname()
 		return
 	case resolver.ErrBlocked:
 		e.Message = "tracker IP is blocked"
@@ -339,9 +345,9 @@ func (a *PeriodicalAnnouncer) newAnnounceError(err error) (e *AnnounceError) {
 			e.Message = "no route to host: " + parsed.Hostname()
 			return
 		}
-		if strings.HasSuffix(s, resolver.ErrNotIPv4Address.Error()) {
+		if strings.HasSuffix(s, resolver.ErrNoAddress.Error()) {
 			parsed, _ := url.Parse(a.Tracker.URL())
-			e.Message = "tracker has no IPv4 address: " + parsed.Hostname()
+			e.Message = "tracker has no IP address: " + parsed.Hostname()
 			return
 		}
 		if strings.HasSuffix(s, "connection reset by peer") {
@@ -12,8 +12,6 @@ import (
 	"github.com/panzarasa/rain/internal/blocklist/stree"
 )
 
-var errNotIPv4Address = errors.New("address is not ipv4")
-
 // Blocklist holds a list of IP ranges in a Segment Tree structure for faster lookups.
 type Blocklist struct {
 	Logger Logger
@@ -42,13 +40,12 @@ func (b *Blocklist) Blocked(ip net.IP) bool {
 	b.m.RLock()
 	defer b.m.RUnlock()
 
-	ip = ip.To4()
+	ip = ip.To16()
 	if ip == nil {
 		return false
 	}
 
-	val := binary.BigEndian.Uint32(ip)
-	return b.tree.Contains(stree.ValueType(val))
+	return b.tree.Contains(value(ip))
 }
 
 // Reload the segment tree by reading new rules from a io.Reader.
@@ -87,7 +84,7 @@ func load(r io.Reader, logger Logger) (*stree.Stree, int, error) {
 			}
 			continue
 		}
-		tree.AddRange(stree.ValueType(r.first), stree.ValueType(r.last))
+		tree.AddRange(r.first, r.last)
 		n++
 	}
 	if err := scanner.Err(); err != nil {
@@ -102,8 +99,10 @@ func load(r io.Reader, logger Logger) (*stree.Stree, int, error) {
 	return &tree, n, nil
 }
 
+// ipRange holds the first and last addresses of a CIDR range.
+// IPv4 addresses are stored in IPv4-mapped IPv6 form, so both kinds of ranges can be kept in the same tree.
 type ipRange struct {
-	first, last uint32
+	first, last stree.ValueType
 }
 
 func parseCIDR(b []byte) (r ipRange, err error) {
@@ -111,15 +110,28 @@ func parseCIDR(b []byte) (r ipRange, err error) {
 	if err != nil {
 		return
 	}
-	if len(ipnet.IP) != 4 {
-		err = errNotIPv4Address
-		return
+	ip := ipnet.IP.To16()
+	mask := ipnet.Mask
+	if len(ipnet.IP) == net.IPv4len {
+		mask = append(net.CIDRMask(96, 128)[:12], mask...)
 	}
-	if len(ipnet.Mask) != 4 {
-		err = errNotIPv4Address
+	if ip == nil || len(mask) != net.IPv6len {
+		err = errors.New("invalid address")
 		return
 	}
-	r.first = binary.BigEndian.Uint32(ipnet.IP)
-	r.last = r.first | ^binary.BigEndian.Uint32(ipnet.Mask)
+	last := make(net.IP, net.IPv6len)
+	for i := range ip {
+		last[i] = ip[i] | ^mask[i]
+	}
+	r.first = value(ip)
+	r.last = value(last)
 	return
 }
+
+// value converts a 16-byte IP address to a value in the segment tree.
+func value(ip net.IP) stree.ValueType {
+	return stree.ValueType{
+		Hi: binary.BigEndian.Uint64(ip[:8]),
+		Lo: binary.BigEndian.Uint64(ip[8:]),
+	}
+}
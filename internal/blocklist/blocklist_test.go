@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/panzarasa/rain/internal/blocklist/stree"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,8 +17,33 @@ func TestParseCIDR(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, uint32(256), r.first)
-	assert.Equal(t, uint32(511), r.last)
+	assert.Equal(t, stree.ValueType{Lo: 0xffff00000100}, r.first)
+	assert.Equal(t, stree.ValueType{Lo: 0xffff000001ff}, r.last)
+}
+
+func TestParseCIDR6(t *testing.T) {
+	l := "2001:db8::/32"
+	r, err := parseCIDR([]byte(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, stree.ValueType{Hi: 0x20010db800000000}, r.first)
+	assert.Equal(t, stree.ValueType{Hi: 0x20010db8ffffffff, Lo: 0xffffffffffffffff}, r.last)
+}
+
+func TestContains6(t *testing.T) {
+	r := bytes.NewBufferString("1.2.3.0/24\n2001:db8::/32\n")
+	b := New()
+	n, err := b.Reload(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, n)
+	assert.True(t, b.Blocked(net.ParseIP("1.2.3.4")))
+	assert.True(t, b.Blocked(net.ParseIP("::ffff:1.2.3.4")))
+	assert.True(t, b.Blocked(net.ParseIP("2001:db8:1::1")))
+	assert.False(t, b.Blocked(net.ParseIP("2001:db9::1")))
+	assert.False(t, b.Blocked(net.ParseIP("1.2.4.1")))
 }
 
 func TestContains(t *testing.T) {
@@ -27,7 +27,7 @@ func (n *node) insertInterval(intrvl interval) {
 }
 
 // querySingle traverse tree in search of overlaps
-func (n node) querySingle(from, to ValueType, result map[ValueType]interval) {
+func (n node) querySingle(from, to ValueType, result map[int]interval) {
 	if n.segment.Disjoint(from, to) {
 		return
 	}
@@ -43,7 +43,7 @@ func (n node) querySingle(from, to ValueType, result map[ValueType]interval) {
 }
 
 type interval struct {
-	ID ValueType // unique
+	ID int // unique
 	segment
 }
 
@@ -53,15 +53,14 @@ type segment struct {
 }
 
 func (s segment) subsetOf(other segment) bool {
-	return other.From <= s.From && other.To >= s.To
+	return !s.From.Less(other.From) && !other.To.Less(s.To)
 }
 
 func (s segment) intersectsWith(other segment) bool {
-	return other.From <= s.To && s.From <= other.To ||
-		s.From <= other.To && other.From <= s.To
+	return !s.To.Less(other.From) && !other.To.Less(s.From)
 }
 
 // Disjoint returns true if Segment does not overlap with interval
 func (s segment) Disjoint(from, to ValueType) bool {
-	return from > s.To || to < s.From
+	return s.To.Less(from) || to.Less(s.From)
 }
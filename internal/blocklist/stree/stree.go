@@ -8,12 +8,25 @@ package stree
 import "sort"
 
 // ValueType is the type of a single value in the segment tree.
-type ValueType uint32
+// It is wide enough to hold an IPv6 address.
+type ValueType struct {
+	Hi, Lo uint64
+}
+
+// Value returns a ValueType that holds n.
+func Value(n uint64) ValueType {
+	return ValueType{Lo: n}
+}
+
+// Less returns true if v is smaller than o.
+func (v ValueType) Less(o ValueType) bool {
+	return v.Hi < o.Hi || v.Hi == o.Hi && v.Lo < o.Lo
+}
 
 // Stree represents a Segment Tree.
 type Stree struct {
 	// Number of intervals
-	count ValueType
+	count int
 	root  *node
 	// Interval stack
 	base []interval
@@ -32,8 +45,8 @@ func (t *Stree) Clear() {
 	t.count = 0
 	t.root = nil
 	t.base = nil
-	t.min = 0
-	t.max = 0
+	t.min = ValueType{}
+	t.max = ValueType{}
 }
 
 // Build segment tree out of interval stack
@@ -81,15 +94,13 @@ func endpoints(base []interval) (result []ValueType, min, max ValueType) {
 
 // dedup removes duplicates from a given slice
 func dedup(sl []ValueType) []ValueType {
-	sort.Slice(sl, func(i, j int) bool { return sl[i] < sl[j] })
-	prev := sl[0] + 1
+	sort.Slice(sl, func(i, j int) bool { return sl[i].Less(sl[j]) })
 	j := 0
 	for i := range sl {
-		if sl[i] == prev {
+		if j > 0 && sl[i] == sl[j-1] {
 			continue
 		}
 		sl[j] = sl[i]
-		prev = sl[j]
 		j++
 	}
 	return sl[:j]
@@ -118,7 +129,7 @@ func (t Stree) Contains(value ValueType) bool {
 
 // query interval
 func (t Stree) query(from, to ValueType) []interval {
-	result := make(map[ValueType]interval)
+	result := make(map[int]interval)
 	if t.root == nil {
 		return nil
 	}
@@ -9,11 +9,11 @@ import "testing"
 func TestEmptyTree(t *testing.T) {
 	var tree Stree
 	tree.Build()
-	result := tree.query(0, 0)
+	result := tree.query(Value(0), Value(0))
 	if len(result) != 0 {
 		t.Errorf("fail query empty tree")
 	}
-	result = tree.query(2, 3)
+	result = tree.query(Value(2), Value(3))
 	if len(result) != 0 {
 		t.Errorf("fail query empty tree")
 	}
@@ -21,13 +21,13 @@ func TestEmptyTree(t *testing.T) {
 
 func TestMinimalTree(t *testing.T) {
 	var tree Stree
-	tree.AddRange(3, 7)
+	tree.AddRange(Value(3), Value(7))
 	tree.Build()
-	result := tree.query(1, 2)
+	result := tree.query(Value(1), Value(2))
 	if len(result) != 0 {
 		t.Errorf("fail query minimal tree")
 	}
-	result = tree.query(2, 3)
+	result = tree.query(Value(2), Value(3))
 	if len(result) != 1 {
 		t.Errorf("fail query minimal tree")
 	}
@@ -35,31 +35,31 @@ func TestMinimalTree(t *testing.T) {
 
 func TestMinimalTree2(t *testing.T) {
 	var tree Stree
-	tree.AddRange(1, 1)
+	tree.AddRange(Value(1), Value(1))
 	tree.Build()
-	if result := tree.query(1, 1); len(result) != 1 {
+	if result := tree.query(Value(1), Value(1)); len(result) != 1 {
 		t.Errorf("fail query minimal tree for (1, 1)")
 	}
-	if result := tree.query(1, 2); len(result) != 1 {
+	if result := tree.query(Value(1), Value(2)); len(result) != 1 {
 		t.Errorf("fail query minimal tree for (1, 2)")
 	}
-	if result := tree.query(2, 3); len(result) != 0 {
+	if result := tree.query(Value(2), Value(3)); len(result) != 0 {
 		t.Errorf("fail query minimal tree for (2, 3)")
 	}
 }
 
 func TestNormalTree(t *testing.T) {
 	var tree Stree
-	tree.AddRange(1, 1)
-	tree.AddRange(2, 3)
-	tree.AddRange(5, 7)
-	tree.AddRange(4, 6)
-	tree.AddRange(6, 9)
+	tree.AddRange(Value(1), Value(1))
+	tree.AddRange(Value(2), Value(3))
+	tree.AddRange(Value(5), Value(7))
+	tree.AddRange(Value(4), Value(6))
+	tree.AddRange(Value(6), Value(9))
 	tree.Build()
-	if result := tree.query(3, 5); len(result) != 3 {
+	if result := tree.query(Value(3), Value(5)); len(result) != 3 {
 		t.Errorf("fail query multiple tree for (3, 5)")
 	}
-	qvalid := map[ValueType]int{
+	qvalid := map[uint64]int{
 		0: 0,
 		1: 1,
 		2: 1,
@@ -71,8 +71,8 @@ func TestNormalTree(t *testing.T) {
 		8: 1,
 		9: 1,
 	}
-	for i := ValueType(0); i <= 9; i++ {
-		if result := tree.query(i, i); len(result) != qvalid[i] {
+	for i := uint64(0); i <= 9; i++ {
+		if result := tree.query(Value(i), Value(i)); len(result) != qvalid[i] {
 			t.Errorf("fail query multiple tree for (%d, %d)", i, i)
 		}
 	}
@@ -80,41 +80,59 @@ func TestNormalTree(t *testing.T) {
 
 func TestContains(t *testing.T) {
 	var tree Stree
-	tree.AddRange(2, 4)
+	tree.AddRange(Value(2), Value(4))
 	tree.Build()
-	if tree.Contains(1) {
+	if tree.Contains(Value(1)) {
 		t.Errorf("fail")
 	}
-	if !tree.Contains(2) {
+	if !tree.Contains(Value(2)) {
 		t.Errorf("fail")
 	}
-	if !tree.Contains(3) {
+	if !tree.Contains(Value(3)) {
 		t.Errorf("fail")
 	}
-	if !tree.Contains(4) {
+	if !tree.Contains(Value(4)) {
 		t.Errorf("fail")
 	}
-	if tree.Contains(5) {
+	if tree.Contains(Value(5)) {
 		t.Errorf("fail")
 	}
 }
 
 func TestDedup(t *testing.T) {
-	l := []ValueType{1, 2, 3, 3, 4, 4, 4}
+	l := []ValueType{Value(1), Value(2), Value(3), Value(3), Value(4), Value(4), Value(4)}
 	l2 := dedup(l)
 	if len(l2) != 4 {
 		t.Errorf("len: %d", len(l2))
 	}
-	if l2[0] != 1 {
-		t.Errorf("item: %d", l2[0])
+	if l2[0] != Value(1) {
+		t.Errorf("item: %v", l2[0])
 	}
-	if l2[1] != 2 {
-		t.Errorf("item: %d", l2[2])
+	if l2[1] != Value(2) {
+		t.Errorf("item: %v", l2[2])
 	}
-	if l2[2] != 3 {
-		t.Errorf("item: %d", l2[2])
+	if l2[2] != Value(3) {
+		t.Errorf("item: %v", l2[2])
 	}
-	if l2[3] != 4 {
-		t.Errorf("item: %d", l2[3])
+	if l2[3] != Value(4) {
+		t.Errorf("item: %v", l2[3])
+	}
+}
+
+func TestWideValues(t *testing.T) {
+	var tree Stree
+	tree.AddRange(ValueType{Hi: 1, Lo: 10}, ValueType{Hi: 2, Lo: 5})
+	tree.Build()
+	if tree.Contains(ValueType{Hi: 1, Lo: 9}) {
+		t.Errorf("fail")
+	}
+	if !tree.Contains(ValueType{Hi: 1, Lo: 1 << 63}) {
+		t.Errorf("fail")
+	}
+	if !tree.Contains(ValueType{Hi: 2}) {
+		t.Errorf("fail")
+	}
+	if tree.Contains(ValueType{Hi: 2, Lo: 6}) {
+		t.Errorf("fail")
 	}
 }
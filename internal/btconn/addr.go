@@ -0,0 +1,32 @@
+package btconn
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// PeerAddr returns the address of the peer as *net.TCPAddr.
+// Addresses of uTP connections are converted because peers are identified by IP and port regardless of the transport.
+// Other address types are accepted if they are in "ip:port" form.
+func PeerAddr(addr net.Addr) (*net.TCPAddr, error) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a, nil
+	case *net.UDPAddr:
+		return &net.TCPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}, nil
+	}
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("peer address is not an IP address: %s", addr.String())
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
@@ -0,0 +1,32 @@
+package btconn
+
+import (
+	"net"
+	"testing"
+)
+
+type stringAddr string
+
+func (a stringAddr) Network() string { return "tcp" }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestPeerAddr(t *testing.T) {
+	addr, err := PeerAddr(&net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "1.2.3.4:5" {
+		t.Fatalf("unexpected addr: %s", addr)
+	}
+	addr, err = PeerAddr(stringAddr("[::1]:6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "[::1]:6" {
+		t.Fatalf("unexpected addr: %s", addr)
+	}
+	_, err = PeerAddr(stringAddr("example.com:7"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
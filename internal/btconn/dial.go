@@ -10,11 +10,21 @@ import (
 	"github.com/panzarasa/rain/internal/mse"
 )
 
-// Dial new connection to the address. Does the BitTorrent protocol handshake.
+// DialFunc opens a new connection to the address.
+type DialFunc func(ctx context.Context, addr net.Addr) (net.Conn, error)
+
+// DialTCP is a DialFunc that opens a TCP connection.
+func DialTCP(ctx context.Context, addr net.Addr) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr.String())
+}
+
+// Dial new connection to the address with the dial function. Does the BitTorrent protocol handshake.
 // Handles encryption. May try to connect again if encryption does not match with given setting.
 // Returns a net.Conn that is ready for sending/receiving BitTorrent peer protocol messages.
 func Dial(
 	addr net.Addr,
+	dial DialFunc,
 	dialTimeout, handshakeTimeout time.Duration,
 	enableEncryption,
 	forceEncryption bool,
@@ -36,10 +46,15 @@ func Dial(
 		}
 	}()
 
+	dialCtx := func() (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+		return dial(ctx, addr)
+	}
+
 	// First connection
 	log.Debug("Connecting to peer...")
-	dialer := net.Dialer{Timeout: dialTimeout}
-	conn, err = dialer.DialContext(ctx, addr.Network(), addr.String())
+	conn, err = dialCtx()
 	if err != nil {
 		return
 	}
@@ -97,7 +112,7 @@ func Dial(
 			// Close current connection and try again without encryption
 			conn.Close()
 			log.Debug("Connecting again without encryption...")
-			conn, err = dialer.DialContext(ctx, addr.Network(), addr.String())
+			conn, err = dialCtx()
 			if err != nil {
 				return
 			}
@@ -1,10 +1,12 @@
 package console
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,6 +22,7 @@ const (
 	torrents int = iota
 	sessionStats
 	addTorrent
+	speedLimit
 	help
 )
 
@@ -134,6 +137,7 @@ func (c *Console) keybindings(g *gocui.Gui) {
 	_ = g.SetKeybinding("help", 'q', gocui.ModNone, c.quit)
 	_ = g.SetKeybinding("session-stats", 'q', gocui.ModNone, c.quit)
 	_ = g.SetKeybinding("add-torrent", gocui.KeyCtrlQ, gocui.ModNone, c.quit)
+	_ = g.SetKeybinding("speed-limit", gocui.KeyCtrlQ, gocui.ModNone, c.quit)
 
 	// Navigation
 	_ = g.SetKeybinding("torrents", 'j', gocui.ModNone, c.cursorDown)
@@ -160,6 +164,8 @@ func (c *Console) keybindings(g *gocui.Gui) {
 	_ = g.SetKeybinding("torrents", gocui.KeyCtrlV, gocui.ModNone, c.verify)
 	_ = g.SetKeybinding("torrents", gocui.KeyCtrlA, gocui.ModNone, c.switchAddTorrent)
 	_ = g.SetKeybinding("add-torrent", gocui.KeyEnter, gocui.ModNone, c.addTorrentHandleEnter)
+	_ = g.SetKeybinding("torrents", gocui.KeyCtrlL, gocui.ModNone, c.switchSpeedLimit)
+	_ = g.SetKeybinding("speed-limit", gocui.KeyEnter, gocui.ModNone, c.speedLimitHandleEnter)
 }
 
 func (c *Console) startUpdatingTorrents(g *gocui.Gui) {
@@ -217,6 +223,11 @@ func (c *Console) layout(g *gocui.Gui) error {
 	}
 	if c.selectedPage != addTorrent {
 		_ = g.DeleteView("add-torrent")
+	}
+	if c.selectedPage != speedLimit {
+		_ = g.DeleteView("speed-limit")
+	}
+	if c.selectedPage != addTorrent && c.selectedPage != speedLimit {
 		g.Cursor = false
 	}
 	switch c.selectedPage {
@@ -249,6 +260,13 @@ func (c *Console) layout(g *gocui.Gui) error {
 		}
 		g.Cursor = true
 		_, err = g.SetCurrentView("add-torrent")
+	case speedLimit:
+		err = c.drawSpeedLimit(g)
+		if err != nil {
+			return err
+		}
+		g.Cursor = true
+		_, err = g.SetCurrentView("speed-limit")
 	}
 	return err
 }
@@ -302,6 +320,7 @@ func (c *Console) drawHelp(g *gocui.Gui) error {
 	fmt.Fprintln(v, "ctrl+alt+a  Announce torrent")
 	fmt.Fprintln(v, "    ctrl+v  Verify torrent")
 	fmt.Fprintln(v, "    ctrl+a  Add new torrent")
+	fmt.Fprintln(v, "    ctrl+l  Set speed limit of torrent")
 
 	return nil
 }
@@ -321,6 +340,21 @@ func (c *Console) drawAddTorrent(g *gocui.Gui) error {
 	return nil
 }
 
+func (c *Console) drawSpeedLimit(g *gocui.Gui) error {
+	maxX, maxY := g.Size()
+	v, err := g.SetView("speed-limit", 5, 2, maxX-6, maxY-3)
+	if err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Frame = true
+		v.Title = "Speed Limit in KiB/s as \"download upload\", 0 for unlimited (Press ctrl-q to close window)"
+		v.Editable = true
+		v.Wrap = true
+	}
+	return nil
+}
+
 func (c *Console) drawSessionStats(g *gocui.Gui) error {
 	maxX, maxY := g.Size()
 	v, err := g.SetView("session-stats", 5, 2, maxX-6, maxY-3)
@@ -568,9 +602,9 @@ func (c *Console) drawDetails(g *gocui.Gui) error {
 					fmt.Fprintf(v, "    Status: %s, Error: %s\n", t.Status, errStr)
 				default:
 					if t.Warning != "" {
-						fmt.Fprintf(v, "    Status: %s, Seeders: %d, Leechers: %d Warning: %s\n", t.Status, t.Seeders, t.Leechers, t.Warning)
+						fmt.Fprintf(v, "    Status: %s, Seeders: %d, Leechers: %d, Completed: %d Warning: %s\n", t.Status, t.Seeders, t.Leechers, t.Completed, t.Warning)
 					} else {
-						fmt.Fprintf(v, "    Status: %s, Seeders: %d, Leechers: %d\n", t.Status, t.Seeders, t.Leechers)
+						fmt.Fprintf(v, "    Status: %s, Seeders: %d, Leechers: %d, Completed: %d\n", t.Status, t.Seeders, t.Leechers, t.Completed)
 					}
 				}
 				var nextAnnounce string
@@ -580,6 +614,9 @@ func (c *Console) drawDetails(g *gocui.Gui) error {
 					nextAnnounce = t.NextAnnounce.Time.Format(time.RFC3339)
 				}
 				fmt.Fprintf(v, "    Last announce: %s, Next announce: %s\n", t.LastAnnounce.Time.Format(time.RFC3339), nextAnnounce)
+				if !t.LastScrape.IsZero() {
+					fmt.Fprintf(v, "    Last scrape: %s\n", t.LastScrape.Time.Format(time.RFC3339))
+				}
 			}
 		case peers:
 			format := "%2s %21s %7s %8s %6s %s\n"
@@ -831,6 +868,40 @@ func (c *Console) addTorrentHandleEnter(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
+func (c *Console) speedLimitHandleEnter(g *gocui.Gui, v *gocui.View) error {
+	handleError := func(err error) error {
+		v.Clear()
+		_ = v.SetCursor(0, 0)
+		fmt.Fprintln(v, "error:", err)
+		return nil
+	}
+	fields := strings.Fields(strings.Join(v.BufferLines(), " "))
+	if len(fields) != 2 {
+		return handleError(errors.New("enter download and upload limits separated by space"))
+	}
+	download, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return handleError(err)
+	}
+	upload, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return handleError(err)
+	}
+
+	c.m.Lock()
+	id := c.selectedID
+	c.m.Unlock()
+
+	err = c.client.SetTorrentSpeedLimit(id, download, upload)
+	if err != nil {
+		return handleError(err)
+	}
+	v.Clear()
+	c.selectedPage = torrents
+	c.triggerUpdateDetails(true)
+	return nil
+}
+
 func (c *Console) switchRow(v *gocui.View, row int) error {
 	switch {
 	case len(c.torrents) == 0:
@@ -1070,6 +1141,17 @@ func (c *Console) switchAddTorrent(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
+func (c *Console) switchSpeedLimit(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	id := c.selectedID
+	c.m.Unlock()
+	if id == "" {
+		return nil
+	}
+	c.selectedPage = speedLimit
+	return nil
+}
+
 func (c *Console) triggerUpdateDetails(clear bool) {
 	if clear {
 		c.updatingDetails = true
@@ -1089,7 +1171,7 @@ func (c *Console) triggerUpdateTorrents() {
 
 func flags(p rpctypes.Peer) string {
 	var sb strings.Builder
-	sb.Grow(6)
+	sb.Grow(7)
 	if p.ClientInterested {
 		if p.PeerChoking {
 			sb.WriteString("d")
@@ -1135,6 +1217,8 @@ func flags(p rpctypes.Peer) string {
 		sb.WriteString("I")
 	case "MANUAL":
 		sb.WriteString("M")
+	case "LSD":
+		sb.WriteString("L")
 	default:
 		sb.WriteString(" ")
 	}
@@ -1146,6 +1230,11 @@ func flags(p rpctypes.Peer) string {
 	default:
 		sb.WriteString(" ")
 	}
+	if p.Transport == "UTP" {
+		sb.WriteString("P")
+	} else {
+		sb.WriteString(" ")
+	}
 	return sb.String()
 }
 
@@ -1193,6 +1282,16 @@ func getUploadSpeed(stats *rpctypes.Stats) string {
 	return fmt.Sprintf("%d KiB/s", stats.Speed.Upload/1024)
 }
 
+func getSpeedLimit(stats *rpctypes.Stats) string {
+	format := func(limit int64) string {
+		if limit == 0 {
+			return "unlimited"
+		}
+		return fmt.Sprintf("%d KiB/s", limit)
+	}
+	return fmt.Sprintf("%s down, %s up", format(stats.SpeedLimit.Download), format(stats.SpeedLimit.Upload))
+}
+
 func getETA(stats *rpctypes.Stats) string {
 	var eta string
 	if stats.ETA != -1 {
@@ -1205,17 +1304,20 @@ func getETA(stats *rpctypes.Stats) string {
 func FormatStats(stats *rpctypes.Stats, v io.Writer) {
 	fmt.Fprintf(v, "Name: %s\n", stats.Name)
 	fmt.Fprintf(v, "Private: %v\n", stats.Private)
+	fmt.Fprintf(v, "Data dir: %s\n", stats.DataDir)
 	status := stats.Status
 	if status == "Stopped" && stats.Error != "" {
 		status = status + ": " + stats.Error
 	}
 	fmt.Fprintf(v, "Status: %s\n", status)
+	fmt.Fprintf(v, "Queue position: %d\n", stats.QueuePosition)
 	fmt.Fprintf(v, "Progress: %d\n", getProgress(stats))
 	fmt.Fprintf(v, "Ratio: %.2f\n", getRatio(stats))
 	fmt.Fprintf(v, "Size: %s\n", getSize(stats))
 	fmt.Fprintf(v, "Peers: %d in %d out\n", stats.Peers.Incoming, stats.Peers.Outgoing)
 	fmt.Fprintf(v, "Download speed: %11s\n", getDownloadSpeed(stats))
 	fmt.Fprintf(v, "Upload speed:   %11s\n", getUploadSpeed(stats))
+	fmt.Fprintf(v, "Speed limit: %s\n", getSpeedLimit(stats))
 	fmt.Fprintf(v, "ETA: %s\n", getETA(stats))
 }
 
@@ -1228,4 +1330,5 @@ func FormatSessionStats(s *rpctypes.SessionStats, v io.Writer) {
 	fmt.Fprintf(v, "ReadCache Objects: %d, Size: %dMB, Utilization: %d%%\n", s.ReadCacheObjects, s.ReadCacheSize/(1<<20), s.ReadCacheUtilization)
 	fmt.Fprintf(v, "WriteCache Objects: %d, Size: %dMB, PendingKeys: %d\n", s.WriteCacheObjects, s.WriteCacheSize/(1<<20), s.WriteCachePendingKeys)
 	fmt.Fprintf(v, "DownloadSpeed: %dKB/s, UploadSpeed: %dKB/s\n", s.SpeedDownload/1024, s.SpeedUpload/1024)
+	fmt.Fprintf(v, "DownloadSpeedLimit: %dKiB/s, UploadSpeedLimit: %dKiB/s\n", s.SpeedLimitDownload, s.SpeedLimitUpload)
 }
@@ -0,0 +1,121 @@
+// Package dhtitem stores and retrieves arbitrary items in the BitTorrent DHT. See BEP 44.
+package dhtitem
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha1" // nolint: gosec
+	"errors"
+	"strconv"
+
+	"github.com/zeebo/bencode"
+)
+
+const (
+	// MaxValueSize is the maximum size of the bencoded value of an item.
+	MaxValueSize = 1000
+	// MaxSaltSize is the maximum size of the salt of a mutable item.
+	MaxSaltSize = 64
+)
+
+var (
+	errValueTooLarge    = errors.New("value is too large")
+	errSaltTooLarge     = errors.New("salt is too large")
+	errInvalidValue     = errors.New("value is not bencoded")
+	errInvalidKey       = errors.New("invalid public key")
+	errInvalidSignature = errors.New("invalid signature")
+)
+
+// Item is a value stored in DHT. Items without a public key are immutable.
+type Item struct {
+	// Value is the bencoded value of the item.
+	Value []byte
+	// Fields below are set for mutable items only.
+	PublicKey ed25519.PublicKey
+	Salt      []byte
+	Seq       int64
+	Signature []byte
+}
+
+// NewMutable returns a new mutable item signed with the private key.
+func NewMutable(key ed25519.PrivateKey, salt, value []byte, seq int64) (*Item, error) {
+	i := &Item{
+		Value:     value,
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Salt:      salt,
+		Seq:       seq,
+	}
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+	i.Signature = ed25519.Sign(key, signBuffer(salt, seq, value))
+	return i, nil
+}
+
+// Mutable returns true if the item is signed by a public key.
+func (i *Item) Mutable() bool {
+	return i.PublicKey != nil
+}
+
+// Target returns the key of the item in DHT.
+func (i *Item) Target() [20]byte {
+	if i.Mutable() {
+		return MutableTarget(i.PublicKey, i.Salt)
+	}
+	return sha1.Sum(i.Value)
+}
+
+// MutableTarget returns the key of a mutable item in DHT.
+func MutableTarget(publicKey ed25519.PublicKey, salt []byte) [20]byte {
+	b := make([]byte, 0, len(publicKey)+len(salt))
+	b = append(b, publicKey...)
+	b = append(b, salt...)
+	return sha1.Sum(b)
+}
+
+// Verify checks the size limits of the item and the signature if the item is mutable.
+func (i *Item) Verify() error {
+	if err := i.validate(); err != nil {
+		return err
+	}
+	if !i.Mutable() {
+		return nil
+	}
+	if !ed25519.Verify(i.PublicKey, signBuffer(i.Salt, i.Seq, i.Value), i.Signature) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+func (i *Item) validate() error {
+	if len(i.Value) > MaxValueSize {
+		return errValueTooLarge
+	}
+	if len(i.Salt) > MaxSaltSize {
+		return errSaltTooLarge
+	}
+	var v interface{}
+	if len(i.Value) == 0 || bencode.DecodeBytes(i.Value, &v) != nil {
+		return errInvalidValue
+	}
+	if i.Mutable() && len(i.PublicKey) != ed25519.PublicKeySize {
+		return errInvalidKey
+	}
+	return nil
+}
+
+// signBuffer returns the bytes that are signed for a mutable item.
+func signBuffer(salt []byte, seq int64, value []byte) []byte {
+	var b bytes.Buffer
+	if len(salt) > 0 {
+		b.WriteString("4:salt")
+		b.WriteString(strconv.Itoa(len(salt)))
+		b.WriteByte(':')
+		b.Write(salt)
+	}
+	b.WriteString("3:seqi")
+	b.WriteString(strconv.FormatInt(seq, 10))
+	b.WriteString("e1:v")
+	b.Write(value)
+	return b.Bytes()
+}
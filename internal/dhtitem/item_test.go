@@ -0,0 +1,63 @@
+package dhtitem
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vector from BEP 44.
+func TestSignBuffer(t *testing.T) {
+	b := signBuffer([]byte("foobar"), 1, []byte("12:Hello World!"))
+	expected := "4:salt6:foobar3:seqi1e1:v12:Hello World!"
+	if string(b) != expected {
+		t.Fatalf("unexpected sign buffer: %q", b)
+	}
+	b = signBuffer(nil, 1, []byte("12:Hello World!"))
+	if string(b) != "3:seqi1e1:v12:Hello World!" {
+		t.Fatalf("unexpected sign buffer: %q", b)
+	}
+}
+
+func TestImmutableTarget(t *testing.T) {
+	i := &Item{Value: []byte("12:Hello World!")}
+	target := i.Target()
+	if hex.EncodeToString(target[:]) != "e5f96f6f38320f0f33959cb4d3d656452117aadb" {
+		t.Fatalf("unexpected target: %x", target)
+	}
+	if err := i.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test vector from BEP 44.
+func TestMutableTarget(t *testing.T) {
+	publicKey, _ := hex.DecodeString("77ff84905a91936367c01360803104f92432fcd904a43511876df5cdf3e7e548")
+	target := MutableTarget(publicKey, []byte("foobar"))
+	if hex.EncodeToString(target[:]) != "411eba73b6f087ca51a3795d9c8c938d365e32c1" {
+		t.Fatalf("unexpected target: %x", target)
+	}
+}
+
+func TestMutableItem(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := NewMutable(key, []byte("foobar"), []byte("12:Hello World!"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = i.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	i.Seq++
+	if err = i.Verify(); err != errInvalidSignature {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = NewMutable(key, nil, []byte("not bencoded"), 1)
+	if err != errInvalidValue {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
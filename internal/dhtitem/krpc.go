@@ -0,0 +1,162 @@
+package dhtitem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+// KRPC error codes
+const (
+	errorGeneric          = 201
+	errorProtocol         = 203
+	errorMethodUnknown    = 204
+	errorMessageTooBig    = 205
+	errorInvalidSignature = 206
+	errorSaltTooBig       = 207
+	errorSeqLessThanStore = 302
+)
+
+type message struct {
+	T string        `bencode:"t"`
+	Y string        `bencode:"y"`
+	Q string        `bencode:"q,omitempty"`
+	A *queryArgs    `bencode:"a,omitempty"`
+	R *response     `bencode:"r,omitempty"`
+	E []interface{} `bencode:"e,omitempty"`
+}
+
+type queryArgs struct {
+	ID     string             `bencode:"id"`
+	Target string             `bencode:"target,omitempty"`
+	Token  string             `bencode:"token,omitempty"`
+	V      bencode.RawMessage `bencode:"v,omitempty"`
+	K      string             `bencode:"k,omitempty"`
+	Salt   string             `bencode:"salt,omitempty"`
+	Seq    *int64             `bencode:"seq,omitempty"`
+	Sig    string             `bencode:"sig,omitempty"`
+	// Address families of nodes wanted in response: "n4" and "n6". See BEP 32.
+	Want []string `bencode:"want,omitempty"`
+}
+
+type response struct {
+	ID     string             `bencode:"id"`
+	Nodes  string             `bencode:"nodes,omitempty"`
+	Nodes6 string             `bencode:"nodes6,omitempty"`
+	Token  string             `bencode:"token,omitempty"`
+	V      bencode.RawMessage `bencode:"v,omitempty"`
+	K      string             `bencode:"k,omitempty"`
+	Seq    *int64             `bencode:"seq,omitempty"`
+	Sig    string             `bencode:"sig,omitempty"`
+}
+
+// item returns the item in the response of a get query.
+func (r *response) item(salt []byte) *Item {
+	if len(r.V) == 0 {
+		return nil
+	}
+	i := &Item{Value: r.V}
+	if r.K != "" {
+		i.PublicKey = []byte(r.K)
+		i.Salt = salt
+		i.Signature = []byte(r.Sig)
+		if r.Seq != nil {
+			i.Seq = *r.Seq
+		}
+	}
+	return i
+}
+
+// Error is returned from a remote node in response to a query.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Message)
+}
+
+func newError(e []interface{}) *Error {
+	err := &Error{Code: errorGeneric}
+	if len(e) > 0 {
+		if code, ok := e[0].(int64); ok {
+			err.Code = int(code)
+		}
+	}
+	if len(e) > 1 {
+		if msg, ok := e[1].(string); ok {
+			err.Message = msg
+		}
+	}
+	return err
+}
+
+type contact struct {
+	ID   [20]byte
+	Addr *net.UDPAddr
+	// Last time a message is received from the contact.
+	seen time.Time
+}
+
+// encodeNodes returns the contacts that have an address of length ipLen in compact node info format.
+// Each node is 20 bytes ID, IP address and 2 bytes port.
+// ipLen must be net.IPv4len for "nodes" key and net.IPv6len for "nodes6" key.
+func encodeNodes(contacts []contact, ipLen int) string {
+	b := make([]byte, 0, len(contacts)*(20+ipLen+2))
+	for _, c := range contacts {
+		ip := ipOf(c.Addr, ipLen)
+		if ip == nil {
+			continue
+		}
+		b = append(b, c.ID[:]...)
+		b = append(b, ip...)
+		b = append(b, byte(c.Addr.Port>>8), byte(c.Addr.Port))
+	}
+	return string(b)
+}
+
+func decodeNodes(s string, ipLen int) []contact {
+	length := 20 + ipLen + 2
+	contacts := make([]contact, 0, len(s)/length)
+	for ; len(s) >= length; s = s[length:] {
+		var c contact
+		copy(c.ID[:], s[:20])
+		c.Addr = &net.UDPAddr{
+			IP:   net.IP([]byte(s[20 : 20+ipLen])),
+			Port: int(binary.BigEndian.Uint16([]byte(s[20+ipLen : length]))),
+		}
+		if c.Addr.Port == 0 || ipOf(c.Addr, ipLen) == nil {
+			continue
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts
+}
+
+// ipOf returns the IP of the address in length ipLen. Returns nil if the address is not in that family.
+func ipOf(addr *net.UDPAddr, ipLen int) net.IP {
+	ip4 := addr.IP.To4()
+	if ipLen == net.IPv4len {
+		return ip4
+	}
+	if ip4 != nil {
+		return nil
+	}
+	return addr.IP.To16()
+}
+
+// closer returns true if a is closer to the target than b.
+func closer(target, a, b [20]byte) bool {
+	for i := range target {
+		da := a[i] ^ target[i]
+		db := b[i] ^ target[i]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
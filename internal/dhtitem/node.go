@@ -0,0 +1,611 @@
+package dhtitem
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1" // nolint: gosec
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/panzarasa/rain/internal/logger"
+	"github.com/zeebo/bencode"
+)
+
+const (
+	// Number of closest nodes that an item is stored in.
+	k = 8
+	// Maximum number of lookup iterations.
+	maxLookupRounds = 16
+	queryTimeout    = 2 * time.Second
+	maxContacts     = 1000
+	maxMessageSize  = 2048
+	// Contacts that are not seen in this duration are not returned to other nodes and may be evicted.
+	contactTimeout = 15 * time.Minute
+	// Length of random transaction IDs.
+	txIDLength = 4
+)
+
+var (
+	// ErrNotFound is returned from Get when no node has the item.
+	ErrNotFound = errors.New("item not found in dht")
+	// ErrNoNodes is returned from Put when no node can be contacted to store the item.
+	ErrNoNodes = errors.New("no dht node accepted the item")
+
+	errClosed = errors.New("dht node is closed")
+)
+
+// Node is a DHT node that can store and retrieve items.
+// It runs on its own UDP socket and answers ping, find_node, get and put queries from other nodes.
+// Socket listens on both IPv4 and IPv6 if the host is unspecified. See BEP 32.
+type Node struct {
+	conn      *net.UDPConn
+	ipv4      bool
+	ipv6      bool
+	id        [20]byte
+	bootstrap []string
+	secret    [20]byte
+	store     *store
+	log       logger.Logger
+
+	m            sync.Mutex
+	transactions map[string]*transaction
+	contacts     map[string]contact
+
+	closeC chan struct{}
+	doneC  chan struct{}
+}
+
+// New starts a new node listening on addr. Nodes in bootstrap list are contacted first in lookups.
+func New(addr string, bootstrap []string, l logger.Logger) (*Node, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if laddr.IP.IsUnspecified() {
+		// Nil IP makes a dual-stack socket.
+		laddr.IP = nil
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	lip := conn.LocalAddr().(*net.UDPAddr).IP
+	n := &Node{
+		conn:         conn,
+		ipv4:         lip.To4() != nil || lip.IsUnspecified(),
+		ipv6:         lip.To4() == nil,
+		bootstrap:    bootstrap,
+		store:        newStore(),
+		log:          l,
+		transactions: make(map[string]*transaction),
+		contacts:     make(map[string]contact),
+		closeC:       make(chan struct{}),
+		doneC:        make(chan struct{}),
+	}
+	if _, err = rand.Read(n.id[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err = rand.Read(n.secret[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go n.readLoop()
+	return n, nil
+}
+
+// Addr returns the local address of the node.
+func (n *Node) Addr() *net.UDPAddr {
+	return n.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Close the node and stop listening.
+func (n *Node) Close() {
+	close(n.closeC)
+	n.conn.Close()
+	<-n.doneC
+}
+
+// Get looks up the item with the target in DHT.
+// Salt is used only for verifying mutable items.
+// If multiple versions of a mutable item is found, the one with the highest sequence number is returned.
+func (n *Node) Get(ctx context.Context, target [20]byte, salt []byte) (*Item, error) {
+	best := n.store.get(target)
+	nodes := n.lookup(ctx, target)
+	for _, ln := range nodes {
+		i := ln.resp.item(salt)
+		if i == nil || i.Target() != target || i.Verify() != nil {
+			continue
+		}
+		if best == nil || (i.Mutable() && i.Seq > best.Seq) {
+			best = i
+		}
+	}
+	if best == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotFound
+	}
+	return best, nil
+}
+
+// Put stores the item in the closest nodes to its target.
+// Returns the number of nodes that accepted the item.
+func (n *Node) Put(ctx context.Context, item *Item) (int, error) {
+	if err := item.Verify(); err != nil {
+		return 0, err
+	}
+	target := item.Target()
+	// Keep a copy so the item can be served to other nodes.
+	err := n.store.put(target, item)
+	if err != nil {
+		return 0, err
+	}
+	nodes := n.lookup(ctx, target)
+	a := &queryArgs{
+		ID: string(n.id[:]),
+		V:  item.Value,
+	}
+	if item.Mutable() {
+		seq := item.Seq
+		a.K = string(item.PublicKey)
+		a.Salt = string(item.Salt)
+		a.Seq = &seq
+		a.Sig = string(item.Signature)
+	}
+	var stored int
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	for _, ln := range nodes {
+		if ln.resp.Token == "" {
+			continue
+		}
+		args := *a
+		args.Token = ln.resp.Token
+		wg.Add(1)
+		go func(addr *net.UDPAddr) {
+			defer wg.Done()
+			_, err := n.query(ctx, addr, "put", &args)
+			if err != nil {
+				n.log.Debugf("cannot put item to %s: %s", addr, err)
+				return
+			}
+			m.Lock()
+			stored++
+			m.Unlock()
+		}(ln.Addr)
+	}
+	wg.Wait()
+	if stored == 0 {
+		return 0, ErrNoNodes
+	}
+	return stored, nil
+}
+
+type lookupNode struct {
+	contact
+	hasID   bool
+	queried bool
+	failed  bool
+	resp    *response
+}
+
+// lookup sends get queries to the nodes iteratively approaching to the target.
+// Returns the k closest nodes that have responded.
+func (n *Node) lookup(ctx context.Context, target [20]byte) []*lookupNode {
+	var nodes []*lookupNode
+	seen := make(map[string]struct{})
+	add := func(c contact, hasID bool) {
+		if hasID && c.ID == n.id {
+			return
+		}
+		if !n.supports(c.Addr) {
+			return
+		}
+		key := c.Addr.String()
+		if _, ok := seen[key]; ok {
+			return
+		}
+		seen[key] = struct{}{}
+		nodes = append(nodes, &lookupNode{contact: c, hasID: hasID})
+	}
+	for _, c := range n.closestContacts(target, 0) {
+		add(c, true)
+	}
+	for _, s := range n.bootstrap {
+		addr, err := net.ResolveUDPAddr("udp", s)
+		if err != nil {
+			n.log.Debugf("cannot resolve dht bootstrap node %s: %s", s, err)
+			continue
+		}
+		add(contact{Addr: addr}, false)
+	}
+	a := &queryArgs{ID: string(n.id[:]), Target: string(target[:]), Want: n.want()}
+	for round := 0; round < maxLookupRounds && ctx.Err() == nil; round++ {
+		// Nodes with unknown IDs are bootstrap nodes and they are queried first.
+		sort.SliceStable(nodes, func(i, j int) bool {
+			if nodes[i].hasID != nodes[j].hasID {
+				return !nodes[i].hasID
+			}
+			return closer(target, nodes[i].ID, nodes[j].ID)
+		})
+		var batch []*lookupNode
+		var closest int
+		for _, ln := range nodes {
+			if ln.failed {
+				continue
+			}
+			if !ln.queried {
+				batch = append(batch, ln)
+			}
+			if closest++; closest == k {
+				break
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+		var wg sync.WaitGroup
+		for _, ln := range batch {
+			ln.queried = true
+			wg.Add(1)
+			go func(ln *lookupNode) {
+				defer wg.Done()
+				ln.resp, ln.failed = nil, true
+				r, err := n.query(ctx, ln.Addr, "get", a)
+				if err != nil {
+					n.log.Debugf("get query to %s failed: %s", ln.Addr, err)
+					return
+				}
+				ln.resp, ln.failed = r, false
+			}(ln)
+		}
+		wg.Wait()
+		for _, ln := range batch {
+			if ln.failed {
+				continue
+			}
+			copy(ln.ID[:], ln.resp.ID)
+			ln.hasID = true
+			for _, c := range decodeNodes(ln.resp.Nodes, net.IPv4len) {
+				add(c, true)
+			}
+			for _, c := range decodeNodes(ln.resp.Nodes6, net.IPv6len) {
+				add(c, true)
+			}
+		}
+	}
+	var ret []*lookupNode
+	for _, ln := range nodes {
+		if ln.resp != nil {
+			ret = append(ret, ln)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return closer(target, ret[i].ID, ret[j].ID) })
+	if len(ret) > k {
+		ret = ret[:k]
+	}
+	return ret
+}
+
+func (n *Node) query(ctx context.Context, addr *net.UDPAddr, q string, a *queryArgs) (*response, error) {
+	txID, ch, err := n.newTransaction(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer n.endTransaction(txID)
+	b, err := bencode.EncodeBytes(message{T: txID, Y: "q", Q: q, A: a})
+	if err != nil {
+		return nil, err
+	}
+	_, err = n.conn.WriteToUDP(b, addr)
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(queryTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-ch:
+		if msg.Y == "e" {
+			return nil, newError(msg.E)
+		}
+		if msg.R == nil || len(msg.R.ID) != 20 {
+			return nil, errors.New("invalid response")
+		}
+		var c contact
+		copy(c.ID[:], msg.R.ID)
+		c.Addr = addr
+		n.addContact(c)
+		return msg.R, nil
+	case <-timer.C:
+		n.removeContact(addr)
+		return nil, errors.New("timeout")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.closeC:
+		return nil, errClosed
+	}
+}
+
+// transaction is a query that is waiting for a response from the node at addr.
+type transaction struct {
+	addr *net.UDPAddr
+	ch   chan *message
+}
+
+// newTransaction returns a random transaction ID for the query to addr and the channel that the response is sent to.
+// Random IDs make it harder to spoof responses.
+func (n *Node) newTransaction(addr *net.UDPAddr) (string, chan *message, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	b := make([]byte, txIDLength)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return "", nil, err
+		}
+		if _, ok := n.transactions[string(b)]; !ok {
+			break
+		}
+	}
+	ch := make(chan *message, 1)
+	n.transactions[string(b)] = &transaction{addr: addr, ch: ch}
+	return string(b), ch, nil
+}
+
+func (n *Node) endTransaction(txID string) {
+	n.m.Lock()
+	delete(n.transactions, txID)
+	n.m.Unlock()
+}
+
+// addContact saves the node that has sent a message.
+// If there is no room, contacts that are not seen recently are evicted.
+func (n *Node) addContact(c contact) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	c.seen = time.Now()
+	key := c.Addr.String()
+	if _, ok := n.contacts[key]; !ok && len(n.contacts) >= maxContacts {
+		n.evictContacts(c.seen)
+	}
+	n.contacts[key] = c
+}
+
+// evictContacts removes the stale contacts. If none is stale, the least recently seen contact is removed.
+func (n *Node) evictContacts(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	var evicted bool
+	for key, c := range n.contacts {
+		if now.Sub(c.seen) > contactTimeout {
+			delete(n.contacts, key)
+			evicted = true
+			continue
+		}
+		if oldestKey == "" || c.seen.Before(oldest) {
+			oldestKey, oldest = key, c.seen
+		}
+	}
+	if !evicted {
+		delete(n.contacts, oldestKey)
+	}
+}
+
+func (n *Node) removeContact(addr *net.UDPAddr) {
+	n.m.Lock()
+	delete(n.contacts, addr.String())
+	n.m.Unlock()
+}
+
+// closestContacts returns the contacts that are closest to the target.
+// If ipLen is not zero, only the contacts with an address of that length are returned.
+func (n *Node) closestContacts(target [20]byte, ipLen int) []contact {
+	now := time.Now()
+	n.m.Lock()
+	contacts := make([]contact, 0, len(n.contacts))
+	for _, c := range n.contacts {
+		if now.Sub(c.seen) > contactTimeout {
+			continue
+		}
+		if ipLen != 0 && ipOf(c.Addr, ipLen) == nil {
+			continue
+		}
+		contacts = append(contacts, c)
+	}
+	n.m.Unlock()
+	sort.Slice(contacts, func(i, j int) bool { return closer(target, contacts[i].ID, contacts[j].ID) })
+	if len(contacts) > k {
+		contacts = contacts[:k]
+	}
+	return contacts
+}
+
+// supports returns true if the node can send messages to the address family of addr.
+func (n *Node) supports(addr *net.UDPAddr) bool {
+	if addr.IP.To4() != nil {
+		return n.ipv4
+	}
+	return n.ipv6
+}
+
+// want returns the address families of nodes that this node wants in responses.
+func (n *Node) want() []string {
+	var ret []string
+	if n.ipv4 {
+		ret = append(ret, "n4")
+	}
+	if n.ipv6 {
+		ret = append(ret, "n6")
+	}
+	return ret
+}
+
+// wantedFamilies returns which of "nodes" and "nodes6" keys must be in the response to a query.
+// The address family of the querying node is used if the query has no "want" argument.
+func wantedFamilies(want []string, from *net.UDPAddr) (n4, n6 bool) {
+	for _, w := range want {
+		switch w {
+		case "n4":
+			n4 = true
+		case "n6":
+			n6 = true
+		}
+	}
+	if !n4 && !n6 {
+		n4 = from.IP.To4() != nil
+		n6 = !n4
+	}
+	return
+}
+
+func (n *Node) readLoop() {
+	defer close(n.doneC)
+	buf := make([]byte, maxMessageSize)
+	for {
+		nn, from, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.closeC:
+			default:
+				n.log.Errorln("cannot read dht message:", err)
+			}
+			return
+		}
+		var msg message
+		err = bencode.DecodeBytes(buf[:nn], &msg)
+		if err != nil {
+			n.log.Debugf("invalid dht message from %s: %s", from, err)
+			continue
+		}
+		switch msg.Y {
+		case "q":
+			n.handleQuery(from, &msg)
+		case "r", "e":
+			n.m.Lock()
+			tx, ok := n.transactions[msg.T]
+			n.m.Unlock()
+			if !ok {
+				continue
+			}
+			// Response must come from the node that is queried.
+			if !tx.addr.IP.Equal(from.IP) || tx.addr.Port != from.Port {
+				n.log.Debugf("dht response from unexpected address: %s expected: %s", from, tx.addr)
+				continue
+			}
+			select {
+			case tx.ch <- &msg:
+			default:
+			}
+		}
+	}
+}
+
+func (n *Node) handleQuery(from *net.UDPAddr, msg *message) {
+	if msg.A == nil || len(msg.A.ID) != 20 {
+		n.sendError(from, msg.T, errorProtocol, "invalid arguments")
+		return
+	}
+	r := &response{ID: string(n.id[:])}
+	switch msg.Q {
+	case "ping":
+	case "find_node", "get":
+		if len(msg.A.Target) != 20 {
+			n.sendError(from, msg.T, errorProtocol, "invalid target")
+			return
+		}
+		var target [20]byte
+		copy(target[:], msg.A.Target)
+		n4, n6 := wantedFamilies(msg.A.Want, from)
+		if n4 {
+			r.Nodes = encodeNodes(n.closestContacts(target, net.IPv4len), net.IPv4len)
+		}
+		if n6 {
+			r.Nodes6 = encodeNodes(n.closestContacts(target, net.IPv6len), net.IPv6len)
+		}
+		if msg.Q == "find_node" {
+			break
+		}
+		r.Token = n.token(from)
+		if i := n.store.get(target); i != nil {
+			r.V = i.Value
+			if i.Mutable() {
+				seq := i.Seq
+				r.K = string(i.PublicKey)
+				r.Seq = &seq
+				r.Sig = string(i.Signature)
+			}
+		}
+	case "put":
+		if msg.A.Token != n.token(from) {
+			n.sendError(from, msg.T, errorProtocol, "invalid token")
+			return
+		}
+		i := &Item{Value: msg.A.V}
+		if msg.A.K != "" {
+			i.PublicKey = []byte(msg.A.K)
+			i.Salt = []byte(msg.A.Salt)
+			i.Signature = []byte(msg.A.Sig)
+			if msg.A.Seq != nil {
+				i.Seq = *msg.A.Seq
+			}
+		}
+		if err := i.Verify(); err != nil {
+			code := errorProtocol
+			switch err {
+			case errValueTooLarge:
+				code = errorMessageTooBig
+			case errSaltTooLarge:
+				code = errorSaltTooBig
+			case errInvalidSignature, errInvalidKey:
+				code = errorInvalidSignature
+			}
+			n.sendError(from, msg.T, code, err.Error())
+			return
+		}
+		if err := n.store.put(i.Target(), i); err != nil {
+			if e, ok := err.(*Error); ok {
+				n.sendError(from, msg.T, e.Code, e.Message)
+			} else {
+				n.sendError(from, msg.T, errorGeneric, err.Error())
+			}
+			return
+		}
+	default:
+		n.sendError(from, msg.T, errorMethodUnknown, "method unknown")
+		return
+	}
+	var c contact
+	copy(c.ID[:], msg.A.ID)
+	c.Addr = from
+	n.addContact(c)
+	n.send(from, message{T: msg.T, Y: "r", R: r})
+}
+
+// token returns the write token for the address. Nodes must present the token in put queries.
+func (n *Node) token(addr *net.UDPAddr) string {
+	b := make([]byte, 0, len(n.secret)+net.IPv6len)
+	b = append(b, n.secret[:]...)
+	b = append(b, addr.IP.To16()...)
+	sum := sha1.Sum(b)
+	return string(sum[:8])
+}
+
+func (n *Node) sendError(to *net.UDPAddr, txID string, code int, msg string) {
+	n.send(to, message{T: txID, Y: "e", E: []interface{}{code, msg}})
+}
+
+func (n *Node) send(to *net.UDPAddr, msg message) {
+	b, err := bencode.EncodeBytes(msg)
+	if err != nil {
+		n.log.Errorln("cannot encode dht message:", err)
+		return
+	}
+	_, err = n.conn.WriteToUDP(b, to)
+	if err != nil {
+		n.log.Debugf("cannot send dht message to %s: %s", to, err)
+	}
+}
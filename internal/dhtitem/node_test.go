@@ -0,0 +1,184 @@
+package dhtitem
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/panzarasa/rain/internal/logger"
+	"github.com/zeebo/bencode"
+)
+
+func newTestNode(t *testing.T, bootstrap ...string) *Node {
+	n, err := New("127.0.0.1:0", bootstrap, logger.New("dht item test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPutGet(t *testing.T) {
+	n1 := newTestNode(t)
+	defer n1.Close()
+	n2 := newTestNode(t, n1.Addr().String())
+	defer n2.Close()
+	n3 := newTestNode(t, n1.Addr().String())
+	defer n3.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	immutable := &Item{Value: []byte("12:Hello World!")}
+	stored, err := n2.Put(ctx, immutable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == 0 {
+		t.Fatalf("unexpected number of nodes: %d", stored)
+	}
+	i, err := n3.Get(ctx, immutable.Target(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(i.Value) != "12:Hello World!" {
+		t.Fatalf("unexpected value: %q", i.Value)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("release")
+	for seq := int64(1); seq <= 2; seq++ {
+		mutable, err := NewMutable(key, salt, []byte("i"+strconv.FormatInt(seq, 10)+"e"), seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = n2.Put(ctx, mutable)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	target := MutableTarget(key.Public().(ed25519.PublicKey), salt)
+	i, err = n3.Get(ctx, target, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.Seq != 2 || string(i.Value) != "i2e" {
+		t.Fatalf("unexpected item: seq=%d value=%q", i.Seq, i.Value)
+	}
+	old, _ := NewMutable(key, salt, []byte("i1e"), 1)
+	_, err = n3.Put(ctx, old)
+	if err == nil {
+		t.Fatal("old item must be rejected")
+	}
+
+	var missing [20]byte
+	_, err = n3.Get(ctx, missing, nil)
+	if err != ErrNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestPutGetIPv6(t *testing.T) {
+	n1, err := New("[::1]:0", nil, logger.New("dht item test"))
+	if err != nil {
+		t.Skip("IPv6 is not available:", err)
+	}
+	defer n1.Close()
+	n2, err := New("[::1]:0", []string{n1.Addr().String()}, logger.New("dht item test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n2.Close()
+	n3, err := New("[::1]:0", []string{n1.Addr().String()}, logger.New("dht item test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n3.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	item := &Item{Value: []byte("4:rain")}
+	_, err = n2.Put(ctx, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := n3.Get(ctx, item.Target(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(i.Value) != "4:rain" {
+		t.Fatalf("unexpected value: %q", i.Value)
+	}
+}
+
+func TestResponseFromOtherAddress(t *testing.T) {
+	n := newTestNode(t)
+	defer n.Close()
+
+	// Queried node does not answer.
+	silent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	// Other node cannot answer the query even if it knows the transaction ID.
+	spoofer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			n.m.Lock()
+			var txIDs []string
+			for txID := range n.transactions {
+				txIDs = append(txIDs, txID)
+			}
+			n.m.Unlock()
+			for _, txID := range txIDs {
+				b, _ := bencode.EncodeBytes(message{T: txID, Y: "e", E: []interface{}{errorGeneric, "spoofed"}})
+				_, _ = spoofer.WriteToUDP(b, n.Addr())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	_, err = n.query(ctx, silent.LocalAddr().(*net.UDPAddr), "ping", &queryArgs{ID: string(n.id[:])})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestEvictContacts(t *testing.T) {
+	n := newTestNode(t)
+	defer n.Close()
+
+	now := time.Now()
+	for i := 0; i < maxContacts; i++ {
+		c := contact{Addr: &net.UDPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 1}}
+		n.addContact(c)
+	}
+	stale := n.contacts["10.0.0.0:1"]
+	stale.seen = now.Add(-2 * contactTimeout)
+	n.contacts["10.0.0.0:1"] = stale
+
+	n.addContact(contact{Addr: &net.UDPAddr{IP: net.IPv4(10, 1, 0, 0), Port: 1}})
+	if len(n.contacts) != maxContacts {
+		t.Fatalf("unexpected number of contacts: %d", len(n.contacts))
+	}
+	if _, ok := n.contacts["10.0.0.0:1"]; ok {
+		t.Fatal("stale contact is not evicted")
+	}
+	if _, ok := n.contacts["10.1.0.0:1"]; !ok {
+		t.Fatal("new contact is not added")
+	}
+}
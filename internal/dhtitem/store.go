@@ -0,0 +1,71 @@
+package dhtitem
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+const (
+	maxStoredItems = 1000
+	storedItemTTL  = 2 * time.Hour
+)
+
+// store keeps the items that are put by other nodes.
+type store struct {
+	m     sync.Mutex
+	items map[[20]byte]storedItem
+}
+
+type storedItem struct {
+	*Item
+	storedAt time.Time
+}
+
+func newStore() *store {
+	return &store{items: make(map[[20]byte]storedItem)}
+}
+
+func (s *store) get(target [20]byte) *Item {
+	s.m.Lock()
+	defer s.m.Unlock()
+	si, ok := s.items[target]
+	if !ok {
+		return nil
+	}
+	if time.Since(si.storedAt) > storedItemTTL {
+		delete(s.items, target)
+		return nil
+	}
+	return si.Item
+}
+
+// put saves a verified item. Mutable items with a lower sequence number than the stored one are rejected.
+func (s *store) put(target [20]byte, item *Item) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if old, ok := s.items[target]; ok && item.Mutable() {
+		if item.Seq < old.Seq {
+			return &Error{Code: errorSeqLessThanStore, Message: "sequence number less than current"}
+		}
+		if item.Seq == old.Seq && !bytes.Equal(item.Value, old.Value) {
+			return &Error{Code: errorSeqLessThanStore, Message: "sequence number is not incremented"}
+		}
+	}
+	if _, ok := s.items[target]; !ok && len(s.items) >= maxStoredItems {
+		s.evictOldest()
+	}
+	s.items[target] = storedItem{Item: item, storedAt: time.Now()}
+	return nil
+}
+
+func (s *store) evictOldest() {
+	var oldestTarget [20]byte
+	var oldest time.Time
+	for target, si := range s.items {
+		if oldest.IsZero() || si.storedAt.Before(oldest) {
+			oldestTarget, oldest = target, si.storedAt
+		}
+	}
+	delete(s.items, oldestTarget)
+}
@@ -0,0 +1,126 @@
+// Package dirwatcher notifies about new files in a directory.
+// On Linux, inotify is used for getting notified. On other systems or if inotify fails, the directory is polled at interval.
+package dirwatcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/panzarasa/rain/internal/logger"
+)
+
+// Watcher sends the paths of new files in a directory.
+type Watcher struct {
+	dir          string
+	suffix       string
+	pollInterval time.Duration
+	log          logger.Logger
+	filesC       chan string
+	closeC       chan struct{}
+	doneC        chan struct{}
+
+	// Files found in last scan and their modification times. Used for skipping files that are already sent.
+	seen map[string]time.Time
+}
+
+// New returns a new Watcher for files with names ending with suffix in dir.
+// Files that exist in dir when Run is called are sent too.
+// A file must be removed or renamed after it is processed, otherwise it may be sent again.
+func New(dir, suffix string, pollInterval time.Duration, l logger.Logger) *Watcher {
+	return &Watcher{
+		dir:          dir,
+		suffix:       suffix,
+		pollInterval: pollInterval,
+		log:          l,
+		filesC:       make(chan string),
+		closeC:       make(chan struct{}),
+		doneC:        make(chan struct{}),
+		seen:         make(map[string]time.Time),
+	}
+}
+
+// Files returns the channel that the paths of new files are sent.
+func (w *Watcher) Files() <-chan string {
+	return w.filesC
+}
+
+// Close the watcher.
+func (w *Watcher) Close() {
+	close(w.closeC)
+	<-w.doneC
+}
+
+// Run the watcher. Blocks until Close is called.
+func (w *Watcher) Run() {
+	defer close(w.doneC)
+	err := w.watch()
+	if err == nil {
+		return
+	}
+	w.log.Warningf("cannot watch directory %s, falling back to polling: %s", w.dir, err)
+	w.poll()
+}
+
+func (w *Watcher) poll() {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	for {
+		w.scan(true)
+		select {
+		case <-ticker.C:
+		case <-w.closeC:
+			return
+		}
+	}
+}
+
+// scan sends the files in the directory that are not sent before.
+// If skipRecent is true, files that are modified in last second are skipped because they may still be written.
+func (w *Watcher) scan(skipRecent bool) {
+	infos, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		w.log.Errorf("cannot read directory %s: %s", w.dir, err)
+		return
+	}
+	seen := make(map[string]time.Time, len(infos))
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() || !strings.HasSuffix(fi.Name(), w.suffix) {
+			continue
+		}
+		if skipRecent && time.Since(fi.ModTime()) < time.Second {
+			continue
+		}
+		seen[fi.Name()] = fi.ModTime()
+		if mtime, ok := w.seen[fi.Name()]; ok && mtime.Equal(fi.ModTime()) {
+			continue
+		}
+		if !w.send(fi.Name()) {
+			return
+		}
+	}
+	w.seen = seen
+}
+
+// notify is called when a file is created or changed in the directory.
+func (w *Watcher) notify(name string) bool {
+	if !strings.HasSuffix(name, w.suffix) {
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(w.dir, name))
+	if err != nil || !fi.Mode().IsRegular() {
+		return true
+	}
+	return w.send(name)
+}
+
+func (w *Watcher) send(name string) bool {
+	select {
+	case w.filesC <- filepath.Join(w.dir, name):
+		return true
+	case <-w.closeC:
+		return false
+	}
+}
@@ -0,0 +1,55 @@
+package dirwatcher
+
+import (
+	"bytes"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watch sends new files in the directory by listening inotify events.
+// It returns an error only if the watch cannot be started.
+func (w *Watcher) watch() error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	// Non-blocking file is added to the runtime poller so Read can be interrupted by Close.
+	f := os.NewFile(uintptr(fd), "inotify")
+	_, err = unix.InotifyAddWatch(fd, w.dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	go func() {
+		<-w.closeC
+		f.Close()
+	}()
+	w.scan(false)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			select {
+			case <-w.closeC:
+			default:
+				w.log.Errorf("cannot read inotify events for directory %s: %s", w.dir, err)
+			}
+			return nil
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			e := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset])) // nolint: gosec
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(e.Len)]
+			offset += unix.SizeofInotifyEvent + int(e.Len)
+			if e.Mask&unix.IN_Q_OVERFLOW != 0 {
+				w.scan(false)
+				continue
+			}
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			if !w.notify(name) {
+				return nil
+			}
+		}
+	}
+}
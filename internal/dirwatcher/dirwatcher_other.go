@@ -0,0 +1,9 @@
+// +build !linux
+
+package dirwatcher
+
+import "errors"
+
+func (w *Watcher) watch() error {
+	return errors.New("not supported")
+}
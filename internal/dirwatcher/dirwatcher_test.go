@@ -0,0 +1,57 @@
+package dirwatcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/panzarasa/rain/internal/logger"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirwatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "existing.torrent")
+	err = ioutil.WriteFile(existing, []byte("foo"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "other.txt"), []byte("foo"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := New(dir, ".torrent", 100*time.Millisecond, logger.New("dirwatcher"))
+	go w.Run()
+	defer w.Close()
+
+	expectFile(t, w, existing)
+	err = os.Remove(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := filepath.Join(dir, "created.torrent")
+	err = ioutil.WriteFile(created, []byte("foo"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, w, created)
+}
+
+func expectFile(t *testing.T, w *Watcher, path string) {
+	select {
+	case name := <-w.Files():
+		if name != path {
+			t.Fatalf("unexpected file: %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("file is not found: %s", path)
+	}
+}
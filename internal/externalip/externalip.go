@@ -14,6 +14,8 @@ func init() {
 		log.Warningln("cannot get interface addresses:", err)
 		return
 	}
+	// IPv6 addresses are put after IPv4 addresses so FirstExternalIP prefers IPv4.
+	var ips6 []net.IP
 	for _, addr := range addrs {
 		in, ok := addr.(*net.IPNet)
 		if !ok {
@@ -21,6 +23,9 @@ func init() {
 		}
 		i4 := in.IP.To4()
 		if i4 == nil {
+			if isPublicIPv6(in.IP) {
+				ips6 = append(ips6, in.IP)
+			}
 			continue
 		}
 		if !isPublicIP(i4) {
@@ -28,6 +33,7 @@ func init() {
 		}
 		ips = append(ips, i4)
 	}
+	ips = append(ips, ips6...)
 }
 
 func isPublicIP(ip4 net.IP) bool {
@@ -46,6 +52,14 @@ func isPublicIP(ip4 net.IP) bool {
 	}
 }
 
+func isPublicIPv6(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() {
+		return false
+	}
+	// Unique local addresses (fc00::/7)
+	return ip[0]&0xfe != 0xfc
+}
+
 // IsExternal returns true if the given IP matches one of the IP address of the external network interfaces on the server.
 func IsExternal(ip net.IP) bool {
 	for i := range ips {
@@ -8,6 +8,8 @@ type FileSection struct {
 	Offset int64
 	Length int64
 	Name   string
+	// Padding sections are not stored anywhere. They contain zeros.
+	Padding bool
 }
 
 // ReadWriterAt combines the io.ReaderAt and io.WriterAt interfaces.
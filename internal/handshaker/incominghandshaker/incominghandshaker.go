@@ -13,6 +13,7 @@ import (
 // IncomingHandshaker does the BitTorrent protocol handshake on an incoming connection.
 type IncomingHandshaker struct {
 	Conn       net.Conn
+	Addr       *net.TCPAddr
 	PeerID     [20]byte
 	Extensions [8]byte
 	Cipher     mse.CryptoMethod
@@ -22,10 +23,11 @@ type IncomingHandshaker struct {
 	doneC  chan struct{}
 }
 
-// New returns a new IncomingHandshaker for a net.Conn.
-func New(conn net.Conn) *IncomingHandshaker {
+// New returns a new IncomingHandshaker for a net.Conn. addr is the address of the peer.
+func New(conn net.Conn, addr *net.TCPAddr) *IncomingHandshaker {
 	return &IncomingHandshaker{
 		Conn:   conn,
+		Addr:   addr,
 		closeC: make(chan struct{}),
 		doneC:  make(chan struct{}),
 	}
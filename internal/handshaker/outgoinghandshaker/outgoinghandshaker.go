@@ -1,6 +1,7 @@
 package outgoinghandshaker
 
 import (
+	"context"
 	"io"
 	"net"
 	"time"
@@ -9,29 +10,40 @@ import (
 	"github.com/panzarasa/rain/internal/logger"
 	"github.com/panzarasa/rain/internal/mse"
 	"github.com/panzarasa/rain/internal/peersource"
+	"github.com/panzarasa/rain/internal/utp"
 )
 
 // OutgoingHandshaker does the BitTorrent handshake on an outgoing connection.
 type OutgoingHandshaker struct {
 	Addr       *net.TCPAddr
 	Source     peersource.Source
+	Transport  peersource.Transport
 	Conn       net.Conn
 	PeerID     [20]byte
 	Extensions [8]byte
 	Cipher     mse.CryptoMethod
 	Error      error
 
+	utpSocket *utp.Socket
+	dialTCP   btconn.DialFunc
+	// Set after first successful connection. Used when connecting again without encryption.
+	connected bool
+
 	closeC chan struct{}
 	doneC  chan struct{}
 }
 
 // New returns a new OutgoingHandshaker for a TCP address.
-func New(addr *net.TCPAddr, source peersource.Source) *OutgoingHandshaker {
+// If utpSocket is not nil, uTP on the same port is tried together with TCP.
+// TCP connections are opened with dialTCP.
+func New(addr *net.TCPAddr, source peersource.Source, utpSocket *utp.Socket, dialTCP btconn.DialFunc) *OutgoingHandshaker {
 	return &OutgoingHandshaker{
-		Addr:   addr,
-		Source: source,
-		closeC: make(chan struct{}),
-		doneC:  make(chan struct{}),
+		Addr:      addr,
+		Source:    source,
+		utpSocket: utpSocket,
+		dialTCP:   dialTCP,
+		closeC:    make(chan struct{}),
+		doneC:     make(chan struct{}),
 	}
 }
 
@@ -46,7 +58,7 @@ func (h *OutgoingHandshaker) Run(dialTimeout, handshakeTimeout time.Duration, pe
 	defer close(h.doneC)
 	log := logger.New("peer -> " + h.Addr.String())
 
-	conn, cipher, peerExtensions, peerID, err := btconn.Dial(h.Addr, dialTimeout, handshakeTimeout, !disableOutgoingEncryption, forceOutgoingEncryption, ourExtensions, infoHash, peerID, h.closeC)
+	conn, cipher, peerExtensions, peerID, err := btconn.Dial(h.Addr, h.dial, dialTimeout, handshakeTimeout, !disableOutgoingEncryption, forceOutgoingEncryption, ourExtensions, infoHash, peerID, h.closeC)
 	if err != nil {
 		if err == io.EOF {
 			log.Debug("peer has closed the connection: EOF")
@@ -66,7 +78,7 @@ func (h *OutgoingHandshaker) Run(dialTimeout, handshakeTimeout time.Duration, pe
 		}
 		return
 	}
-	log.Debugf("Connected to peer. (cipher=%s extensions=%x client=%q)", cipher, peerExtensions, peerID[:8])
+	log.Debugf("Connected to peer. (transport=%s cipher=%s extensions=%x client=%q)", h.Transport, cipher, peerExtensions, peerID[:8])
 
 	h.Conn = conn
 	h.PeerID = peerID
@@ -79,3 +91,87 @@ func (h *OutgoingHandshaker) Run(dialTimeout, handshakeTimeout time.Duration, pe
 		conn.Close()
 	}
 }
+
+// utpHeadStart is the time that uTP is tried alone before TCP is tried in parallel.
+// A peer rejects the second connection from the same IP, so both transports are not started at the same time.
+const utpHeadStart = 300 * time.Millisecond
+
+// dial tries uTP first and also starts TCP if uTP does not connect in utpHeadStart. The transport that connects first is used.
+// Once connected, the same transport is used for the following calls.
+func (h *OutgoingHandshaker) dial(ctx context.Context, addr net.Addr) (net.Conn, error) {
+	if h.utpSocket == nil || (h.connected && h.Transport == peersource.TCP) {
+		return h.dialTransport(ctx, addr, peersource.TCP)
+	}
+	if h.connected {
+		return h.dialTransport(ctx, addr, peersource.UTP)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn      net.Conn
+		transport peersource.Transport
+		err       error
+	}
+	resultC := make(chan result, 2)
+	start := func(tr peersource.Transport) {
+		go func() {
+			conn, err := h.dialTransport(ctx, addr, tr)
+			resultC <- result{conn: conn, transport: tr, err: err}
+		}()
+	}
+	start(peersource.UTP)
+	pending := 1
+	tcpStarted := false
+	startTCP := func() {
+		if !tcpStarted {
+			tcpStarted = true
+			start(peersource.TCP)
+			pending++
+		}
+	}
+	timer := time.NewTimer(utpHeadStart)
+	defer timer.Stop()
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			startTCP()
+		case r := <-resultC:
+			pending--
+			if r.err != nil {
+				// Most peers do not support uTP. TCP error is more meaningful.
+				if err == nil || r.transport == peersource.TCP {
+					err = r.err
+				}
+				if ctx.Err() == nil {
+					startTCP()
+				}
+				continue
+			}
+			if pending > 0 {
+				// Close the other connection if it is established before being canceled.
+				go func() {
+					if r := <-resultC; r.err == nil {
+						r.conn.Close()
+					}
+				}()
+			}
+			h.connected = true
+			h.Transport = r.transport
+			return r.conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (h *OutgoingHandshaker) dialTransport(ctx context.Context, addr net.Addr, tr peersource.Transport) (net.Conn, error) {
+	if tr == peersource.UTP {
+		conn, err := h.utpSocket.DialContext(ctx, &net.UDPAddr{IP: h.Addr.IP, Port: h.Addr.Port, Zone: h.Addr.Zone})
+		if err != nil {
+			// Do not return a typed nil in net.Conn interface.
+			return nil, err
+		}
+		return conn, nil
+	}
+	return h.dialTCP(ctx, addr)
+}
@@ -0,0 +1,207 @@
+// Package lsd implements Local Service Discovery for finding peers in the local network. See BEP 14.
+package lsd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/panzarasa/rain/internal/logger"
+)
+
+const port = 6771
+
+var (
+	multicastAddr4 = &net.UDPAddr{IP: net.IPv4(239, 192, 152, 143), Port: port}
+	multicastAddr6 = &net.UDPAddr{IP: net.ParseIP("ff15::efc0:988f"), Port: port}
+)
+
+const maxMessageSize = 1400
+
+var errInvalidMessage = errors.New("invalid lsd message")
+
+// Peer is announced by another client in the local network.
+type Peer struct {
+	InfoHash [20]byte
+	Addr     *net.TCPAddr
+}
+
+// LSD announces torrents to the local network and listens announces of other clients.
+type LSD struct {
+	// Peers found in local network are sent to this channel.
+	// Peers are dropped if the channel is not ready for receiving.
+	PeersC chan Peer
+
+	cookie string
+	conns  []*conn
+	log    logger.Logger
+	closeC chan struct{}
+}
+
+type conn struct {
+	listen *net.UDPConn
+	send   *net.UDPConn
+	group  *net.UDPAddr
+}
+
+// New starts listening for announces on IPv4 and IPv6 multicast groups.
+// It is not an error if one of the groups cannot be joined.
+func New(l logger.Logger) (*LSD, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	s := &LSD{
+		PeersC: make(chan Peer, 100),
+		cookie: hex.EncodeToString(b),
+		log:    l,
+		closeC: make(chan struct{}),
+	}
+	var lastErr error
+	for _, group := range []*net.UDPAddr{multicastAddr4, multicastAddr6} {
+		c, err := listen(group)
+		if err != nil {
+			l.Debugf("cannot join lsd multicast group %s: %s", group, err)
+			lastErr = err
+			continue
+		}
+		s.conns = append(s.conns, c)
+	}
+	if len(s.conns) == 0 {
+		return nil, lastErr
+	}
+	for _, c := range s.conns {
+		go s.readLoop(c)
+	}
+	return s, nil
+}
+
+func listen(group *net.UDPAddr) (*conn, error) {
+	network := "udp4"
+	if group.IP.To4() == nil {
+		network = "udp6"
+	}
+	lc, err := net.ListenMulticastUDP(network, nil, group)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := net.ListenUDP(network, nil)
+	if err != nil {
+		lc.Close()
+		return nil, err
+	}
+	return &conn{listen: lc, send: sc, group: group}, nil
+}
+
+// Close the LSD and stop listening.
+func (s *LSD) Close() {
+	close(s.closeC)
+	for _, c := range s.conns {
+		c.listen.Close()
+		c.send.Close()
+	}
+}
+
+// Announce the torrent that is listening peers on port to the local network.
+func (s *LSD) Announce(infoHash [20]byte, port int) {
+	for _, c := range s.conns {
+		msg := formatMessage(c.group, port, [][20]byte{infoHash}, s.cookie)
+		_, err := c.send.WriteToUDP(msg, c.group)
+		if err != nil {
+			s.log.Debugf("cannot send lsd announce to %s: %s", c.group, err)
+		}
+	}
+}
+
+func (s *LSD) readLoop(c *conn) {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, from, err := c.listen.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.closeC:
+			default:
+				s.log.Errorln("cannot read lsd message:", err)
+			}
+			return
+		}
+		port, infoHashes, cookie, err := parseMessage(buf[:n])
+		if err != nil {
+			s.log.Debugf("invalid lsd message from %s: %s", from, err)
+			continue
+		}
+		if cookie == s.cookie {
+			// Our own announce
+			continue
+		}
+		addr := &net.TCPAddr{IP: from.IP, Port: port, Zone: from.Zone}
+		for _, ih := range infoHashes {
+			select {
+			case s.PeersC <- Peer{InfoHash: ih, Addr: addr}:
+			case <-s.closeC:
+				return
+			default:
+			}
+		}
+	}
+}
+
+func formatMessage(group *net.UDPAddr, port int, infoHashes [][20]byte, cookie string) []byte {
+	var b bytes.Buffer
+	b.WriteString("BT-SEARCH * HTTP/1.1\r\n")
+	fmt.Fprintf(&b, "Host: %s\r\n", group.String())
+	fmt.Fprintf(&b, "Port: %d\r\n", port)
+	for _, ih := range infoHashes {
+		fmt.Fprintf(&b, "Infohash: %x\r\n", ih[:])
+	}
+	if cookie != "" {
+		fmt.Fprintf(&b, "cookie: %s\r\n", cookie)
+	}
+	b.WriteString("\r\n\r\n")
+	return b.Bytes()
+}
+
+func parseMessage(b []byte) (port int, infoHashes [][20]byte, cookie string, err error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	line, err := r.ReadLine()
+	if err != nil {
+		return
+	}
+	if line != "BT-SEARCH * HTTP/1.1" {
+		err = errInvalidMessage
+		return
+	}
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		return
+	}
+	port, err = strconv.Atoi(h.Get("Port"))
+	if err != nil || port <= 0 || port > 65535 {
+		err = errors.New("invalid port in lsd message")
+		return
+	}
+	for _, s := range h["Infohash"] {
+		var ih [20]byte
+		b, err2 := hex.DecodeString(strings.TrimSpace(s))
+		if err2 != nil || len(b) != 20 {
+			err = errors.New("invalid infohash in lsd message")
+			return
+		}
+		copy(ih[:], b)
+		infoHashes = append(infoHashes, ih)
+	}
+	if len(infoHashes) == 0 {
+		err = errors.New("no infohash in lsd message")
+		return
+	}
+	cookie = h.Get("Cookie")
+	return
+}
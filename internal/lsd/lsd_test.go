@@ -0,0 +1,48 @@
+package lsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage(t *testing.T) {
+	ih1 := [20]byte{1, 2, 3}
+	ih2 := [20]byte{4, 5, 6}
+	b := formatMessage(multicastAddr4, 6881, [][20]byte{ih1, ih2}, "abc")
+	assert.Equal(t, "BT-SEARCH * HTTP/1.1\r\n"+
+		"Host: 239.192.152.143:6771\r\n"+
+		"Port: 6881\r\n"+
+		"Infohash: 0102030000000000000000000000000000000000\r\n"+
+		"Infohash: 0405060000000000000000000000000000000000\r\n"+
+		"cookie: abc\r\n"+
+		"\r\n\r\n", string(b))
+
+	port, ihs, cookie, err := parseMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 6881, port)
+	assert.Equal(t, [][20]byte{ih1, ih2}, ihs)
+	assert.Equal(t, "abc", cookie)
+
+	b = formatMessage(multicastAddr6, 6881, [][20]byte{ih1}, "")
+	assert.Contains(t, string(b), "Host: [ff15::efc0:988f]:6771\r\n")
+	_, _, cookie, err = parseMessage(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "", cookie)
+}
+
+func TestInvalidMessage(t *testing.T) {
+	cases := []string{
+		"",
+		"GET / HTTP/1.1\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 0\r\nInfohash: 0102030000000000000000000000000000000000\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\nInfohash: 0102\r\n\r\n",
+	}
+	for _, c := range cases {
+		_, _, _, err := parseMessage([]byte(c))
+		assert.Error(t, err, c)
+	}
+}
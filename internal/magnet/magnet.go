@@ -19,6 +19,10 @@ type Magnet struct {
 	Name     string
 	Trackers [][]string
 	Peers    []string
+	// PublicKey is set for links pointing to a mutable torrent in DHT (BEP 46).
+	// InfoHash is zero in that case and must be resolved from DHT.
+	PublicKey []byte
+	Salt      []byte
 }
 
 // New parses the string and returns new Magnet.
@@ -34,19 +38,37 @@ func New(s string) (*Magnet, error) {
 
 	params := u.Query()
 
+	var magnet Magnet
+	for _, xs := range params["xs"] {
+		if strings.HasPrefix(xs, "urn:btpk:") {
+			magnet.PublicKey, err = publicKeyString(xs)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if magnet.PublicKey != nil {
+		if salts := params["s"]; len(salts) != 0 {
+			magnet.Salt, err = hex.DecodeString(salts[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	xts, ok := params["xt"]
-	if !ok {
+	if !ok && magnet.PublicKey == nil {
 		return nil, errors.New("missing xt param")
 	}
-	if len(xts) == 0 {
+	if ok && len(xts) == 0 {
 		return nil, errors.New("empty xt param")
 	}
-	xt := xts[0]
-
-	var magnet Magnet
-	magnet.InfoHash, err = infoHashString(xt)
-	if err != nil {
-		return nil, err
+	if ok {
+		magnet.InfoHash, err = infoHashString(xts[0])
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	names := params["dn"]
@@ -83,8 +105,22 @@ func New(s string) (*Magnet, error) {
 func (m *Magnet) String() string {
 	var b strings.Builder
 	b.Grow(2048)
-	b.WriteString("magnet:?xt=urn:btih:")
-	b.WriteString(hex.EncodeToString(m.InfoHash[:]))
+	b.WriteString("magnet:?")
+	if m.PublicKey != nil {
+		b.WriteString("xs=urn:btpk:")
+		b.WriteString(hex.EncodeToString(m.PublicKey))
+		if len(m.Salt) > 0 {
+			b.WriteString("&s=")
+			b.WriteString(hex.EncodeToString(m.Salt))
+		}
+		if m.InfoHash != [20]byte{} {
+			b.WriteString("&")
+		}
+	}
+	if m.PublicKey == nil || m.InfoHash != [20]byte{} {
+		b.WriteString("xt=urn:btih:")
+		b.WriteString(hex.EncodeToString(m.InfoHash[:]))
+	}
 	if m.Name != "" {
 		b.WriteString("&dn=")
 		b.WriteString(url.QueryEscape(m.Name))
@@ -116,6 +152,7 @@ type trackerTier struct {
 
 // infoHashString returns a new info hash value from a string.
 // s must be 40 (hex encoded) or 32 (base32 encoded) characters, otherwise it returns error.
+// SHA-256 hash in a "urn:btmh:" string is truncated to 20 bytes.
 func infoHashString(xt string) ([20]byte, error) {
 	var ih [20]byte
 	var b []byte
@@ -136,16 +173,31 @@ func infoHashString(xt string) ([20]byte, error) {
 		}
 	case strings.HasPrefix(xt, "urn:btmh:"):
 		xt = xt[9:]
-		b, err = multihash.FromHexString(xt)
+		mh, err := multihash.FromHexString(xt)
 		if err != nil {
 			return ih, err
 		}
-		if len(b) != 20 {
-			return ih, errors.New("invalid multihash (len != 20)")
+		dm, err := multihash.Decode(mh)
+		if err != nil {
+			return ih, err
+		}
+		if dm.Code != multihash.SHA2_256 || len(dm.Digest) != 32 {
+			return ih, errors.New("multihash must be a SHA-256 hash")
 		}
+		// v2 info hash is truncated to 20 bytes. See BEP 52.
+		b = dm.Digest
 	default:
 		return ih, errors.New("invalid xt param: must start with \"urn:btih:\" or \"urn:btmh\"")
 	}
 	copy(ih[:], b)
 	return ih, nil
 }
+
+// publicKeyString returns the ed25519 public key in a "urn:btpk:" string. Key must be 64 hex characters.
+func publicKeyString(xs string) ([]byte, error) {
+	xs = xs[9:]
+	if len(xs) != 64 {
+		return nil, errors.New("public key must be 64 characters")
+	}
+	return hex.DecodeString(xs)
+}
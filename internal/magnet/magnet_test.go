@@ -31,3 +31,48 @@ func TestParse(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestParseMutable(t *testing.T) {
+	u := "magnet:?xs=urn:btpk:8543d3e6115f0f98c944077a4493dcd543e49c739fd998550a1f614ab36ed63e&s=73616c74&tr=udp%3a%2f%2ftracker.rain%3a2710"
+	m, err := New(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(m.PublicKey) != "8543d3e6115f0f98c944077a4493dcd543e49c739fd998550a1f614ab36ed63e" {
+		t.Fatal("invalid public key")
+	}
+	if string(m.Salt) != "salt" {
+		t.Fatal("invalid salt")
+	}
+	if m.InfoHash != [20]byte{} {
+		t.Fatal("info hash must be empty")
+	}
+	if len(m.Trackers) != 1 {
+		t.Fatal("invalid trackers")
+	}
+	s := m.String()
+	if !strings.EqualFold(u, s) {
+		t.Log(u)
+		t.Log(s)
+		t.FailNow()
+	}
+	_, err = New("magnet:?xs=urn:btpk:1234")
+	if err == nil {
+		t.Fatal("invalid public key must be rejected")
+	}
+}
+
+func TestParseV2(t *testing.T) {
+	hash := "caf1e1c30e81cb361b9ee167c4aa64228a7fa4fa9f6105232b28ad099f3a302e"
+	m, err := New("magnet:?xt=urn:btmh:1220" + hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(m.InfoHash[:]) != hash[:40] {
+		t.Fatal("invalid info hash")
+	}
+	_, err = New("magnet:?xt=urn:btmh:1114" + hash[:40])
+	if err == nil {
+		t.Fatal("SHA-1 multihash must not be accepted")
+	}
+}
@@ -0,0 +1,98 @@
+// Package merkle implements the hash trees that are used for verifying data in BitTorrent v2 torrents (BEP 52).
+// Leaves of a tree are SHA-256 hashes of 16 KiB blocks. Layers are stored as concatenated hashes.
+package merkle
+
+import (
+	"crypto/sha256"
+)
+
+const (
+	// BlockSize is the size of data that is hashed into a single leaf.
+	BlockSize = 16 * 1024
+	// HashSize is the size of a single hash in the tree.
+	HashSize = sha256.Size
+)
+
+// BlockHashes returns the concatenated hashes of 16 KiB blocks in b.
+// Last block may be shorter than BlockSize.
+func BlockHashes(b []byte) []byte {
+	ret := make([]byte, 0, (len(b)+BlockSize-1)/BlockSize*HashSize)
+	for len(b) > 0 {
+		n := BlockSize
+		if n > len(b) {
+			n = len(b)
+		}
+		sum := sha256.Sum256(b[:n])
+		ret = append(ret, sum[:]...)
+		b = b[n:]
+	}
+	return ret
+}
+
+// NumLeaves returns the number of leaves that a tree must have for holding n hashes.
+func NumLeaves(n int) int {
+	ret := 1
+	for ret < n {
+		ret <<= 1
+	}
+	return ret
+}
+
+// PadHash returns the root hash of a tree that has numLeaves leaves with all zero hashes.
+// It is used for filling the missing hashes of a layer that is not at the bottom of the tree.
+func PadHash(numLeaves int) []byte {
+	h := make([]byte, HashSize)
+	for ; numLeaves > 1; numLeaves /= 2 {
+		h = hashPair(h, h)
+	}
+	return h
+}
+
+// Layers returns the layers of the tree above the given layer including itself.
+// Layer is padded with pad hashes to numLeaves, which must be a power of two.
+// The first element of the result is the padded layer, the last element is the root hash.
+func Layers(layer []byte, numLeaves int, pad []byte) [][]byte {
+	padded := make([]byte, numLeaves*HashSize)
+	n := copy(padded, layer)
+	for ; n < len(padded); n += HashSize {
+		copy(padded[n:], pad)
+	}
+	layers := [][]byte{padded}
+	for len(padded) > HashSize {
+		next := make([]byte, 0, len(padded)/2)
+		for i := 0; i < len(padded); i += 2 * HashSize {
+			next = append(next, hashPair(padded[i:i+HashSize], padded[i+HashSize:i+2*HashSize])...)
+		}
+		layers = append(layers, next)
+		padded = next
+	}
+	return layers
+}
+
+// Root returns the root hash of the tree built on top of the layer.
+// Layer is padded with pad hashes to numLeaves, which must be a power of two.
+func Root(layer []byte, numLeaves int, pad []byte) []byte {
+	layers := Layers(layer, numLeaves, pad)
+	return layers[len(layers)-1]
+}
+
+// ProofRoot returns the root hash of a tree from the hash of a node and the uncle hashes on the path to the root.
+// index is the position of the node in its layer. Uncle hashes are ordered from bottom to top.
+func ProofRoot(h []byte, index int, uncles []byte) []byte {
+	for ; len(uncles) >= HashSize; uncles = uncles[HashSize:] {
+		if index%2 == 0 {
+			h = hashPair(h, uncles[:HashSize])
+		} else {
+			h = hashPair(uncles[:HashSize], h)
+		}
+		index /= 2
+	}
+	return h
+}
+
+func hashPair(a, b []byte) []byte {
+	h := sha256.New()
+	_, _ = h.Write(a)
+	_, _ = h.Write(b)
+	return h.Sum(nil)
+}
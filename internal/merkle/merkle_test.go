@@ -0,0 +1,65 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumLeaves(t *testing.T) {
+	assert.Equal(t, 1, NumLeaves(0))
+	assert.Equal(t, 1, NumLeaves(1))
+	assert.Equal(t, 2, NumLeaves(2))
+	assert.Equal(t, 4, NumLeaves(3))
+	assert.Equal(t, 512, NumLeaves(300))
+}
+
+func TestBlockHashes(t *testing.T) {
+	b := bytes.Repeat([]byte{1}, BlockSize+1)
+	h := BlockHashes(b)
+	assert.Len(t, h, 2*HashSize)
+	h0 := sha256.Sum256(b[:BlockSize])
+	h1 := sha256.Sum256(b[BlockSize:])
+	assert.Equal(t, h0[:], h[:HashSize])
+	assert.Equal(t, h1[:], h[HashSize:])
+}
+
+func TestRoot(t *testing.T) {
+	zero := make([]byte, HashSize)
+	leaves := BlockHashes(bytes.Repeat([]byte{1}, 3*BlockSize))
+
+	// Single leaf is the root itself.
+	assert.Equal(t, leaves[:HashSize], Root(leaves[:HashSize], 1, zero))
+
+	h01 := hashPair(leaves[:HashSize], leaves[HashSize:2*HashSize])
+	h23 := hashPair(leaves[2*HashSize:], zero)
+	assert.Equal(t, hashPair(h01, h23), Root(leaves, 4, zero))
+
+	layers := Layers(leaves, 4, zero)
+	assert.Len(t, layers, 3)
+	assert.Len(t, layers[0], 4*HashSize)
+	assert.Equal(t, append(h01, h23...), layers[1])
+}
+
+func TestPadHash(t *testing.T) {
+	zero := make([]byte, HashSize)
+	assert.Equal(t, zero, PadHash(1))
+	assert.Equal(t, hashPair(zero, zero), PadHash(2))
+	assert.Equal(t, Root(nil, 8, zero), PadHash(8))
+}
+
+func TestProofRoot(t *testing.T) {
+	zero := make([]byte, HashSize)
+	leaves := BlockHashes(bytes.Repeat([]byte{1}, 3*BlockSize))
+	layers := Layers(leaves, 4, zero)
+	root := layers[2]
+
+	// Prove the third leaf with its sibling and the hash of the left subtree.
+	uncles := append(append([]byte{}, zero...), layers[1][:HashSize]...)
+	assert.Equal(t, root, ProofRoot(leaves[2*HashSize:], 2, uncles))
+	// Prove the left subtree.
+	assert.Equal(t, root, ProofRoot(layers[1][:HashSize], 0, layers[1][HashSize:]))
+	assert.NotEqual(t, root, ProofRoot(leaves[2*HashSize:], 3, uncles))
+}
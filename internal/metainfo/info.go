@@ -2,6 +2,7 @@ package metainfo
 
 import (
 	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"strings"
 	"unicode"
 
+	"github.com/panzarasa/rain/internal/merkle"
 	"github.com/zeebo/bencode"
 )
 
@@ -26,24 +28,38 @@ var (
 type Info struct {
 	PieceLength uint32
 	Name        string
-	Hash        [20]byte
-	Length      int64
-	NumPieces   uint32
-	Bytes       []byte
-	Private     bool
-	Files       []File
+	// Hash is the SHA-1 hash of info dictionary.
+	// For v2 only torrents, it is the SHA-256 hash truncated to 20 bytes.
+	Hash      [20]byte
+	Length    int64
+	NumPieces uint32
+	Bytes     []byte
+	Private   bool
+	Files     []File
+	// MetaVersion is 2 for v2 and hybrid torrents, 1 for others.
+	MetaVersion int
+	// HashV2 is the SHA-256 hash of info dictionary of v2 and hybrid torrents.
+	HashV2 [32]byte
+	// PieceLayers is the bencoded "piece layers" dictionary of v2 and hybrid torrents. Set by SetPieceLayers.
+	PieceLayers []byte
 	pieces      []byte
+	piecesV2    []byte
+	filesV2     []fileV2
+	layers      map[string][]byte
 }
 
 // File represents a file inside a Torrent.
 type File struct {
 	Length int64
 	Path   string
+	// Padding files are not written to disk. They are used for aligning files to piece boundaries.
+	Padding bool
 }
 
 type file struct {
 	Length int64    `bencode:"length"`
 	Path   []string `bencode:"path"`
+	Attr   string   `bencode:"attr,omitempty"`
 }
 
 // NewInfo returns info from bencoded bytes in b.
@@ -55,6 +71,8 @@ func NewInfo(b []byte) (*Info, error) {
 		Private     bencode.RawMessage `bencode:"private"`
 		Length      int64              `bencode:"length"` // Single File Mode
 		Files       []file             `bencode:"files"`  // Multiple File mode
+		MetaVersion int                `bencode:"meta version"`
+		FileTree    bencode.RawMessage `bencode:"file tree"` // v2
 	}
 	if err := bencode.DecodeBytes(b, &ib); err != nil {
 		return nil, err
@@ -62,11 +80,27 @@ func NewInfo(b []byte) (*Info, error) {
 	if ib.PieceLength == 0 {
 		return nil, errZeroPieceLength
 	}
+	var filesV2 []fileV2
+	switch ib.MetaVersion {
+	case 0, 1:
+	case 2:
+		if !isPowerOfTwo(ib.PieceLength) || ib.PieceLength < merkle.BlockSize {
+			return nil, errPieceLengthV2
+		}
+		var err error
+		filesV2, err = parseFileTree(ib.FileTree)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errUnsupportedMetaVersion
+	}
+	v2Only := filesV2 != nil && len(ib.Pieces) == 0
 	if len(ib.Pieces)%sha1.Size != 0 {
 		return nil, errInvalidPieceData
 	}
 	numPieces := len(ib.Pieces) / sha1.Size
-	if numPieces == 0 {
+	if numPieces == 0 && !v2Only {
 		return nil, errZeroPieces
 	}
 	// ".." is not allowed in file names
@@ -83,19 +117,30 @@ func NewInfo(b []byte) (*Info, error) {
 		pieces:      ib.Pieces,
 		Name:        ib.Name,
 		Private:     parsePrivateField(ib.Private),
+		MetaVersion: 1,
 	}
-	multiFile := len(ib.Files) > 0
-	if multiFile {
-		for _, f := range ib.Files {
-			i.Length += f.Length
-		}
+	var multiFile bool
+	if v2Only {
+		multiFile = len(filesV2) > 1 || len(filesV2[0].Path) > 1
 	} else {
-		i.Length = ib.Length
-	}
-	totalPieceDataLength := int64(i.PieceLength) * int64(i.NumPieces)
-	delta := totalPieceDataLength - i.Length
-	if delta >= int64(i.PieceLength) || delta < 0 {
-		return nil, errInvalidPieceData
+		multiFile = len(ib.Files) > 0
+		if multiFile {
+			for _, f := range ib.Files {
+				i.Length += f.Length
+			}
+		} else {
+			i.Length = ib.Length
+		}
+		totalPieceDataLength := int64(i.PieceLength) * int64(i.NumPieces)
+		delta := totalPieceDataLength - i.Length
+		if delta >= int64(i.PieceLength) || delta < 0 {
+			return nil, errInvalidPieceData
+		}
+		if filesV2 != nil {
+			if err := checkHybrid(ib.Files, ib.Length, multiFile, filesV2); err != nil {
+				return nil, err
+			}
+		}
 	}
 	i.Bytes = b
 
@@ -103,6 +148,15 @@ func NewInfo(b []byte) (*Info, error) {
 	hash := sha1.New()   // nolint: gosec
 	_, _ = hash.Write(b) // nolint: gosec
 	copy(i.Hash[:], hash.Sum(nil))
+	if filesV2 != nil {
+		i.MetaVersion = 2
+		i.filesV2 = filesV2
+		i.HashV2 = sha256.Sum256(b)
+		if v2Only {
+			// Truncated v2 hash is used in places where a 20 bytes hash is needed. (handshake, trackers, DHT)
+			copy(i.Hash[:], i.HashV2[:])
+		}
+	}
 
 	// name field is optional
 	if ib.Name != "" {
@@ -112,7 +166,30 @@ func NewInfo(b []byte) (*Info, error) {
 	}
 
 	// construct files
-	if multiFile {
+	switch {
+	case v2Only:
+		var err error
+		i.Files, i.Length, err = layoutV2(filesV2, i.PieceLength, i.Name, multiFile)
+		if err != nil {
+			return nil, err
+		}
+		if i.Length == 0 {
+			return nil, errZeroPieces
+		}
+		n := (i.Length + int64(i.PieceLength) - 1) / int64(i.PieceLength)
+		if n > maxPiecesV2 {
+			return nil, errTooManyPieces
+		}
+		i.NumPieces = uint32(n)
+		// Hashes of the files that fit in a single piece are in the file tree.
+		// Others are set from the piece layers later.
+		i.piecesV2 = make([]byte, int(i.NumPieces)*merkle.HashSize)
+		for _, f := range filesV2 {
+			if f.Length > 0 && f.Length <= int64(i.PieceLength) {
+				copy(i.piecesV2[int(f.firstPiece)*merkle.HashSize:], f.PiecesRoot[:])
+			}
+		}
+	case multiFile:
 		i.Files = make([]File, len(ib.Files))
 		for j, f := range ib.Files {
 			parts := make([]string, 0, len(f.Path)+1)
@@ -121,11 +198,12 @@ func NewInfo(b []byte) (*Info, error) {
 				parts = append(parts, cleanName(p))
 			}
 			i.Files[j] = File{
-				Path:   filepath.Join(parts...),
-				Length: f.Length,
+				Path:    filepath.Join(parts...),
+				Length:  f.Length,
+				Padding: isPadding(f),
 			}
 		}
-	} else {
+	default:
 		i.Files = []File{{Path: cleanName(i.Name), Length: i.Length}}
 	}
 	return &i, nil
@@ -279,7 +357,13 @@ func NewInfoBytes(path string, private bool, pieceLength uint32) ([]byte, error)
 }
 
 // PieceHash returns the hash of a piece at index.
+// For v2 only torrents, it is the root hash of the merkle tree of blocks in the piece.
 func (i *Info) PieceHash(index uint32) []byte {
+	if i.IsV2Only() {
+		begin := index * merkle.HashSize
+		end := begin + merkle.HashSize
+		return i.piecesV2[begin:end]
+	}
 	begin := index * sha1.Size
 	end := begin + sha1.Size
 	return i.pieces[begin:end]
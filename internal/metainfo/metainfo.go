@@ -28,6 +28,7 @@ func New(r io.Reader) (*MetaInfo, error) {
 		Announce     bencode.RawMessage `bencode:"announce"`
 		AnnounceList bencode.RawMessage `bencode:"announce-list"`
 		URLList      bencode.RawMessage `bencode:"url-list"`
+		PieceLayers  bencode.RawMessage `bencode:"piece layers"`
 	}
 	err := bencode.NewDecoder(r).Decode(&t)
 	if err != nil {
@@ -40,6 +41,10 @@ func New(r io.Reader) (*MetaInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = info.SetPieceLayers(t.PieceLayers)
+	if err != nil {
+		return nil, err
+	}
 	ret.Info = *info
 	if len(t.AnnounceList) > 0 {
 		var ll [][]string
@@ -95,9 +100,11 @@ func isWebseedSupported(s string) bool {
 }
 
 // NewBytes creates a new torrent metadata file from given information.
-func NewBytes(info []byte, trackers [][]string, webseeds []string, comment string) ([]byte, error) {
+// pieceLayers must be set for v2 torrents.
+func NewBytes(info, pieceLayers []byte, trackers [][]string, webseeds []string, comment string) ([]byte, error) {
 	mi := struct {
 		Info         bencode.RawMessage `bencode:"info"`
+		PieceLayers  bencode.RawMessage `bencode:"piece layers,omitempty"`
 		Announce     string             `bencode:"announce,omitempty"`
 		AnnounceList [][]string         `bencode:"announce-list,omitempty"`
 		URLList      bencode.RawMessage `bencode:"url-list,omitempty"`
@@ -106,6 +113,7 @@ func NewBytes(info []byte, trackers [][]string, webseeds []string, comment strin
 		CreatedBy    string             `bencode:"created by,omitempty"`
 	}{
 		Info:         info,
+		PieceLayers:  pieceLayers,
 		Comment:      comment,
 		CreationDate: time.Now().UTC().Unix(),
 		CreatedBy:    Creator,
@@ -0,0 +1,486 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1" // nolint: gosec
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/panzarasa/rain/internal/merkle"
+	"github.com/zeebo/bencode"
+)
+
+var (
+	errUnsupportedMetaVersion = errors.New("unsupported meta version")
+	errPieceLengthV2          = errors.New("piece length of v2 torrent must be a power of two and at least 16K")
+	errInvalidFileTree        = errors.New("invalid file tree")
+	errHybridMismatch         = errors.New("v1 and v2 files of hybrid torrent do not match")
+	errInvalidPieceLayer      = errors.New("invalid piece layer")
+	errMissingPieceLayer      = errors.New("missing piece layer")
+	errTooLarge               = errors.New("total length of files is too large")
+	errTooManyPieces          = errors.New("torrent has too many pieces")
+)
+
+// maxPiecesV2 is the maximum number of pieces in a v2 only torrent.
+// Hashes of pieces are not in the info dictionary of v2 torrents, so the number of pieces is not limited by the size of info.
+// A v1 torrent with this many pieces would have 80 MiB of hashes in info, which is above the default torrent and metadata size limits.
+const maxPiecesV2 = 1 << 22
+
+// fileV2 is a file in the "file tree" of a v2 torrent.
+type fileV2 struct {
+	Path       []string
+	Length     int64
+	PiecesRoot [32]byte
+	// Index of the first piece of the file in torrent. Files in v2 torrents start at piece boundaries.
+	firstPiece uint32
+}
+
+type fileTreeLeaf struct {
+	Length     int64  `bencode:"length"`
+	PiecesRoot []byte `bencode:"pieces root,omitempty"`
+}
+
+// parseFileTree returns the files in "file tree" dictionary in the order of their paths.
+func parseFileTree(b bencode.RawMessage) ([]fileV2, error) {
+	var files []fileV2
+	err := walkFileTree(b, nil, &files)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errInvalidFileTree
+	}
+	return files, nil
+}
+
+func walkFileTree(b bencode.RawMessage, path []string, files *[]fileV2) error {
+	var node map[string]bencode.RawMessage
+	if err := bencode.DecodeBytes(b, &node); err != nil {
+		return errInvalidFileTree
+	}
+	if leaf, ok := node[""]; ok {
+		if len(node) != 1 || len(path) == 0 {
+			return errInvalidFileTree
+		}
+		var fl fileTreeLeaf
+		if err := bencode.DecodeBytes(leaf, &fl); err != nil {
+			return errInvalidFileTree
+		}
+		f := fileV2{Path: path, Length: fl.Length}
+		switch {
+		case fl.Length < 0:
+			return errInvalidFileTree
+		case fl.Length == 0 && len(fl.PiecesRoot) != 0:
+			return errInvalidFileTree
+		case fl.Length > 0 && len(fl.PiecesRoot) != len(f.PiecesRoot):
+			return errInvalidFileTree
+		}
+		copy(f.PiecesRoot[:], fl.PiecesRoot)
+		*files = append(*files, f)
+		return nil
+	}
+	keys := make([]string, 0, len(node))
+	for k := range node {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if strings.TrimSpace(k) == ".." {
+			return fmt.Errorf("invalid file name: %q", filepath.Join(append(path, k)...))
+		}
+		p := make([]string, len(path)+1)
+		copy(p, path)
+		p[len(path)] = k
+		if err := walkFileTree(node[k], p, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// layoutV2 places the files of a v2 torrent to pieces.
+// Padding is inserted before each file that does not start at a piece boundary.
+// Returns the files with padding and total length of the data including padding.
+func layoutV2(files []fileV2, pieceLength uint32, name string, multiFile bool) ([]File, int64, error) {
+	var ret []File
+	var offset int64
+	// Leave room for the padding of the last file so the number of pieces can be calculated without overflow.
+	maxLength := math.MaxInt64 - 2*int64(pieceLength)
+	for i := range files {
+		f := &files[i]
+		if f.Length < 0 || f.Length > maxLength-offset {
+			return nil, 0, errTooLarge
+		}
+		if f.Length > 0 {
+			if mod := offset % int64(pieceLength); mod != 0 {
+				padLength := int64(pieceLength) - mod
+				ret = append(ret, File{
+					Path:    filepath.Join(cleanName(name), ".pad", strconv.FormatInt(padLength, 10)),
+					Length:  padLength,
+					Padding: true,
+				})
+				offset += padLength
+			}
+		}
+		f.firstPiece = uint32(offset / int64(pieceLength))
+		path := cleanName(name)
+		if multiFile {
+			parts := make([]string, 0, len(f.Path)+1)
+			parts = append(parts, path)
+			for _, p := range f.Path {
+				parts = append(parts, cleanName(p))
+			}
+			path = filepath.Join(parts...)
+		}
+		ret = append(ret, File{Path: path, Length: f.Length})
+		offset += f.Length
+	}
+	return ret, offset, nil
+}
+
+// checkHybrid verifies that v1 and v2 parts of a hybrid torrent describe the same files.
+func checkHybrid(v1 []file, length int64, multiFile bool, v2 []fileV2) error {
+	if !multiFile {
+		if len(v2) != 1 || v2[0].Length != length {
+			return errHybridMismatch
+		}
+		return nil
+	}
+	var j int
+	for _, f := range v1 {
+		if isPadding(f) {
+			continue
+		}
+		if j >= len(v2) || v2[j].Length != f.Length || !equalPaths(v2[j].Path, f.Path) {
+			return errHybridMismatch
+		}
+		j++
+	}
+	if j != len(v2) {
+		return errHybridMismatch
+	}
+	return nil
+}
+
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isPadding(f file) bool {
+	return strings.Contains(f.Attr, "p")
+}
+
+func isPowerOfTwo(n uint32) bool {
+	return n != 0 && n&(n-1) == 0
+}
+
+// IsV2Only returns true if the torrent has only v2 piece hashes.
+func (i *Info) IsV2Only() bool {
+	return i.MetaVersion == 2 && len(i.pieces) == 0
+}
+
+// IsHybrid returns true if the torrent has both v1 and v2 piece hashes.
+func (i *Info) IsHybrid() bool {
+	return i.MetaVersion == 2 && len(i.pieces) > 0
+}
+
+// SetPieceLayers validates the bencoded "piece layers" dictionary of a v2 torrent and saves the layers in Info.
+// Layers of all files that are larger than a piece must be present for v2 only torrents.
+func (i *Info) SetPieceLayers(b []byte) error {
+	if i.MetaVersion != 2 {
+		return nil
+	}
+	var m map[string][]byte
+	if len(b) > 0 {
+		if err := bencode.DecodeBytes(b, &m); err != nil {
+			return err
+		}
+	}
+	layers := make(map[string][]byte)
+	for _, f := range i.filesV2 {
+		if f.Length <= int64(i.PieceLength) {
+			continue
+		}
+		layer, ok := m[string(f.PiecesRoot[:])]
+		if !ok {
+			if i.IsV2Only() {
+				return errMissingPieceLayer
+			}
+			continue
+		}
+		numPieces := int((f.Length + int64(i.PieceLength) - 1) / int64(i.PieceLength))
+		if len(layer) != numPieces*merkle.HashSize {
+			return errInvalidPieceLayer
+		}
+		root := merkle.Root(layer, merkle.NumLeaves(numPieces), merkle.PadHash(int(i.PieceLength/merkle.BlockSize)))
+		if !bytes.Equal(root, f.PiecesRoot[:]) {
+			return errInvalidPieceLayer
+		}
+		layers[string(f.PiecesRoot[:])] = layer
+		if i.IsV2Only() {
+			copy(i.piecesV2[int(f.firstPiece)*merkle.HashSize:], layer)
+		}
+	}
+	i.layers = layers
+	i.PieceLayers = nil
+	if len(layers) > 0 {
+		var err error
+		i.PieceLayers, err = bencode.EncodeBytes(layers)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MissingPieceLayers returns the number of pieces of files whose piece layers are not set yet, keyed by their pieces roots.
+// Files that fit in a single piece do not have a piece layer.
+func (i *Info) MissingPieceLayers() map[[32]byte]int {
+	ret := make(map[[32]byte]int)
+	for _, f := range i.filesV2 {
+		if f.Length <= int64(i.PieceLength) {
+			continue
+		}
+		if _, ok := i.layers[string(f.PiecesRoot[:])]; ok {
+			continue
+		}
+		ret[f.PiecesRoot] = int((f.Length + int64(i.PieceLength) - 1) / int64(i.PieceLength))
+	}
+	return ret
+}
+
+// PieceLayer returns the concatenated piece hashes of the file with the pieces root.
+func (i *Info) PieceLayer(piecesRoot [32]byte) ([]byte, bool) {
+	layer, ok := i.layers[string(piecesRoot[:])]
+	return layer, ok
+}
+
+// NewInfoBytesV2 creates a new v2 Info dictionary by reading and hashing the files on the disk.
+// If hybrid is true, v1 piece hashes are added too, so the torrent can be downloaded by v1 only clients.
+// Returns the bencoded info dictionary and "piece layers" dictionary that must be put into the torrent file.
+func NewInfoBytesV2(path string, private bool, pieceLength uint32, hybrid bool) (info, pieceLayers []byte, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rootIsDir := fi.IsDir()
+	var totalLength int64
+	if rootIsDir {
+		totalLength, err = findTotalLength(path)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		totalLength = fi.Size()
+	}
+	if totalLength == 0 {
+		return nil, nil, errors.New("no files")
+	}
+	if pieceLength == 0 {
+		pieceLength = calculatePieceLength(totalLength)
+	} else if !isPowerOfTwo(pieceLength) || pieceLength < merkle.BlockSize {
+		return nil, nil, errPieceLengthV2
+	}
+	root := path
+	fileTree := make(map[string]interface{})
+	layers := make(map[string][]byte)
+	var files []file
+	var v1 *v1Hasher
+	if hybrid {
+		v1 = newV1Hasher(pieceLength)
+	}
+	buf := make([]byte, pieceLength)
+	visit := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		var parts []string
+		if rootIsDir {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			parts = strings.Split(filepath.ToSlash(rel), "/")
+		} else {
+			parts = []string{filepath.Base(path)}
+		}
+		leaf := fileTreeLeaf{Length: fi.Size()}
+		if fi.Size() > 0 {
+			if v1 != nil && v1.offset > 0 {
+				// Align file to the piece boundary for v2.
+				padLength := int64(pieceLength) - v1.offset
+				files = append(files, file{Path: []string{".pad", strconv.FormatInt(padLength, 10)}, Length: padLength, Attr: "p"})
+				v1.pad()
+			}
+			var layer []byte
+			leaf.PiecesRoot, layer, err = hashFileV2(path, buf, v1)
+			if err != nil {
+				return err
+			}
+			if layer != nil {
+				layers[string(leaf.PiecesRoot)] = layer
+			}
+		}
+		files = append(files, file{Path: parts, Length: fi.Size()})
+		node := fileTree
+		for _, p := range parts {
+			child, ok := node[p].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[p] = child
+			}
+			node = child
+		}
+		node[""] = leaf
+		return nil
+	}
+	if rootIsDir {
+		err = filepath.Walk(path, visit)
+	} else {
+		err = visit(path, fi, nil)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	b := struct {
+		Name        string                 `bencode:"name"`
+		Private     bool                   `bencode:"private"`
+		PieceLength uint32                 `bencode:"piece length"`
+		MetaVersion int                    `bencode:"meta version"`
+		FileTree    map[string]interface{} `bencode:"file tree"`
+		Pieces      []byte                 `bencode:"pieces,omitempty"`
+		Length      int64                  `bencode:"length,omitempty"` // Single File Mode
+		Files       []file                 `bencode:"files,omitempty"`  // Multiple File mode
+	}{
+		Name:        filepath.Base(path),
+		Private:     private,
+		PieceLength: pieceLength,
+		MetaVersion: 2,
+		FileTree:    fileTree,
+	}
+	if v1 != nil {
+		b.Pieces = v1.finish()
+		if rootIsDir {
+			b.Files = files
+		} else {
+			b.Length = fi.Size()
+		}
+	}
+	info, err = bencode.EncodeBytes(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(layers) > 0 {
+		pieceLayers, err = bencode.EncodeBytes(layers)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return info, pieceLayers, nil
+}
+
+// hashFileV2 returns the pieces root of the file at path.
+// If the file is larger than a piece, the piece layer is returned too.
+// File data is written to v1 hasher if it is not nil.
+func hashFileV2(path string, buf []byte, v1 *v1Hasher) (piecesRoot, layer []byte, err error) {
+	f, err := os.Open(path) // nolint: gosec
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	blocksPerPiece := len(buf) / merkle.BlockSize
+	var blocks []byte
+	for {
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, nil, err
+		}
+		if v1 != nil {
+			v1.write(buf[:n])
+		}
+		blocks = append(blocks, merkle.BlockHashes(buf[:n])...)
+		if n < len(buf) {
+			break
+		}
+	}
+	numBlocks := len(blocks) / merkle.HashSize
+	if numBlocks <= blocksPerPiece {
+		return merkle.Root(blocks, merkle.NumLeaves(numBlocks), make([]byte, merkle.HashSize)), nil, nil
+	}
+	for i := 0; i < len(blocks); i += blocksPerPiece * merkle.HashSize {
+		end := i + blocksPerPiece*merkle.HashSize
+		if end > len(blocks) {
+			end = len(blocks)
+		}
+		layer = append(layer, merkle.Root(blocks[i:end], blocksPerPiece, make([]byte, merkle.HashSize))...)
+	}
+	numPieces := len(layer) / merkle.HashSize
+	piecesRoot = merkle.Root(layer, merkle.NumLeaves(numPieces), merkle.PadHash(blocksPerPiece))
+	return piecesRoot, layer, nil
+}
+
+// v1Hasher calculates SHA-1 hashes of pieces for the v1 part of hybrid torrents.
+type v1Hasher struct {
+	hash        hash.Hash
+	pieceLength int64
+	offset      int64
+	pieces      []byte
+}
+
+func newV1Hasher(pieceLength uint32) *v1Hasher {
+	return &v1Hasher{
+		hash:        sha1.New(), // nolint: gosec
+		pieceLength: int64(pieceLength),
+	}
+}
+
+func (h *v1Hasher) write(b []byte) {
+	for len(b) > 0 {
+		n := h.pieceLength - h.offset
+		if n > int64(len(b)) {
+			n = int64(len(b))
+		}
+		_, _ = h.hash.Write(b[:n])
+		h.offset += n
+		b = b[n:]
+		if h.offset == h.pieceLength {
+			h.pieces = h.hash.Sum(h.pieces)
+			h.hash.Reset()
+			h.offset = 0
+		}
+	}
+}
+
+// pad writes zeros until the next piece boundary.
+func (h *v1Hasher) pad() {
+	h.write(make([]byte, h.pieceLength-h.offset))
+}
+
+func (h *v1Hasher) finish() []byte {
+	if h.offset > 0 {
+		h.pieces = h.hash.Sum(h.pieces)
+	}
+	return h.pieces
+}
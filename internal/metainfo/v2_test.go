@@ -0,0 +1,180 @@
+package metainfo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zeebo/bencode"
+)
+
+func createTestFiles(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "rain-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "test")
+	files := map[string]int{
+		"a":       100000,
+		"b":       100,
+		"sub/c":   40000,
+		"sub/d/e": 0,
+	}
+	for name, size := range files {
+		p := filepath.Join(root, name)
+		if err = os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, bytes.Repeat([]byte(name[len(name)-1:]), size), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func newTestTorrentV2(t *testing.T, path string, hybrid bool) *MetaInfo {
+	info, layers, err := NewInfoBytesV2(path, false, 32*1024, hybrid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBytes(info, layers, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mi
+}
+
+func TestInfoV2(t *testing.T) {
+	dir := createTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	mi := newTestTorrentV2(t, filepath.Join(dir, "test"), false)
+	info := mi.Info
+	assert.True(t, info.IsV2Only())
+	assert.False(t, info.IsHybrid())
+	assert.Equal(t, 2, info.MetaVersion)
+	assert.Equal(t, info.HashV2[:20], info.Hash[:])
+	assert.Equal(t, int64(140100), info.Length-paddingLength(info))
+
+	var names []string
+	for _, f := range info.Files {
+		if !f.Padding {
+			names = append(names, f.Path)
+		}
+	}
+	assert.Equal(t, []string{"test/a", "test/b", filepath.Join("test", "sub", "c"), filepath.Join("test", "sub", "d", "e")}, names)
+
+	// Every file must start at a piece boundary.
+	var offset int64
+	for _, f := range info.Files {
+		if !f.Padding && f.Length > 0 {
+			assert.Zero(t, offset%int64(info.PieceLength), f.Path)
+		}
+		offset += f.Length
+	}
+	// a: 4 pieces, b: 1 piece, c: 2 pieces
+	assert.Equal(t, uint32(7), info.NumPieces)
+	assert.NotEmpty(t, info.PieceLayers)
+	for i := uint32(0); i < info.NumPieces; i++ {
+		assert.Len(t, info.PieceHash(i), 32)
+	}
+}
+
+func TestInfoHybrid(t *testing.T) {
+	dir := createTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	mi := newTestTorrentV2(t, filepath.Join(dir, "test"), true)
+	info := mi.Info
+	assert.False(t, info.IsV2Only())
+	assert.True(t, info.IsHybrid())
+	assert.NotEqual(t, info.HashV2[:20], info.Hash[:])
+	assert.Equal(t, uint32(7), info.NumPieces)
+	for i := uint32(0); i < info.NumPieces; i++ {
+		assert.Len(t, info.PieceHash(i), 20)
+	}
+}
+
+func TestInvalidPieceLayers(t *testing.T) {
+	dir := createTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	info, layers, err := NewInfoBytesV2(filepath.Join(dir, "test"), false, 32*1024, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string][]byte
+	if err = bencode.DecodeBytes(layers, &m); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range m {
+		v[0]++
+		m[k] = v
+	}
+	layers, err = bencode.EncodeBytes(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBytes(info, layers, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(bytes.NewReader(b))
+	assert.Error(t, err)
+}
+
+func TestNewBytesV1HasNoPieceLayers(t *testing.T) {
+	dir := createTestFiles(t)
+	defer os.RemoveAll(dir)
+
+	info, err := NewInfoBytes(filepath.Join(dir, "test"), false, 32*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBytes(info, nil, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, bytes.Contains(b, []byte("piece layers")))
+}
+
+func paddingLength(info Info) int64 {
+	var n int64
+	for _, f := range info.Files {
+		if f.Padding {
+			n += f.Length
+		}
+	}
+	return n
+}
+
+func TestInfoV2TooLarge(t *testing.T) {
+	newInfo := func(lengths ...int64) error {
+		tree := make(map[string]interface{})
+		for i, length := range lengths {
+			tree[string(rune('a'+i))] = map[string]interface{}{
+				"": map[string]interface{}{"length": length, "pieces root": bytes.Repeat([]byte{1}, 32)},
+			}
+		}
+		b, err := bencode.EncodeBytes(map[string]interface{}{
+			"meta version": 2,
+			"name":         "test",
+			"piece length": 16 * 1024,
+			"file tree":    tree,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewInfo(b)
+		return err
+	}
+	assert.Equal(t, errTooLarge, newInfo(1<<62, 1<<62))
+	assert.Equal(t, errTooManyPieces, newInfo(1<<40))
+}
@@ -17,8 +17,8 @@ import (
 	"github.com/panzarasa/rain/internal/pexlist"
 	"github.com/panzarasa/rain/internal/piece"
 	"github.com/panzarasa/rain/internal/pieceset"
+	"github.com/panzarasa/rain/internal/speedlimiter"
 	"github.com/panzarasa/rain/internal/stringutil"
-	"github.com/juju/ratelimit"
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -28,7 +28,8 @@ type Peer struct {
 
 	ConnectedAt time.Time
 
-	Source peersource.Source
+	Source    peersource.Source
+	Transport peersource.Transport
 
 	Bitfield            *bitfield.Bitfield
 	ReceivedAllowedFast pieceset.PieceSet
@@ -38,6 +39,7 @@ type Peer struct {
 	ExtensionsEnabled bool
 	FastEnabled       bool
 	DHTEnabled        bool
+	V2Enabled         bool
 	EncryptionCipher  mse.CryptoMethod
 
 	ClientInterested bool
@@ -81,18 +83,20 @@ type PieceMessage struct {
 	Piece peerreader.Piece
 }
 
-// New wraps the net.Conn and returns a new Peer.
-func New(conn net.Conn, source peersource.Source, id [20]byte, extensions [8]byte, cipher mse.CryptoMethod, pieceReadTimeout, snubTimeout time.Duration, maxRequestsIn int, br, bw *ratelimit.Bucket) *Peer {
+// New wraps the net.Conn and returns a new Peer. addr is the address of the peer.
+func New(conn net.Conn, addr *net.TCPAddr, source peersource.Source, id [20]byte, extensions [8]byte, cipher mse.CryptoMethod, pieceReadTimeout, snubTimeout time.Duration, maxRequestsIn int, br, bw *speedlimiter.Limiter) *Peer {
 	bf, _ := bitfield.NewBytes(extensions[:], 64)
 	fastEnabled := bf.Test(61)
 	extensionsEnabled := bf.Test(43)
 	dhtEnabled := bf.Test(63)
+	v2Enabled := bf.Test(59)
 
 	t := time.NewTimer(math.MaxInt64)
 	t.Stop()
 	return &Peer{
-		Conn:              peerconn.New(conn, newPeerLogger(source, conn), pieceReadTimeout, maxRequestsIn, fastEnabled, br, bw),
+		Conn:              peerconn.New(conn, addr, newPeerLogger(source, conn), pieceReadTimeout, maxRequestsIn, fastEnabled, br, bw),
 		Source:            source,
+		Transport:         peersource.TransportOf(conn),
 		ConnectedAt:       time.Now(),
 		ID:                id,
 		ClientChoking:     true,
@@ -100,6 +104,7 @@ func New(conn net.Conn, source peersource.Source, id [20]byte, extensions [8]byt
 		ExtensionsEnabled: extensionsEnabled,
 		FastEnabled:       fastEnabled,
 		DHTEnabled:        dhtEnabled,
+		V2Enabled:         v2Enabled,
 		EncryptionCipher:  cipher,
 		snubTimeout:       snubTimeout,
 		snubTimer:         t,
@@ -255,6 +260,11 @@ func (p *Peer) RequestMetadataPiece(index uint32) {
 	})
 }
 
+// RequestHashes is used to request the hashes in merkle tree of a file in v2 torrents by sending a "hash request" message.
+func (p *Peer) RequestHashes(msg peerprotocol.HashRequestMessage) {
+	p.SendMessage(msg)
+}
+
 // RequestPiece is used to request a piece at index by sending a "piece" protocol message.
 func (p *Peer) RequestPiece(index, begin, length uint32) {
 	msg := peerprotocol.RequestMessage{Index: index, Begin: begin, Length: length}
@@ -9,12 +9,13 @@ import (
 	"github.com/panzarasa/rain/internal/peerconn/peerreader"
 	"github.com/panzarasa/rain/internal/peerconn/peerwriter"
 	"github.com/panzarasa/rain/internal/peerprotocol"
-	"github.com/juju/ratelimit"
+	"github.com/panzarasa/rain/internal/speedlimiter"
 )
 
 // Conn is a peer connection that provides a channel for receiving messages and methods for sending messages.
 type Conn struct {
 	conn     net.Conn
+	addr     *net.TCPAddr
 	reader   *peerreader.PeerReader
 	writer   *peerwriter.PeerWriter
 	messages chan interface{}
@@ -23,10 +24,11 @@ type Conn struct {
 	doneC    chan struct{}
 }
 
-// New returns a new PeerConn by wrapping a net.Conn.
-func New(conn net.Conn, l logger.Logger, pieceTimeout time.Duration, maxRequestsIn int, fastEnabled bool, br, bw *ratelimit.Bucket) *Conn {
+// New returns a new PeerConn by wrapping a net.Conn. addr is the address of the peer.
+func New(conn net.Conn, addr *net.TCPAddr, l logger.Logger, pieceTimeout time.Duration, maxRequestsIn int, fastEnabled bool, br, bw *speedlimiter.Limiter) *Conn {
 	return &Conn{
 		conn:     conn,
+		addr:     addr,
 		reader:   peerreader.New(conn, l, pieceTimeout, br),
 		writer:   peerwriter.New(conn, l, maxRequestsIn, fastEnabled, bw),
 		messages: make(chan interface{}),
@@ -38,12 +40,12 @@ func New(conn net.Conn, l logger.Logger, pieceTimeout time.Duration, maxRequests
 
 // Addr returns the net.TCPAddr of the peer.
 func (p *Conn) Addr() *net.TCPAddr {
-	return p.conn.RemoteAddr().(*net.TCPAddr)
+	return p.addr
 }
 
 // IP returns the string representation of IP address.
 func (p *Conn) IP() string {
-	return p.conn.RemoteAddr().(*net.TCPAddr).IP.String()
+	return p.Addr().IP.String()
 }
 
 // String returns the remote address as string.
@@ -14,7 +14,7 @@ import (
 	"github.com/panzarasa/rain/internal/logger"
 	"github.com/panzarasa/rain/internal/peerprotocol"
 	"github.com/panzarasa/rain/internal/piece"
-	"github.com/juju/ratelimit"
+	"github.com/panzarasa/rain/internal/speedlimiter"
 )
 
 const (
@@ -24,6 +24,8 @@ const (
 	readTimeout = 2 * time.Minute
 	// length + msgid + requestmsg
 	readBufferSize = 4 + 1 + 12
+	// max 512 hashes and their proof in "hashes" messages.
+	maxHashesLength = (512 + 64) * 32
 )
 
 var blockPool = bufferpool.New(piece.BlockSize)
@@ -34,20 +36,20 @@ type PeerReader struct {
 	r            io.Reader
 	log          logger.Logger
 	pieceTimeout time.Duration
-	bucket       *ratelimit.Bucket
+	limiter      *speedlimiter.Limiter
 	messages     chan interface{}
 	stopC        chan struct{}
 	doneC        chan struct{}
 }
 
 // New returns a new PeerReader by wrapping a net.Conn.
-func New(conn net.Conn, l logger.Logger, pieceTimeout time.Duration, b *ratelimit.Bucket) *PeerReader {
+func New(conn net.Conn, l logger.Logger, pieceTimeout time.Duration, lim *speedlimiter.Limiter) *PeerReader {
 	return &PeerReader{
 		conn:         conn,
 		r:            bufio.NewReaderSize(conn, readBufferSize),
 		log:          l,
 		pieceTimeout: pieceTimeout,
-		bucket:       b,
+		limiter:      lim,
 		messages:     make(chan interface{}),
 		stopC:        make(chan struct{}),
 		doneC:        make(chan struct{}),
@@ -238,6 +240,41 @@ func (p *PeerReader) Run() {
 				return
 			}
 			msg = em.Payload
+		case peerprotocol.HashRequest:
+			var hm peerprotocol.HashRequestMessage
+			err = binary.Read(p.r, binary.BigEndian, &hm)
+			if err != nil {
+				return
+			}
+			msg = hm
+		case peerprotocol.Hashes:
+			var hm peerprotocol.HashesMessage
+			err = binary.Read(p.r, binary.BigEndian, &hm.HashRequestMessage)
+			if err != nil {
+				return
+			}
+			length -= 48
+			if length > maxHashesLength {
+				err = &blockSizeError{
+					messageID:  id,
+					got:        length,
+					allowedMax: maxHashesLength,
+				}
+				return
+			}
+			hm.Hashes = make([]byte, length)
+			_, err = io.ReadFull(p.r, hm.Hashes)
+			if err != nil {
+				return
+			}
+			msg = hm
+		case peerprotocol.HashReject:
+			var hm peerprotocol.HashRejectMessage
+			err = binary.Read(p.r, binary.BigEndian, &hm.HashRequestMessage)
+			if err != nil {
+				return
+			}
+			msg = hm
 		default:
 			p.log.Debugf("unhandled message type: %s", id)
 			p.log.Debugln("Discarding", length, "bytes...")
@@ -268,8 +305,7 @@ func (p *PeerReader) readPiece(length uint32) (buf bufferpool.Buffer, err error)
 
 	var n, m int
 	for {
-		if p.bucket != nil {
-			d := p.bucket.Take(int64(length))
+		if d := p.limiter.Take(int64(length)); d > 0 {
 			select {
 			case <-time.After(d):
 			case <-p.stopC:
@@ -11,7 +11,7 @@ import (
 	"github.com/panzarasa/rain/internal/logger"
 	"github.com/panzarasa/rain/internal/peerconn/peerreader"
 	"github.com/panzarasa/rain/internal/peerprotocol"
-	"github.com/juju/ratelimit"
+	"github.com/panzarasa/rain/internal/speedlimiter"
 )
 
 const keepAlivePeriod = 2 * time.Minute
@@ -28,14 +28,14 @@ type PeerWriter struct {
 	writeC                chan peerprotocol.Message
 	messages              chan interface{}
 	servedRequests        map[peerprotocol.RequestMessage]struct{}
-	bucket                *ratelimit.Bucket
+	limiter               *speedlimiter.Limiter
 	log                   logger.Logger
 	stopC                 chan struct{}
 	doneC                 chan struct{}
 }
 
 // New returns a new PeerWriter by wrapping a net.Conn.
-func New(conn net.Conn, l logger.Logger, maxQueuedRequests int, fastEnabled bool, b *ratelimit.Bucket) *PeerWriter {
+func New(conn net.Conn, l logger.Logger, maxQueuedRequests int, fastEnabled bool, lim *speedlimiter.Limiter) *PeerWriter {
 	return &PeerWriter{
 		conn:              conn,
 		queueC:            make(chan peerprotocol.Message),
@@ -46,7 +46,7 @@ func New(conn net.Conn, l logger.Logger, maxQueuedRequests int, fastEnabled bool
 		writeC:            make(chan peerprotocol.Message),
 		messages:          make(chan interface{}),
 		servedRequests:    make(map[peerprotocol.RequestMessage]struct{}),
-		bucket:            b,
+		limiter:           lim,
 		log:               l,
 		stopC:             make(chan struct{}),
 		doneC:             make(chan struct{}),
@@ -233,12 +233,13 @@ func (p *PeerWriter) messageWriter() {
 			// Put message ID
 			buf.Bytes()[4] = uint8(msg.ID())
 
-			if _, ok := msg.(Piece); ok && p.bucket != nil {
-				d := p.bucket.Take(int64(buf.Len()))
-				select {
-				case <-time.After(d):
-				case <-p.stopC:
-					return
+			if _, ok := msg.(Piece); ok {
+				if d := p.limiter.Take(int64(buf.Len())); d > 0 {
+					select {
+					case <-time.After(d):
+					case <-p.stopC:
+						return
+					}
 				}
 			}
 
@@ -38,9 +38,17 @@ func calculateBytes(a, b *net.TCPAddr) (ret [2][]byte) {
 	}
 	a4 := a.IP.To4()
 	b4 := b.IP.To4()
-	m := ipv4Mask(a4, b4)
-	ret[0] = a4.Mask(m)
-	ret[1] = b4.Mask(m)
+	if a4 != nil && b4 != nil {
+		m := ipv4Mask(a4, b4)
+		ret[0] = a4.Mask(m)
+		ret[1] = b4.Mask(m)
+		return
+	}
+	a16 := a.IP.To16()
+	b16 := b.IP.To16()
+	m := ipv6Mask(a16, b16)
+	ret[0] = a16.Mask(m)
+	ret[1] = b16.Mask(m)
 	return
 }
 
@@ -54,6 +62,29 @@ func ipv4Mask(a, b net.IP) net.IPMask {
 	return net.IPv4Mask(0xff, 0xff, 0xff, 0xff)
 }
 
+func ipv6Mask(a, b net.IP) net.IPMask {
+	if !sameSubnet(48, 128, a, b) {
+		return ipv6MaskBytes(6, 0x55)
+	}
+	if !sameSubnet(56, 128, a, b) {
+		return ipv6MaskBytes(7, 0x55)
+	}
+	return ipv6MaskBytes(16, 0xff)
+}
+
+// ipv6MaskBytes returns a mask with first n bytes are 0xff and the rest are fill.
+func ipv6MaskBytes(n int, fill byte) net.IPMask {
+	m := make(net.IPMask, net.IPv6len)
+	for i := range m {
+		if i < n {
+			m[i] = 0xff
+		} else {
+			m[i] = fill
+		}
+	}
+	return m
+}
+
 func sameSubnet(ones, bits int, a, b net.IP) bool {
 	mask := net.CIDRMask(ones, bits)
 	return a.Mask(mask).Equal(b.Mask(mask))
@@ -20,6 +20,14 @@ func TestPeerPriority(t *testing.T) {
 		newAddr("123.213.32.10"),
 		newAddr("123.213.32.234"),
 	))
+	assert.Equal(t,
+		Calculate(newAddr("2001:db8:1::1"), newAddr("2001:db8:2::1")),
+		Calculate(newAddr("2001:db8:2::1"), newAddr("2001:db8:1::1")),
+	)
+	assert.NotEqual(t,
+		Calculate(newAddr("2001:db8:1::1"), newAddr("2001:db8:1::2")),
+		Calculate(newAddr("2001:db8:1::1"), newAddr("2001:db8:1::3")),
+	)
 }
 
 func newAddr(ip string) *net.TCPAddr {
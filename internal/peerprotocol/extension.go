@@ -3,7 +3,6 @@ package peerprotocol
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"net"
 
@@ -17,6 +16,8 @@ const (
 	ExtensionIDMetadata
 	// ExtensionIDPEX is ID for PEX extension messages.
 	ExtensionIDPEX
+	// ExtensionIDFirstCustom is the first ID that can be assigned to custom extensions.
+	ExtensionIDFirstCustom
 )
 
 const (
@@ -56,8 +57,17 @@ func (m ExtensionMessage) WriteTo(w io.Writer) (n int64, err error) {
 	if err != nil {
 		return
 	}
+	if rm, ok := m.Payload.(ExtensionRawMessage); ok {
+		nn, err = w.Write(rm.Data)
+		n += int64(nn)
+		return
+	}
+	payload := m.Payload
+	if hm, ok := payload.(ExtensionHandshakeMessage); ok && len(hm.Extra) > 0 {
+		payload = hm.withExtra()
+	}
 	wc := newWriterCounter(w)
-	err = bencode.NewEncoder(wc).Encode(m.Payload)
+	err = bencode.NewEncoder(wc).Encode(payload)
 	n += wc.Count()
 	if err != nil {
 		return
@@ -84,13 +94,18 @@ func (m *ExtensionMessage) UnmarshalBinary(data []byte) error {
 	case ExtensionIDHandshake:
 		var extMsg ExtensionHandshakeMessage
 		err = dec.Decode(&extMsg)
-		m.Payload = extMsg
+		if err != nil {
+			return err
+		}
 		if extMsg.MetadataSize < 0 {
 			extMsg.MetadataSize = 0
 		}
 		if extMsg.RequestQueue < 0 {
 			extMsg.RequestQueue = 0
 		}
+		// Keep all fields for custom extensions.
+		err = bencode.DecodeBytes(payload, &extMsg.Fields)
+		m.Payload = extMsg
 	case ExtensionIDMetadata:
 		var extMsg ExtensionMetadataMessage
 		err = dec.Decode(&extMsg)
@@ -101,7 +116,8 @@ func (m *ExtensionMessage) UnmarshalBinary(data []byte) error {
 		err = dec.Decode(&extMsg)
 		m.Payload = extMsg
 	default:
-		return fmt.Errorf("peer sent invalid extension message id: %d", m.ExtendedMessageID)
+		// Message of a custom extension. Caller must check if the ID is valid.
+		m.Payload = ExtensionRawMessage{ExtendedMessageID: m.ExtendedMessageID, Data: payload}
 	}
 	return err
 }
@@ -113,6 +129,33 @@ type ExtensionHandshakeMessage struct {
 	YourIP       string           `bencode:"yourip,omitempty"`
 	MetadataSize int              `bencode:"metadata_size,omitempty"`
 	RequestQueue int              `bencode:"reqq"`
+
+	// Extra fields that are sent in the handshake for custom extensions. Standard fields above are not overridden.
+	Extra map[string]interface{} `bencode:"-"`
+	// Fields contains all the fields in a received handshake as raw bencoded values.
+	Fields map[string]bencode.RawMessage `bencode:"-"`
+}
+
+// withExtra returns the handshake as a dictionary including the extra fields.
+func (m ExtensionHandshakeMessage) withExtra() map[string]interface{} {
+	d := make(map[string]interface{}, len(m.Extra)+5)
+	for k, v := range m.Extra {
+		d[k] = v
+	}
+	d["m"] = m.M
+	d["v"] = m.V
+	d["reqq"] = m.RequestQueue
+	if m.YourIP != "" {
+		d["yourip"] = m.YourIP
+	} else {
+		delete(d, "yourip")
+	}
+	if m.MetadataSize != 0 {
+		d["metadata_size"] = m.MetadataSize
+	} else {
+		delete(d, "metadata_size")
+	}
+	return d
 }
 
 // NewExtensionHandshake returns a new ExtensionHandshakeMessage by filling the struct with given values.
@@ -137,10 +180,18 @@ type ExtensionMetadataMessage struct {
 	Data      []byte `bencode:"-"`
 }
 
+// ExtensionRawMessage is a message of a custom extension. Data is not parsed.
+type ExtensionRawMessage struct {
+	ExtendedMessageID uint8
+	Data              []byte
+}
+
 // ExtensionPEXMessage is the message for the PEX extension.
 type ExtensionPEXMessage struct {
-	Added   string `bencode:"added"`
-	Dropped string `bencode:"dropped"`
+	Added    string `bencode:"added"`
+	Dropped  string `bencode:"dropped"`
+	Added6   string `bencode:"added6,omitempty"`
+	Dropped6 string `bencode:"dropped6,omitempty"`
 }
 
 func truncateIP(ip net.IP) net.IP {
@@ -0,0 +1,61 @@
+package peerprotocol
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// HashRequestMessage is sent to request the hashes in merkle tree of a file in v2 torrents. See BEP 52.
+type HashRequestMessage struct {
+	PiecesRoot  [32]byte
+	BaseLayer   uint32
+	Index       uint32
+	Length      uint32
+	ProofLayers uint32
+}
+
+// ID returns the peer protocol message type.
+func (m HashRequestMessage) ID() MessageID { return HashRequest }
+
+// Read message data into buffer b.
+func (m HashRequestMessage) Read(b []byte) (int, error) {
+	copy(b[0:32], m.PiecesRoot[:])
+	binary.BigEndian.PutUint32(b[32:36], m.BaseLayer)
+	binary.BigEndian.PutUint32(b[36:40], m.Index)
+	binary.BigEndian.PutUint32(b[40:44], m.Length)
+	binary.BigEndian.PutUint32(b[44:48], m.ProofLayers)
+	return 48, io.EOF
+}
+
+// HashesMessage is sent in response to a HashRequestMessage.
+// Hashes contains the requested hashes followed by the uncle hashes for proving them.
+type HashesMessage struct {
+	HashRequestMessage
+	Hashes []byte
+	pos    int
+}
+
+// ID returns the peer protocol message type.
+func (m HashesMessage) ID() MessageID { return Hashes }
+
+// Read message data into buffer b.
+func (m *HashesMessage) Read(b []byte) (n int, err error) {
+	if m.pos == 0 {
+		n, _ = m.HashRequestMessage.Read(b)
+		m.pos = n
+		b = b[n:]
+	}
+	o := copy(b, m.Hashes[m.pos-48:])
+	m.pos += o
+	n += o
+	if m.pos-48 == len(m.Hashes) {
+		err = io.EOF
+	}
+	return
+}
+
+// HashRejectMessage is sent when a HashRequestMessage cannot be served.
+type HashRejectMessage struct{ HashRequestMessage }
+
+// ID returns the peer protocol message type.
+func (m HashRejectMessage) ID() MessageID { return HashReject }
@@ -0,0 +1,32 @@
+package peersource
+
+import "net"
+
+// Transport indicates which protocol is used for connecting to the Peer.
+type Transport int
+
+const (
+	// TCP indicates that the peer is connected over TCP.
+	TCP Transport = iota
+	// UTP indicates that the peer is connected over uTorrent Transport Protocol on UDP.
+	UTP
+)
+
+// TransportOf returns the Transport of the connection by looking at the type of remote address.
+func TransportOf(conn net.Conn) Transport {
+	if _, ok := conn.RemoteAddr().(*net.UDPAddr); ok {
+		return UTP
+	}
+	return TCP
+}
+
+func (t Transport) String() string {
+	switch t {
+	case TCP:
+		return "tcp"
+	case UTP:
+		return "utp"
+	default:
+		panic("unhandled transport")
+	}
+}
@@ -53,21 +53,21 @@ func (l *PEXList) Drop(addr *net.TCPAddr) {
 }
 
 // Flush returns added and dropped parts and empty the list.
-func (l *PEXList) Flush() (added, dropped string) {
-	added = l.flush(l.added, l.flushed)
-	dropped = l.flush(l.dropped, l.flushed)
+// IPv4 and IPv6 addresses are returned separately.
+func (l *PEXList) Flush() (added, added6, dropped, dropped6 string) {
+	added, added6 = l.flush(l.added, l.flushed)
+	dropped, dropped6 = l.flush(l.dropped, l.flushed)
 	l.flushed = true
 	return
 }
 
-func (l *PEXList) flush(m map[tracker.CompactPeer]struct{}, limit bool) string {
+func (l *PEXList) flush(m map[tracker.CompactPeer]struct{}, limit bool) (string, string) {
 	count := len(m)
 	if limit && count > maxPeers {
 		count = maxPeers
 	}
 
-	var s strings.Builder
-	s.Grow(count * 6)
+	var s, s6 strings.Builder
 	for p := range m {
 		if count == 0 {
 			break
@@ -78,8 +78,12 @@ func (l *PEXList) flush(m map[tracker.CompactPeer]struct{}, limit bool) string {
 		if err != nil {
 			panic(err)
 		}
-		s.Write(b)
+		if p.Is4() {
+			s.Write(b)
+		} else {
+			s6.Write(b)
+		}
 		delete(m, p)
 	}
-	return s.String()
+	return s.String(), s6.String()
 }
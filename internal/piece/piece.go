@@ -6,6 +6,7 @@ import (
 
 	"github.com/panzarasa/rain/internal/allocator"
 	"github.com/panzarasa/rain/internal/filesection"
+	"github.com/panzarasa/rain/internal/merkle"
 	"github.com/panzarasa/rain/internal/metainfo"
 )
 
@@ -20,6 +21,11 @@ type Piece struct {
 	Hash    []byte
 	Writing bool
 	Done    bool
+
+	// For v2 only torrents, Hash is the root of the merkle tree of block hashes in the piece.
+	// Number of leaves in the tree and the length of data excluding the padding at the end are saved here.
+	numLeaves  int
+	dataLength uint32
 }
 
 // Block is part of a Piece that is specified in peerprotocol.Request messages.
@@ -51,6 +57,8 @@ func NewPieces(info *metainfo.Info, files []allocator.File) []Piece {
 
 	fileLeft := func() int64 { return fileLength - fileOffset }
 
+	v2 := info.IsV2Only()
+
 	// Construct pieces
 	var total int64
 	pieces := make([]Piece, info.NumPieces)
@@ -69,12 +77,17 @@ func NewPieces(info *metainfo.Info, files []allocator.File) []Piece {
 			n := uint32(minInt64(int64(left), fileLeft())) // number of bytes to write
 
 			file := filesection.FileSection{
-				File:   files[fileIndex].Storage,
-				Offset: fileOffset,
-				Length: int64(n),
-				Name:   files[fileIndex].Name,
+				File:    files[fileIndex].Storage,
+				Offset:  fileOffset,
+				Length:  int64(n),
+				Name:    files[fileIndex].Name,
+				Padding: info.Files[fileIndex].Padding,
 			}
 			sections = append(sections, file)
+			if v2 && n > 0 && !file.Padding {
+				p.dataLength += n
+				p.numLeaves = numLeavesV2(fileLength, info.PieceLength)
+			}
 
 			left -= n
 			p.Length += n
@@ -147,15 +160,30 @@ func (p *Piece) FindBlock(begin, length uint32) (b Block, ok bool) {
 }
 
 // VerifyHash returns true if hash of piece data in buffer `buf` matches the hash of Piece.
+// Hash h is not used for pieces of v2 only torrents.
 func (p *Piece) VerifyHash(buf []byte, h hash.Hash) bool {
 	if uint32(len(buf)) != p.Length {
 		return false
 	}
+	if p.numLeaves > 0 {
+		leaves := merkle.BlockHashes(buf[:p.dataLength])
+		root := merkle.Root(leaves, p.numLeaves, make([]byte, merkle.HashSize))
+		return bytes.Equal(root, p.Hash)
+	}
 	_, _ = h.Write(buf)
 	sum := h.Sum(nil)
 	return bytes.Equal(sum, p.Hash)
 }
 
+// numLeavesV2 returns the number of leaves in the merkle tree of a piece of a file.
+// Trees of pieces of files larger than a piece are padded to the piece length.
+func numLeavesV2(fileLength int64, pieceLength uint32) int {
+	if fileLength > int64(pieceLength) {
+		return int(pieceLength / BlockSize)
+	}
+	return merkle.NumLeaves(int((fileLength + BlockSize - 1) / BlockSize))
+}
+
 func minInt64(a, b int64) int64 {
 	if a < b {
 		return a
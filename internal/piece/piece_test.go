@@ -1,8 +1,16 @@
 package piece
 
 import (
+	"bytes"
+	"crypto/sha1" // nolint: gosec
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/panzarasa/rain/internal/allocator"
+	"github.com/panzarasa/rain/internal/metainfo"
+	"github.com/panzarasa/rain/storage/filestorage"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -79,3 +87,63 @@ func TestFindBlock(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, Block{Index: 2, Begin: 2 * BlockSize, Length: 42}, b)
 }
+
+func TestVerifyHashV2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rain-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, size := range map[string]int{"a": 100000, "b": 100, "c": 40000} {
+		if err = os.MkdirAll(filepath.Join(dir, "test"), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, "test", name), bytes.Repeat([]byte(name), size), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, hybrid := range []bool{false, true} {
+		info, layers, err := metainfo.NewInfoBytesV2(filepath.Join(dir, "test"), false, 32*1024, hybrid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := metainfo.NewBytes(info, layers, nil, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mi, err := metainfo.New(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sto, err := filestorage.New(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := allocator.New()
+		resultC := make(chan *allocator.Allocator, 1)
+		a.Run(&mi.Info, sto, make(chan allocator.Progress, len(mi.Info.Files)), resultC)
+		<-resultC
+		if a.Error != nil {
+			t.Fatal(a.Error)
+		}
+		assert.False(t, a.HasMissing)
+
+		h := sha1.New() // nolint: gosec
+		for _, p := range NewPieces(&mi.Info, a.Files) {
+			buf := make([]byte, p.Length)
+			_, err = p.Data.ReadAt(buf, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.Reset()
+			assert.True(t, p.VerifyHash(buf, h), "piece %d", p.Index)
+			buf[0]++
+			h.Reset()
+			assert.False(t, p.VerifyHash(buf, h), "piece %d", p.Index)
+		}
+		for _, f := range a.Files {
+			f.Storage.Close()
+		}
+	}
+}
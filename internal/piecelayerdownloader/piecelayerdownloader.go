@@ -0,0 +1,173 @@
+// Package piecelayerdownloader downloads the piece layers of v2 torrents from peers with "hash request" messages. See BEP 52.
+package piecelayerdownloader
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+
+	"github.com/panzarasa/rain/internal/merkle"
+	"github.com/panzarasa/rain/internal/peerprotocol"
+	"github.com/zeebo/bencode"
+)
+
+// maxRequestLength is the maximum number of hashes that are requested in a single message.
+const maxRequestLength = 512
+
+var (
+	errInvalidSize = errors.New("peer sent invalid number of hashes")
+	errInvalidRoot = errors.New("received hashes do not match with pieces root")
+)
+
+// Peer of a torrent.
+type Peer interface {
+	RequestHashes(msg peerprotocol.HashRequestMessage)
+}
+
+// PieceLayerDownloader requests the piece layers of the files from peers and verifies the received hashes against the pieces roots of files.
+type PieceLayerDownloader struct {
+	baseLayer uint32
+	layers    map[[32]byte]*layer
+	// Requests that are not answered yet. Value is nil if the request is not sent to any peer.
+	requests map[peerprotocol.HashRequestMessage]Peer
+	// Requests that are rejected by a peer are not sent again to the same peer.
+	rejected map[Peer]map[peerprotocol.HashRequestMessage]struct{}
+}
+
+type layer struct {
+	numPieces int
+	// Padded to the number of leaves in the tree.
+	hashes []byte
+}
+
+// New returns a new PieceLayerDownloader for the files in layers.
+// Keys of layers are the pieces roots of files and values are the number of pieces in files.
+func New(pieceLength uint32, layers map[[32]byte]int) *PieceLayerDownloader {
+	d := &PieceLayerDownloader{
+		baseLayer: uint32(bits.TrailingZeros32(pieceLength / merkle.BlockSize)),
+		layers:    make(map[[32]byte]*layer, len(layers)),
+		requests:  make(map[peerprotocol.HashRequestMessage]Peer),
+		rejected:  make(map[Peer]map[peerprotocol.HashRequestMessage]struct{}),
+	}
+	for root, numPieces := range layers {
+		numLeaves := merkle.NumLeaves(numPieces)
+		d.layers[root] = &layer{numPieces: numPieces, hashes: make([]byte, numLeaves*merkle.HashSize)}
+		length := numLeaves
+		if length > maxRequestLength {
+			length = maxRequestLength
+		}
+		proofLayers := uint32(bits.TrailingZeros(uint(numLeaves)) - bits.TrailingZeros(uint(length)))
+		for index := 0; index < numLeaves; index += length {
+			req := peerprotocol.HashRequestMessage{
+				PiecesRoot:  root,
+				BaseLayer:   d.baseLayer,
+				Index:       uint32(index),
+				Length:      uint32(length),
+				ProofLayers: proofLayers,
+			}
+			d.requests[req] = nil
+		}
+	}
+	return d
+}
+
+// RequestHashes sends the requests that are not sent to any peer yet to pe.
+// Returns the number of requests sent.
+func (d *PieceLayerDownloader) RequestHashes(pe Peer) int {
+	var n int
+	for req, p := range d.requests {
+		if p != nil {
+			continue
+		}
+		if _, ok := d.rejected[pe][req]; ok {
+			continue
+		}
+		d.requests[req] = pe
+		pe.RequestHashes(req)
+		n++
+	}
+	return n
+}
+
+// Pending returns the number of requests that are sent to pe and not answered yet.
+func (d *PieceLayerDownloader) Pending(pe Peer) int {
+	var n int
+	for _, p := range d.requests {
+		if p == pe {
+			n++
+		}
+	}
+	return n
+}
+
+// GotHashes must be called when a "hashes" message is received from the peer.
+// Hashes are verified with the uncle hashes in the message against the pieces root of the file.
+// Hashes that are not requested from the peer are ignored. They may arrive after the request is sent to another peer.
+func (d *PieceLayerDownloader) GotHashes(pe Peer, msg peerprotocol.HashesMessage) error {
+	req := msg.HashRequestMessage
+	if p, ok := d.requests[req]; !ok || p != pe {
+		return nil
+	}
+	if len(msg.Hashes) != int(req.Length+req.ProofLayers)*merkle.HashSize {
+		return errInvalidSize
+	}
+	length := int(req.Length) * merkle.HashSize
+	hashes, uncles := msg.Hashes[:length], msg.Hashes[length:]
+	subtreeRoot := merkle.Root(hashes, int(req.Length), nil)
+	root := merkle.ProofRoot(subtreeRoot, int(req.Index/req.Length), uncles)
+	if !bytes.Equal(root, req.PiecesRoot[:]) {
+		return errInvalidRoot
+	}
+	copy(d.layers[req.PiecesRoot].hashes[int(req.Index)*merkle.HashSize:], hashes)
+	delete(d.requests, req)
+	return nil
+}
+
+// Rejected must be called when a "hash reject" message is received from the peer.
+// The request is sent to another peer on next call to RequestHashes.
+func (d *PieceLayerDownloader) Rejected(pe Peer, req peerprotocol.HashRequestMessage) {
+	if p, ok := d.requests[req]; !ok || p != pe {
+		return
+	}
+	d.requests[req] = nil
+	m, ok := d.rejected[pe]
+	if !ok {
+		m = make(map[peerprotocol.HashRequestMessage]struct{})
+		d.rejected[pe] = m
+	}
+	m[req] = struct{}{}
+}
+
+// Snubbed must be called when the peer does not answer the requests in time.
+// Pending requests of the peer are treated as rejected.
+func (d *PieceLayerDownloader) Snubbed(pe Peer) {
+	for req, p := range d.requests {
+		if p == pe {
+			d.Rejected(pe, req)
+		}
+	}
+}
+
+// Disconnected must be called when the connection to the peer is closed.
+func (d *PieceLayerDownloader) Disconnected(pe Peer) {
+	for req, p := range d.requests {
+		if p == pe {
+			d.requests[req] = nil
+		}
+	}
+	delete(d.rejected, pe)
+}
+
+// Done returns true if all the hashes are received.
+func (d *PieceLayerDownloader) Done() bool {
+	return len(d.requests) == 0
+}
+
+// PieceLayers returns the bencoded "piece layers" dictionary that contains the received hashes.
+func (d *PieceLayerDownloader) PieceLayers() ([]byte, error) {
+	m := make(map[string][]byte, len(d.layers))
+	for root, l := range d.layers {
+		m[string(root[:])] = l.hashes[:l.numPieces*merkle.HashSize]
+	}
+	return bencode.EncodeBytes(m)
+}
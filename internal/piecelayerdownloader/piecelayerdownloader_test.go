@@ -0,0 +1,94 @@
+package piecelayerdownloader
+
+import (
+	"bytes"
+	"math/bits"
+	"testing"
+
+	"github.com/panzarasa/rain/internal/merkle"
+	"github.com/panzarasa/rain/internal/peerprotocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/zeebo/bencode"
+)
+
+const pieceLength = 32 * 1024
+
+type testPeer struct {
+	requests []peerprotocol.HashRequestMessage
+}
+
+func (p *testPeer) RequestHashes(msg peerprotocol.HashRequestMessage) {
+	p.requests = append(p.requests, msg)
+}
+
+// answer builds the "hashes" message for req from the piece layer.
+func answer(layer []byte, req peerprotocol.HashRequestMessage) peerprotocol.HashesMessage {
+	numLeaves := merkle.NumLeaves(len(layer) / merkle.HashSize)
+	layers := merkle.Layers(layer, numLeaves, merkle.PadHash(pieceLength/merkle.BlockSize))
+	hashes := append([]byte{}, layers[0][int(req.Index)*merkle.HashSize:int(req.Index+req.Length)*merkle.HashSize]...)
+	level := bits.TrailingZeros(uint(req.Length))
+	i := int(req.Index / req.Length)
+	for n := uint32(0); n < req.ProofLayers; n++ {
+		sibling := i ^ 1
+		hashes = append(hashes, layers[level][sibling*merkle.HashSize:(sibling+1)*merkle.HashSize]...)
+		i /= 2
+		level++
+	}
+	return peerprotocol.HashesMessage{HashRequestMessage: req, Hashes: hashes}
+}
+
+func TestDownload(t *testing.T) {
+	const numPieces = 1000
+	layer := merkle.BlockHashes(bytes.Repeat([]byte{1}, numPieces*merkle.BlockSize))
+	var root [32]byte
+	copy(root[:], merkle.Root(layer, merkle.NumLeaves(numPieces), merkle.PadHash(pieceLength/merkle.BlockSize)))
+
+	d := New(pieceLength, map[[32]byte]int{root: numPieces})
+	p1, p2 := new(testPeer), new(testPeer)
+	assert.Equal(t, 2, d.RequestHashes(p1))
+	assert.Equal(t, 0, d.RequestHashes(p2))
+	for _, req := range p1.requests {
+		assert.Equal(t, uint32(1), req.BaseLayer)
+		assert.Equal(t, uint32(maxRequestLength), req.Length)
+		assert.Equal(t, uint32(1), req.ProofLayers)
+	}
+
+	// Rejected request is sent to another peer.
+	d.Rejected(p1, p1.requests[0])
+	assert.Equal(t, 1, d.Pending(p1))
+	assert.Equal(t, 1, d.RequestHashes(p2))
+	assert.Equal(t, p1.requests[0], p2.requests[0])
+
+	// Hashes that do not match with the pieces root are not accepted.
+	bad := answer(layer, p1.requests[1])
+	bad.Hashes = append([]byte{}, bad.Hashes...)
+	bad.Hashes[0]++
+	assert.Equal(t, errInvalidRoot, d.GotHashes(p1, bad))
+	// Late answer from the peer that rejected the request is ignored.
+	assert.NoError(t, d.GotHashes(p1, answer(layer, p1.requests[0])))
+	assert.Equal(t, 1, d.Pending(p2))
+
+	assert.NoError(t, d.GotHashes(p1, answer(layer, p1.requests[1])))
+	assert.False(t, d.Done())
+	assert.NoError(t, d.GotHashes(p2, answer(layer, p2.requests[0])))
+	assert.True(t, d.Done())
+
+	b, err := d.PieceLayers()
+	assert.NoError(t, err)
+	var m map[string][]byte
+	assert.NoError(t, bencode.DecodeBytes(b, &m))
+	assert.Equal(t, layer, m[string(root[:])])
+}
+
+func TestDisconnected(t *testing.T) {
+	var root [32]byte
+	d := New(pieceLength, map[[32]byte]int{root: 2})
+	p1, p2 := new(testPeer), new(testPeer)
+	assert.Equal(t, 1, d.RequestHashes(p1))
+	d.Snubbed(p1)
+	assert.Equal(t, 0, d.RequestHashes(p1))
+	assert.Equal(t, 1, d.RequestHashes(p2))
+	d.Disconnected(p2)
+	assert.Equal(t, 0, d.Pending(p2))
+	assert.Equal(t, 0, d.RequestHashes(p1))
+}
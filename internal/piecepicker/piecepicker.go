@@ -17,6 +17,9 @@ These are the things to consider when selecting a piece for downloading:
 
   * Piece is done (hash checked and written to disk)
   * Piece is writing
+  * Piece is skipped (belongs only to files that are not selected for download)
+  * Priority of the piece (derived from file priorities and read-ahead windows of file readers)
+  * Sequential mode (pieces are picked in order of their indexes instead of rarity)
   * Peer has the piece
   * Peer is choking us
   * Piece is marked as allowed-fast
@@ -39,10 +42,13 @@ type PiecePicker struct {
 	maxDuplicateDownload int
 	available            uint32
 	endgame              bool
+	sequential           bool
 }
 
 type myPiece struct {
 	*piece.Piece
+	// Pieces with higher priority are downloaded first. Pieces with zero priority are not downloaded.
+	Priority  int
 	Having    peerset.PeerSet
 	Requested peerset.PeerSet
 	Snubbed   peerset.PeerSet
@@ -63,10 +69,15 @@ func (p *myPiece) StalledDownloads() int {
 	return p.Snubbed.Len() + p.Choked.Len()
 }
 
+// Skipped returns true if the piece is not going to be downloaded because of its priority.
+func (p *myPiece) Skipped() bool {
+	return p.Priority == 0
+}
+
 // AvailableForWebseed returns true if the piece can be downloaded from a webseed source.
 // If the piece is already requested from a peer, it does not become eligible for downloading from webseed until entering the endgame mode.
 func (p *myPiece) AvailableForWebseed(duplicate bool) bool {
-	if p.Done || p.Writing || p.RequestedWebseed != nil {
+	if p.Done || p.Writing || p.Skipped() || p.RequestedWebseed != nil {
 		return false
 	}
 	if !duplicate {
@@ -79,7 +90,7 @@ func (p *myPiece) AvailableForWebseed(duplicate bool) bool {
 func New(pieces []piece.Piece, maxDuplicateDownload int, webseedSources []*webseedsource.WebseedSource) *PiecePicker {
 	ps := make([]myPiece, len(pieces))
 	for i := range pieces {
-		ps[i] = myPiece{Piece: &pieces[i]}
+		ps[i] = myPiece{Piece: &pieces[i], Priority: 1}
 	}
 	sps := make([]*myPiece, len(ps))
 	sps2 := make([]*myPiece, len(ps))
@@ -131,6 +142,20 @@ func (p *PiecePicker) WebseedStopAt(src *webseedsource.WebseedSource, i uint32)
 	return false
 }
 
+// SetPriority sets the download priority of the piece at index.
+// Pieces with higher priority are picked before the others.
+// Pieces with zero priority are never picked.
+// All pieces have the same non-zero priority after New.
+func (p *PiecePicker) SetPriority(i uint32, priority int) {
+	p.pieces[i].Priority = priority
+}
+
+// SetSequential sets the picking order of pieces that have the same priority.
+// In sequential mode pieces are picked in order of their indexes, otherwise rarest pieces are picked first.
+func (p *PiecePicker) SetSequential(value bool) {
+	p.sequential = value
+}
+
 // Available returns the number of available pieces among the swarm.
 func (p *PiecePicker) Available() uint32 {
 	return p.available
@@ -263,7 +288,7 @@ func (p *PiecePicker) findPiece(pe *peer.Peer) (mp *myPiece, allowedFast bool) {
 func (p *PiecePicker) pickAllowedFast(pe *peer.Peer) *myPiece {
 	for _, pi := range pe.ReceivedAllowedFast.Pieces {
 		mp := &p.pieces[pi.Index]
-		if mp.Done || mp.Writing {
+		if mp.Done || mp.Writing || mp.Skipped() {
 			continue
 		}
 		if mp.Requested.Len() == 0 && mp.Having.Has(pe) {
@@ -274,15 +299,22 @@ func (p *PiecePicker) pickAllowedFast(pe *peer.Peer) *myPiece {
 }
 
 func (p *PiecePicker) pickRarest(pe *peer.Peer) *myPiece {
-	// Sort by rarity
+	// Sort by priority, then by index in sequential mode or by rarity otherwise
 	sort.Slice(p.piecesByAvailability, func(i, j int) bool {
-		return len(p.piecesByAvailability[i].Having.Peers) < len(p.piecesByAvailability[j].Having.Peers)
+		pi, pj := p.piecesByAvailability[i], p.piecesByAvailability[j]
+		if pi.Priority != pj.Priority {
+			return pi.Priority > pj.Priority
+		}
+		if p.sequential {
+			return pi.Index < pj.Index
+		}
+		return len(pi.Having.Peers) < len(pj.Having.Peers)
 	})
 	var picked *myPiece
 	var hasUnrequested bool
 	// Select unrequested piece
 	for _, mp := range p.piecesByAvailability {
-		if mp.Done || mp.Writing {
+		if mp.Done || mp.Writing || mp.Skipped() {
 			continue
 		}
 		if mp.Requested.Len() == 0 && mp.Having.Has(pe) {
@@ -300,13 +332,17 @@ func (p *PiecePicker) pickRarest(pe *peer.Peer) *myPiece {
 }
 
 func (p *PiecePicker) pickEndgame(pe *peer.Peer) *myPiece {
-	// Sort by request count
+	// Sort by priority, then by request count
 	sort.Slice(p.piecesByAvailability, func(i, j int) bool {
-		return p.piecesByAvailability[i].RunningDownloads() < p.piecesByAvailability[j].RunningDownloads()
+		pi, pj := p.piecesByAvailability[i], p.piecesByAvailability[j]
+		if pi.Priority != pj.Priority {
+			return pi.Priority > pj.Priority
+		}
+		return pi.RunningDownloads() < pj.RunningDownloads()
 	})
 	// Select unrequested piece
 	for _, mp := range p.piecesByAvailability {
-		if mp.Done || mp.Writing {
+		if mp.Done || mp.Writing || mp.Skipped() {
 			continue
 		}
 		if mp.Requested.Len() < p.maxDuplicateDownload && mp.Having.Has(pe) {
@@ -323,7 +359,7 @@ func (p *PiecePicker) pickStalled(pe *peer.Peer) *myPiece {
 	})
 	// Select unrequested piece
 	for _, mp := range p.piecesByStalled {
-		if mp.Done || mp.Writing {
+		if mp.Done || mp.Writing || mp.Skipped() {
 			continue
 		}
 		if mp.RunningDownloads() > 0 {
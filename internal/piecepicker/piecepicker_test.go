@@ -67,6 +67,57 @@ func TestPiecePicker(t *testing.T) {
 	assert.True(t, pp.endgame)
 }
 
+func TestPiecePickerPriority(t *testing.T) {
+	pieces := make([]piece.Piece, numPieces)
+	for i := range pieces {
+		pieces[i] = newPiece(i)
+	}
+	peers := make([]*peer.Peer, numPeers)
+	for i := range peers {
+		peers[i] = newPeer(i)
+	}
+	pp := New(pieces, 2, nil)
+	pp.SetPriority(0, 0)
+	pp.SetPriority(1, 0)
+	pp.SetPriority(5, 2)
+	for i := range pieces {
+		pp.HandleHave(peers[0], uint32(i))
+	}
+	pp.HandleHave(peers[1], 0)
+	pp.HandleHave(peers[1], 1)
+	pp.HandleHave(peers[2], 4)
+
+	assert.Equal(t, &pieces[5], pp.pickFor(peers[0]))
+	assert.Nil(t, pp.pickFor(peers[1]))
+	assert.False(t, pp.endgame)
+	assert.Equal(t, &pieces[4], pp.pickFor(peers[2]))
+}
+
+func TestPiecePickerSequential(t *testing.T) {
+	pieces := make([]piece.Piece, numPieces)
+	for i := range pieces {
+		pieces[i] = newPiece(i)
+	}
+	peers := make([]*peer.Peer, numPeers)
+	for i := range peers {
+		peers[i] = newPeer(i)
+	}
+	pp := New(pieces, 2, nil)
+	pp.SetSequential(true)
+	pp.SetPriority(5, 2)
+	for i := range pieces {
+		pp.HandleHave(peers[0], uint32(i))
+		pp.HandleHave(peers[2], uint32(i))
+	}
+	pp.HandleHave(peers[1], 3)
+	pp.HandleHave(peers[1], 6)
+
+	assert.Equal(t, &pieces[5], pp.pickFor(peers[0]))
+	assert.Equal(t, &pieces[3], pp.pickFor(peers[1]))
+	assert.Equal(t, &pieces[0], pp.pickFor(peers[2]))
+	assert.False(t, pp.endgame)
+}
+
 func newPiece(i int) piece.Piece {
 	return piece.Piece{Index: uint32(i)}
 }
@@ -0,0 +1,321 @@
+// Package proxy provides a dialer that opens TCP connections through a SOCKS5 or HTTP proxy server.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	errUnsupportedScheme = errors.New("unsupported proxy scheme (must be socks5 or http)")
+	errNoAcceptableAuth  = errors.New("no acceptable authentication method for socks5 proxy")
+	errAuthFailed        = errors.New("socks5 proxy authentication failed")
+	errInvalidVersion    = errors.New("invalid socks version in proxy response")
+)
+
+// Dialer opens connections through a proxy server.
+// Host names are sent to the proxy server without resolving, so no DNS query is made locally.
+type Dialer struct {
+	scheme   string
+	addr     string
+	username string
+	password string
+	hasAuth  bool
+}
+
+// New returns a new Dialer for the proxy at URL.
+// Supported schemes are "socks5" and "http". Credentials can be given in the URL as user info.
+func New(rawURL string) (*Dialer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dialer{scheme: u.Scheme}
+	var defaultPort string
+	switch u.Scheme {
+	case "socks5":
+		defaultPort = "1080"
+	case "http":
+		defaultPort = "80"
+	default:
+		return nil, errUnsupportedScheme
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("proxy host is empty")
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	d.addr = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		d.hasAuth = true
+		d.username = u.User.Username()
+		d.password, _ = u.User.Password()
+		if d.scheme == "socks5" && (len(d.username) > 255 || len(d.password) > 255) {
+			return nil, errors.New("socks5 username or password is too long")
+		}
+	}
+	return d, nil
+}
+
+// String returns the address of proxy server without credentials.
+func (d *Dialer) String() string {
+	return d.scheme + "://" + d.addr
+}
+
+// DialContext connects to the address through the proxy server. Only "tcp" networks are supported.
+// RemoteAddr of the returned connection is the address of the destination, not the proxy server.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("network not supported by proxy: %s", network)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %s", portStr)
+	}
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := d.handshake(ctx, conn, host, uint16(port))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+// handshake makes the proxy server connect to the destination.
+// Handshake is aborted when the context is done.
+func (d *Dialer) handshake(ctx context.Context, conn net.Conn, host string, port uint16) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	stopC := make(chan struct{})
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+		select {
+		case <-ctx.Done():
+			// Unblock pending reads and writes.
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-stopC:
+		}
+	}()
+
+	var r io.Reader = conn
+	var err error
+	switch d.scheme {
+	case "socks5":
+		err = d.connectSOCKS5(conn, host, port)
+	case "http":
+		r, err = d.connectHTTP(conn, host, port)
+	}
+	close(stopC)
+	<-doneC
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, r: r, raddr: remoteAddr(host, port)}, nil
+}
+
+func (d *Dialer) connectSOCKS5(conn net.Conn, host string, port uint16) error {
+	// Method selection
+	methods := []byte{0x00}
+	if d.hasAuth {
+		methods = append(methods, 0x02)
+	}
+	req := append([]byte{5, byte(len(methods))}, methods...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[0] != 5 {
+		return errInvalidVersion
+	}
+	switch resp[1] {
+	case 0x00:
+	case 0x02:
+		if !d.hasAuth {
+			return errNoAcceptableAuth
+		}
+		// Username/password authentication. See RFC 1929.
+		req = []byte{1, byte(len(d.username))}
+		req = append(req, d.username...)
+		req = append(req, byte(len(d.password)))
+		req = append(req, d.password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, resp[:]); err != nil {
+			return err
+		}
+		if resp[1] != 0 {
+			return errAuthFailed
+		}
+	default:
+		return errNoAcceptableAuth
+	}
+
+	// Connect request
+	req = []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("host name is too long for socks5")
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 1)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 4)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != 5 {
+		return errInvalidVersion
+	}
+	if hdr[1] != 0 {
+		return socks5Error(hdr[1])
+	}
+	// Skip bound address and port
+	var n int
+	switch hdr[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return fmt.Errorf("invalid address type in socks5 response: %d", hdr[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
+
+func (d *Dialer) connectHTTP(conn net.Conn, host string, port uint16) (io.Reader, error) {
+	hostport := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: hostport},
+		Host:   hostport,
+		Header: make(http.Header),
+	}
+	if d.hasAuth {
+		cred := base64.StdEncoding.EncodeToString([]byte(d.username + ":" + d.password))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http proxy returned status: %s", resp.Status)
+	}
+	if br.Buffered() == 0 {
+		return conn, nil
+	}
+	// Proxy server may have sent some data from the destination.
+	return io.MultiReader(io.LimitReader(br, int64(br.Buffered())), conn), nil
+}
+
+type socks5Error byte
+
+func (e socks5Error) Error() string {
+	var s string
+	switch e {
+	case 1:
+		s = "general failure"
+	case 2:
+		s = "connection not allowed by ruleset"
+	case 3:
+		s = "network unreachable"
+	case 4:
+		s = "host unreachable"
+	case 5:
+		s = "connection refused"
+	case 6:
+		s = "TTL expired"
+	case 7:
+		s = "command not supported"
+	case 8:
+		s = "address type not supported"
+	default:
+		s = "unknown error " + strconv.Itoa(int(e))
+	}
+	return "socks5 proxy: " + s
+}
+
+// proxyConn reports the address of the destination as remote address.
+type proxyConn struct {
+	net.Conn
+	r     io.Reader
+	raddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func remoteAddr(host string, port uint16) net.Addr {
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.TCPAddr{IP: ip, Port: int(port)}
+	}
+	return hostAddr(net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
+
+// hostAddr is the address of a destination that is given with a host name.
+type hostAddr string
+
+func (a hostAddr) Network() string { return "tcp" }
+func (a hostAddr) String() string  { return string(a) }
@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startEchoServer starts a TCP server that writes back what it reads.
+func startEchoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+// startSOCKS5Server starts a minimal SOCKS5 server that requires user/pass authentication.
+// Host names are resolved to the loopback address.
+func startSOCKS5Server(t *testing.T, user, pass string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn, user, pass)
+		}
+	}()
+	return l
+}
+
+func serveSOCKS5(conn net.Conn, user, pass string) {
+	defer conn.Close()
+	var b [2]byte
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, b[1])); err != nil {
+		return
+	}
+	_, _ = conn.Write([]byte{5, 2})
+	// Auth
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return
+	}
+	u := make([]byte, b[1])
+	if _, err := io.ReadFull(conn, u); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, b[:1]); err != nil {
+		return
+	}
+	p := make([]byte, b[0])
+	if _, err := io.ReadFull(conn, p); err != nil {
+		return
+	}
+	if string(u) != user || string(p) != pass {
+		_, _ = conn.Write([]byte{1, 1})
+		return
+	}
+	_, _ = conn.Write([]byte{1, 0})
+	// Connect
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return
+	}
+	var host string
+	switch hdr[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		if _, err := io.ReadFull(conn, b[:1]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, make([]byte, b[0])); err != nil {
+			return
+		}
+		host = "127.0.0.1"
+	default:
+		return
+	}
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return
+	}
+	port := binary.BigEndian.Uint16(b[:])
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	go func() { _, _ = io.Copy(target, conn) }()
+	_, _ = io.Copy(conn, target)
+}
+
+// startHTTPProxy starts a minimal HTTP proxy that only supports the CONNECT method.
+func startHTTPProxy(t *testing.T, auth string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != auth {
+					_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer target.Close()
+				_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+	return l
+}
+
+func testEcho(t *testing.T, d *Dialer, addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assert.Equal(t, addr, conn.RemoteAddr().String())
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 5)
+	_, err = io.ReadFull(conn, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestSOCKS5(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	px := startSOCKS5Server(t, "user", "pass")
+	defer px.Close()
+
+	d, err := New("socks5://user:pass@" + px.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEcho(t, d, echo.Addr().String())
+
+	_, port, _ := net.SplitHostPort(echo.Addr().String())
+	testEcho(t, d, net.JoinHostPort("localhost", port))
+
+	d, err = New("socks5://user:wrong@" + px.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.DialContext(context.Background(), "tcp", echo.Addr().String())
+	assert.Equal(t, errAuthFailed, err)
+}
+
+func TestHTTP(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	px := startHTTPProxy(t, "Basic dXNlcjpwYXNz")
+	defer px.Close()
+
+	d, err := New("http://user:pass@" + px.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEcho(t, d, echo.Addr().String())
+
+	d, err = New("http://" + px.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.DialContext(context.Background(), "tcp", echo.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestDialCancel(t *testing.T) {
+	// Server accepts connections but never responds.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d, err := New("socks5://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = d.DialContext(ctx, "tcp", "127.0.0.1:1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New("ftp://127.0.0.1")
+	assert.Equal(t, errUnsupportedScheme, err)
+	_, err = New("socks5://")
+	assert.Error(t, err)
+	d, err := New("socks5://127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "socks5://127.0.0.1:1080", d.String())
+}
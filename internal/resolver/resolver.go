@@ -13,13 +13,13 @@ import (
 var (
 	// ErrBlocked indicates that the resolved IP is blocked in the blocklist.
 	ErrBlocked = errors.New("ip is blocked")
-	// ErrNotIPv4Address indicates that the resolved IP address is not IPv4.
-	ErrNotIPv4Address = errors.New("not ipv4 address")
+	// ErrNoAddress indicates that the host does not have any IP address.
+	ErrNoAddress = errors.New("no ip address")
 	// ErrInvalidPort indicates that the port number in the address is invalid.
 	ErrInvalidPort = errors.New("invalid port number")
 )
 
-// Resolve `hostport` to an IPv4 address.
+// Resolve `hostport` to an IP address.
 func Resolve(ctx context.Context, hostport string, timeout time.Duration, bl *blocklist.Blocklist) (net.IP, int, error) {
 	host, portStr, err := net.SplitHostPort(hostport)
 	if err != nil {
@@ -34,23 +34,24 @@ func Resolve(ctx context.Context, hostport string, timeout time.Duration, bl *bl
 	}
 	ip := net.ParseIP(host)
 	if ip == nil {
-		ip, err = ResolveIPv4(ctx, timeout, host)
+		ip, err = ResolveIP(ctx, timeout, host)
 		if err != nil {
 			return nil, 0, err
 		}
 	}
-	i4 := ip.To4()
-	if i4 == nil {
-		return nil, 0, ErrNotIPv4Address
+	if i4 := ip.To4(); i4 != nil {
+		ip = i4
 	}
 	if bl != nil && bl.Blocked(ip) {
 		return nil, 0, ErrBlocked
 	}
-	return i4, port, nil
+	return ip, port, nil
 }
 
-// ResolveIPv4 resolves `host` to and IPv4 address.
-func ResolveIPv4(ctx context.Context, timeout time.Duration, host string) (net.IP, error) {
+// ResolveIP resolves `host` to an IP address.
+// Only addresses that have a route from the local host are considered, so IPv6 addresses are returned on IPv6-only hosts.
+// IPv4 addresses are preferred over IPv6 addresses if both are reachable.
+func ResolveIP(ctx context.Context, timeout time.Duration, host string) (net.IP, error) {
 	var cancel func()
 	ctx, cancel = context.WithTimeout(ctx, timeout)
 	defer cancel()
@@ -58,11 +59,38 @@ func ResolveIPv4(ctx context.Context, timeout time.Duration, host string) (net.I
 	if err != nil {
 		return nil, err
 	}
+	if len(addrs) == 0 {
+		return nil, ErrNoAddress
+	}
+	var routable net.IP
 	for _, ia := range addrs {
-		i4 := ia.IP.To4()
-		if i4 != nil {
+		if !hasRoute(ia.IP) {
+			continue
+		}
+		if i4 := ia.IP.To4(); i4 != nil {
 			return i4, nil
 		}
+		if routable == nil {
+			routable = ia.IP
+		}
+	}
+	if routable != nil {
+		return routable, nil
+	}
+	// Let the caller get the error from dialing.
+	if i4 := addrs[0].IP.To4(); i4 != nil {
+		return i4, nil
+	}
+	return addrs[0].IP, nil
+}
+
+// hasRoute returns true if the local host has a route to ip.
+// Connecting a UDP socket selects a route without sending any packet.
+func hasRoute(ip net.IP) bool {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: 9})
+	if err != nil {
+		return false
 	}
-	return nil, ErrNotIPv4Address
+	_ = conn.Close()
+	return true
 }
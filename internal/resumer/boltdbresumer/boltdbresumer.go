@@ -3,11 +3,13 @@ package boltdbresumer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"runtime/debug"
 	"strconv"
 	"time"
 
+	"github.com/panzarasa/rain/internal/resumer"
 	"go.etcd.io/bbolt"
 )
 
@@ -21,6 +23,7 @@ var Keys = struct {
 	FixedPeers      []byte
 	Dest            []byte
 	Info            []byte
+	PieceLayers     []byte
 	Bitfield        []byte
 	AddedAt         []byte
 	BytesDownloaded []byte
@@ -28,6 +31,21 @@ var Keys = struct {
 	BytesWasted     []byte
 	SeededFor       []byte
 	Started         []byte
+	FilePriorities  []byte
+	Sequential      []byte
+	SpeedLimitDown  []byte
+	SpeedLimitUp    []byte
+	SeedRatioLimit  []byte
+	SeedRatioAction []byte
+	SeedTimeLimit   []byte
+	SeedTimeAction  []byte
+	QueuePosition   []byte
+	DataDir         []byte
+	PublicKey       []byte
+	Salt            []byte
+	Seq             []byte
+	Storage         []byte
+	PreviousVersion []byte
 }{
 	InfoHash:        []byte("info_hash"),
 	Port:            []byte("port"),
@@ -37,6 +55,7 @@ var Keys = struct {
 	FixedPeers:      []byte("fixed_peers"),
 	Dest:            []byte("dest"),
 	Info:            []byte("info"),
+	PieceLayers:     []byte("piece_layers"),
 	Bitfield:        []byte("bitfield"),
 	AddedAt:         []byte("added_at"),
 	BytesDownloaded: []byte("bytes_downloaded"),
@@ -44,31 +63,96 @@ var Keys = struct {
 	BytesWasted:     []byte("bytes_wasted"),
 	SeededFor:       []byte("seeded_for"),
 	Started:         []byte("started"),
+	FilePriorities:  []byte("file_priorities"),
+	Sequential:      []byte("sequential"),
+	SpeedLimitDown:  []byte("speed_limit_download"),
+	SpeedLimitUp:    []byte("speed_limit_upload"),
+	SeedRatioLimit:  []byte("seed_ratio_limit"),
+	SeedRatioAction: []byte("seed_ratio_action"),
+	SeedTimeLimit:   []byte("seed_time_limit"),
+	SeedTimeAction:  []byte("seed_time_action"),
+	QueuePosition:   []byte("queue_position"),
+	DataDir:         []byte("data_dir"),
+	PublicKey:       []byte("public_key"),
+	Salt:            []byte("salt"),
+	Seq:             []byte("seq"),
+	Storage:         []byte("storage"),
+	PreviousVersion: []byte("previous_version_dir"),
 }
 
+// ErrLocked is returned from Open if the database file is used by another process.
+var ErrLocked = errors.New("resume database is locked by another process")
+
+var (
+	torrentsBucket = []byte("torrents")
+	sessionBucket  = []byte("session")
+)
+
 // Resumer contains methods for saving/loading resume information of a torrent to a BoltDB database.
 type Resumer struct {
 	db     *bbolt.DB
 	bucket []byte
 }
 
-// New returns a new Resumer.
-func New(db *bbolt.DB, bucket []byte) (*Resumer, error) {
-	err := db.Update(func(tx *bbolt.Tx) error {
-		_, err2 := tx.CreateBucketIfNotExists(bucket)
+var _ resumer.Resumer = (*Resumer)(nil)
+
+// Open the database file at path. The file is created if it does not exist.
+func Open(path string) (*Resumer, error) {
+	db, err := bbolt.Open(path, 0640, &bbolt.Options{Timeout: time.Second})
+	if err == bbolt.ErrTimeout {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err2 := tx.CreateBucketIfNotExists(sessionBucket)
+		if err2 != nil {
+			return err2
+		}
+		_, err2 = tx.CreateBucketIfNotExists(torrentsBucket)
 		return err2
 	})
 	if err != nil {
+		_ = db.Close()
 		return nil, err
 	}
 	return &Resumer{
 		db:     db,
-		bucket: bucket,
+		bucket: torrentsBucket,
 	}, nil
 }
 
+// Close the database file.
+func (r *Resumer) Close() error {
+	return r.db.Close()
+}
+
+// List returns the IDs of all torrents in the database.
+func (r *Resumer) List() ([]string, error) {
+	var ids []string
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(r.bucket).ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// Delete the resume data of a torrent.
+func (r *Resumer) Delete(torrentID string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		err := tx.Bucket(r.bucket).DeleteBucket([]byte(torrentID))
+		if err == bbolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
 // Write the torrent spec for torrent with `torrentID`.
-func (r *Resumer) Write(torrentID string, spec *Spec) error {
+func (r *Resumer) Write(torrentID string, spec *resumer.Spec) error {
 	port := strconv.Itoa(spec.Port)
 	trackers, err := json.Marshal(spec.Trackers)
 	if err != nil {
@@ -82,6 +166,10 @@ func (r *Resumer) Write(torrentID string, spec *Spec) error {
 	if err != nil {
 		return err
 	}
+	filePriorities, err := json.Marshal(spec.FilePriorities)
+	if err != nil {
+		return err
+	}
 	return r.db.Update(func(tx *bbolt.Tx) error {
 		b, err := tx.Bucket(r.bucket).CreateBucketIfNotExists([]byte(torrentID))
 		if err != nil {
@@ -94,6 +182,7 @@ func (r *Resumer) Write(torrentID string, spec *Spec) error {
 		_ = b.Put(Keys.URLList, urlList)
 		_ = b.Put(Keys.FixedPeers, fixedPeers)
 		_ = b.Put(Keys.Info, spec.Info)
+		_ = b.Put(Keys.PieceLayers, spec.PieceLayers)
 		_ = b.Put(Keys.Bitfield, spec.Bitfield)
 		_ = b.Put(Keys.AddedAt, []byte(spec.AddedAt.Format(time.RFC3339)))
 		_ = b.Put(Keys.BytesDownloaded, []byte(strconv.FormatInt(spec.BytesDownloaded, 10)))
@@ -101,28 +190,50 @@ func (r *Resumer) Write(torrentID string, spec *Spec) error {
 		_ = b.Put(Keys.BytesWasted, []byte(strconv.FormatInt(spec.BytesWasted, 10)))
 		_ = b.Put(Keys.SeededFor, []byte(spec.SeededFor.String()))
 		_ = b.Put(Keys.Started, []byte(strconv.FormatBool(spec.Started)))
+		_ = b.Put(Keys.FilePriorities, filePriorities)
+		_ = b.Put(Keys.Sequential, []byte(strconv.FormatBool(spec.Sequential)))
+		_ = b.Put(Keys.SpeedLimitDown, []byte(strconv.FormatInt(spec.SpeedLimitDownload, 10)))
+		_ = b.Put(Keys.SpeedLimitUp, []byte(strconv.FormatInt(spec.SpeedLimitUpload, 10)))
+		_ = b.Put(Keys.SeedRatioLimit, []byte(strconv.FormatFloat(spec.SeedRatioLimit, 'f', -1, 64)))
+		_ = b.Put(Keys.SeedRatioAction, []byte(strconv.Itoa(spec.SeedRatioAction)))
+		_ = b.Put(Keys.SeedTimeLimit, []byte(spec.SeedTimeLimit.String()))
+		_ = b.Put(Keys.SeedTimeAction, []byte(strconv.Itoa(spec.SeedTimeAction)))
+		_ = b.Put(Keys.QueuePosition, []byte(strconv.Itoa(spec.QueuePosition)))
+		_ = b.Put(Keys.DataDir, []byte(spec.DataDir))
+		_ = b.Put(Keys.PublicKey, spec.PublicKey)
+		_ = b.Put(Keys.Salt, spec.Salt)
+		_ = b.Put(Keys.Seq, []byte(strconv.FormatInt(spec.Seq, 10)))
+		_ = b.Put(Keys.Storage, []byte(spec.Storage))
+		_ = b.Put(Keys.PreviousVersion, []byte(spec.PreviousVersionDir))
 		return nil
 	})
 }
 
-// WriteInfo writes only the info dict of a torrent.
-func (r *Resumer) WriteInfo(torrentID string, value []byte) error {
+// WriteInfo writes only the info dict and piece layers of a torrent.
+func (r *Resumer) WriteInfo(torrentID string, info, pieceLayers []byte) error {
 	return r.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
 		if b == nil {
 			return nil
 		}
-		return b.Put(Keys.Info, value)
+		err := b.Put(Keys.Info, info)
+		if err != nil {
+			return err
+		}
+		return b.Put(Keys.PieceLayers, pieceLayers)
 	})
 }
 
-// WriteBitfield writes only bitfield of a torrent.
+// WriteBitfield writes only bitfield of a torrent. Nil value deletes the bitfield.
 func (r *Resumer) WriteBitfield(torrentID string, value []byte) error {
 	return r.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
 		if b == nil {
 			return nil
 		}
+		if value == nil {
+			return b.Delete(Keys.Bitfield)
+		}
 		return b.Put(Keys.Bitfield, value)
 	})
 }
@@ -138,7 +249,152 @@ func (r *Resumer) WriteStarted(torrentID string, value bool) error {
 	})
 }
 
-func (r *Resumer) Read(torrentID string) (spec *Spec, err error) {
+// WriteFilePriorities writes only the file priorities of a torrent.
+func (r *Resumer) WriteFilePriorities(torrentID string, value []int) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		bk := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if bk == nil {
+			return nil
+		}
+		return bk.Put(Keys.FilePriorities, b)
+	})
+}
+
+// WriteSequential writes only the sequential download mode of a torrent.
+func (r *Resumer) WriteSequential(torrentID string, value bool) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		return b.Put(Keys.Sequential, []byte(strconv.FormatBool(value)))
+	})
+}
+
+// WriteSpeedLimit writes only the download and upload speed limits of a torrent.
+func (r *Resumer) WriteSpeedLimit(torrentID string, download, upload int64) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		err := b.Put(Keys.SpeedLimitDown, []byte(strconv.FormatInt(download, 10)))
+		if err != nil {
+			return err
+		}
+		return b.Put(Keys.SpeedLimitUp, []byte(strconv.FormatInt(upload, 10)))
+	})
+}
+
+// WriteQueuePosition writes only the queue position of a torrent.
+func (r *Resumer) WriteQueuePosition(torrentID string, value int) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		return b.Put(Keys.QueuePosition, []byte(strconv.Itoa(value)))
+	})
+}
+
+// WriteDataDir writes only the data directory of a torrent.
+func (r *Resumer) WriteDataDir(torrentID string, value string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		return b.Put(Keys.DataDir, []byte(value))
+	})
+}
+
+// WriteMutable writes only the public key, salt and sequence number of the mutable torrent item in DHT.
+func (r *Resumer) WriteMutable(torrentID string, publicKey, salt []byte, seq int64) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		err := b.Put(Keys.PublicKey, publicKey)
+		if err != nil {
+			return err
+		}
+		err = b.Put(Keys.Salt, salt)
+		if err != nil {
+			return err
+		}
+		return b.Put(Keys.Seq, []byte(strconv.FormatInt(seq, 10)))
+	})
+}
+
+// WriteTrackers writes only the trackers of a torrent.
+func (r *Resumer) WriteTrackers(torrentID string, value [][]string) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		bk := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if bk == nil {
+			return nil
+		}
+		return bk.Put(Keys.Trackers, b)
+	})
+}
+
+// WriteStats writes the stats of multiple torrents in a single transaction.
+func (r *Resumer) WriteStats(stats map[string]resumer.StatsUpdate) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		for id, s := range stats {
+			b := tx.Bucket(r.bucket).Bucket([]byte(id))
+			if b == nil {
+				continue
+			}
+			_ = b.Put(Keys.BytesDownloaded, []byte(strconv.FormatInt(s.BytesDownloaded, 10)))
+			_ = b.Put(Keys.BytesUploaded, []byte(strconv.FormatInt(s.BytesUploaded, 10)))
+			_ = b.Put(Keys.BytesWasted, []byte(strconv.FormatInt(s.BytesWasted, 10)))
+			_ = b.Put(Keys.SeededFor, []byte(time.Duration(s.SeededFor).String()))
+			if s.Bitfield != nil {
+				_ = b.Put(Keys.Bitfield, s.Bitfield)
+			}
+		}
+		return nil
+	})
+}
+
+// ReadSessionValue returns the value of a session wide key. Returns nil if the key does not exist.
+func (r *Resumer) ReadSessionValue(key string) ([]byte, error) {
+	var val []byte
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(sessionBucket).Get([]byte(key))
+		if b != nil {
+			val = make([]byte, len(b))
+			copy(val, b)
+		}
+		return nil
+	})
+	return val, err
+}
+
+// WriteSessionValues writes session wide keys in a single transaction.
+func (r *Resumer) WriteSessionValues(values map[string][]byte) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(sessionBucket)
+		for k, v := range values {
+			err := b.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Resumer) Read(torrentID string) (spec *resumer.Spec, err error) {
 	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
 	defer func() {
 		if r := recover(); r != nil {
@@ -156,7 +412,7 @@ func (r *Resumer) Read(torrentID string) (spec *Spec, err error) {
 			return fmt.Errorf("key not found: %q", string(Keys.InfoHash))
 		}
 
-		spec = new(Spec)
+		spec = new(resumer.Spec)
 		spec.InfoHash = make([]byte, len(value))
 		copy(spec.InfoHash, value)
 
@@ -221,6 +477,12 @@ func (r *Resumer) Read(torrentID string) (spec *Spec, err error) {
 			copy(spec.Info, value)
 		}
 
+		value = b.Get(Keys.PieceLayers)
+		if value != nil {
+			spec.PieceLayers = make([]byte, len(value))
+			copy(spec.PieceLayers, value)
+		}
+
 		value = b.Get(Keys.Bitfield)
 		if value != nil {
 			spec.Bitfield = make([]byte, len(value))
@@ -275,6 +537,113 @@ func (r *Resumer) Read(torrentID string) (spec *Spec, err error) {
 			}
 		}
 
+		value = b.Get(Keys.Sequential)
+		if value != nil {
+			spec.Sequential, err = strconv.ParseBool(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SpeedLimitDown)
+		if value != nil {
+			spec.SpeedLimitDownload, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SpeedLimitUp)
+		if value != nil {
+			spec.SpeedLimitUpload, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SeedRatioLimit)
+		if value != nil {
+			spec.SeedRatioLimit, err = strconv.ParseFloat(string(value), 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SeedRatioAction)
+		if value != nil {
+			spec.SeedRatioAction, err = strconv.Atoi(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SeedTimeLimit)
+		if value != nil {
+			spec.SeedTimeLimit, err = time.ParseDuration(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SeedTimeAction)
+		if value != nil {
+			spec.SeedTimeAction, err = strconv.Atoi(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.QueuePosition)
+		if value != nil {
+			spec.QueuePosition, err = strconv.Atoi(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.DataDir)
+		if value != nil {
+			spec.DataDir = string(value)
+		}
+
+		value = b.Get(Keys.PublicKey)
+		if len(value) > 0 {
+			spec.PublicKey = make([]byte, len(value))
+			copy(spec.PublicKey, value)
+		}
+
+		value = b.Get(Keys.Salt)
+		if len(value) > 0 {
+			spec.Salt = make([]byte, len(value))
+			copy(spec.Salt, value)
+		}
+
+		value = b.Get(Keys.Seq)
+		if value != nil {
+			spec.Seq, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.Storage)
+		if value != nil {
+			spec.Storage = string(value)
+		}
+
+		value = b.Get(Keys.PreviousVersion)
+		if value != nil {
+			spec.PreviousVersionDir = string(value)
+		}
+
+		value = b.Get(Keys.FilePriorities)
+		if value != nil {
+			err = json.Unmarshal(value, &spec.FilePriorities)
+			if err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
 	return
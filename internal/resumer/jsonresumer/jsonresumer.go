@@ -0,0 +1,259 @@
+// Package jsonresumer provides a Resumer implementation that keeps the resume data in a directory of JSON files.
+// Files can be read, diffed and backed up while the Session is running.
+package jsonresumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/panzarasa/rain/internal/resumer"
+)
+
+const (
+	torrentsDir = "torrents"
+	sessionDir  = "session"
+	ext         = ".json"
+)
+
+// Resumer keeps the spec of each torrent in "<dir>/torrents/<id>.json" and session values in "<dir>/session/<key>".
+// Files are replaced atomically, so a reader never sees a partially written file.
+type Resumer struct {
+	dir string
+	// m serializes read-modify-write cycles of partial updates.
+	m sync.Mutex
+}
+
+var _ resumer.Resumer = (*Resumer)(nil)
+
+// Open the database directory at dir. The directory is created if it does not exist.
+func Open(dir string) (*Resumer, error) {
+	for _, d := range []string{torrentsDir, sessionDir} {
+		err := os.MkdirAll(filepath.Join(dir, d), 0750)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Resumer{dir: dir}, nil
+}
+
+// Close does nothing. All writes are done when the methods return.
+func (r *Resumer) Close() error {
+	return nil
+}
+
+func (r *Resumer) torrentPath(torrentID string) string {
+	return filepath.Join(r.dir, torrentsDir, torrentID+ext)
+}
+
+// List returns the IDs of all torrents in the directory.
+func (r *Resumer) List() ([]string, error) {
+	fis, err := ioutil.ReadDir(filepath.Join(r.dir, torrentsDir))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ext) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(fi.Name(), ext))
+	}
+	return ids, nil
+}
+
+// Read the spec of a torrent.
+func (r *Resumer) Read(torrentID string) (*resumer.Spec, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	spec, err := r.read(torrentID)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("torrent not found: %q", torrentID)
+	}
+	return spec, err
+}
+
+// Write replaces the spec of a torrent.
+func (r *Resumer) Write(torrentID string, spec *resumer.Spec) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.write(torrentID, spec)
+}
+
+// Delete the spec of a torrent.
+func (r *Resumer) Delete(torrentID string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	err := os.Remove(r.torrentPath(torrentID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *Resumer) read(torrentID string) (*resumer.Spec, error) {
+	b, err := ioutil.ReadFile(r.torrentPath(torrentID))
+	if err != nil {
+		return nil, err
+	}
+	spec := new(resumer.Spec)
+	err = json.Unmarshal(b, spec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read torrent %q: %s", torrentID, err)
+	}
+	return spec, nil
+}
+
+func (r *Resumer) write(torrentID string, spec *resumer.Spec) error {
+	b, err := json.MarshalIndent(spec, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(r.torrentPath(torrentID), append(b, '\n'))
+}
+
+// update reads the spec of a torrent, calls f and writes the spec back. Missing torrents are ignored.
+func (r *Resumer) update(torrentID string, f func(spec *resumer.Spec)) error {
+	spec, err := r.read(torrentID)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f(spec)
+	return r.write(torrentID, spec)
+}
+
+func (r *Resumer) updateLocked(torrentID string, f func(spec *resumer.Spec)) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.update(torrentID, f)
+}
+
+// WriteInfo writes only the info dict and piece layers of a torrent.
+func (r *Resumer) WriteInfo(torrentID string, info, pieceLayers []byte) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) {
+		spec.Info = info
+		spec.PieceLayers = pieceLayers
+	})
+}
+
+// WriteBitfield writes only bitfield of a torrent. Nil value deletes the bitfield.
+func (r *Resumer) WriteBitfield(torrentID string, value []byte) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) { spec.Bitfield = value })
+}
+
+// WriteStarted writes the start status of a torrent.
+func (r *Resumer) WriteStarted(torrentID string, value bool) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) { spec.Started = value })
+}
+
+// WriteFilePriorities writes only the file priorities of a torrent.
+func (r *Resumer) WriteFilePriorities(torrentID string, value []int) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) { spec.FilePriorities = value })
+}
+
+// WriteSequential writes only the sequential download mode of a torrent.
+func (r *Resumer) WriteSequential(torrentID string, value bool) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) { spec.Sequential = value })
+}
+
+// WriteSpeedLimit writes only the download and upload speed limits of a torrent.
+func (r *Resumer) WriteSpeedLimit(torrentID string, download, upload int64) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) {
+		spec.SpeedLimitDownload = download
+		spec.SpeedLimitUpload = upload
+	})
+}
+
+// WriteQueuePosition writes only the queue position of a torrent.
+func (r *Resumer) WriteQueuePosition(torrentID string, value int) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) { spec.QueuePosition = value })
+}
+
+// WriteDataDir writes only the data directory of a torrent.
+func (r *Resumer) WriteDataDir(torrentID string, value string) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) { spec.DataDir = value })
+}
+
+// WriteMutable writes only the public key, salt and sequence number of the mutable torrent item in DHT.
+func (r *Resumer) WriteMutable(torrentID string, publicKey, salt []byte, seq int64) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) {
+		spec.PublicKey = publicKey
+		spec.Salt = salt
+		spec.Seq = seq
+	})
+}
+
+// WriteTrackers writes only the trackers of a torrent.
+func (r *Resumer) WriteTrackers(torrentID string, value [][]string) error {
+	return r.updateLocked(torrentID, func(spec *resumer.Spec) { spec.Trackers = value })
+}
+
+// WriteStats writes the stats of multiple torrents.
+func (r *Resumer) WriteStats(stats map[string]resumer.StatsUpdate) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for id, s := range stats {
+		err := r.update(id, func(spec *resumer.Spec) {
+			spec.BytesDownloaded = s.BytesDownloaded
+			spec.BytesUploaded = s.BytesUploaded
+			spec.BytesWasted = s.BytesWasted
+			spec.SeededFor = time.Duration(s.SeededFor)
+			if s.Bitfield != nil {
+				spec.Bitfield = s.Bitfield
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSessionValue returns the value of a session wide key. Returns nil if the key does not exist.
+func (r *Resumer) ReadSessionValue(key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(r.dir, sessionDir, key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// WriteSessionValues writes each session wide key into a separate file.
+func (r *Resumer) WriteSessionValues(values map[string][]byte) error {
+	for k, v := range values {
+		err := writeFile(filepath.Join(r.dir, sessionDir, k), v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes data to a temporary file and renames it to name.
+func writeFile(name string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640) // nolint: gosec
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,33 @@
+package jsonresumer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/panzarasa/rain/internal/resumer/resumertest"
+)
+
+func TestResumer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rain-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	resumertest.Run(t, r)
+
+	// No temporary files are left after writes.
+	fis, err := ioutil.ReadDir(filepath.Join(dir, torrentsDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != "b.json" {
+		t.Fatalf("unexpected files: %v", fis)
+	}
+}
@@ -1,6 +1,40 @@
 // Package resumer contains an interface that is used by torrent package for resuming an existing download.
 package resumer
 
+// Resumer saves the resume data of torrents and session wide values such as the cached blocklist.
+// Partial writes for a torrent that does not exist are ignored.
+type Resumer interface {
+	// List returns the IDs of all torrents.
+	List() ([]string, error)
+	// Read returns the resume data of a torrent.
+	Read(torrentID string) (*Spec, error)
+	// Write replaces the resume data of a torrent.
+	Write(torrentID string, spec *Spec) error
+	// Delete removes the resume data of a torrent.
+	Delete(torrentID string) error
+
+	// WriteInfo writes the info dict and "piece layers" of a torrent that is received from peers.
+	WriteInfo(torrentID string, info, pieceLayers []byte) error
+	// WriteBitfield writes the bitfield of a torrent. Nil value deletes the bitfield.
+	WriteBitfield(torrentID string, value []byte) error
+	WriteStarted(torrentID string, value bool) error
+	WriteFilePriorities(torrentID string, value []int) error
+	WriteSequential(torrentID string, value bool) error
+	WriteSpeedLimit(torrentID string, download, upload int64) error
+	WriteQueuePosition(torrentID string, value int) error
+	WriteDataDir(torrentID string, value string) error
+	WriteMutable(torrentID string, publicKey, salt []byte, seq int64) error
+	WriteTrackers(torrentID string, value [][]string) error
+	// WriteStats writes the stats of multiple torrents at once. It is called periodically for all torrents.
+	WriteStats(stats map[string]StatsUpdate) error
+
+	// ReadSessionValue returns nil if there is no value for key.
+	ReadSessionValue(key string) ([]byte, error)
+	WriteSessionValues(values map[string][]byte) error
+
+	Close() error
+}
+
 // Stats of a torrent.
 type Stats struct {
 	BytesDownloaded int64
@@ -8,3 +42,10 @@ type Stats struct {
 	BytesWasted     int64
 	SeededFor       int64 // time.Duration
 }
+
+// StatsUpdate contains the values of a torrent that are saved periodically.
+type StatsUpdate struct {
+	Stats
+	// Bitfield is not written if nil.
+	Bitfield []byte
+}
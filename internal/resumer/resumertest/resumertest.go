@@ -0,0 +1,146 @@
+// Package resumertest contains tests that are run against each Resumer implementation.
+package resumertest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/panzarasa/rain/internal/resumer"
+)
+
+// Run tests the behavior of an empty Resumer that is expected by the torrent package.
+func Run(t *testing.T, r resumer.Resumer) {
+	ids, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+
+	spec := &resumer.Spec{
+		InfoHash: []byte{1, 2, 3},
+		Port:     6881,
+		Name:     "foo",
+		Trackers: [][]string{{"http://tracker"}},
+		Info:     []byte("info"),
+		Bitfield: []byte{0xff},
+		AddedAt:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Started:  true,
+		Storage:  "file",
+
+		PreviousVersionDir: "/old",
+	}
+	err = r.Write("a", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Write("b", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err = r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+
+	s, err := r.Read("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.InfoHash, spec.InfoHash) || s.Port != spec.Port || s.Name != spec.Name ||
+		!bytes.Equal(s.Info, spec.Info) || !bytes.Equal(s.Bitfield, spec.Bitfield) ||
+		!s.AddedAt.Equal(spec.AddedAt) || !s.Started || s.Storage != spec.Storage || s.PreviousVersionDir != spec.PreviousVersionDir ||
+		!reflect.DeepEqual(s.Trackers, spec.Trackers) {
+		t.Fatalf("unexpected spec: %#v", s)
+	}
+
+	// Partial writes
+	must(t, r.WriteStarted("a", false))
+	must(t, r.WriteFilePriorities("a", []int{1, 0}))
+	must(t, r.WriteSequential("a", true))
+	must(t, r.WriteSpeedLimit("a", 10, 20))
+	must(t, r.WriteQueuePosition("a", 3))
+	must(t, r.WriteDataDir("a", "/data"))
+	must(t, r.WriteMutable("a", []byte("key"), []byte("salt"), 5))
+	must(t, r.WriteTrackers("a", [][]string{{"http://t1", "http://t2"}}))
+	must(t, r.WriteInfo("a", []byte("info2"), []byte("layers2")))
+	must(t, r.WriteStats(map[string]resumer.StatsUpdate{
+		"a": {Stats: resumer.Stats{BytesDownloaded: 1, BytesUploaded: 2, BytesWasted: 3, SeededFor: int64(time.Minute)}, Bitfield: []byte{0x0f}},
+		"b": {Stats: resumer.Stats{BytesDownloaded: 4}},
+	}))
+	s, err = r.Read("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Started || !reflect.DeepEqual(s.FilePriorities, []int{1, 0}) || !s.Sequential ||
+		s.SpeedLimitDownload != 10 || s.SpeedLimitUpload != 20 || s.QueuePosition != 3 || s.DataDir != "/data" ||
+		string(s.PublicKey) != "key" || string(s.Salt) != "salt" || s.Seq != 5 ||
+		!reflect.DeepEqual(s.Trackers, [][]string{{"http://t1", "http://t2"}}) || string(s.Info) != "info2" || string(s.PieceLayers) != "layers2" ||
+		s.BytesDownloaded != 1 || s.BytesUploaded != 2 || s.BytesWasted != 3 || s.SeededFor != time.Minute ||
+		!bytes.Equal(s.Bitfield, []byte{0x0f}) || s.Name != spec.Name {
+		t.Fatalf("unexpected spec: %#v", s)
+	}
+	// Bitfield is not changed if it is not given in stats.
+	s, err = r.Read("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.BytesDownloaded != 4 || !bytes.Equal(s.Bitfield, spec.Bitfield) {
+		t.Fatalf("unexpected spec: %#v", s)
+	}
+	must(t, r.WriteBitfield("b", nil))
+	s, err = r.Read("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Bitfield) != 0 {
+		t.Fatal("bitfield is not deleted")
+	}
+
+	// Partial writes for missing torrents are ignored.
+	must(t, r.WriteStarted("missing", true))
+	must(t, r.WriteStats(map[string]resumer.StatsUpdate{"missing": {}}))
+	if _, err = r.Read("missing"); err == nil {
+		t.Fatal("missing torrent must not be read")
+	}
+
+	must(t, r.Delete("a"))
+	must(t, r.Delete("missing"))
+	ids, err = r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"b"}) {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+
+	// Session values
+	val, err := r.ReadSessionValue("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != nil {
+		t.Fatal("missing value must be nil")
+	}
+	must(t, r.WriteSessionValues(map[string][]byte{"key": []byte("value"), "key2": {}}))
+	val, err = r.ReadSessionValue("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "value" {
+		t.Fatalf("unexpected value: %q", val)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
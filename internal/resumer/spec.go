@@ -0,0 +1,187 @@
+package resumer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// Spec contains fields for resuming an existing torrent.
+type Spec struct {
+	InfoHash        []byte
+	Port            int
+	Name            string
+	Trackers        [][]string
+	URLList         []string
+	FixedPeers      []string
+	Info            []byte
+	PieceLayers     []byte
+	Bitfield        []byte
+	AddedAt         time.Time
+	BytesDownloaded int64
+	BytesUploaded   int64
+	BytesWasted     int64
+	SeededFor       time.Duration
+	// Started is true if the torrent is in queue. Queued torrents are started when their turn comes.
+	Started           bool
+	StopAfterDownload bool
+	FilePriorities    []int
+	Sequential        bool
+	// Speed limits of the torrent in KiB/s. Zero means unlimited.
+	SpeedLimitDownload int64
+	SpeedLimitUpload   int64
+	// Seed limits of the torrent. Zero limit means no limit.
+	SeedRatioLimit  float64
+	SeedRatioAction int
+	SeedTimeLimit   time.Duration
+	SeedTimeAction  int
+	QueuePosition   int
+	// DataDir is the directory that the files are downloaded into. Empty means Config.DataDir of the Session.
+	DataDir string
+	// Public key, salt and sequence number of the DHT item pointing to the latest version of a mutable torrent (BEP 46).
+	PublicKey []byte
+	Salt      []byte
+	Seq       int64
+	// Storage is the name of the storage backend of the torrent. Empty means Config.Storage of the Session.
+	Storage string
+	// PreviousVersionDir is the data directory of the previous version of a mutable torrent.
+	// Files in it are reused when the info of this version is received.
+	PreviousVersionDir string
+}
+
+type jsonSpec struct {
+	Port               int
+	Name               string
+	Trackers           [][]string
+	URLList            []string
+	FixedPeers         []string
+	AddedAt            time.Time
+	BytesDownloaded    int64
+	BytesUploaded      int64
+	BytesWasted        int64
+	Started            bool
+	StopAfterDownload  bool
+	FilePriorities     []int
+	Sequential         bool
+	SpeedLimitDownload int64
+	SpeedLimitUpload   int64
+	SeedRatioLimit     float64
+	SeedRatioAction    int
+	SeedTimeAction     int
+	QueuePosition      int
+	DataDir            string
+	Seq                int64
+	Storage            string
+	PreviousVersionDir string `json:",omitempty"`
+
+	// JSON safe types
+	InfoHash      string
+	Info          string
+	PieceLayers   string
+	Bitfield      string
+	SeededFor     int64
+	SeedTimeLimit int64
+	PublicKey     string
+	Salt          string
+}
+
+// MarshalJSON converts the Spec to a JSON string.
+func (s Spec) MarshalJSON() ([]byte, error) {
+	j := jsonSpec{
+		Port:               s.Port,
+		Name:               s.Name,
+		Trackers:           s.Trackers,
+		URLList:            s.URLList,
+		FixedPeers:         s.FixedPeers,
+		AddedAt:            s.AddedAt,
+		BytesDownloaded:    s.BytesDownloaded,
+		BytesUploaded:      s.BytesUploaded,
+		BytesWasted:        s.BytesWasted,
+		Started:            s.Started,
+		StopAfterDownload:  s.StopAfterDownload,
+		FilePriorities:     s.FilePriorities,
+		Sequential:         s.Sequential,
+		SpeedLimitDownload: s.SpeedLimitDownload,
+		SpeedLimitUpload:   s.SpeedLimitUpload,
+		SeedRatioLimit:     s.SeedRatioLimit,
+		SeedRatioAction:    s.SeedRatioAction,
+		SeedTimeAction:     s.SeedTimeAction,
+		QueuePosition:      s.QueuePosition,
+		DataDir:            s.DataDir,
+		Seq:                s.Seq,
+		Storage:            s.Storage,
+		PreviousVersionDir: s.PreviousVersionDir,
+
+		InfoHash:      base64.StdEncoding.EncodeToString(s.InfoHash),
+		Info:          base64.StdEncoding.EncodeToString(s.Info),
+		PieceLayers:   base64.StdEncoding.EncodeToString(s.PieceLayers),
+		Bitfield:      base64.StdEncoding.EncodeToString(s.Bitfield),
+		SeededFor:     int64(s.SeededFor),
+		SeedTimeLimit: int64(s.SeedTimeLimit),
+		PublicKey:     base64.StdEncoding.EncodeToString(s.PublicKey),
+		Salt:          base64.StdEncoding.EncodeToString(s.Salt),
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON fills the Spec from a JSON string.
+func (s *Spec) UnmarshalJSON(b []byte) error {
+	var j jsonSpec
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+	s.InfoHash, err = base64.StdEncoding.DecodeString(j.InfoHash)
+	if err != nil {
+		return err
+	}
+	s.Info, err = base64.StdEncoding.DecodeString(j.Info)
+	if err != nil {
+		return err
+	}
+	s.PieceLayers, err = base64.StdEncoding.DecodeString(j.PieceLayers)
+	if err != nil {
+		return err
+	}
+	s.Bitfield, err = base64.StdEncoding.DecodeString(j.Bitfield)
+	if err != nil {
+		return err
+	}
+	s.PublicKey, err = base64.StdEncoding.DecodeString(j.PublicKey)
+	if err != nil {
+		return err
+	}
+	if len(s.PublicKey) == 0 {
+		s.PublicKey = nil
+	}
+	s.Salt, err = base64.StdEncoding.DecodeString(j.Salt)
+	if err != nil {
+		return err
+	}
+	s.SeededFor = time.Duration(j.SeededFor)
+	s.Port = j.Port
+	s.Name = j.Name
+	s.Trackers = j.Trackers
+	s.URLList = j.URLList
+	s.FixedPeers = j.FixedPeers
+	s.AddedAt = j.AddedAt
+	s.BytesDownloaded = j.BytesDownloaded
+	s.BytesUploaded = j.BytesUploaded
+	s.BytesWasted = j.BytesWasted
+	s.Started = j.Started
+	s.StopAfterDownload = j.StopAfterDownload
+	s.FilePriorities = j.FilePriorities
+	s.Sequential = j.Sequential
+	s.SpeedLimitDownload = j.SpeedLimitDownload
+	s.SpeedLimitUpload = j.SpeedLimitUpload
+	s.SeedRatioLimit = j.SeedRatioLimit
+	s.SeedRatioAction = j.SeedRatioAction
+	s.SeedTimeLimit = time.Duration(j.SeedTimeLimit)
+	s.SeedTimeAction = j.SeedTimeAction
+	s.QueuePosition = j.QueuePosition
+	s.DataDir = j.DataDir
+	s.Seq = j.Seq
+	s.Storage = j.Storage
+	s.PreviousVersionDir = j.PreviousVersionDir
+	return nil
+}
@@ -0,0 +1,273 @@
+// +build cgo
+
+// Package sqliteresumer provides a Resumer implementation that keeps the resume data in a SQLite database.
+// The database can be inspected with the sqlite3 shell while the Session is running.
+package sqliteresumer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // registers "sqlite3" driver
+	"github.com/panzarasa/rain/internal/resumer"
+)
+
+// DriverName is the name of the database/sql driver that is used by Open.
+const DriverName = "sqlite3"
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS torrents (id TEXT PRIMARY KEY, spec TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS session (key TEXT PRIMARY KEY, value BLOB NOT NULL)`,
+}
+
+// Resumer keeps the spec of each torrent as a JSON document in "torrents" table and session values in "session" table.
+type Resumer struct {
+	db *sql.DB
+}
+
+var _ resumer.Resumer = (*Resumer)(nil)
+
+// Open the database file at path.
+// The database is opened in WAL mode so other processes can read it while it is being written.
+func Open(path string) (*Resumer, error) {
+	db, err := sql.Open(DriverName, path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer. Sharing a connection prevents "database is locked" errors.
+	db.SetMaxOpenConns(1)
+	var mode string
+	err = db.QueryRow("PRAGMA journal_mode=WAL").Scan(&mode)
+	if err == nil {
+		_, err = db.Exec("PRAGMA busy_timeout=5000")
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	r, err := New(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// New returns a new Resumer that uses an already opened database. Tables are created if they do not exist.
+func New(db *sql.DB) (*Resumer, error) {
+	for _, q := range schema {
+		_, err := db.Exec(q)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Resumer{db: db}, nil
+}
+
+// Close the database.
+func (r *Resumer) Close() error {
+	return r.db.Close()
+}
+
+// List returns the IDs of all torrents in the database.
+func (r *Resumer) List() ([]string, error) {
+	rows, err := r.db.Query("SELECT id FROM torrents")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Read the spec of a torrent.
+func (r *Resumer) Read(torrentID string) (*resumer.Spec, error) {
+	var b []byte
+	err := r.db.QueryRow("SELECT spec FROM torrents WHERE id = ?", torrentID).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("torrent not found: %q", torrentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	spec := new(resumer.Spec)
+	err = json.Unmarshal(b, spec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read torrent %q: %s", torrentID, err)
+	}
+	return spec, nil
+}
+
+// Write replaces the spec of a torrent.
+func (r *Resumer) Write(torrentID string, spec *resumer.Spec) error {
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec("INSERT OR REPLACE INTO torrents (id, spec) VALUES (?, ?)", torrentID, string(b))
+	return err
+}
+
+// Delete the spec of a torrent.
+func (r *Resumer) Delete(torrentID string) error {
+	_, err := r.db.Exec("DELETE FROM torrents WHERE id = ?", torrentID)
+	return err
+}
+
+// update reads the spec of a torrent, calls f and writes the spec back in tx. Missing torrents are ignored.
+func update(tx *sql.Tx, torrentID string, f func(spec *resumer.Spec)) error {
+	var b []byte
+	err := tx.QueryRow("SELECT spec FROM torrents WHERE id = ?", torrentID).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	spec := new(resumer.Spec)
+	err = json.Unmarshal(b, spec)
+	if err != nil {
+		return fmt.Errorf("cannot read torrent %q: %s", torrentID, err)
+	}
+	f(spec)
+	b, err = json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE torrents SET spec = ? WHERE id = ?", string(b), torrentID)
+	return err
+}
+
+// transaction runs f in a transaction. The transaction is committed if f returns nil.
+func (r *Resumer) transaction(f func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	err = f(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Resumer) update(torrentID string, f func(spec *resumer.Spec)) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		return update(tx, torrentID, f)
+	})
+}
+
+// WriteInfo writes only the info dict and piece layers of a torrent.
+func (r *Resumer) WriteInfo(torrentID string, info, pieceLayers []byte) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.Info = info
+		spec.PieceLayers = pieceLayers
+	})
+}
+
+// WriteBitfield writes only bitfield of a torrent. Nil value deletes the bitfield.
+func (r *Resumer) WriteBitfield(torrentID string, value []byte) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Bitfield = value })
+}
+
+// WriteStarted writes the start status of a torrent.
+func (r *Resumer) WriteStarted(torrentID string, value bool) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Started = value })
+}
+
+// WriteFilePriorities writes only the file priorities of a torrent.
+func (r *Resumer) WriteFilePriorities(torrentID string, value []int) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.FilePriorities = value })
+}
+
+// WriteSequential writes only the sequential download mode of a torrent.
+func (r *Resumer) WriteSequential(torrentID string, value bool) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Sequential = value })
+}
+
+// WriteSpeedLimit writes only the download and upload speed limits of a torrent.
+func (r *Resumer) WriteSpeedLimit(torrentID string, download, upload int64) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.SpeedLimitDownload = download
+		spec.SpeedLimitUpload = upload
+	})
+}
+
+// WriteQueuePosition writes only the queue position of a torrent.
+func (r *Resumer) WriteQueuePosition(torrentID string, value int) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.QueuePosition = value })
+}
+
+// WriteDataDir writes only the data directory of a torrent.
+func (r *Resumer) WriteDataDir(torrentID string, value string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.DataDir = value })
+}
+
+// WriteMutable writes only the public key, salt and sequence number of the mutable torrent item in DHT.
+func (r *Resumer) WriteMutable(torrentID string, publicKey, salt []byte, seq int64) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.PublicKey = publicKey
+		spec.Salt = salt
+		spec.Seq = seq
+	})
+}
+
+// WriteTrackers writes only the trackers of a torrent.
+func (r *Resumer) WriteTrackers(torrentID string, value [][]string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Trackers = value })
+}
+
+// WriteStats writes the stats of multiple torrents in a single transaction.
+func (r *Resumer) WriteStats(stats map[string]resumer.StatsUpdate) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		for id, s := range stats {
+			err := update(tx, id, func(spec *resumer.Spec) {
+				spec.BytesDownloaded = s.BytesDownloaded
+				spec.BytesUploaded = s.BytesUploaded
+				spec.BytesWasted = s.BytesWasted
+				spec.SeededFor = time.Duration(s.SeededFor)
+				if s.Bitfield != nil {
+					spec.Bitfield = s.Bitfield
+				}
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReadSessionValue returns the value of a session wide key. Returns nil if the key does not exist.
+func (r *Resumer) ReadSessionValue(key string) ([]byte, error) {
+	var b []byte
+	err := r.db.QueryRow("SELECT value FROM session WHERE key = ?", key).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+// WriteSessionValues writes session wide keys in a single transaction.
+func (r *Resumer) WriteSessionValues(values map[string][]byte) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		for k, v := range values {
+			_, err := tx.Exec("INSERT OR REPLACE INTO session (key, value) VALUES (?, ?)", k, v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
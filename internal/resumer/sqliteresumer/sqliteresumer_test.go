@@ -0,0 +1,26 @@
+// +build cgo
+
+package sqliteresumer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/panzarasa/rain/internal/resumer/resumertest"
+)
+
+func TestResumer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rain-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r, err := Open(filepath.Join(dir, "session.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	resumertest.Run(t, r)
+}
@@ -15,6 +15,7 @@ type Peer struct {
 	Client             string
 	Addr               string
 	Source             string
+	Transport          string
 	ConnectedAt        Time
 	Downloading        bool
 	ClientInterested   bool
@@ -36,18 +37,38 @@ type Webseed struct {
 	DownloadSpeed int
 }
 
+// File in a Torrent.
+type File struct {
+	Path     string
+	Length   int64
+	Priority string
+}
+
 // Tracker of a Torrent.
 type Tracker struct {
 	URL           string
 	Status        string
 	Leechers      int
 	Seeders       int
+	Completed     int
 	Warning       string
 	Error         string
 	ErrorUnknown  bool
 	ErrorInternal string
 	LastAnnounce  Time
 	NextAnnounce  Time
+	LastScrape    Time
+}
+
+// Event is a change in the state of a torrent in Session.
+type Event struct {
+	ID        uint64
+	Time      Time
+	Type      string
+	TorrentID string
+	Error     string `json:",omitempty"`
+	Tracker   string `json:",omitempty"`
+	Piece     uint32 `json:",omitempty"`
 }
 
 // SessionStats contains statistics about a Session.
@@ -80,6 +101,9 @@ type SessionStats struct {
 	SpeedUpload   int
 	SpeedRead     int
 	SpeedWrite    int
+
+	SpeedLimitDownload int64
+	SpeedLimitUpload   int64
 }
 
 // Stats contains statistics about a Torrent.
@@ -119,6 +143,7 @@ type Stats struct {
 		Tracker int
 		DHT     int
 		PEX     int
+		LSD     int
 	}
 	Downloads struct {
 		Total   int
@@ -139,7 +164,37 @@ type Stats struct {
 		Download int
 		Upload   int
 	}
-	ETA int
+	SpeedLimit struct {
+		Download int64
+		Upload   int64
+	}
+	QueuePosition int
+	DataDir       string
+	ETA           int
+}
+
+// SetSpeedLimitsRequest contains request arguments for Session.SetSpeedLimits method.
+type SetSpeedLimitsRequest struct {
+	// Speed limits in KiB/s. Zero means unlimited.
+	Download int64
+	Upload   int64
+}
+
+// SetSpeedLimitsResponse contains response arguments for Session.SetSpeedLimits method.
+type SetSpeedLimitsResponse struct {
+}
+
+// GetEventsRequest contains request arguments for Session.GetEvents method.
+type GetEventsRequest struct {
+	// Return events with ID greater than After.
+	After uint64
+	// Seconds to wait for a new event if there is none.
+	Timeout int
+}
+
+// GetEventsResponse contains response arguments for Session.GetEvents method.
+type GetEventsResponse struct {
+	Events []Event
 }
 
 // GetMagnetRequest contains request arguments for Session.GetMagnet method.
@@ -176,6 +231,18 @@ type AddTorrentOptions struct {
 	ID                string
 	Stopped           bool
 	StopAfterDownload bool
+	Sequential        bool
+	// Zero value uses the session default. Negative value disables the limit.
+	SeedRatioLimit float64
+	// Seed time limit in seconds. Zero value uses the session default. Negative value disables the limit.
+	SeedTimeLimit int
+	// One of "stop", "remove" or "remove-data". Empty value uses the session default.
+	SeedRatioAction string
+	SeedTimeAction  string
+	// Directory on the server to download the files into. Empty value uses the session default.
+	DataDir string
+	// Storage backend on the server, e.g. "file", "memory", "blob" or "s3". Empty value uses the session default.
+	Storage string
 }
 
 // AddTorrentRequest contains request arguments for Session.AddTorrent method.
@@ -266,6 +333,68 @@ type GetTorrentWebseedsResponse struct {
 	Webseeds []Webseed
 }
 
+// GetTorrentFilesRequest contains request arguments for Session.GetTorrentFiles method.
+type GetTorrentFilesRequest struct {
+	ID string
+}
+
+// GetTorrentFilesResponse contains response arguments for Session.GetTorrentFiles method.
+type GetTorrentFilesResponse struct {
+	Files []File
+}
+
+// SetFilePrioritiesRequest contains request arguments for Session.SetFilePriorities method.
+type SetFilePrioritiesRequest struct {
+	ID         string
+	Priorities []string
+}
+
+// SetFilePrioritiesResponse contains response arguments for Session.SetFilePriorities method.
+type SetFilePrioritiesResponse struct {
+}
+
+// SetSequentialRequest contains request arguments for Session.SetSequential method.
+type SetSequentialRequest struct {
+	ID         string
+	Sequential bool
+}
+
+// SetSequentialResponse contains response arguments for Session.SetSequential method.
+type SetSequentialResponse struct {
+}
+
+// SetTorrentSpeedLimitRequest contains request arguments for Session.SetTorrentSpeedLimit method.
+type SetTorrentSpeedLimitRequest struct {
+	ID string
+	// Speed limits in KiB/s. Zero means unlimited.
+	Download int64
+	Upload   int64
+}
+
+// SetTorrentSpeedLimitResponse contains response arguments for Session.SetTorrentSpeedLimit method.
+type SetTorrentSpeedLimitResponse struct {
+}
+
+// SetTorrentQueuePositionRequest contains request arguments for Session.SetTorrentQueuePosition method.
+type SetTorrentQueuePositionRequest struct {
+	ID       string
+	Position int
+}
+
+// SetTorrentQueuePositionResponse contains response arguments for Session.SetTorrentQueuePosition method.
+type SetTorrentQueuePositionResponse struct {
+}
+
+// RelocateTorrentRequest contains request arguments for Session.RelocateTorrent method.
+type RelocateTorrentRequest struct {
+	ID      string
+	DataDir string
+}
+
+// RelocateTorrentResponse contains response arguments for Session.RelocateTorrent method.
+type RelocateTorrentResponse struct {
+}
+
 // StartTorrentRequest contains request arguments for Session.StartTorrent method.
 type StartTorrentRequest struct {
 	ID string
@@ -306,6 +435,8 @@ type VerifyTorrentResponse struct {
 type MoveTorrentRequest struct {
 	ID     string
 	Target string
+	// Token is sent to the RPC server of the target in "Authorization: Bearer <token>" header.
+	Token string
 }
 
 // MoveTorrentResponse contains response arguments for Session.MoveTorrent method.
@@ -347,3 +478,38 @@ type StopAllTorrentsRequest struct {
 // StopAllTorrentsResponse contains response arguments for Session.StopAllTorrents method.
 type StopAllTorrentsResponse struct {
 }
+
+// DHTItem is an arbitrary value stored in DHT.
+type DHTItem struct {
+	// Bencoded value
+	Value []byte
+	// Fields below are set for mutable items only.
+	PublicKey []byte
+	Salt      []byte
+	Seq       int64
+	Signature []byte
+}
+
+// DHTPutRequest contains request arguments for Session.DHTPut method.
+type DHTPutRequest struct {
+	Item DHTItem
+}
+
+// DHTPutResponse contains response arguments for Session.DHTPut method.
+type DHTPutResponse struct {
+	// Hex encoded target of the item.
+	Target string
+}
+
+// DHTGetRequest contains request arguments for Session.DHTGet method.
+type DHTGetRequest struct {
+	// Hex encoded target of the item.
+	Target string
+	// Salt of mutable item.
+	Salt []byte
+}
+
+// DHTGetResponse contains response arguments for Session.DHTGet method.
+type DHTGetResponse struct {
+	Item DHTItem
+}
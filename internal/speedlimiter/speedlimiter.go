@@ -0,0 +1,71 @@
+// Package speedlimiter provides a rate limiter for peer connections whose rate can be changed at runtime.
+package speedlimiter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+)
+
+// Limiter limits the number of bytes transferred per second.
+// Limiters can be chained. Transfers are limited by the limiter and all of its parents.
+// Methods are safe to call from multiple goroutines and on a nil Limiter.
+type Limiter struct {
+	parent *Limiter
+
+	m      sync.RWMutex
+	bucket *ratelimit.Bucket // nil means unlimited
+	rate   int64
+}
+
+// New returns a new Limiter with the rate in bytes per second.
+// Zero rate means unlimited. Parent may be nil.
+func New(rate int64, parent *Limiter) *Limiter {
+	l := &Limiter{parent: parent}
+	l.SetRate(rate)
+	return l
+}
+
+// SetRate sets the rate of the limiter in bytes per second.
+// Zero or negative rate removes the limit.
+func (l *Limiter) SetRate(rate int64) {
+	var b *ratelimit.Bucket
+	if rate > 0 {
+		b = ratelimit.NewBucketWithRate(float64(rate), rate)
+	} else {
+		rate = 0
+	}
+	l.m.Lock()
+	l.bucket = b
+	l.rate = rate
+	l.m.Unlock()
+}
+
+// Rate returns the rate of the limiter in bytes per second. Zero means unlimited.
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.m.RLock()
+	defer l.m.RUnlock()
+	return l.rate
+}
+
+// Take takes count bytes from the limiter and its parents.
+// It returns the time that the caller should wait until the bytes can be transferred.
+func (l *Limiter) Take(count int64) time.Duration {
+	var d time.Duration
+	for ; l != nil; l = l.parent {
+		l.m.RLock()
+		b := l.bucket
+		l.m.RUnlock()
+		if b == nil {
+			continue
+		}
+		if d2 := b.Take(count); d2 > d {
+			d = d2
+		}
+	}
+	return d
+}
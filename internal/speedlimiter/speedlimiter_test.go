@@ -0,0 +1,39 @@
+package speedlimiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	var nilLimiter *Limiter
+	if d := nilLimiter.Take(100); d != 0 {
+		t.Fatal(d)
+	}
+	parent := New(0, nil)
+	l := New(0, parent)
+	if d := l.Take(100); d != 0 {
+		t.Fatal(d)
+	}
+	parent.SetRate(100)
+	l.Take(100)
+	if d := l.Take(100); d < 900*time.Millisecond {
+		t.Fatal(d)
+	}
+	parent.SetRate(0)
+	l.SetRate(1000)
+	if l.Rate() != 1000 {
+		t.Fatal(l.Rate())
+	}
+	l.Take(1000)
+	if d := l.Take(100); d < 90*time.Millisecond {
+		t.Fatal(d)
+	}
+	l.SetRate(-1)
+	if l.Rate() != 0 {
+		t.Fatal(l.Rate())
+	}
+	if d := l.Take(100); d != 0 {
+		t.Fatal(d)
+	}
+}
@@ -1,56 +1,93 @@
 package tracker
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"net"
 )
 
-// CompactPeer is a struct value which consist of a 4-bytes IP address and a 2-bytes port value.
+const (
+	compactPeerLen  = net.IPv4len + 2
+	compactPeer6Len = net.IPv6len + 2
+)
+
+// CompactPeer is a struct value which consist of a 16-bytes IP address and a 2-bytes port value.
+// IPv4 addresses are kept in IPv4-mapped IPv6 form.
 // CompactPeer can be used as a key in maps because it does not contain any pointers.
 type CompactPeer struct {
-	IP   [net.IPv4len]byte
+	IP   [net.IPv6len]byte
 	Port uint16
 }
 
 // NewCompactPeer returns a new CompactPeer from a net.TCPAddr.
 func NewCompactPeer(addr *net.TCPAddr) CompactPeer {
 	p := CompactPeer{Port: uint16(addr.Port)}
-	copy(p.IP[:], addr.IP.To4())
+	copy(p.IP[:], addr.IP.To16())
 	return p
 }
 
+// Is4 returns true if the peer has an IPv4 address.
+func (p CompactPeer) Is4() bool {
+	return net.IP(p.IP[:]).To4() != nil
+}
+
 // Addr returns a net.TCPAddr from CompactPeer.
 func (p CompactPeer) Addr() *net.TCPAddr {
-	return &net.TCPAddr{IP: p.IP[:], Port: int(p.Port)}
+	ip := net.IP(p.IP[:])
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p.Port)}
 }
 
 // MarshalBinary returns the bytes.
+// Result is 6 bytes long for IPv4 addresses and 18 bytes long for IPv6 addresses.
 func (p CompactPeer) MarshalBinary() ([]byte, error) {
-	buf := bytes.NewBuffer(make([]byte, 0, 6))
-	err := binary.Write(buf, binary.BigEndian, p)
-	return buf.Bytes(), err
+	ip := p.IP[:]
+	if p.Is4() {
+		ip = ip[12:]
+	}
+	b := make([]byte, len(ip)+2)
+	copy(b, ip)
+	binary.BigEndian.PutUint16(b[len(ip):], p.Port)
+	return b, nil
 }
 
 // UnmarshalBinary reads bytes from a slice into the CompactPeer.
 func (p *CompactPeer) UnmarshalBinary(data []byte) error {
-	if len(data) != 6 {
+	var ip net.IP
+	switch len(data) {
+	case compactPeerLen:
+		ip = net.IP(data[:net.IPv4len]).To16()
+	case compactPeer6Len:
+		ip = net.IP(data[:net.IPv6len])
+	default:
 		return errors.New("invalid compact peer length")
 	}
-	return binary.Read(bytes.NewReader(data), binary.BigEndian, p)
+	copy(p.IP[:], ip)
+	p.Port = binary.BigEndian.Uint16(data[len(data)-2:])
+	return nil
 }
 
-// DecodePeersCompact parses and returns addresses for list of CompactPeers.
+// DecodePeersCompact parses and returns addresses for list of IPv4 CompactPeers.
 func DecodePeersCompact(b []byte) ([]*net.TCPAddr, error) {
-	if len(b)%6 != 0 {
+	return decodePeers(b, compactPeerLen)
+}
+
+// DecodePeersCompact6 parses and returns addresses for list of IPv6 CompactPeers as described in BEP 7.
+func DecodePeersCompact6(b []byte) ([]*net.TCPAddr, error) {
+	return decodePeers(b, compactPeer6Len)
+}
+
+func decodePeers(b []byte, size int) ([]*net.TCPAddr, error) {
+	if len(b)%size != 0 {
 		return nil, errors.New("invalid peer list length")
 	}
-	count := len(b) / 6
+	count := len(b) / size
 	addrs := make([]*net.TCPAddr, 0, count)
-	for i := 0; i < len(b); i += 6 {
+	for i := 0; i < len(b); i += size {
 		var peer CompactPeer
-		err := peer.UnmarshalBinary(b[i : i+6])
+		err := peer.UnmarshalBinary(b[i : i+size])
 		if err != nil {
 			return nil, err
 		}
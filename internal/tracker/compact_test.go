@@ -1,18 +1,19 @@
 package tracker
 
 import (
+	"net"
 	"testing"
 )
 
 func TestCompactPeer(t *testing.T) {
-	cp := CompactPeer{
-		IP:   [4]byte{1, 2, 3, 4},
-		Port: 5,
-	}
+	cp := NewCompactPeer(&net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5})
 	b, err := cp.MarshalBinary()
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(b) != 6 {
+		t.Fatalf("invalid length: %d", len(b))
+	}
 	var cp2 CompactPeer
 	err = cp2.UnmarshalBinary(b)
 	if err != nil {
@@ -22,3 +23,24 @@ func TestCompactPeer(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestCompactPeer6(t *testing.T) {
+	cp := NewCompactPeer(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5})
+	if cp.Is4() {
+		t.FailNow()
+	}
+	b, err := cp.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 18 {
+		t.Fatalf("invalid length: %d", len(b))
+	}
+	addrs, err := DecodePeersCompact6(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0].String() != "[2001:db8::1]:5" {
+		t.Fatalf("invalid addrs: %v", addrs)
+	}
+}
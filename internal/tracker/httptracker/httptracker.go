@@ -92,37 +92,10 @@ func (t *HTTPTracker) Announce(ctx context.Context, req tracker.AnnounceRequest)
 
 	t.log.Debugf("making request to: %q", sb.String())
 
-	httpReq, err := http.NewRequest(http.MethodGet, sb.String(), nil)
+	code, header, body, err := t.get(ctx, sb.String())
 	if err != nil {
 		return nil, err
 	}
-	httpReq = httpReq.WithContext(ctx)
-
-	httpReq.Header.Set("User-Agent", t.userAgent)
-
-	doReq := func() (int, http.Header, []byte, error) {
-		resp, err := t.http.Do(httpReq)
-		if err != nil {
-			return 0, nil, nil, err
-		}
-		t.log.Debugf("tracker responded %d with %d bytes body", resp.StatusCode, resp.ContentLength)
-		defer resp.Body.Close()
-		if resp.ContentLength > t.maxResponseLength {
-			return 0, resp.Header, nil, fmt.Errorf("tracker respsonse too large: %d", resp.ContentLength)
-		}
-		r := io.LimitReader(resp.Body, t.maxResponseLength)
-		data, err := ioutil.ReadAll(r)
-		return resp.StatusCode, resp.Header, data, err
-	}
-
-	code, header, body, err := doReq()
-	if uerr, ok := err.(*url.Error); ok && uerr.Err == context.Canceled {
-		return nil, context.Canceled
-	}
-	if err != nil {
-		return nil, err
-	}
-	t.log.Debugf("read %d bytes from body", len(body))
 
 	var response announceResponse
 	err = bencode.DecodeBytes(body, &response)
@@ -166,6 +139,14 @@ func (t *HTTPTracker) Announce(ctx context.Context, req tracker.AnnounceRequest)
 	if err != nil {
 		return nil, err
 	}
+	// IPv6 peers are in a separate key as described in BEP 7.
+	if len(response.Peers6) > 0 {
+		peers6, err := tracker.DecodePeersCompact6(response.Peers6)
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, peers6...)
+	}
 	t.log.Debugf("got %d peers", len(peers))
 
 	// Filter external IP
@@ -190,6 +171,42 @@ func (t *HTTPTracker) Announce(ctx context.Context, req tracker.AnnounceRequest)
 	}, nil
 }
 
+// get makes a GET request to the tracker and returns the response.
+func (t *HTTPTracker) get(ctx context.Context, u string) (int, http.Header, []byte, error) {
+	httpReq, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+
+	httpReq.Header.Set("User-Agent", t.userAgent)
+
+	doReq := func() (int, http.Header, []byte, error) {
+		resp, err := t.http.Do(httpReq)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		t.log.Debugf("tracker responded %d with %d bytes body", resp.StatusCode, resp.ContentLength)
+		defer resp.Body.Close()
+		if resp.ContentLength > t.maxResponseLength {
+			return 0, resp.Header, nil, fmt.Errorf("tracker respsonse too large: %d", resp.ContentLength)
+		}
+		r := io.LimitReader(resp.Body, t.maxResponseLength)
+		data, err := ioutil.ReadAll(r)
+		return resp.StatusCode, resp.Header, data, err
+	}
+
+	code, header, body, err := doReq()
+	if uerr, ok := err.(*url.Error); ok && uerr.Err == context.Canceled {
+		return 0, nil, nil, context.Canceled
+	}
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	t.log.Debugf("read %d bytes from body", len(body))
+	return code, header, body, nil
+}
+
 func parsePeersDictionary(b bencode.RawMessage) ([]*net.TCPAddr, error) {
 	var peers []struct {
 		IP   string `bencode:"ip"`
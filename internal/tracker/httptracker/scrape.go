@@ -0,0 +1,110 @@
+package httptracker
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/panzarasa/rain/internal/tracker"
+	"github.com/zeebo/bencode"
+)
+
+// Number of info hashes to send in a single scrape request to keep the URL short.
+const maxScrapeInfoHashes = 50
+
+type scrapeResponse struct {
+	FailureReason string `bencode:"failure reason"`
+	Files         map[string]struct {
+		Complete   int32 `bencode:"complete"`
+		Incomplete int32 `bencode:"incomplete"`
+		Downloaded int32 `bencode:"downloaded"`
+	} `bencode:"files"`
+}
+
+// Scrape the torrents by doing GET requests to the scrape URL of the tracker as described in BEP 48.
+func (t *HTTPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]tracker.ScrapeResult, error) {
+	scrapeURL, err := scrapeURL(t.rawURL)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[[20]byte]tracker.ScrapeResult, len(infoHashes))
+	for len(infoHashes) > 0 {
+		n := len(infoHashes)
+		if n > maxScrapeInfoHashes {
+			n = maxScrapeInfoHashes
+		}
+		err = t.scrape(ctx, scrapeURL, infoHashes[:n], results)
+		if err != nil {
+			return nil, err
+		}
+		infoHashes = infoHashes[n:]
+	}
+	return results, nil
+}
+
+func (t *HTTPTracker) scrape(ctx context.Context, scrapeURL string, infoHashes [][20]byte, results map[[20]byte]tracker.ScrapeResult) error {
+	var sb strings.Builder
+	sb.WriteString(scrapeURL)
+	sep := '?'
+	if strings.ContainsRune(scrapeURL, '?') {
+		sep = '&'
+	}
+	for _, ih := range infoHashes {
+		sb.WriteRune(sep)
+		sb.WriteString("info_hash=")
+		sb.WriteString(url.QueryEscape(string(ih[:])))
+		sep = '&'
+	}
+
+	t.log.Debugf("making scrape request to: %q", sb.String())
+
+	code, header, body, err := t.get(ctx, sb.String())
+	if err != nil {
+		return err
+	}
+
+	var response scrapeResponse
+	err = bencode.DecodeBytes(body, &response)
+	if err != nil {
+		if code != 200 {
+			return &StatusError{
+				Code:   code,
+				Header: header,
+				Body:   string(body),
+			}
+		}
+		return tracker.ErrDecode
+	}
+	if response.FailureReason != "" {
+		return &tracker.Error{FailureReason: response.FailureReason}
+	}
+	for key, f := range response.Files {
+		if len(key) != 20 {
+			continue
+		}
+		var ih [20]byte
+		copy(ih[:], key)
+		results[ih] = tracker.ScrapeResult{
+			Seeders:   f.Complete,
+			Leechers:  f.Incomplete,
+			Completed: f.Downloaded,
+		}
+	}
+	return nil
+}
+
+// scrapeURL returns the scrape URL of the tracker from its announce URL.
+// The last path segment must start with "announce", which is replaced with "scrape".
+func scrapeURL(announceURL string) (string, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return "", err
+	}
+	i := strings.LastIndexByte(u.Path, '/')
+	if !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", tracker.ErrScrapeNotSupported
+	}
+	u.Path = u.Path[:i+1] + "scrape" + u.Path[i+1+len("announce"):]
+	u.RawPath = ""
+	return u.String(), nil
+}
@@ -0,0 +1,33 @@
+package httptracker
+
+import (
+	"testing"
+
+	"github.com/panzarasa/rain/internal/tracker"
+)
+
+func TestScrapeURL(t *testing.T) {
+	cases := []struct {
+		announce string
+		scrape   string
+		err      error
+	}{
+		{"http://example.com/announce", "http://example.com/scrape", nil},
+		{"http://example.com/x/announce", "http://example.com/x/scrape", nil},
+		{"http://example.com/announce.php", "http://example.com/scrape.php", nil},
+		{"http://example.com/announce?x2%0644", "http://example.com/scrape?x2%0644", nil},
+		{"http://example.com/a", "", tracker.ErrScrapeNotSupported},
+		{"http://example.com/announce?x=y%0a", "http://example.com/scrape?x=y%0a", nil},
+		{"http://example.com/announce/x", "", tracker.ErrScrapeNotSupported},
+	}
+	for _, c := range cases {
+		s, err := scrapeURL(c.announce)
+		if err != c.err {
+			t.Errorf("unexpected error for %s: %v", c.announce, err)
+			continue
+		}
+		if s != c.scrape {
+			t.Errorf("unexpected scrape url for %s: %s", c.announce, s)
+		}
+	}
+}
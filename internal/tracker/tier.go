@@ -31,6 +31,11 @@ func (t *Tier) Announce(ctx context.Context, req AnnounceRequest) (*AnnounceResp
 	return resp, err
 }
 
+// Scrape torrents from the current Tracker in the Tier.
+func (t *Tier) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]ScrapeResult, error) {
+	return t.Trackers[t.index].Scrape(ctx, infoHashes)
+}
+
 // URL returns the current Tracker in the Tier.
 func (t *Tier) URL() string {
 	return t.Trackers[t.index].URL()
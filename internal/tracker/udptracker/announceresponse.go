@@ -6,3 +6,9 @@ type udpAnnounceResponse struct {
 	Leechers int32
 	Seeders  int32
 }
+
+type udpScrapeResult struct {
+	Seeders   int32
+	Completed int32
+	Leechers  int32
+}
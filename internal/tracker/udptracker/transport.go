@@ -76,7 +76,7 @@ func (t *Transport) listen() error {
 	}
 
 	var laddr net.UDPAddr
-	conn, err := net.ListenUDP("udp4", &laddr)
+	conn, err := net.ListenUDP("udp", &laddr)
 	if err != nil {
 		return err
 	}
@@ -135,8 +135,9 @@ func (t *Transport) Close() error {
 // sends the bytes to the transaction's response channel.
 func (t *Transport) readLoop() {
 	// Read buffer must be big enough to hold a UDP packet of maximum expected size.
+	// Each IPv6 peer takes 18 bytes in announce response.
 	const maxNumWant = 1000
-	bigBuf := make([]byte, 20+6*maxNumWant)
+	bigBuf := make([]byte, 20+18*maxNumWant)
 	for {
 		n, err := t.conn.Read(bigBuf)
 		if err != nil {
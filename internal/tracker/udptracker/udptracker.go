@@ -69,7 +69,12 @@ func (t *UDPTracker) Announce(ctx context.Context, req tracker.AnnounceRequest)
 		return nil, err
 	}
 
-	response, peers, err := t.parseAnnounceResponse(reply)
+	// Trackers return IPv6 peers if the request is sent over IPv6.
+	decodePeers := tracker.DecodePeersCompact
+	if trx.addr.(*net.UDPAddr).IP.To4() == nil {
+		decodePeers = tracker.DecodePeersCompact6
+	}
+	response, peers, err := t.parseAnnounceResponse(reply, decodePeers)
 	if err != nil {
 		return nil, tracker.ErrDecode
 	}
@@ -83,7 +88,7 @@ func (t *UDPTracker) Announce(ctx context.Context, req tracker.AnnounceRequest)
 	}, nil
 }
 
-func (t *UDPTracker) parseAnnounceResponse(data []byte) (*udpAnnounceResponse, []*net.TCPAddr, error) {
+func (t *UDPTracker) parseAnnounceResponse(data []byte, decodePeers func([]byte) ([]*net.TCPAddr, error)) (*udpAnnounceResponse, []*net.TCPAddr, error) {
 	var response udpAnnounceResponse
 	err := binary.Read(bytes.NewReader(data), binary.BigEndian, &response)
 	if err != nil {
@@ -93,9 +98,64 @@ func (t *UDPTracker) parseAnnounceResponse(data []byte) (*udpAnnounceResponse, [
 	if response.Action != actionAnnounce {
 		return nil, nil, errors.New("invalid action")
 	}
-	peers, err := tracker.DecodePeersCompact(data[binary.Size(response):])
+	peers, err := decodePeers(data[binary.Size(response):])
 	if err != nil {
 		return nil, nil, err
 	}
 	return &response, peers, nil
 }
+
+// BEP 15: Up to about 74 torrents can be scraped at once.
+const maxScrapeInfoHashes = 74
+
+// Scrape the torrents from UDP tracker.
+func (t *UDPTracker) Scrape(ctx context.Context, infoHashes [][20]byte) (map[[20]byte]tracker.ScrapeResult, error) {
+	results := make(map[[20]byte]tracker.ScrapeResult, len(infoHashes))
+	for len(infoHashes) > 0 {
+		n := len(infoHashes)
+		if n > maxScrapeInfoHashes {
+			n = maxScrapeInfoHashes
+		}
+		err := t.scrape(ctx, infoHashes[:n], results)
+		if err != nil {
+			return nil, err
+		}
+		infoHashes = infoHashes[n:]
+	}
+	return results, nil
+}
+
+func (t *UDPTracker) scrape(ctx context.Context, infoHashes [][20]byte, results map[[20]byte]tracker.ScrapeResult) error {
+	request := &scrapeRequest{InfoHashes: infoHashes}
+	request.SetAction(actionScrape)
+	trx := newTransaction(request, t.dest)
+
+	reply, err := t.transport.Do(ctx, trx)
+	if err != nil {
+		return err
+	}
+
+	var header udpMessageHeader
+	r := bytes.NewReader(reply)
+	err = binary.Read(r, binary.BigEndian, &header)
+	if err != nil {
+		return tracker.ErrDecode
+	}
+	if header.Action != actionScrape {
+		return tracker.ErrDecode
+	}
+	// Results are in the same order with the info hashes in the request.
+	for _, ih := range infoHashes {
+		var res udpScrapeResult
+		err = binary.Read(r, binary.BigEndian, &res)
+		if err != nil {
+			return tracker.ErrDecode
+		}
+		results[ih] = tracker.ScrapeResult{
+			Seeders:   res.Seeders,
+			Leechers:  res.Leechers,
+			Completed: res.Completed,
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package trackermanager
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/panzarasa/rain/internal/blocklist"
+	"github.com/panzarasa/rain/internal/proxy"
 	"github.com/panzarasa/rain/internal/resolver"
 	"github.com/panzarasa/rain/internal/tracker"
 	"github.com/panzarasa/rain/internal/tracker/httptracker"
@@ -21,15 +23,23 @@ import (
 type TrackerManager struct {
 	httpTransport *http.Transport
 	udpTransport  *udptracker.Transport
+	disableUDP    bool
 }
 
 // New returns a new TrackerManager.
-func New(bl *blocklist.Blocklist, dnsTimeout time.Duration, tlsSkipVerify bool) *TrackerManager {
+// If px is not nil, HTTP trackers are contacted through the proxy. Blocklist is not checked for them because host names are resolved by the proxy.
+// UDP trackers cannot be used through a proxy. They are not returned from Get if disableUDP is true.
+func New(bl *blocklist.Blocklist, dnsTimeout time.Duration, tlsSkipVerify bool, px *proxy.Dialer, disableUDP bool) *TrackerManager {
 	m := &TrackerManager{
 		httpTransport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: tlsSkipVerify}, // nolint: gosec
 		},
 		udpTransport: udptracker.NewTransport(bl, dnsTimeout),
+		disableUDP:   disableUDP,
+	}
+	if px != nil {
+		m.httpTransport.DialContext = px.DialContext
+		return m
 	}
 	m.httpTransport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
 		ip, port, err := resolver.Resolve(ctx, addr, dnsTimeout, bl)
@@ -54,6 +64,9 @@ func (m *TrackerManager) Get(s string, httpTimeout time.Duration, httpUserAgent
 		tr := httptracker.New(s, u, httpTimeout, m.httpTransport, httpUserAgent, httpMaxResponseLength)
 		return tr, nil
 	case "udp":
+		if m.disableUDP {
+			return nil, errors.New("udp trackers are disabled in proxy-only mode")
+		}
 		tr := udptracker.New(s, u, m.udpTransport)
 		return tr, nil
 	default:
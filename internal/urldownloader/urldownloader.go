@@ -88,30 +88,34 @@ func (d *URLDownloader) Run(client *http.Client, pieces []piece.Piece, multifile
 	buf := pool.Get(int(pieces[d.current].Length))
 
 	processJob := func(job downloadJob) bool {
-		u := d.getURL(job.Filename, multifile)
-		req, err := http.NewRequest(http.MethodGet, u, nil)
-		if err != nil {
-			panic(err)
-		}
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", job.RangeBegin, job.RangeBegin+job.Length-1))
-		req = req.WithContext(ctx)
-		resp, err := client.Do(req)
-		if err != nil {
-			d.sendResult(resultC, &PieceResult{Downloader: d, Error: err})
-			return false
-		}
-		defer resp.Body.Close()
-		err = checkStatus(resp)
-		if err != nil {
-			d.sendResult(resultC, &PieceResult{Downloader: d, Error: err})
-			return false
+		var body io.Reader = zeroReader{}
+		if !job.Padding {
+			u := d.getURL(job.Filename, multifile)
+			req, err := http.NewRequest(http.MethodGet, u, nil)
+			if err != nil {
+				panic(err)
+			}
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", job.RangeBegin, job.RangeBegin+job.Length-1))
+			req = req.WithContext(ctx)
+			resp, err := client.Do(req)
+			if err != nil {
+				d.sendResult(resultC, &PieceResult{Downloader: d, Error: err})
+				return false
+			}
+			defer resp.Body.Close()
+			err = checkStatus(resp)
+			if err != nil {
+				d.sendResult(resultC, &PieceResult{Downloader: d, Error: err})
+				return false
+			}
+			body = resp.Body
 		}
 		timer := time.AfterFunc(readTimeout, cancel)
 		defer timer.Stop()
 		var m int64 // position in response
 		for m < job.Length {
 			readSize := calcReadSize(buf, n, job, m)
-			o, err := readFull(resp.Body, buf.Data[n:int64(n)+readSize], timer, readTimeout)
+			o, err := readFull(body, buf.Data[n:int64(n)+readSize], timer, readTimeout)
 			if err != nil {
 				d.sendResult(resultC, &PieceResult{Downloader: d, Error: err})
 				return false
@@ -191,6 +195,16 @@ func (d *URLDownloader) sendResult(resultC chan interface{}, res *PieceResult) {
 	}
 }
 
+// zeroReader is read in place of padding files.
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
 func checkStatus(resp *http.Response) error {
 	switch resp.StatusCode {
 	case 200, 206:
@@ -0,0 +1,649 @@
+package utp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Max number of payload bytes in a packet. Kept small enough to avoid IP fragmentation.
+	maxPayload = 1200
+	// Size of the receive buffer advertised to the peer.
+	recvBufferSize = 1 << 20
+	// Out of order packets further than this are dropped.
+	maxOutOfOrder = 1024
+
+	// LEDBAT parameters
+	targetDelay              = 100 * time.Millisecond
+	maxWindowIncreasePerRTT  = 3000
+	minWindow                = maxPayload
+	maxWindow                = recvBufferSize
+	initialWindow            = 4 * maxPayload
+	baseDelayHistoryInterval = time.Minute
+
+	initialRTO = time.Second
+	minRTO     = 500 * time.Millisecond
+	maxRTO     = time.Minute
+	// Connection fails if there is no response to SYN after this many retransmissions.
+	maxSynTimeouts = 3
+	// Connection fails if no packet is acked after this many consecutive timeouts.
+	maxTimeouts = 8
+	// Number of duplicate acks that triggers a fast retransmit.
+	dupAckThreshold = 3
+)
+
+var (
+	errConnReset   = errors.New("utp connection reset by peer")
+	errConnTimeout = errors.New("utp connection timed out")
+)
+
+// timeoutError is returned when a deadline is exceeded.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+type connState int
+
+const (
+	stateSynSent connState = iota
+	stateConnected
+)
+
+type packet struct {
+	typ           int
+	seqNr         uint16
+	payload       []byte
+	sentAt        time.Time
+	transmissions int
+	// Packet is lost and must be sent again when window allows.
+	needResend bool
+}
+
+// Conn is a uTP connection. It implements net.Conn.
+type Conn struct {
+	s      *Socket
+	raddr  *net.UDPAddr
+	recvID uint16
+	sendID uint16
+
+	m     sync.Mutex
+	state connState
+	// Closed and replaced when state of the connection changes. Used for waking blocked Read and Write calls.
+	changedC chan struct{}
+	// Set when connection fails. All operations return this error afterwards.
+	err error
+	// Close is called.
+	closed bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// Next sequence number to send.
+	seqNr uint16
+	// Sent packets that are not acked yet, in order of sequence numbers.
+	outbuf []*packet
+	// Number of bytes in flight.
+	curWindow int
+	// Congestion window in bytes.
+	maxWindow float64
+	// Receive window of the peer.
+	peerWindow uint32
+	dupAcks    int
+	finSent    bool
+	finAcked   bool
+
+	rtt, rttVar time.Duration
+	rto         time.Duration
+	rtoDeadline time.Time
+	timeouts    int
+
+	baseDelay delayHistory
+
+	// Sequence number of the last packet received in order.
+	ackNr uint16
+	// Payloads of packets received out of order.
+	inbuf      map[uint16][]byte
+	inbufBytes int
+	// Data received in order, waiting to be read.
+	readBuf []byte
+	// Peer has sent FIN with sequence number eofSeqNr.
+	finReceived bool
+	eofSeqNr    uint16
+	// All data up to FIN is received.
+	eof bool
+	// Difference between our clock and the timestamp of the last packet received.
+	replyMicro uint32
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+func newConn(s *Socket, raddr *net.UDPAddr, recvID, sendID uint16) *Conn {
+	return &Conn{
+		s:          s,
+		raddr:      raddr,
+		recvID:     recvID,
+		sendID:     sendID,
+		changedC:   make(chan struct{}),
+		maxWindow:  initialWindow,
+		peerWindow: maxPayload,
+		rto:        initialRTO,
+		inbuf:      make(map[uint16][]byte),
+	}
+}
+
+// LocalAddr returns the address of the Socket.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.s.Addr()
+}
+
+// RemoteAddr returns the UDP address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.m.Lock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.notify()
+	c.m.Unlock()
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read calls.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.m.Lock()
+	c.readDeadline = t
+	c.notify()
+	c.m.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write calls.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.m.Lock()
+	c.writeDeadline = t
+	c.notify()
+	c.m.Unlock()
+	return nil
+}
+
+// Read data from the connection. Returns io.EOF after the peer closes the connection and all data is read.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for {
+		if c.closed {
+			return 0, errClosed
+		}
+		if len(c.readBuf) > 0 {
+			wasFull := c.recvWindow() < maxPayload
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			if len(c.readBuf) == 0 {
+				c.readBuf = nil
+			}
+			if wasFull && c.recvWindow() >= maxPayload {
+				// Tell the peer that it can send again.
+				c.sendState()
+			}
+			return n, nil
+		}
+		if c.eof {
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		if !c.wait(c.readDeadline) {
+			return 0, timeoutError{}
+		}
+	}
+}
+
+// Write data to the connection. Blocks until all data is put into the send window.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	var n int
+	for len(b) > 0 {
+		if c.closed {
+			return n, errClosed
+		}
+		if c.err != nil {
+			return n, c.err
+		}
+		size := len(b)
+		if size > maxPayload {
+			size = maxPayload
+		}
+		if c.canSend(size) {
+			c.sendPacket(stData, b[:size])
+			b = b[size:]
+			n += size
+			continue
+		}
+		if !c.wait(c.writeDeadline) {
+			return n, timeoutError{}
+		}
+	}
+	return n, nil
+}
+
+// Close the connection. Data that is already written is still delivered to the peer in background.
+func (c *Conn) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return errClosed
+	}
+	c.closed = true
+	c.notify()
+	if c.err != nil || c.state != stateConnected {
+		c.s.remove(c)
+		return nil
+	}
+	if !c.finSent {
+		c.finSent = true
+		c.sendPacket(stFin, nil)
+	}
+	return nil
+}
+
+// wait blocks until the state of connection changes or deadline is reached.
+// Must be called with lock held. Returns false if deadline is exceeded.
+func (c *Conn) wait(deadline time.Time) bool {
+	var timeoutC <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return false
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeoutC = t.C
+	}
+	ch := c.changedC
+	c.m.Unlock()
+	defer c.m.Lock()
+	select {
+	case <-ch:
+		return true
+	case <-timeoutC:
+		return false
+	}
+}
+
+func (c *Conn) notify() {
+	close(c.changedC)
+	c.changedC = make(chan struct{})
+}
+
+// fail closes the connection with an error.
+func (c *Conn) fail(err error) {
+	c.m.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.notify()
+	c.m.Unlock()
+	c.s.remove(c)
+}
+
+// connect sends SYN and waits until the peer responds.
+func (c *Conn) connect(ctx context.Context) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.state = stateSynSent
+	c.seqNr = 1
+	c.sendPacket(stSyn, nil)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.m.Lock()
+			c.notify()
+			c.m.Unlock()
+		case <-done:
+		}
+	}()
+	for c.state == stateSynSent {
+		if c.err != nil {
+			return c.err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.wait(time.Time{})
+	}
+	return nil
+}
+
+// accept initializes the connection with the SYN packet received from the peer.
+func (c *Conn) accept(h *header) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.state = stateConnected
+	c.seqNr = uint16(rand.Intn(1 << 16)) // nolint: gosec
+	c.ackNr = h.SeqNr
+	c.peerWindow = h.WndSize
+	c.replyMicro = timestampMicro() - h.Timestamp
+	c.sendState()
+}
+
+// handleSyn is called when the peer sends SYN again for an accepted connection.
+func (c *Conn) handleSyn(h *header) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if h.SeqNr == c.ackNr {
+		c.sendState()
+	}
+}
+
+func (c *Conn) handlePacket(h *header, payload []byte) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.err != nil {
+		return
+	}
+	now := time.Now()
+	c.replyMicro = timestampMicro() - h.Timestamp
+	c.peerWindow = h.WndSize
+	if h.Type == stReset {
+		c.err = errConnReset
+		c.notify()
+		c.s.remove(c)
+		return
+	}
+	if c.state == stateSynSent {
+		if h.Type != stState {
+			return
+		}
+		c.state = stateConnected
+		c.ackNr = h.SeqNr - 1
+	}
+	c.handleAck(h, now)
+	switch h.Type {
+	case stData, stFin:
+		c.handleData(h, payload)
+	}
+	c.notify()
+	if c.closed && c.finAcked && len(c.outbuf) == 0 {
+		c.s.remove(c)
+	}
+}
+
+func (c *Conn) handleAck(h *header, now time.Time) {
+	if len(c.outbuf) == 0 || !seqLess(h.AckNr, c.seqNr) {
+		return
+	}
+	var acked, bytesAcked int
+	for len(c.outbuf) > 0 && !seqLess(h.AckNr, c.outbuf[0].seqNr) {
+		p := c.outbuf[0]
+		c.outbuf[0] = nil
+		c.outbuf = c.outbuf[1:]
+		acked++
+		bytesAcked += len(p.payload)
+		if !p.needResend {
+			c.curWindow -= len(p.payload)
+		}
+		if p.transmissions == 1 {
+			c.updateRTT(now.Sub(p.sentAt))
+		}
+		if p.typ == stFin {
+			c.finAcked = true
+		}
+	}
+	if acked == 0 {
+		if h.Type != stState || h.AckNr != c.outbuf[0].seqNr-1 {
+			return
+		}
+		c.dupAcks++
+		if c.dupAcks == dupAckThreshold {
+			// Packet after the acked one is probably lost.
+			c.maxWindow = math.Max(c.maxWindow/2, minWindow)
+			p := c.outbuf[0]
+			if !p.needResend {
+				c.curWindow -= len(p.payload)
+				p.needResend = true
+			}
+			c.resend(p, now)
+		}
+		return
+	}
+	c.dupAcks = 0
+	c.timeouts = 0
+	c.rtoDeadline = now.Add(c.rto)
+	if bytesAcked > 0 && h.TimestampDiff != 0 {
+		c.updateWindow(bytesAcked, h.TimestampDiff, now)
+	}
+	c.flush(now)
+}
+
+func (c *Conn) handleData(h *header, payload []byte) {
+	if !seqLess(c.ackNr, h.SeqNr) || h.SeqNr-c.ackNr > maxOutOfOrder {
+		// Duplicate or too far in future. Ack again in case our previous ack is lost.
+		c.sendState()
+		return
+	}
+	if h.Type == stFin {
+		c.finReceived = true
+		c.eofSeqNr = h.SeqNr
+	} else if _, ok := c.inbuf[h.SeqNr]; !ok && !(c.finReceived && seqLess(c.eofSeqNr, h.SeqNr)) {
+		b := make([]byte, len(payload))
+		copy(b, payload)
+		c.inbuf[h.SeqNr] = b
+		c.inbufBytes += len(b)
+	}
+	for {
+		next := c.ackNr + 1
+		if c.finReceived && next == c.eofSeqNr {
+			c.ackNr = next
+			c.eof = true
+			break
+		}
+		b, ok := c.inbuf[next]
+		if !ok {
+			break
+		}
+		delete(c.inbuf, next)
+		c.inbufBytes -= len(b)
+		c.readBuf = append(c.readBuf, b...)
+		c.ackNr = next
+	}
+	c.sendState()
+}
+
+// tick is called periodically for handling retransmission timeouts.
+func (c *Conn) tick(now time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.err != nil || len(c.outbuf) == 0 || now.Before(c.rtoDeadline) {
+		return
+	}
+	c.timeouts++
+	limit := maxTimeouts
+	if c.state == stateSynSent {
+		limit = maxSynTimeouts
+	}
+	if c.timeouts > limit {
+		c.err = errConnTimeout
+		c.notify()
+		c.s.remove(c)
+		return
+	}
+	c.rto *= 2
+	if c.rto > maxRTO {
+		c.rto = maxRTO
+	}
+	// All packets in flight are considered lost.
+	c.maxWindow = minWindow
+	c.curWindow = 0
+	for _, p := range c.outbuf {
+		p.needResend = true
+	}
+	c.rtoDeadline = now.Add(c.rto)
+	c.flush(now)
+}
+
+// flush sends the lost packets as the window allows.
+func (c *Conn) flush(now time.Time) {
+	for _, p := range c.outbuf {
+		if !p.needResend {
+			continue
+		}
+		if !c.canSend(len(p.payload)) {
+			return
+		}
+		c.resend(p, now)
+	}
+}
+
+func (c *Conn) canSend(size int) bool {
+	window := int(c.maxWindow)
+	if int(c.peerWindow) < window {
+		window = int(c.peerWindow)
+	}
+	return c.curWindow == 0 || c.curWindow+size <= window
+}
+
+// sendPacket sends a new packet that needs to be acked.
+func (c *Conn) sendPacket(typ int, payload []byte) {
+	p := &packet{typ: typ, seqNr: c.seqNr}
+	if len(payload) > 0 {
+		p.payload = make([]byte, len(payload))
+		copy(p.payload, payload)
+	}
+	c.seqNr++
+	if len(c.outbuf) == 0 {
+		c.rtoDeadline = time.Now().Add(c.rto)
+	}
+	c.outbuf = append(c.outbuf, p)
+	c.curWindow += len(p.payload)
+	c.transmit(p, time.Now())
+}
+
+func (c *Conn) resend(p *packet, now time.Time) {
+	p.needResend = false
+	c.curWindow += len(p.payload)
+	c.transmit(p, now)
+}
+
+func (c *Conn) transmit(p *packet, now time.Time) {
+	p.sentAt = now
+	p.transmissions++
+	c.write(p.typ, p.seqNr, p.payload)
+}
+
+// sendState sends an ack.
+func (c *Conn) sendState() {
+	c.write(stState, c.seqNr, nil)
+}
+
+func (c *Conn) write(typ int, seqNr uint16, payload []byte) {
+	b := make([]byte, headerSize+len(payload))
+	h := header{
+		Type:          typ,
+		ConnID:        c.sendID,
+		Timestamp:     timestampMicro(),
+		TimestampDiff: c.replyMicro,
+		WndSize:       uint32(c.recvWindow()),
+		SeqNr:         seqNr,
+		AckNr:         c.ackNr,
+	}
+	if typ == stSyn {
+		// SYN is sent with the ID that the peer is going to use for sending us packets.
+		h.ConnID = c.recvID
+	}
+	h.marshal(b)
+	copy(b[headerSize:], payload)
+	c.s.writeTo(b, c.raddr)
+}
+
+func (c *Conn) recvWindow() int {
+	n := recvBufferSize - len(c.readBuf) - c.inbufBytes
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func (c *Conn) updateRTT(sample time.Duration) {
+	if c.rtt == 0 {
+		c.rtt = sample
+		c.rttVar = sample / 2
+	} else {
+		delta := c.rtt - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttVar += (delta - c.rttVar) / 4
+		c.rtt += (sample - c.rtt) / 8
+	}
+	c.rto = c.rtt + 4*c.rttVar
+	if c.rto < minRTO {
+		c.rto = minRTO
+	}
+}
+
+// updateWindow adjusts congestion window with LEDBAT algorithm.
+// Window grows while the queuing delay on the path is lower than the target and shrinks when it is higher.
+func (c *Conn) updateWindow(bytesAcked int, delay uint32, now time.Time) {
+	c.baseDelay.add(delay, now)
+	queuingDelay := time.Duration(int32(delay-c.baseDelay.min())) * time.Microsecond
+	if queuingDelay < 0 {
+		queuingDelay = 0
+	}
+	offTarget := float64(targetDelay-queuingDelay) / float64(targetDelay)
+	windowFactor := float64(bytesAcked) / math.Max(c.maxWindow, float64(bytesAcked))
+	c.maxWindow += maxWindowIncreasePerRTT * offTarget * windowFactor
+	if c.maxWindow < minWindow {
+		c.maxWindow = minWindow
+	}
+	if c.maxWindow > maxWindow {
+		c.maxWindow = maxWindow
+	}
+}
+
+// delayHistory keeps the minimum delay seen in the last two minutes.
+type delayHistory struct {
+	current, previous uint32
+	start             time.Time
+}
+
+func (d *delayHistory) add(sample uint32, now time.Time) {
+	switch {
+	case d.start.IsZero():
+		d.current, d.previous = sample, sample
+		d.start = now
+	case now.Sub(d.start) > baseDelayHistoryInterval:
+		d.previous = d.current
+		d.current = sample
+		d.start = now
+	case timestampLess(sample, d.current):
+		d.current = sample
+	}
+}
+
+func (d *delayHistory) min() uint32 {
+	if timestampLess(d.previous, d.current) {
+		return d.previous
+	}
+	return d.current
+}
+
+func timestampMicro() uint32 {
+	return uint32(time.Now().UnixNano() / int64(time.Microsecond))
+}
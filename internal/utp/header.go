@@ -0,0 +1,91 @@
+package utp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Packet types
+const (
+	stData  = 0
+	stFin   = 1
+	stState = 2
+	stReset = 3
+	stSyn   = 4
+)
+
+const (
+	version    = 1
+	headerSize = 20
+)
+
+var errInvalidPacket = errors.New("invalid packet")
+
+// header is the fixed part of every uTP packet as described in BEP 29.
+type header struct {
+	Type          int
+	ConnID        uint16
+	Timestamp     uint32
+	TimestampDiff uint32
+	WndSize       uint32
+	SeqNr         uint16
+	AckNr         uint16
+}
+
+// marshal writes the header into the first headerSize bytes of b.
+// Packets are sent without extensions.
+func (h *header) marshal(b []byte) {
+	b[0] = byte(h.Type<<4 | version)
+	b[1] = 0
+	binary.BigEndian.PutUint16(b[2:4], h.ConnID)
+	binary.BigEndian.PutUint32(b[4:8], h.Timestamp)
+	binary.BigEndian.PutUint32(b[8:12], h.TimestampDiff)
+	binary.BigEndian.PutUint32(b[12:16], h.WndSize)
+	binary.BigEndian.PutUint16(b[16:18], h.SeqNr)
+	binary.BigEndian.PutUint16(b[18:20], h.AckNr)
+}
+
+// unmarshal parses the header and returns the payload of the packet.
+// Extensions are skipped since none of them is required for a working connection.
+func (h *header) unmarshal(b []byte) ([]byte, error) {
+	if len(b) < headerSize {
+		return nil, errInvalidPacket
+	}
+	if b[0]&0x0f != version {
+		return nil, errInvalidPacket
+	}
+	h.Type = int(b[0] >> 4)
+	if h.Type > stSyn {
+		return nil, errInvalidPacket
+	}
+	ext := b[1]
+	h.ConnID = binary.BigEndian.Uint16(b[2:4])
+	h.Timestamp = binary.BigEndian.Uint32(b[4:8])
+	h.TimestampDiff = binary.BigEndian.Uint32(b[8:12])
+	h.WndSize = binary.BigEndian.Uint32(b[12:16])
+	h.SeqNr = binary.BigEndian.Uint16(b[16:18])
+	h.AckNr = binary.BigEndian.Uint16(b[18:20])
+	b = b[headerSize:]
+	for ext != 0 {
+		if len(b) < 2 {
+			return nil, errInvalidPacket
+		}
+		ext = b[0]
+		length := int(b[1])
+		if len(b) < 2+length {
+			return nil, errInvalidPacket
+		}
+		b = b[2+length:]
+	}
+	return b, nil
+}
+
+// seqLess compares sequence numbers by taking wrap around into account.
+func seqLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+// timestampLess compares timestamps by taking wrap around into account.
+func timestampLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
@@ -0,0 +1,254 @@
+// Package utp implements the uTorrent Transport Protocol (BEP 29).
+// A Socket listens on a UDP port and multiplexes uTP connections over it.
+// Connections use LEDBAT congestion control, so they yield to other traffic sharing the same link.
+package utp
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/panzarasa/rain/internal/logger"
+)
+
+const (
+	// Incoming connections waiting to be accepted. New connections are dropped if the queue is full.
+	acceptQueueSize = 32
+	// Interval for checking retransmission timeouts.
+	tickInterval = 50 * time.Millisecond
+	// Max size of an incoming datagram.
+	maxPacketSize = 64 << 10
+)
+
+var errClosed = errors.New("use of closed utp connection")
+
+// connKey identifies a connection on the socket.
+// Packets sent to us contain the receive ID of our side of the connection.
+type connKey struct {
+	addr   string
+	recvID uint16
+}
+
+// Socket is a UDP socket that uTP connections are made over.
+// Socket implements net.Listener for accepting incoming connections.
+type Socket struct {
+	pc  net.PacketConn
+	log logger.Logger
+
+	m     sync.Mutex
+	conns map[connKey]*Conn
+
+	acceptC   chan *Conn
+	closeC    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Listener = (*Socket)(nil)
+
+// Listen on the UDP address for uTP connections.
+func Listen(network string, addr *net.UDPAddr, l logger.Logger) (*Socket, error) {
+	pc, err := net.ListenUDP(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Socket{
+		pc:      pc,
+		log:     l,
+		conns:   make(map[connKey]*Conn),
+		acceptC: make(chan *Conn, acceptQueueSize),
+		closeC:  make(chan struct{}),
+	}
+	go s.readLoop()
+	go s.tickLoop()
+	return s, nil
+}
+
+// Addr returns the local address of the socket.
+func (s *Socket) Addr() net.Addr {
+	return s.pc.LocalAddr()
+}
+
+// Accept waits for and returns the next incoming connection.
+func (s *Socket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.acceptC:
+		return c, nil
+	case <-s.closeC:
+		return nil, errClosed
+	}
+}
+
+// Close the socket. All connections made over the socket are closed too.
+func (s *Socket) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closeC)
+		err = s.pc.Close()
+		s.m.Lock()
+		conns := make([]*Conn, 0, len(s.conns))
+		for _, c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.conns = make(map[connKey]*Conn)
+		s.m.Unlock()
+		for _, c := range conns {
+			c.fail(errClosed)
+		}
+	})
+	return err
+}
+
+// DialContext opens a new uTP connection to addr.
+func (s *Socket) DialContext(ctx context.Context, addr *net.UDPAddr) (*Conn, error) {
+	addr = normalizeAddr(addr)
+	s.m.Lock()
+	select {
+	case <-s.closeC:
+		s.m.Unlock()
+		return nil, errClosed
+	default:
+	}
+	var c *Conn
+	for {
+		recvID := uint16(rand.Intn(1 << 16)) // nolint: gosec
+		key := connKey{addr: addr.String(), recvID: recvID}
+		if _, ok := s.conns[key]; ok {
+			continue
+		}
+		c = newConn(s, addr, recvID, recvID+1)
+		s.conns[key] = c
+		break
+	}
+	s.m.Unlock()
+
+	err := c.connect(ctx)
+	if err != nil {
+		c.m.Lock()
+		c.closed = true
+		c.m.Unlock()
+		s.remove(c)
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *Socket) remove(c *Conn) {
+	s.m.Lock()
+	key := connKey{addr: c.raddr.String(), recvID: c.recvID}
+	if s.conns[key] == c {
+		delete(s.conns, key)
+	}
+	s.m.Unlock()
+}
+
+func (s *Socket) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.closeC:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			s.log.Errorln("cannot read from utp socket:", err)
+			s.Close()
+			return
+		}
+		var h header
+		payload, err := h.unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.handlePacket(normalizeAddr(addr.(*net.UDPAddr)), &h, payload)
+	}
+}
+
+func (s *Socket) handlePacket(addr *net.UDPAddr, h *header, payload []byte) {
+	if h.Type == stSyn {
+		s.handleSyn(addr, h)
+		return
+	}
+	s.m.Lock()
+	c, ok := s.conns[connKey{addr: addr.String(), recvID: h.ConnID}]
+	s.m.Unlock()
+	if !ok {
+		return
+	}
+	c.handlePacket(h, payload)
+}
+
+func (s *Socket) handleSyn(addr *net.UDPAddr, h *header) {
+	key := connKey{addr: addr.String(), recvID: h.ConnID + 1}
+	s.m.Lock()
+	c, ok := s.conns[key]
+	if ok {
+		s.m.Unlock()
+		// Our reply to the SYN is lost.
+		c.handleSyn(h)
+		return
+	}
+	select {
+	case <-s.closeC:
+		s.m.Unlock()
+		return
+	default:
+	}
+	c = newConn(s, addr, h.ConnID+1, h.ConnID)
+	s.conns[key] = c
+	s.m.Unlock()
+
+	c.accept(h)
+	select {
+	case s.acceptC <- c:
+	default:
+		s.log.Debugln("utp accept queue is full, dropping connection from", addr)
+		c.fail(errClosed)
+	}
+}
+
+func (s *Socket) tickLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.m.Lock()
+			conns := make([]*Conn, 0, len(s.conns))
+			for _, c := range s.conns {
+				conns = append(conns, c)
+			}
+			s.m.Unlock()
+			for _, c := range conns {
+				c.tick(now)
+			}
+		case <-s.closeC:
+			return
+		}
+	}
+}
+
+func (s *Socket) writeTo(b []byte, addr *net.UDPAddr) {
+	_, err := s.pc.WriteTo(b, addr)
+	if err != nil {
+		select {
+		case <-s.closeC:
+		default:
+			s.log.Debugln("cannot write to utp socket:", err)
+		}
+	}
+}
+
+// normalizeAddr converts IPv4-mapped IPv6 addresses to IPv4 so the same peer always has the same key.
+func normalizeAddr(addr *net.UDPAddr) *net.UDPAddr {
+	if ip4 := addr.IP.To4(); ip4 != nil && len(addr.IP) != net.IPv4len {
+		return &net.UDPAddr{IP: ip4, Port: addr.Port}
+	}
+	return addr
+}
@@ -0,0 +1,184 @@
+package utp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/panzarasa/rain/internal/logger"
+)
+
+func listen(t *testing.T) *Socket {
+	s, err := Listen("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, logger.New("utp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func dialAccept(t *testing.T, s1, s2 *Socket) (*Conn, net.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c1, err := s1.DialContext(ctx, s2.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := s2.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c1, c2
+}
+
+func TestHeader(t *testing.T) {
+	h := header{Type: stData, ConnID: 1, Timestamp: 2, TimestampDiff: 3, WndSize: 4, SeqNr: 5, AckNr: 6}
+	b := make([]byte, headerSize+3)
+	h.marshal(b)
+	copy(b[headerSize:], "foo")
+	var h2 header
+	payload, err := h2.unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h2 != h {
+		t.Errorf("header: %+v", h2)
+	}
+	if string(payload) != "foo" {
+		t.Errorf("payload: %q", payload)
+	}
+}
+
+func TestSeqLess(t *testing.T) {
+	if !seqLess(1, 2) {
+		t.Error("1 < 2")
+	}
+	if !seqLess(65535, 0) {
+		t.Error("65535 < 0")
+	}
+	if seqLess(2, 2) {
+		t.Error("2 < 2")
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	s1 := listen(t)
+	defer s1.Close()
+	s2 := listen(t)
+	defer s2.Close()
+	c1, c2 := dialAccept(t, s1, s2)
+
+	data1 := make([]byte, 4<<20)
+	data2 := make([]byte, 3<<20)
+	rand.Read(data1)
+	rand.Read(data2)
+
+	// Send data in both directions at the same time.
+	errC := make(chan error, 2)
+	go func() {
+		_, err := c1.Write(data1)
+		errC <- err
+	}()
+	go func() {
+		_, err := c2.Write(data2)
+		errC <- err
+	}()
+	recvC := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, len(data1))
+		_, err := io.ReadFull(c2, b)
+		if err != nil {
+			t.Error(err)
+		}
+		recvC <- b
+	}()
+	b := make([]byte, len(data2))
+	if _, err := io.ReadFull(c1, b); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errC; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(b, data2) {
+		t.Error("invalid data received by dialer")
+	}
+	if !bytes.Equal(<-recvC, data1) {
+		t.Error("invalid data received by acceptor")
+	}
+	c1.Close()
+	c2.Close()
+}
+
+func TestEOF(t *testing.T) {
+	s1 := listen(t)
+	defer s1.Close()
+	s2 := listen(t)
+	defer s2.Close()
+	c1, c2 := dialAccept(t, s1, s2)
+	defer c2.Close()
+
+	if _, err := c1.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c1.Close()
+	b, err := ioutil.ReadAll(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("read: %q", b)
+	}
+	if _, err = c2.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("error: %s", err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	s1 := listen(t)
+	defer s1.Close()
+	s2 := listen(t)
+	defer s2.Close()
+	c1, c2 := dialAccept(t, s1, s2)
+	defer c1.Close()
+	defer c2.Close()
+
+	c2.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := c2.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("error: %s", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	s1 := listen(t)
+	defer s1.Close()
+	pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = s1.DialContext(ctx, pc.LocalAddr().(*net.UDPAddr))
+	if err != context.DeadlineExceeded {
+		t.Errorf("error: %s", err)
+	}
+}
+
+func TestCloseSocket(t *testing.T) {
+	s1 := listen(t)
+	s2 := listen(t)
+	defer s2.Close()
+	c1, c2 := dialAccept(t, s1, s2)
+	defer c2.Close()
+	s1.Close()
+	if _, err := c1.Read(make([]byte, 1)); err != errClosed {
+		t.Errorf("error: %s", err)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
-	"crypto/sha1" // nolint: gosec
+	"crypto/ed25519"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -23,6 +25,7 @@ import (
 	"github.com/panzarasa/rain/internal/logger"
 	"github.com/panzarasa/rain/internal/magnet"
 	"github.com/panzarasa/rain/internal/metainfo"
+	"github.com/panzarasa/rain/internal/rpctypes"
 	"github.com/panzarasa/rain/rainrpc"
 	"github.com/panzarasa/rain/torrent"
 	"github.com/hokaccha/go-prettyjson"
@@ -128,6 +131,28 @@ func main() {
 					Usage: "request timeout",
 					Value: 10 * time.Second,
 				},
+				cli.StringFlag{
+					Name:   "token",
+					Usage:  "authentication token of RPC server",
+					EnvVar: "RAIN_RPC_TOKEN",
+				},
+				cli.StringFlag{
+					Name:  "username",
+					Usage: "username for basic authentication",
+				},
+				cli.StringFlag{
+					Name:   "password",
+					Usage:  "password for basic authentication",
+					EnvVar: "RAIN_RPC_PASSWORD",
+				},
+				cli.StringFlag{
+					Name:  "ca-cert",
+					Usage: "verify server certificate with CA certificates in `FILE`",
+				},
+				cli.BoolFlag{
+					Name:  "insecure",
+					Usage: "do not verify server certificate",
+				},
 			},
 			Before: handleBeforeClient,
 			Subcommands: []cli.Command{
@@ -158,6 +183,34 @@ func main() {
 							Name:  "stopped",
 							Usage: "do not start torrent automatically",
 						},
+						cli.BoolFlag{
+							Name:  "sequential",
+							Usage: "download pieces in order",
+						},
+						cli.Float64Flag{
+							Name:  "seed-ratio",
+							Usage: "stop seeding when upload/download ratio reaches this value (negative for no limit)",
+						},
+						cli.StringFlag{
+							Name:  "seed-ratio-action",
+							Usage: "action when seed ratio is reached: stop, remove or remove-data",
+						},
+						cli.DurationFlag{
+							Name:  "seed-time",
+							Usage: "stop seeding after torrent is seeded for this duration (negative for no limit)",
+						},
+						cli.StringFlag{
+							Name:  "seed-time-action",
+							Usage: "action when seed time is reached: stop, remove or remove-data",
+						},
+						cli.StringFlag{
+							Name:  "data-dir",
+							Usage: "directory on the server to download the files into",
+						},
+						cli.StringFlag{
+							Name:  "storage",
+							Usage: "storage backend on the server: file, memory, blob or s3",
+						},
 						cli.StringFlag{
 							Name:  "id",
 							Usage: "if id is not given, a unique id is automatically generated",
@@ -210,6 +263,23 @@ func main() {
 						},
 					},
 				},
+				{
+					Name:     "events",
+					Usage:    "print events of session as they happen",
+					Category: "Getters",
+					Action:   handleEvents,
+					Flags: []cli.Flag{
+						cli.Uint64Flag{
+							Name:  "after",
+							Usage: "print events after this event ID",
+						},
+						cli.DurationFlag{
+							Name:  "wait",
+							Usage: "how long the server waits for a new event in a single request",
+							Value: 30 * time.Second,
+						},
+					},
+				},
 				{
 					Name:     "trackers",
 					Usage:    "get trackers of torrent",
@@ -234,6 +304,18 @@ func main() {
 						},
 					},
 				},
+				{
+					Name:     "files",
+					Usage:    "get files of torrent",
+					Category: "Getters",
+					Action:   handleFiles,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+					},
+				},
 				{
 					Name:     "peers",
 					Usage:    "get peers of torrent",
@@ -280,6 +362,112 @@ func main() {
 						},
 					},
 				},
+				{
+					Name:     "set-file-priority",
+					Usage:    "set download priority of files in torrent",
+					Category: "Actions",
+					Action:   handleSetFilePriority,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+						cli.IntSliceFlag{
+							Name:  "index,i",
+							Usage: "index of file as listed in files command, can be given multiple times (default: all files)",
+						},
+						cli.StringFlag{
+							Name:     "priority,p",
+							Required: true,
+							Usage:    "one of: skip, low, normal, high",
+						},
+					},
+				},
+				{
+					Name:     "set-sequential",
+					Usage:    "enable or disable downloading pieces in order",
+					Category: "Actions",
+					Action:   handleSetSequential,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+						cli.BoolFlag{
+							Name:  "disable",
+							Usage: "download rarest pieces first",
+						},
+					},
+				},
+				{
+					Name:     "set-speed-limit",
+					Usage:    "set download and upload speed limits of torrent",
+					Category: "Actions",
+					Action:   handleSetSpeedLimit,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+						cli.Int64Flag{
+							Name:  "download,d",
+							Usage: "download speed limit in KiB/s (0 for unlimited)",
+						},
+						cli.Int64Flag{
+							Name:  "upload,u",
+							Usage: "upload speed limit in KiB/s (0 for unlimited)",
+						},
+					},
+				},
+				{
+					Name:     "set-queue-position",
+					Usage:    "move torrent to a position in queue",
+					Category: "Actions",
+					Action:   handleSetQueuePosition,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+						cli.IntFlag{
+							Name:     "position,p",
+							Usage:    "position in queue, 0 is the head of the queue",
+							Required: true,
+						},
+					},
+				},
+				{
+					Name:     "relocate",
+					Usage:    "move files of torrent into another directory on the server",
+					Category: "Actions",
+					Action:   handleRelocate,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+						cli.StringFlag{
+							Name:  "data-dir",
+							Usage: "target directory, empty for the default location",
+						},
+					},
+				},
+				{
+					Name:     "set-global-speed-limit",
+					Usage:    "set download and upload speed limits of session",
+					Category: "Actions",
+					Action:   handleSetGlobalSpeedLimit,
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:  "download,d",
+							Usage: "download speed limit in KiB/s (0 for unlimited)",
+						},
+						cli.Int64Flag{
+							Name:  "upload,u",
+							Usage: "upload speed limit in KiB/s (0 for unlimited)",
+						},
+					},
+				},
 				{
 					Name:     "announce",
 					Usage:    "announce to tracker",
@@ -355,6 +543,43 @@ func main() {
 							Required: true,
 							Usage:    "target server in host:port format",
 						},
+						cli.StringFlag{
+							Name:   "target-token",
+							Usage:  "authentication token of target server",
+							EnvVar: "RAIN_MOVE_TARGET_TOKEN",
+						},
+					},
+				},
+				{
+					Name:     "dht-put",
+					Usage:    "store an item in dht",
+					Category: "Actions",
+					Action:   handleDHTPut,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "value,v",
+							Usage: "value of the item. stored as a bencoded string unless --bencoded is given.",
+						},
+						cli.BoolFlag{
+							Name:  "bencoded",
+							Usage: "value is already bencoded",
+						},
+						cli.StringFlag{
+							Name:  "info-hash,i",
+							Usage: "publish a new version of a mutable torrent with hex encoded `INFOHASH` instead of a value. requires --key-file.",
+						},
+						cli.StringFlag{
+							Name:  "key-file,k",
+							Usage: "store a mutable item signed with the hex encoded ed25519 private key seed in `FILE`. a new key is generated if the file does not exist.",
+						},
+						cli.StringFlag{
+							Name:  "salt,s",
+							Usage: "salt of the mutable item",
+						},
+						cli.Int64Flag{
+							Name:  "seq",
+							Usage: "sequence number of the mutable item. by default, sequence number of the current item is incremented.",
+						},
 					},
 				},
 				{
@@ -385,6 +610,34 @@ func main() {
 						},
 					},
 				},
+				{
+					Name:     "dht-get",
+					Usage:    "get an item from dht",
+					Category: "Getters",
+					Action:   handleDHTGet,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "target,t",
+							Usage: "hex encoded target of the item",
+						},
+						cli.StringFlag{
+							Name:  "public-key,k",
+							Usage: "hex encoded public key of the mutable item",
+						},
+						cli.StringFlag{
+							Name:  "salt,s",
+							Usage: "salt of the mutable item",
+						},
+						cli.BoolFlag{
+							Name:  "bencoded",
+							Usage: "print the value without decoding",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "print the item as json",
+						},
+					},
+				},
 				{
 					Name:     "console",
 					Usage:    "show client console",
@@ -428,7 +681,7 @@ func main() {
 				},
 				{
 					Name:   "infohash",
-					Usage:  "calculate and print info-hash in torrent file",
+					Usage:  "calculate and print info-hash in torrent file. full v2 info-hash is printed on second line for v2 and hybrid torrents.",
 					Action: handleInfoHash,
 					Flags: []cli.Flag{
 						cli.StringFlag{
@@ -472,6 +725,11 @@ func main() {
 							Name:  "webseed,w",
 							Usage: "add webseed `URL`",
 						},
+						cli.StringFlag{
+							Name:  "version",
+							Usage: "BitTorrent protocol `VERSION` of torrent: v1, v2 or hybrid. piece length must be a power of two for v2 and hybrid.",
+							Value: "v1",
+						},
 					},
 				},
 			},
@@ -683,7 +941,17 @@ func handleDownload(c *cli.Context) error {
 }
 
 func handleBeforeClient(c *cli.Context) error {
-	clt = rainrpc.NewClient(c.String("url"))
+	var err error
+	clt, err = rainrpc.NewClientWithOptions(c.String("url"), &rainrpc.ClientOptions{
+		Token:              c.String("token"),
+		Username:           c.String("username"),
+		Password:           c.String("password"),
+		CACertFile:         c.String("ca-cert"),
+		InsecureSkipVerify: c.Bool("insecure"),
+	})
+	if err != nil {
+		return err
+	}
 	clt.SetTimeout(c.Duration("timeout"))
 	return nil
 }
@@ -721,8 +989,16 @@ func handleAdd(c *cli.Context) error {
 	var marshalErr error
 	arg := c.String("torrent")
 	addOpt := &rainrpc.AddTorrentOptions{
-		Stopped: c.Bool("stopped"),
-		ID:      c.String("id"),
+		Stopped:    c.Bool("stopped"),
+		ID:         c.String("id"),
+		Sequential: c.Bool("sequential"),
+
+		SeedRatioLimit:  c.Float64("seed-ratio"),
+		SeedRatioAction: c.String("seed-ratio-action"),
+		SeedTimeLimit:   c.Duration("seed-time"),
+		SeedTimeAction:  c.String("seed-time-action"),
+		DataDir:         c.String("data-dir"),
+		Storage:         c.String("storage"),
 	}
 	if isURI(arg) {
 		resp, err := clt.AddURI(arg, addOpt)
@@ -794,6 +1070,26 @@ func handleSessionStats(c *cli.Context) error {
 	return nil
 }
 
+func handleEvents(c *cli.Context) error {
+	wait := c.Duration("wait")
+	clt.SetTimeout(c.GlobalDuration("timeout") + wait)
+	after := c.Uint64("after")
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		events, err := clt.GetEvents(after, wait)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			err = enc.Encode(e)
+			if err != nil {
+				return err
+			}
+			after = e.ID
+		}
+	}
+}
+
 func handleTrackers(c *cli.Context) error {
 	resp, err := clt.GetTorrentTrackers(c.String("id"))
 	if err != nil {
@@ -822,6 +1118,20 @@ func handleWebseeds(c *cli.Context) error {
 	return nil
 }
 
+func handleFiles(c *cli.Context) error {
+	resp, err := clt.GetTorrentFiles(c.String("id"))
+	if err != nil {
+		return err
+	}
+	b, err := prettyjson.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, _ = os.Stdout.Write(b)
+	_, _ = os.Stdout.WriteString("\n")
+	return nil
+}
+
 func handlePeers(c *cli.Context) error {
 	resp, err := clt.GetTorrentPeers(c.String("id"))
 	if err != nil {
@@ -844,6 +1154,51 @@ func handleAddTracker(c *cli.Context) error {
 	return clt.AddTracker(c.String("id"), c.String("tracker"))
 }
 
+func handleSetFilePriority(c *cli.Context) error {
+	id := c.String("id")
+	files, err := clt.GetTorrentFiles(id)
+	if err != nil {
+		return err
+	}
+	priorities := make([]string, len(files))
+	for i, f := range files {
+		priorities[i] = f.Priority
+	}
+	indexes := c.IntSlice("index")
+	if len(indexes) == 0 {
+		for i := range priorities {
+			priorities[i] = c.String("priority")
+		}
+	}
+	for _, i := range indexes {
+		if i < 0 || i >= len(priorities) {
+			return fmt.Errorf("invalid file index: %d", i)
+		}
+		priorities[i] = c.String("priority")
+	}
+	return clt.SetFilePriorities(id, priorities)
+}
+
+func handleSetSequential(c *cli.Context) error {
+	return clt.SetSequential(c.String("id"), !c.Bool("disable"))
+}
+
+func handleSetSpeedLimit(c *cli.Context) error {
+	return clt.SetTorrentSpeedLimit(c.String("id"), c.Int64("download"), c.Int64("upload"))
+}
+
+func handleSetQueuePosition(c *cli.Context) error {
+	return clt.SetTorrentQueuePosition(c.String("id"), c.Int("position"))
+}
+
+func handleRelocate(c *cli.Context) error {
+	return clt.RelocateTorrent(c.String("id"), c.String("data-dir"))
+}
+
+func handleSetGlobalSpeedLimit(c *cli.Context) error {
+	return clt.SetSpeedLimits(c.Int64("download"), c.Int64("upload"))
+}
+
 func handleAnnounce(c *cli.Context) error {
 	return clt.AnnounceTorrent(c.String("id"))
 }
@@ -869,7 +1224,125 @@ func handleStopAll(c *cli.Context) error {
 }
 
 func handleMove(c *cli.Context) error {
-	return clt.MoveTorrent(c.String("id"), c.String("target"))
+	return clt.MoveTorrent(c.String("id"), c.String("target"), c.String("target-token"))
+}
+
+func handleDHTPut(c *cli.Context) error {
+	var value []byte
+	var err error
+	switch {
+	case c.IsSet("info-hash"):
+		if !c.IsSet("key-file") {
+			return errors.New("key file is required for publishing a mutable torrent")
+		}
+		ih, err2 := hex.DecodeString(c.String("info-hash"))
+		if err2 != nil || len(ih) != 20 {
+			return errors.New("invalid info hash")
+		}
+		value, err = bencode.EncodeBytes(map[string][]byte{"ih": ih})
+	case !c.IsSet("value"):
+		return errors.New("value or info hash must be given")
+	case c.Bool("bencoded"):
+		value = []byte(c.String("value"))
+	default:
+		value, err = bencode.EncodeBytes(c.String("value"))
+	}
+	if err != nil {
+		return err
+	}
+	item := rpctypes.DHTItem{Value: value}
+	if keyFile := c.String("key-file"); keyFile != "" {
+		key, err := readDHTKey(keyFile)
+		if err != nil {
+			return err
+		}
+		salt := []byte(c.String("salt"))
+		seq := c.Int64("seq")
+		if !c.IsSet("seq") {
+			seq = 1
+			target := torrent.DHTTarget(key.Public().(ed25519.PublicKey), salt)
+			current, err := clt.DHTGet(hex.EncodeToString(target[:]), salt)
+			if err == nil {
+				seq = current.Seq + 1
+			}
+		}
+		mi, err := torrent.NewMutableDHTItem(key, salt, value, seq)
+		if err != nil {
+			return err
+		}
+		item = rpctypes.DHTItem{
+			Value:     mi.Value,
+			PublicKey: mi.PublicKey,
+			Salt:      mi.Salt,
+			Seq:       mi.Seq,
+			Signature: mi.Signature,
+		}
+	}
+	target, err := clt.DHTPut(item)
+	if err != nil {
+		return err
+	}
+	fmt.Println(target)
+	return nil
+}
+
+// readDHTKey reads the private key seed from the file. A new key is generated and saved if the file does not exist.
+func readDHTKey(name string) (ed25519.PrivateKey, error) {
+	b, err := ioutil.ReadFile(name) // nolint: gosec
+	if os.IsNotExist(err) {
+		_, key, err2 := ed25519.GenerateKey(nil)
+		if err2 != nil {
+			return nil, err2
+		}
+		err = ioutil.WriteFile(name, []byte(hex.EncodeToString(key.Seed())+"\n"), 0600)
+		return key, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid key size: %d", len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func handleDHTGet(c *cli.Context) error {
+	target := c.String("target")
+	if pk := c.String("public-key"); pk != "" {
+		publicKey, err := hex.DecodeString(pk)
+		if err != nil {
+			return err
+		}
+		t := torrent.DHTTarget(publicKey, []byte(c.String("salt")))
+		target = hex.EncodeToString(t[:])
+	}
+	if target == "" {
+		return errors.New("target or public key must be given")
+	}
+	item, err := clt.DHTGet(target, []byte(c.String("salt")))
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		b, err := prettyjson.Marshal(item)
+		if err != nil {
+			return err
+		}
+		_, _ = os.Stdout.Write(b)
+		_, _ = os.Stdout.WriteString("\n")
+		return nil
+	}
+	var s string
+	if !c.Bool("bencoded") && bencode.DecodeBytes(item.Value, &s) == nil {
+		fmt.Println(s)
+		return nil
+	}
+	fmt.Println(string(item.Value))
+	return nil
 }
 
 func handleConsole(c *cli.Context) error {
@@ -895,6 +1368,12 @@ func handleTorrentShow(c *cli.Context) error {
 		if pieces, ok := info["pieces"].(string); ok {
 			info["pieces"] = fmt.Sprintf("<<< %d bytes of data >>>", len(pieces))
 		}
+		if tree, ok := info["file tree"].(map[string]interface{}); ok {
+			hexPiecesRoots(tree)
+		}
+	}
+	if layers, ok := val["piece layers"].(map[string]interface{}); ok {
+		val["piece layers"] = fmt.Sprintf("<<< %d layers >>>", len(layers))
 	}
 	b, err := prettyjson.Marshal(val)
 	if err != nil {
@@ -905,6 +1384,23 @@ func handleTorrentShow(c *cli.Context) error {
 	return nil
 }
 
+// hexPiecesRoots converts binary "pieces root" values in file tree of v2 torrents to hex strings for printing.
+func hexPiecesRoots(node map[string]interface{}) {
+	for k, v := range node {
+		child, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if k == "" {
+			if root, ok := child["pieces root"].(string); ok {
+				child["pieces root"] = hex.EncodeToString([]byte(root))
+			}
+			continue
+		}
+		hexPiecesRoots(child)
+	}
+}
+
 func handleInfoHash(c *cli.Context) error {
 	f, err := os.Open(c.String("file")) // nolint: gosec
 	if err != nil {
@@ -912,15 +1408,21 @@ func handleInfoHash(c *cli.Context) error {
 	}
 	defer f.Close()
 
-	var metainfo struct {
+	var mi struct {
 		Info bencode.RawMessage `bencode:"info"`
 	}
-	err = bencode.NewDecoder(f).Decode(&metainfo)
+	err = bencode.NewDecoder(f).Decode(&mi)
 	if err != nil {
 		return err
 	}
-	sum := sha1.Sum(metainfo.Info) // nolint: gosec
-	fmt.Println(hex.EncodeToString(sum[:]))
+	info, err := metainfo.NewInfo(mi.Info)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hex.EncodeToString(info.Hash[:]))
+	if info.MetaVersion == 2 {
+		fmt.Println(hex.EncodeToString(info.HashV2[:]))
+	}
 	return nil
 }
 
@@ -932,6 +1434,7 @@ func handleTorrentCreate(c *cli.Context) error {
 	comment := c.String("comment")
 	trackers := c.StringSlice("tracker")
 	webseeds := c.StringSlice("webseed")
+	version := c.String("version")
 
 	var err error
 	out, err = homedir.Expand(out)
@@ -948,11 +1451,21 @@ func handleTorrentCreate(c *cli.Context) error {
 		tiers[i] = []string{tr}
 	}
 
-	info, err := metainfo.NewInfoBytes(path, private, uint32(pieceLength<<10))
+	var info, pieceLayers []byte
+	switch version {
+	case "v1":
+		info, err = metainfo.NewInfoBytes(path, private, uint32(pieceLength<<10))
+	case "v2":
+		info, pieceLayers, err = metainfo.NewInfoBytesV2(path, private, uint32(pieceLength<<10), false)
+	case "hybrid":
+		info, pieceLayers, err = metainfo.NewInfoBytesV2(path, private, uint32(pieceLength<<10), true)
+	default:
+		return fmt.Errorf("unknown torrent version: %q", version)
+	}
 	if err != nil {
 		return err
 	}
-	mi, err := metainfo.NewBytes(info, tiers, webseeds, comment)
+	mi, err := metainfo.NewBytes(info, pieceLayers, tiers, webseeds, comment)
 	if err != nil {
 		return err
 	}
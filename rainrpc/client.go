@@ -2,7 +2,10 @@
 package rainrpc
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -19,16 +22,82 @@ type Client struct {
 	addr       string
 }
 
+// ClientOptions contains optional parameters for connecting to a remote Session.
+type ClientOptions struct {
+	// Token is sent in "Authorization: Bearer <token>" header.
+	Token string
+	// Username and password for HTTP basic authentication.
+	Username string
+	Password string
+	// File that contains CA certificates in PEM format for verifying the certificate of server.
+	// System certificates are used if empty.
+	CACertFile string
+	// Do not verify the certificate of server.
+	InsecureSkipVerify bool
+}
+
 // NewClient returns a new Client for remote address.
 func NewClient(addr string) *Client {
+	clt, _ := NewClientWithOptions(addr, nil)
+	return clt
+}
+
+// NewClientWithOptions returns a new Client for remote address.
+// Returns error only if the CA certificate file cannot be read.
+func NewClientWithOptions(addr string, options *ClientOptions) (*Client, error) {
 	hc := &http.Client{
 		Timeout: 10 * time.Second,
 	}
+	if options != nil {
+		tr := &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}, // nolint: gosec
+		}
+		if options.CACertFile != "" {
+			b, err := ioutil.ReadFile(options.CACertFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, errors.New("no certificate found in CA file")
+			}
+			tr.TLSClientConfig.RootCAs = pool
+		}
+		hc.Transport = &authTransport{
+			base:     tr,
+			token:    options.Token,
+			username: options.Username,
+			password: options.Password,
+		}
+	}
 	return &Client{
 		client:     jsonrpc2.NewCustomHTTPClient(addr, hc),
 		httpClient: hc,
 		addr:       addr,
+	}, nil
+}
+
+// authTransport adds credentials to the requests.
+type authTransport struct {
+	base     http.RoundTripper
+	token    string
+	username string
+	password string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.token == "" && t.username == "" {
+		return t.base.RoundTrip(req)
 	}
+	// RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	} else {
+		req.SetBasicAuth(t.username, t.password)
+	}
+	return t.base.RoundTrip(req)
 }
 
 func (c *Client) SetTimeout(d time.Duration) {
@@ -62,6 +131,17 @@ type AddTorrentOptions struct {
 	ID                string
 	Stopped           bool
 	StopAfterDownload bool
+	Sequential        bool
+	// Seed limits of the torrent. Zero values use the session defaults. Negative values disable the limits.
+	SeedRatioLimit float64
+	SeedTimeLimit  time.Duration
+	// Actions when seed limits are reached: "stop", "remove" or "remove-data". Empty values use the session defaults.
+	SeedRatioAction string
+	SeedTimeAction  string
+	// Directory on the server to download the files into. Empty value uses the session default.
+	DataDir string
+	// Storage backend on the server, e.g. "file", "memory", "blob" or "s3". Empty value uses the session default.
+	Storage string
 }
 
 // AddTorrent adds a new torrent by reading .torrent file.
@@ -75,6 +155,13 @@ func (c *Client) AddTorrent(f io.Reader, options *AddTorrentOptions) (*rpctypes.
 		args.AddTorrentOptions.ID = options.ID
 		args.AddTorrentOptions.Stopped = options.Stopped
 		args.AddTorrentOptions.StopAfterDownload = options.StopAfterDownload
+		args.AddTorrentOptions.Sequential = options.Sequential
+		args.AddTorrentOptions.SeedRatioLimit = options.SeedRatioLimit
+		args.AddTorrentOptions.SeedRatioAction = options.SeedRatioAction
+		args.AddTorrentOptions.SeedTimeLimit = int(options.SeedTimeLimit / time.Second)
+		args.AddTorrentOptions.SeedTimeAction = options.SeedTimeAction
+		args.AddTorrentOptions.DataDir = options.DataDir
+		args.AddTorrentOptions.Storage = options.Storage
 	}
 	var reply rpctypes.AddTorrentResponse
 	return &reply.Torrent, c.client.Call("Session.AddTorrent", args, &reply)
@@ -87,6 +174,13 @@ func (c *Client) AddURI(uri string, options *AddTorrentOptions) (*rpctypes.Torre
 		args.AddTorrentOptions.ID = options.ID
 		args.AddTorrentOptions.Stopped = options.Stopped
 		args.AddTorrentOptions.StopAfterDownload = options.StopAfterDownload
+		args.AddTorrentOptions.Sequential = options.Sequential
+		args.AddTorrentOptions.SeedRatioLimit = options.SeedRatioLimit
+		args.AddTorrentOptions.SeedRatioAction = options.SeedRatioAction
+		args.AddTorrentOptions.SeedTimeLimit = int(options.SeedTimeLimit / time.Second)
+		args.AddTorrentOptions.SeedTimeAction = options.SeedTimeAction
+		args.AddTorrentOptions.DataDir = options.DataDir
+		args.AddTorrentOptions.Storage = options.Storage
 	}
 	var reply rpctypes.AddURIResponse
 	return &reply.Torrent, c.client.Call("Session.AddURI", args, &reply)
@@ -120,6 +214,23 @@ func (c *Client) GetSessionStats() (*rpctypes.SessionStats, error) {
 	return &reply.Stats, c.client.Call("Session.GetSessionStats", args, &reply)
 }
 
+// SetSpeedLimits changes the global download and upload speed limits of the remote Session in KiB/s.
+// Zero value means unlimited.
+func (c *Client) SetSpeedLimits(download, upload int64) error {
+	args := rpctypes.SetSpeedLimitsRequest{Download: download, Upload: upload}
+	var reply rpctypes.SetSpeedLimitsResponse
+	return c.client.Call("Session.SetSpeedLimits", args, &reply)
+}
+
+// GetEvents returns the events with ID greater than after.
+// If there is no such event, the server waits for a new event for at most timeout duration.
+// Timeout must be less than the timeout of the Client. Server limits the timeout to 1 minute.
+func (c *Client) GetEvents(after uint64, timeout time.Duration) ([]rpctypes.Event, error) {
+	args := rpctypes.GetEventsRequest{After: after, Timeout: int(timeout / time.Second)}
+	var reply rpctypes.GetEventsResponse
+	return reply.Events, c.client.Call("Session.GetEvents", args, &reply)
+}
+
 // GetMagnet returns the torrent as a magnet link.
 func (c *Client) GetMagnet(id string) (string, error) {
 	args := rpctypes.GetMagnetRequest{ID: id}
@@ -160,6 +271,52 @@ func (c *Client) GetTorrentWebseeds(id string) ([]rpctypes.Webseed, error) {
 	return reply.Webseeds, c.client.Call("Session.GetTorrentWebseeds", args, &reply)
 }
 
+// GetTorrentFiles returns the list of files in the torrent with their download priorities.
+func (c *Client) GetTorrentFiles(id string) ([]rpctypes.File, error) {
+	args := rpctypes.GetTorrentFilesRequest{ID: id}
+	var reply rpctypes.GetTorrentFilesResponse
+	return reply.Files, c.client.Call("Session.GetTorrentFiles", args, &reply)
+}
+
+// SetFilePriorities sets the download priority of each file in the torrent.
+// Valid priorities are "skip", "low", "normal" and "high".
+func (c *Client) SetFilePriorities(id string, priorities []string) error {
+	args := rpctypes.SetFilePrioritiesRequest{ID: id, Priorities: priorities}
+	var reply rpctypes.SetFilePrioritiesResponse
+	return c.client.Call("Session.SetFilePriorities", args, &reply)
+}
+
+// SetSequential enables or disables downloading pieces of the torrent in order.
+func (c *Client) SetSequential(id string, value bool) error {
+	args := rpctypes.SetSequentialRequest{ID: id, Sequential: value}
+	var reply rpctypes.SetSequentialResponse
+	return c.client.Call("Session.SetSequential", args, &reply)
+}
+
+// SetTorrentSpeedLimit sets the download and upload speed limits of the torrent in KiB/s.
+// Zero value means unlimited.
+func (c *Client) SetTorrentSpeedLimit(id string, download, upload int64) error {
+	args := rpctypes.SetTorrentSpeedLimitRequest{ID: id, Download: download, Upload: upload}
+	var reply rpctypes.SetTorrentSpeedLimitResponse
+	return c.client.Call("Session.SetTorrentSpeedLimit", args, &reply)
+}
+
+// SetTorrentQueuePosition moves the torrent to a position in queue. Position 0 is the head of the queue.
+func (c *Client) SetTorrentQueuePosition(id string, position int) error {
+	args := rpctypes.SetTorrentQueuePositionRequest{ID: id, Position: position}
+	var reply rpctypes.SetTorrentQueuePositionResponse
+	return c.client.Call("Session.SetTorrentQueuePosition", args, &reply)
+}
+
+// RelocateTorrent moves the files of the torrent into another directory on the server.
+// Empty dataDir moves the files back to the default location.
+// The call returns after all files are moved. Timeout of the Client must be long enough for moving the files.
+func (c *Client) RelocateTorrent(id, dataDir string) error {
+	args := rpctypes.RelocateTorrentRequest{ID: id, DataDir: dataDir}
+	var reply rpctypes.RelocateTorrentResponse
+	return c.client.Call("Session.RelocateTorrent", args, &reply)
+}
+
 // StartTorrent starts the torrent.
 func (c *Client) StartTorrent(id string) error {
 	args := rpctypes.StartTorrentRequest{ID: id}
@@ -190,8 +347,9 @@ func (c *Client) VerifyTorrent(id string) error {
 }
 
 // MoveTorrent moves the torrent to another Session.
-func (c *Client) MoveTorrent(id, target string) error {
-	args := rpctypes.MoveTorrentRequest{ID: id, Target: target}
+// token is used for authenticating to the RPC server of the target. It may be empty if the target does not require authentication.
+func (c *Client) MoveTorrent(id, target, token string) error {
+	args := rpctypes.MoveTorrentRequest{ID: id, Target: target, Token: token}
 	var reply rpctypes.MoveTorrentResponse
 	return c.client.Call("Session.MoveTorrent", args, &reply)
 }
@@ -223,3 +381,18 @@ func (c *Client) AddTracker(id string, uri string) error {
 	var reply rpctypes.AddTrackerResponse
 	return c.client.Call("Session.AddTracker", args, &reply)
 }
+
+// DHTPut stores the item in DHT and returns the hex encoded target of the item.
+func (c *Client) DHTPut(item rpctypes.DHTItem) (string, error) {
+	args := rpctypes.DHTPutRequest{Item: item}
+	var reply rpctypes.DHTPutResponse
+	err := c.client.Call("Session.DHTPut", args, &reply)
+	return reply.Target, err
+}
+
+// DHTGet retrieves the item with the hex encoded target from DHT. Salt is required for mutable items only.
+func (c *Client) DHTGet(target string, salt []byte) (*rpctypes.DHTItem, error) {
+	args := rpctypes.DHTGetRequest{Target: target, Salt: salt}
+	var reply rpctypes.DHTGetResponse
+	return &reply.Item, c.client.Call("Session.DHTGet", args, &reply)
+}
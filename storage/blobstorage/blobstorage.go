@@ -0,0 +1,159 @@
+// Package blobstorage implements Storage interface that packs all files of a torrent into a single sparse file.
+package blobstorage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/panzarasa/rain/storage"
+)
+
+// BlobStorage implements Storage interface for saving all files into a single file on disk.
+// Files are placed one after another in the order they are opened.
+// Torrents open their files in the order they appear in metainfo, so the layout does not change between runs.
+type BlobStorage struct {
+	path string
+	// Size of the blob before it is opened by this BlobStorage. -1 if the blob does not exist.
+	initialSize int64
+
+	m        sync.Mutex
+	file     *os.File
+	refs     int
+	segments map[string]segment
+	end      int64
+}
+
+type segment struct {
+	offset, size int64
+}
+
+// New returns a new BlobStorage that saves files into the blob at path.
+func New(path string) (*BlobStorage, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &BlobStorage{
+		path:        path,
+		initialSize: -1,
+		segments:    make(map[string]segment),
+	}
+	fi, err := os.Stat(path)
+	if err == nil {
+		s.initialSize = fi.Size()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s, nil
+}
+
+var _ storage.Storage = (*BlobStorage)(nil)
+
+// Path returns the absolute path of the blob.
+func (s *BlobStorage) Path() string {
+	return s.path
+}
+
+// Open a file.
+// The file exists if it is opened before or the blob has existed before and it is large enough to contain the file.
+func (s *BlobStorage) Open(name string, size int64) (f storage.File, exists bool, err error) {
+	name = filepath.Clean(name)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	seg, ok := s.segments[name]
+	if !ok {
+		seg = segment{offset: s.end, size: size}
+	} else if seg.size != size {
+		return nil, false, errors.New("size of file has changed: " + name)
+	}
+
+	if s.file == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), os.ModeDir|0750)
+		if err != nil {
+			return
+		}
+		s.file, err = os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0640) // nolint: gosec
+		if err != nil {
+			return
+		}
+	}
+	defer func() {
+		if err != nil && s.refs == 0 {
+			_ = s.file.Close()
+			s.file = nil
+		}
+	}()
+
+	// Grow the blob without writing data. Unwritten regions do not use disk space on most file systems.
+	fi, err := s.file.Stat()
+	if err != nil {
+		return
+	}
+	if fi.Size() < seg.offset+seg.size {
+		err = s.file.Truncate(seg.offset + seg.size)
+		if err != nil {
+			return
+		}
+	}
+	if !ok {
+		s.segments[name] = seg
+		s.end += size
+	}
+	s.refs++
+	// Files that are opened before by this BlobStorage exist in the blob.
+	exists = ok || s.initialSize >= seg.offset+seg.size
+	return &file{storage: s, file: s.file, segment: seg}, exists, nil
+}
+
+func (s *BlobStorage) release() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+type file struct {
+	storage *BlobStorage
+	file    *os.File
+	segment
+	closeOnce sync.Once
+}
+
+var errOutOfBounds = errors.New("write out of file bounds")
+
+func (f *file) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off >= f.size {
+		return 0, io.EOF
+	}
+	if rem := f.size - off; int64(len(p)) > rem {
+		n, err = f.file.ReadAt(p[:rem], f.offset+off)
+		if err == nil {
+			err = io.EOF
+		}
+		return
+	}
+	return f.file.ReadAt(p, f.offset+off)
+}
+
+func (f *file) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off+int64(len(p)) > f.size {
+		return 0, errOutOfBounds
+	}
+	return f.file.WriteAt(p, f.offset+off)
+}
+
+func (f *file) Close() error {
+	var err error
+	f.closeOnce.Do(func() { err = f.storage.release() })
+	return err
+}
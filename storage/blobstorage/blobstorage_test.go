@@ -0,0 +1,80 @@
+package blobstorage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlobStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rain-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "torrent.blob")
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f1, exists, err := s.Open("a", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("file must not exist")
+	}
+	f2, _, err := s.Open("b/c", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f1.WriteAt([]byte("abc"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f2.WriteAt([]byte("defg"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f1.WriteAt([]byte("xy"), 2)
+	if err != errOutOfBounds {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f1.Close()
+	f2.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "abcdefg" {
+		t.Fatalf("unexpected blob: %q", b)
+	}
+
+	// Layout is the same when the files are opened in the same order.
+	s, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, exists, err = s.Open("a", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("file must exist")
+	}
+	f2, _, err = s.Open("b/c", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	_, err = f2.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "defg" {
+		t.Fatalf("unexpected data: %q", buf)
+	}
+}
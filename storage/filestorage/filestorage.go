@@ -5,7 +5,7 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/panzarasa/rain/internal/storage"
+	"github.com/panzarasa/rain/storage"
 )
 
 // FileStorage implements Storage interface for saving files on disk.
@@ -25,6 +25,11 @@ func New(dest string) (*FileStorage, error) {
 
 var _ storage.Storage = (*FileStorage)(nil)
 
+// Dest returns the absolute path of the directory that the files are saved into.
+func (s *FileStorage) Dest() string {
+	return s.dest
+}
+
 // Open a file.
 func (s *FileStorage) Open(name string, size int64) (f storage.File, exists bool, err error) {
 	name = filepath.Clean(name)
@@ -0,0 +1,135 @@
+// Package memstorage implements Storage interface that keeps files in memory.
+package memstorage
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/panzarasa/rain/storage"
+)
+
+// chunkSize is the unit of memory allocation. Regions of a file that are never written do not use memory.
+const chunkSize = 16 * 1024
+
+// MemStorage implements Storage interface for keeping files in memory.
+// Contents are lost when the MemStorage is garbage collected.
+type MemStorage struct {
+	m     sync.Mutex
+	files map[string]*file
+}
+
+// New returns a new empty MemStorage.
+func New() *MemStorage {
+	return &MemStorage{files: make(map[string]*file)}
+}
+
+var _ storage.Storage = (*MemStorage)(nil)
+
+// Open a file. Files that are opened before are reported as existing.
+func (s *MemStorage) Open(name string, size int64) (f storage.File, exists bool, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	mf, ok := s.files[name]
+	if !ok {
+		mf = &file{chunks: make(map[int64][]byte)}
+		s.files[name] = mf
+	}
+	mf.truncate(size)
+	return mf, ok, nil
+}
+
+// Size returns the number of bytes allocated for file contents.
+func (s *MemStorage) Size() int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	var n int64
+	for _, f := range s.files {
+		f.m.RLock()
+		n += int64(len(f.chunks)) * chunkSize
+		f.m.RUnlock()
+	}
+	return n
+}
+
+type file struct {
+	m      sync.RWMutex
+	size   int64
+	chunks map[int64][]byte
+}
+
+var errNegativeOffset = errors.New("negative offset")
+
+func (f *file) truncate(size int64) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	for i, c := range f.chunks {
+		begin := i * chunkSize
+		switch {
+		case begin >= size:
+			delete(f.chunks, i)
+		case begin+chunkSize > size:
+			for j := size - begin; j < chunkSize; j++ {
+				c[j] = 0
+			}
+		}
+	}
+	f.size = size
+}
+
+func (f *file) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	f.m.RLock()
+	defer f.m.RUnlock()
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if rem := f.size - off; int64(len(p)) > rem {
+		p = p[:rem]
+		err = io.EOF
+	}
+	for n < len(p) {
+		i, o := (off+int64(n))/chunkSize, (off+int64(n))%chunkSize
+		var m int
+		if c, ok := f.chunks[i]; ok {
+			m = copy(p[n:], c[o:])
+		} else {
+			m = len(p) - n
+			if limit := int(chunkSize - o); m > limit {
+				m = limit
+			}
+			for j := n; j < n+m; j++ {
+				p[j] = 0
+			}
+		}
+		n += m
+	}
+	return n, err
+}
+
+func (f *file) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if end := off + int64(len(p)); end > f.size {
+		f.size = end
+	}
+	for n < len(p) {
+		i, o := (off+int64(n))/chunkSize, (off+int64(n))%chunkSize
+		c, ok := f.chunks[i]
+		if !ok {
+			c = make([]byte, chunkSize)
+			f.chunks[i] = c
+		}
+		n += copy(c[o:], p[n:])
+	}
+	return n, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
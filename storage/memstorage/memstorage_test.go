@@ -0,0 +1,51 @@
+package memstorage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	s := New()
+	f, exists, err := s.Open("a/b", chunkSize*3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("file must not exist")
+	}
+	data := bytes.Repeat([]byte{'x'}, 100)
+	_, err = f.WriteAt(data, chunkSize-50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != 2*chunkSize {
+		t.Fatalf("unexpected size: %d", s.Size())
+	}
+	buf := make([]byte, 200)
+	n, err := f.ReadAt(buf, chunkSize-100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(make([]byte, 50), data...)
+	expected = append(expected, make([]byte, 50)...)
+	if n != len(buf) || !bytes.Equal(buf, expected) {
+		t.Fatal("unexpected data")
+	}
+	n, err = f.ReadAt(buf, chunkSize*3-10)
+	if n != 10 || err != io.EOF {
+		t.Fatalf("unexpected read at end: n=%d err=%v", n, err)
+	}
+
+	_, exists, err = s.Open("a/b", chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("file must exist")
+	}
+	if s.Size() != chunkSize {
+		t.Fatalf("unexpected size after truncate: %d", s.Size())
+	}
+}
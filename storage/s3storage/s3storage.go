@@ -0,0 +1,321 @@
+// Package s3storage implements Storage interface that keeps files as objects in an S3 compatible object storage.
+package s3storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/panzarasa/rain/storage"
+)
+
+// DefaultPartSize is the size of parts in multipart uploads if Config.PartSize is not set.
+const DefaultPartSize = 16 << 20
+
+// maxParts is the maximum number of parts in a multipart upload allowed by S3.
+const maxParts = 10000
+
+// Config of S3Storage.
+type Config struct {
+	// Endpoint is the base URL of the API, e.g. "https://s3.amazonaws.com" or "http://127.0.0.1:9000" for MinIO.
+	// Objects are addressed in path style: <endpoint>/<bucket>/<key>.
+	Endpoint string
+	// Region of the bucket. Default is "us-east-1".
+	Region string
+	Bucket string
+	// Prefix is prepended to the paths of files in torrent to get the object keys.
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// StagingDir is the local directory that written files are kept in until they are uploaded.
+	StagingDir string
+	// Files larger than PartSize are uploaded in multiple parts. S3 requires parts to be at least 5 MiB.
+	// Part size is increased for files that cannot be uploaded in 10,000 parts.
+	PartSize int64
+	// HTTPClient is used for making requests. Default is http.DefaultClient.
+	HTTPClient *http.Client
+	// OnUploadError is called from the upload goroutine when a closed file cannot be uploaded.
+	OnUploadError func(name string, err error)
+}
+
+// S3Storage implements Storage interface for keeping files in a bucket.
+// Reads of existing objects are made with ranged requests, so seeding does not need local disk space.
+// Written files are staged in a local directory and uploaded in background after they are closed.
+// Staged files that are not uploaded (e.g. the program is killed) are uploaded next time they are closed.
+type S3Storage struct {
+	client        *client
+	prefix        string
+	stagingDir    string
+	partSize      int64
+	onUploadError func(name string, err error)
+
+	mUploads sync.Mutex
+	// uploads contains the files that are being uploaded. Channels are closed when the uploads finish.
+	uploads map[string]chan struct{}
+	// uploadErr is the last error returned from uploads since the last call to Wait.
+	uploadErr error
+}
+
+// New returns a new S3Storage.
+func New(cfg Config) (*S3Storage, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("s3 endpoint is required")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = DefaultPartSize
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	stagingDir, err := filepath.Abs(cfg.StagingDir)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Storage{
+		client: &client{
+			endpoint:   u,
+			region:     cfg.Region,
+			bucket:     cfg.Bucket,
+			accessKey:  cfg.AccessKey,
+			secretKey:  cfg.SecretKey,
+			httpClient: cfg.HTTPClient,
+			now:        time.Now,
+		},
+		prefix:        cfg.Prefix,
+		stagingDir:    stagingDir,
+		partSize:      cfg.PartSize,
+		onUploadError: cfg.OnUploadError,
+		uploads:       make(map[string]chan struct{}),
+	}, nil
+}
+
+var _ storage.Storage = (*S3Storage)(nil)
+
+// Open a file. The file exists if an object with the same size exists in the bucket or the file is staged locally.
+// If the file is being uploaded, Open waits until the upload is finished.
+func (s *S3Storage) Open(name string, size int64) (f storage.File, exists bool, err error) {
+	name = filepath.Clean(name)
+	s.mUploads.Lock()
+	done := s.uploads[name]
+	s.mUploads.Unlock()
+	if done != nil {
+		<-done
+	}
+	sf := &file{
+		storage:     s,
+		name:        name,
+		key:         s.prefix + path.Clean(filepath.ToSlash(name)),
+		size:        size,
+		stagingPath: filepath.Join(s.stagingDir, name),
+	}
+
+	// A staged file is left from a previous run that could not upload it.
+	_, err = os.Stat(sf.stagingPath)
+	if err == nil {
+		err = sf.openStaging(false)
+		if err != nil {
+			return
+		}
+		sf.dirty = true
+		return sf, true, nil
+	}
+	if !os.IsNotExist(err) {
+		return
+	}
+
+	objectSize, exists, err := s.client.head(sf.key)
+	if err != nil {
+		return
+	}
+	if exists && objectSize == size {
+		return sf, true, nil
+	}
+	// Object is created or replaced when the file is closed.
+	err = sf.openStaging(false)
+	if err != nil {
+		return
+	}
+	sf.dirty = size == 0
+	return sf, false, nil
+}
+
+type file struct {
+	storage     *S3Storage
+	name        string
+	key         string
+	size        int64
+	stagingPath string
+
+	m sync.RWMutex
+	// staging is nil if the file is read from the bucket.
+	staging *os.File
+	// dirty is true if the staging file needs to be uploaded.
+	dirty bool
+}
+
+var errOutOfBounds = errors.New("write out of file bounds")
+
+// openStaging opens the staging file. If download is true, contents of the object is copied into the staging file.
+func (f *file) openStaging(download bool) (err error) {
+	err = os.MkdirAll(filepath.Dir(f.stagingPath), os.ModeDir|0750)
+	if err != nil {
+		return
+	}
+	of, err := os.OpenFile(f.stagingPath, os.O_RDWR|os.O_CREATE, 0640) // nolint: gosec
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = of.Close()
+			_ = os.Remove(f.stagingPath)
+		}
+	}()
+	if download {
+		err = f.storage.client.download(f.key, of)
+		if err != nil {
+			return
+		}
+	}
+	err = of.Truncate(f.size)
+	if err != nil {
+		return
+	}
+	f.staging = of
+	return nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off >= f.size {
+		return 0, io.EOF
+	}
+	if rem := f.size - off; int64(len(p)) > rem {
+		p = p[:rem]
+		err = io.EOF
+	}
+	f.m.RLock()
+	defer f.m.RUnlock()
+	var err2 error
+	if f.staging != nil {
+		n, err2 = f.staging.ReadAt(p, off)
+	} else {
+		n, err2 = f.storage.client.readRange(f.key, p, off)
+	}
+	if err2 != nil {
+		err = err2
+	}
+	return
+}
+
+func (f *file) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off+int64(len(p)) > f.size {
+		return 0, errOutOfBounds
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.staging == nil {
+		// Existing object is modified. Writes are made on a local copy.
+		err = f.openStaging(true)
+		if err != nil {
+			return
+		}
+	}
+	f.dirty = true
+	return f.staging.WriteAt(p, off)
+}
+
+// Close starts uploading the file in background if it is written.
+// If upload fails, the staging file is kept on disk and it is uploaded after the file is opened and closed again.
+func (f *file) Close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.staging == nil {
+		return nil
+	}
+	staging := f.staging
+	f.staging = nil
+	if f.dirty {
+		f.dirty = false
+		f.storage.startUpload(f.name, f.key, staging, f.size)
+		return nil
+	}
+	err := staging.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(f.stagingPath)
+}
+
+// startUpload uploads the staging file in a new goroutine. Staging file is closed after the upload.
+// It is removed if the upload is successful.
+func (s *S3Storage) startUpload(name, key string, staging *os.File, size int64) {
+	done := make(chan struct{})
+	s.mUploads.Lock()
+	s.uploads[name] = done
+	s.mUploads.Unlock()
+	go func() {
+		defer close(done)
+		err := s.client.upload(key, staging, size, partSizeFor(size, s.partSize))
+		err2 := staging.Close()
+		if err == nil {
+			err = err2
+		}
+		if err == nil {
+			err = os.Remove(staging.Name())
+		}
+		s.mUploads.Lock()
+		delete(s.uploads, name)
+		if err != nil {
+			s.uploadErr = err
+		}
+		s.mUploads.Unlock()
+		if err != nil && s.onUploadError != nil {
+			s.onUploadError(name, err)
+		}
+	}()
+}
+
+// Wait blocks until the uploads of closed files are finished.
+// Returns the last upload error since the previous call to Wait.
+func (s *S3Storage) Wait() error {
+	s.mUploads.Lock()
+	uploads := make([]chan struct{}, 0, len(s.uploads))
+	for _, done := range s.uploads {
+		uploads = append(uploads, done)
+	}
+	s.mUploads.Unlock()
+	for _, done := range uploads {
+		<-done
+	}
+	s.mUploads.Lock()
+	defer s.mUploads.Unlock()
+	err := s.uploadErr
+	s.uploadErr = nil
+	return err
+}
+
+// partSizeFor returns the size of parts for uploading an object of size.
+// Part size is increased to a multiple of 1 MiB if the object cannot be uploaded in maxParts parts.
+func partSizeFor(size, partSize int64) int64 {
+	if min := (size + maxParts - 1) / maxParts; min > partSize {
+		const mib = 1 << 20
+		return (min + mib - 1) / mib * mib
+	}
+	return partSize
+}
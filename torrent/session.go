@@ -9,57 +9,77 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/panzarasa/rain/internal/bitfield"
 	"github.com/panzarasa/rain/internal/blocklist"
+	"github.com/panzarasa/rain/internal/btconn"
+	"github.com/panzarasa/rain/internal/dhtitem"
+	"github.com/panzarasa/rain/internal/dirwatcher"
 	"github.com/panzarasa/rain/internal/logger"
+	"github.com/panzarasa/rain/internal/lsd"
 	"github.com/panzarasa/rain/internal/piececache"
+	"github.com/panzarasa/rain/internal/proxy"
 	"github.com/panzarasa/rain/internal/resolver"
 	"github.com/panzarasa/rain/internal/resourcemanager"
-	"github.com/panzarasa/rain/internal/resumer/boltdbresumer"
+	"github.com/panzarasa/rain/internal/resumer"
 	"github.com/panzarasa/rain/internal/semaphore"
+	"github.com/panzarasa/rain/internal/speedlimiter"
 	"github.com/panzarasa/rain/internal/tracker"
 	"github.com/panzarasa/rain/internal/trackermanager"
-	"github.com/juju/ratelimit"
+	"github.com/panzarasa/rain/storage/blobstorage"
+	"github.com/panzarasa/rain/storage/filestorage"
 	"github.com/mitchellh/go-homedir"
 	"github.com/nictuku/dht"
-	"go.etcd.io/bbolt"
 )
 
-var (
-	sessionBucket         = []byte("session")
-	torrentsBucket        = []byte("torrents")
-	blocklistKey          = []byte("blocklist")
-	blocklistTimestampKey = []byte("blocklist-timestamp")
-	blocklistURLHashKey   = []byte("blocklist-url-hash")
+// Keys of the session values in resume database.
+const (
+	blocklistKey          = "blocklist"
+	blocklistTimestampKey = "blocklist-timestamp"
+	blocklistURLHashKey   = "blocklist-url-hash"
 )
 
 // Session contains torrents, DHT node, caches and other data structures shared by multiple torrents.
 type Session struct {
-	config         Config
-	db             *bbolt.DB
-	resumer        *boltdbresumer.Resumer
-	log            logger.Logger
-	extensions     [8]byte
-	dht            *dht.DHT
-	rpc            *rpcServer
-	trackerManager *trackermanager.TrackerManager
-	ram            *resourcemanager.ResourceManager
-	pieceCache     *piececache.Cache
-	webseedClient  http.Client
-	createdAt      time.Time
-	semWrite       *semaphore.Semaphore
-	metrics        *sessionMetrics
-	bucketDownload *ratelimit.Bucket
-	bucketUpload   *ratelimit.Bucket
-	closeC         chan struct{}
+	config          Config
+	resumer         resumer.Resumer
+	log             logger.Logger
+	extensions      [8]byte
+	dht             *dht.DHT
+	dhtItems        *dhtitem.Node
+	lsd             *lsd.LSD
+	rpc             *rpcServer
+	trackerManager  *trackermanager.TrackerManager
+	ram             *resourcemanager.ResourceManager
+	pieceCache      *piececache.Cache
+	webseedClient   http.Client
+	proxy           *proxy.Dialer
+	proxyTransport  *http.Transport
+	s3Client        http.Client
+	moveClient      http.Client
+	peerDial        btconn.DialFunc
+	createdAt       time.Time
+	semWrite        *semaphore.Semaphore
+	metrics         *sessionMetrics
+	limiterDownload *speedlimiter.Limiter
+	limiterUpload   *speedlimiter.Limiter
+	closeC          chan struct{}
 
 	mPeerRequests   sync.Mutex
 	dhtPeerRequests map[*torrent]struct{}
 
+	// Torrents that are updated when a new version is published in DHT (BEP 46). Keys are torrent IDs.
+	mMutableTorrents sync.Mutex
+	mutableTorrents  map[string]*mutableTorrent
+
+	// Torrents that are being received from other Sessions. Keys are torrent IDs.
+	mMoves sync.Mutex
+	moves  map[string]*incomingMove
+
 	mTorrents          sync.RWMutex
 	torrents           map[string]*Torrent
 	torrentsByInfoHash map[dht.InfoHash][]*Torrent
@@ -71,6 +91,40 @@ type Session struct {
 	mBlocklist         sync.RWMutex
 	blocklist          *blocklist.Blocklist
 	blocklistTimestamp time.Time
+
+	mSpeedLimits sync.Mutex
+	// Global speed limits in bytes/s.
+	speedLimitDownload int64
+	speedLimitUpload   int64
+	speedLimitSchedule []speedLimitRule
+
+	defaultSeedLimits seedLimits
+
+	// Protects queue fields of torrents.
+	mQueue            sync.Mutex
+	lastQueuePosition int
+	// Serializes processing of the queue.
+	mProcessQueue sync.Mutex
+	queueC        chan struct{}
+
+	mEvents sync.Mutex
+	events  []Event
+	// Starts from the current time in microseconds, so the IDs are greater than the IDs of previous Sessions
+	// and clients that wait for events after an ID from before a restart get the new events.
+	lastEventID uint64
+	// Closed and replaced when a new event is published.
+	eventsC chan struct{}
+	hookC   chan Event
+
+	mScrapes sync.RWMutex
+	scrapes  map[scrapeKey]scrapeResult
+
+	// Extension protocol messages registered by the application.
+	customExtensions extensionRegistry
+
+	watchers []*dirwatcher.Watcher
+	// Waits goroutines adding files from watch directories.
+	watchWG sync.WaitGroup
 }
 
 // NewSession creates a new Session for downloading and seeding torrents.
@@ -94,45 +148,65 @@ func NewSession(cfg Config) (*Session, error) {
 	if err != nil {
 		return nil, err
 	}
+	schedule, err := parseSpeedLimitSchedule(cfg.SpeedLimitSchedule)
+	if err != nil {
+		return nil, err
+	}
+	seedLimits, err := defaultSeedLimits(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.WatchDirs, err = prepareWatchDirs(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	_, err = cfg.storageProvider("", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	px, err := newProxy(&cfg)
+	if err != nil {
+		return nil, err
+	}
 	err = os.MkdirAll(filepath.Dir(cfg.Database), 0750)
 	if err != nil {
 		return nil, err
 	}
 	l := logger.New("session")
-	db, err := bbolt.Open(cfg.Database, 0640, &bbolt.Options{Timeout: time.Second})
-	if err == bbolt.ErrTimeout {
-		return nil, errors.New("resume database is locked by another process")
-	} else if err != nil {
+	res, err := cfg.openResumer()
+	if err != nil {
 		return nil, err
 	}
+	var (
+		dhtNode      *dht.DHT
+		dhtItemsNode *dhtitem.Node
+		lsdNode      *lsd.LSD
+		c            *Session
+	)
+	// Release the resources opened so far if a later step fails.
 	defer func() {
-		if err != nil {
-			db.Close()
+		if err == nil {
+			return
 		}
-	}()
-	var ids []string
-	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err2 := tx.CreateBucketIfNotExists(sessionBucket)
-		if err2 != nil {
-			return err2
+		if c != nil {
+			_ = c.Close()
+			return
 		}
-		b, err2 := tx.CreateBucketIfNotExists(torrentsBucket)
-		if err2 != nil {
-			return err2
+		if dhtNode != nil {
+			dhtNode.Stop()
 		}
-		return b.ForEach(func(k, _ []byte) error {
-			ids = append(ids, string(k))
-			return nil
-		})
-	})
-	if err != nil {
-		return nil, err
-	}
-	res, err := boltdbresumer.New(db, torrentsBucket)
+		if dhtItemsNode != nil {
+			dhtItemsNode.Close()
+		}
+		if lsdNode != nil {
+			lsdNode.Close()
+		}
+		_ = res.Close()
+	}()
+	ids, err := res.List()
 	if err != nil {
 		return nil, err
 	}
-	var dhtNode *dht.DHT
 	if cfg.DHTEnabled {
 		dhtConfig := dht.NewConfig()
 		dhtConfig.Address = cfg.DHTHost
@@ -140,15 +214,32 @@ func NewSession(cfg Config) (*Session, error) {
 		dhtConfig.DHTRouters = strings.Join(cfg.DHTBootstrapNodes, ",")
 		dhtConfig.SaveRoutingTable = false
 		dhtConfig.NumTargetPeers = 0
-		dhtNode, err = dht.New(dhtConfig)
+		var n *dht.DHT
+		n, err = dht.New(dhtConfig)
+		if err != nil {
+			return nil, err
+		}
+		err = n.Start()
 		if err != nil {
 			return nil, err
 		}
-		err = dhtNode.Start()
+		dhtNode = n
+	}
+	if cfg.DHTEnabled && cfg.DHTItemsPort != 0 {
+		addr := net.JoinHostPort(cfg.DHTHost, strconv.Itoa(int(cfg.DHTItemsPort)))
+		dhtItemsNode, err = dhtitem.New(addr, cfg.DHTBootstrapNodes, logger.New("dht items"))
 		if err != nil {
 			return nil, err
 		}
 	}
+	if cfg.LSDEnabled {
+		lsdNode, err = lsd.New(logger.New("lsd"))
+		if err != nil {
+			// Multicast may not be available on the host. Session can work without it.
+			l.Warningln("cannot start local service discovery:", err)
+			lsdNode, err = nil, nil
+		}
+	}
 	ports := make(map[int]struct{})
 	for p := cfg.PortBegin; p < cfg.PortEnd; p++ {
 		ports[int(p)] = struct{}{}
@@ -159,25 +250,44 @@ func NewSession(cfg Config) (*Session, error) {
 	if cfg.BlocklistEnabledForTrackers {
 		blTracker = bl
 	}
-	c := &Session{
+	c = &Session{
 		config:             cfg,
-		db:                 db,
 		resumer:            res,
 		blocklist:          bl,
-		trackerManager:     trackermanager.New(blTracker, cfg.DNSResolveTimeout, !cfg.TrackerHTTPVerifyTLS),
+		trackerManager:     trackermanager.New(blTracker, cfg.DNSResolveTimeout, !cfg.TrackerHTTPVerifyTLS, proxyFor(px, &cfg, cfg.ProxyTrackers), cfg.ProxyOnly),
 		log:                l,
 		torrents:           make(map[string]*Torrent),
 		torrentsByInfoHash: make(map[dht.InfoHash][]*Torrent),
+		mutableTorrents:    make(map[string]*mutableTorrent),
+		moves:              make(map[string]*incomingMove),
 		availablePorts:     ports,
 		dht:                dhtNode,
+		dhtItems:           dhtItemsNode,
+		lsd:                lsdNode,
 		pieceCache:         piececache.New(cfg.ReadCacheSize, cfg.ReadCacheTTL, cfg.ParallelReads),
 		ram:                resourcemanager.New(cfg.WriteCacheSize),
 		createdAt:          time.Now(),
 		semWrite:           semaphore.New(int(cfg.ParallelWrites)),
 		closeC:             make(chan struct{}),
+		queueC:             make(chan struct{}, 1),
+		eventsC:            make(chan struct{}),
+		lastEventID:        uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+		hookC:              make(chan Event, hookQueueSize),
+		scrapes:            make(map[scrapeKey]scrapeResult),
+		lastQueuePosition:  -1,
+		proxy:              px,
+		proxyTransport:     newProxyTransport(px),
+		s3Client:           http.Client{Transport: newHTTPTransport(proxyFor(px, &cfg, cfg.ProxyS3))},
+		moveClient:         http.Client{Transport: newHTTPTransport(proxyFor(px, &cfg, false))},
+		peerDial:           peerDialer(proxyFor(px, &cfg, cfg.ProxyPeers)),
 		webseedClient: http.Client{
 			Transport: &http.Transport{
 				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					if wpx := proxyFor(px, &cfg, cfg.ProxyWebseeds); wpx != nil {
+						dctx, cancel := context.WithTimeout(ctx, cfg.WebseedDialTimeout)
+						defer cancel()
+						return wpx.DialContext(dctx, network, addr)
+					}
 					ip, port, err := resolver.Resolve(ctx, addr, cfg.DNSResolveTimeout, bl)
 					if err != nil {
 						return nil, err
@@ -194,12 +304,14 @@ func NewSession(cfg Config) (*Session, error) {
 			},
 		},
 	}
-	if cfg.SpeedLimitDownload > 0 {
-		c.bucketDownload = ratelimit.NewBucketWithRate(float64(cfg.SpeedLimitDownload), cfg.SpeedLimitDownload)
-	}
-	if cfg.SpeedLimitUpload > 0 {
-		c.bucketUpload = ratelimit.NewBucketWithRate(float64(cfg.SpeedLimitUpload), cfg.SpeedLimitUpload)
-	}
+	c.limiterDownload = speedlimiter.New(0, nil)
+	c.limiterUpload = speedlimiter.New(0, nil)
+	c.speedLimitDownload = cfg.SpeedLimitDownload
+	c.speedLimitUpload = cfg.SpeedLimitUpload
+	c.speedLimitSchedule = schedule
+	c.defaultSeedLimits = seedLimits
+	c.applySpeedLimits(time.Now())
+	c.initMetrics()
 	err = c.startBlocklistReloader()
 	if err != nil {
 		return nil, err
@@ -210,11 +322,11 @@ func NewSession(cfg Config) (*Session, error) {
 	}
 	ext.Set(61) // Fast Extension (BEP 6)
 	ext.Set(43) // Extension Protocol (BEP 10)
+	ext.Set(59) // BitTorrent v2 (BEP 52)
 	if cfg.DHTEnabled {
 		ext.Set(63) // DHT Protocol (BEP 5)
 		c.dhtPeerRequests = make(map[*torrent]struct{})
 	}
-	c.initMetrics()
 	c.loadExistingTorrents(ids)
 	if c.config.RPCEnabled {
 		c.rpc = newRPCServer(c)
@@ -226,7 +338,27 @@ func NewSession(cfg Config) (*Session, error) {
 	if cfg.DHTEnabled {
 		go c.processDHTResults()
 	}
+	if c.lsd != nil {
+		go c.processLSDResults()
+	}
+	if c.dhtItems != nil {
+		go c.mutableTorrentUpdater()
+	}
 	go c.updateStatsLoop()
+	go c.queueManager()
+	if len(c.speedLimitSchedule) > 0 {
+		go c.speedLimitScheduler()
+	}
+	if c.hooksEnabled() {
+		go c.hookRunner()
+	}
+	if c.config.TrackerScrapeInterval > 0 {
+		go c.scraper()
+	}
+	if c.config.RPCEnabled && c.config.RPCMoveTimeout > 0 {
+		go c.moveExpirer()
+	}
+	c.startWatchers()
 	return c, nil
 }
 
@@ -260,9 +392,17 @@ func (s *Session) getTrackerUserAgent(private bool) string {
 func (s *Session) Close() error {
 	close(s.closeC)
 
+	s.stopWatchers()
+
 	if s.config.DHTEnabled {
 		s.dht.Stop()
 	}
+	if s.dhtItems != nil {
+		s.dhtItems.Close()
+	}
+	if s.lsd != nil {
+		s.lsd.Close()
+	}
 
 	s.updateStats()
 
@@ -286,10 +426,15 @@ func (s *Session) Close() error {
 		}
 	}
 
+	if s.proxyTransport != nil {
+		s.proxyTransport.CloseIdleConnections()
+	}
+	s.s3Client.CloseIdleConnections()
+	s.moveClient.CloseIdleConnections()
 	s.ram.Close()
 	s.pieceCache.Close()
 	s.metrics.Close()
-	return s.db.Close()
+	return s.resumer.Close()
 }
 
 // ListTorrents returns all torrents in session as a slice.
@@ -329,9 +474,20 @@ func (s *Session) GetTorrent(id string) *Torrent {
 
 // RemoveTorrent removes the torrent from the session and delete its files.
 func (s *Session) RemoveTorrent(id string) error {
+	return s.removeTorrent(id, true)
+}
+
+func (s *Session) removeTorrent(id string, removeData bool) error {
 	t, err := s.removeTorrentFromClient(id)
 	if t != nil {
-		go func() { _ = s.stopAndRemoveData(t) }()
+		s.publishEvent(Event{Type: EventTorrentRemoved, TorrentID: id})
+		// Next torrent in queue may start.
+		defer s.triggerQueue()
+		if removeData {
+			go func() { _ = s.stopAndRemoveData(t) }()
+		} else {
+			go s.stopAndRemove(t)
+		}
 	}
 	return err
 }
@@ -345,6 +501,7 @@ func (s *Session) removeTorrentFromClient(id string) (*Torrent, error) {
 	}
 	t.torrent.log.Info("removing torrent")
 	delete(s.torrents, id)
+	s.setMutableTorrent(id, nil)
 
 	// Delete from the list of torrents with same info hash
 	ih := dht.InfoHash(t.torrent.InfoHash())
@@ -360,20 +517,31 @@ func (s *Session) removeTorrentFromClient(id string) (*Torrent, error) {
 	if len(s.torrentsByInfoHash[ih]) == 0 {
 		s.dht.RemoveInfoHash(string(ih))
 	}
-	return t, s.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(torrentsBucket).DeleteBucket([]byte(id))
-	})
+	return t, s.resumer.Delete(id)
 }
 
-func (s *Session) stopAndRemoveData(t *Torrent) error {
+func (s *Session) stopAndRemove(t *Torrent) {
 	t.torrent.Close()
 	s.releasePort(t.torrent.port)
+}
+
+func (s *Session) stopAndRemoveData(t *Torrent) error {
+	s.stopAndRemove(t)
 	var err error
 	var dest string
-	if s.config.DataDirIncludesTorrentID {
-		dest = filepath.Join(s.config.DataDir, t.torrent.id)
-	} else if t.torrent.info != nil {
-		dest = t.torrent.info.Name
+	switch sto := t.torrent.storage.(type) {
+	case *filestorage.FileStorage:
+		if s.isTorrentIDDir(t.torrent.id, sto.Dest()) {
+			dest = sto.Dest()
+		} else if t.torrent.info != nil {
+			dest = filepath.Join(sto.Dest(), t.torrent.info.Name)
+		}
+	case *blobstorage.BlobStorage:
+		if s.isTorrentIDDir(t.torrent.id, filepath.Dir(sto.Path())) {
+			dest = filepath.Dir(sto.Path())
+		} else {
+			dest = sto.Path()
+		}
 	}
 	if dest != "" {
 		err = os.RemoveAll(dest)
@@ -386,42 +554,36 @@ func (s *Session) stopAndRemoveData(t *Torrent) error {
 
 // StartAll starts all torrents in session.
 func (s *Session) StartAll() error {
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		tb := tx.Bucket(torrentsBucket)
-		s.mTorrents.RLock()
-		for _, t := range s.torrents {
-			b := tb.Bucket([]byte(t.torrent.id))
-			_ = b.Put([]byte("started"), []byte("true"))
+	s.mTorrents.RLock()
+	for _, t := range s.torrents {
+		err := s.resumer.WriteStarted(t.torrent.id, true)
+		if err != nil {
+			s.mTorrents.RUnlock()
+			return err
 		}
-		defer s.mTorrents.RUnlock()
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 	for _, t := range s.torrents {
-		t.torrent.Start()
+		s.setQueued(t.torrent, true)
 	}
+	s.mTorrents.RUnlock()
+	s.processQueue()
 	return nil
 }
 
 // StopAll stops all torrents in session.
 func (s *Session) StopAll() error {
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		tb := tx.Bucket(torrentsBucket)
-		s.mTorrents.RLock()
-		for _, t := range s.torrents {
-			b := tb.Bucket([]byte(t.torrent.id))
-			_ = b.Put([]byte("started"), []byte("false"))
+	s.mTorrents.RLock()
+	for _, t := range s.torrents {
+		err := s.resumer.WriteStarted(t.torrent.id, false)
+		if err != nil {
+			s.mTorrents.RUnlock()
+			return err
 		}
-		defer s.mTorrents.RUnlock()
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 	for _, t := range s.torrents {
+		s.setQueued(t.torrent, false)
 		t.torrent.Stop()
 	}
+	s.mTorrents.RUnlock()
 	return nil
 }
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"path/filepath"
 	"strings"
@@ -14,10 +13,10 @@ import (
 	"github.com/panzarasa/rain/internal/magnet"
 	"github.com/panzarasa/rain/internal/metainfo"
 	"github.com/panzarasa/rain/internal/resumer"
-	"github.com/panzarasa/rain/internal/resumer/boltdbresumer"
-	"github.com/panzarasa/rain/internal/storage/filestorage"
 	"github.com/panzarasa/rain/internal/webseedsource"
+	"github.com/panzarasa/rain/storage"
 	"github.com/gofrs/uuid"
+	"github.com/mitchellh/go-homedir"
 	"github.com/nictuku/dht"
 )
 
@@ -30,6 +29,23 @@ type AddTorrentOptions struct {
 	Stopped bool
 	// Stop torrent after all pieces are downloaded.
 	StopAfterDownload bool
+	// Download pieces in order instead of rarest first.
+	Sequential bool
+	// Directory to download the files into. If empty, Config.DataDir is used.
+	// Config.DataDirIncludesTorrentID does not apply to this directory.
+	DataDir string
+	// Storage backend of the torrent. If empty, Config.Storage is used.
+	Storage string
+	// Seeding ends when the ratio of uploaded bytes to downloaded bytes reaches this value.
+	// Zero value uses Config.SeedRatioLimit. Negative value disables the limit.
+	SeedRatioLimit float64
+	// Action when SeedRatioLimit is reached. Zero value uses Config.SeedRatioAction.
+	SeedRatioAction SeedAction
+	// Seeding ends after the torrent is seeded for this duration.
+	// Zero value uses Config.SeedTimeLimit. Negative value disables the limit.
+	SeedTimeLimit time.Duration
+	// Action when SeedTimeLimit is reached. Zero value uses Config.SeedTimeAction.
+	SeedTimeAction SeedAction
 }
 
 // AddTorrent adds a new torrent to the session by reading .torrent metainfo from reader.
@@ -65,10 +81,12 @@ func (s *Session) addTorrentStopped(r io.Reader, opt *AddTorrentOptions) (*Torre
 	if err != nil {
 		return nil, newInputError(err)
 	}
-	id, port, sto, err := s.add(opt)
+	id, port, sto, dataDir, err := s.add(opt)
 	if err != nil {
 		return nil, err
 	}
+	seedLimits := s.seedLimits(opt)
+	queuePosition := s.nextQueuePosition()
 	defer func() {
 		if err != nil {
 			s.releasePort(port)
@@ -89,31 +107,45 @@ func (s *Session) addTorrentStopped(r io.Reader, opt *AddTorrentOptions) (*Torre
 		resumer.Stats{},
 		webseedsource.NewList(mi.URLList),
 		opt.StopAfterDownload,
+		nil, // filePriorities
+		opt.Sequential,
+		seedLimits,
 	)
 	if err != nil {
 		return nil, err
 	}
+	t.queuePosition = queuePosition
 	go s.checkTorrent(t)
 	defer func() {
 		if err != nil {
 			t.Close()
 		}
 	}()
-	rspec := &boltdbresumer.Spec{
+	rspec := &resumer.Spec{
 		InfoHash:          mi.Info.Hash[:],
 		Port:              port,
 		Name:              mi.Info.Name,
 		Trackers:          mi.AnnounceList,
 		URLList:           mi.URLList,
 		Info:              mi.Info.Bytes,
+		PieceLayers:       mi.Info.PieceLayers,
 		AddedAt:           t.addedAt,
 		StopAfterDownload: opt.StopAfterDownload,
+		Sequential:        opt.Sequential,
+		SeedRatioLimit:    seedLimits.Ratio,
+		SeedRatioAction:   int(seedLimits.RatioAction),
+		SeedTimeLimit:     seedLimits.Time,
+		SeedTimeAction:    int(seedLimits.TimeAction),
+		QueuePosition:     queuePosition,
+		DataDir:           dataDir,
+		Storage:           opt.Storage,
 	}
 	err = s.resumer.Write(id, rspec)
 	if err != nil {
 		return nil, err
 	}
 	t2 := s.insertTorrent(t)
+	s.publishEvent(Event{Type: EventTorrentAdded, TorrentID: id})
 	return t2, nil
 }
 
@@ -154,9 +186,8 @@ func filterOutControlChars(s string) string {
 }
 
 func (s *Session) addURL(u string, opt *AddTorrentOptions) (*Torrent, error) {
-	client := http.Client{
-		Timeout: s.config.TorrentAddHTTPTimeout,
-	}
+	client := s.httpClient(s.config.ProxyAddURI)
+	client.Timeout = s.config.TorrentAddHTTPTimeout
 	resp, err := client.Get(u)
 	if err != nil {
 		return nil, newInputError(err)
@@ -175,10 +206,26 @@ func (s *Session) addMagnet(link string, opt *AddTorrentOptions) (*Torrent, erro
 	if err != nil {
 		return nil, newInputError(err)
 	}
-	id, port, sto, err := s.add(opt)
+	var seq int64
+	if ma.PublicKey != nil {
+		// Link points to a mutable torrent. Latest version is looked up in DHT.
+		ma.InfoHash, seq, err = s.resolveMutableTorrent(ma.PublicKey, ma.Salt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.addMagnetVersion(ma, opt, seq, nil)
+}
+
+// addMagnetVersion adds the torrent in magnet link.
+// seq and prev are used only for mutable torrents, they are the sequence number of the DHT item and the version that is replaced.
+func (s *Session) addMagnetVersion(ma *magnet.Magnet, opt *AddTorrentOptions, seq int64, prev *previousVersion) (*Torrent, error) {
+	id, port, sto, dataDir, err := s.add(opt)
 	if err != nil {
 		return nil, err
 	}
+	seedLimits := s.seedLimits(opt)
+	queuePosition := s.nextQueuePosition()
 	defer func() {
 		if err != nil {
 			s.releasePort(port)
@@ -199,17 +246,22 @@ func (s *Session) addMagnet(link string, opt *AddTorrentOptions) (*Torrent, erro
 		resumer.Stats{},
 		nil, // webseedSources
 		opt.StopAfterDownload,
+		nil, // filePriorities
+		opt.Sequential,
+		seedLimits,
 	)
 	if err != nil {
 		return nil, err
 	}
+	t.queuePosition = queuePosition
+	t.previousVersion = prev
 	go s.checkTorrent(t)
 	defer func() {
 		if err != nil {
 			t.Close()
 		}
 	}()
-	rspec := &boltdbresumer.Spec{
+	rspec := &resumer.Spec{
 		InfoHash:          ma.InfoHash[:],
 		Port:              port,
 		Name:              ma.Name,
@@ -217,19 +269,44 @@ func (s *Session) addMagnet(link string, opt *AddTorrentOptions) (*Torrent, erro
 		FixedPeers:        ma.Peers,
 		AddedAt:           t.addedAt,
 		StopAfterDownload: opt.StopAfterDownload,
+		Sequential:        opt.Sequential,
+		SeedRatioLimit:    seedLimits.Ratio,
+		SeedRatioAction:   int(seedLimits.RatioAction),
+		SeedTimeLimit:     seedLimits.Time,
+		SeedTimeAction:    int(seedLimits.TimeAction),
+		QueuePosition:     queuePosition,
+		DataDir:           dataDir,
+		Storage:           opt.Storage,
+		PublicKey:         ma.PublicKey,
+		Salt:              ma.Salt,
+		Seq:               seq,
+	}
+	if prev != nil {
+		rspec.PreviousVersionDir = prev.dir
 	}
 	err = s.resumer.Write(id, rspec)
 	if err != nil {
 		return nil, err
 	}
 	t2 := s.insertTorrent(t)
+	if ma.PublicKey != nil {
+		s.setMutableTorrent(id, &mutableTorrent{publicKey: ma.PublicKey, salt: ma.Salt, seq: seq})
+	}
+	s.publishEvent(Event{Type: EventTorrentAdded, TorrentID: id})
 	if !opt.Stopped {
 		err = t2.Start()
 	}
 	return t2, err
 }
 
-func (s *Session) add(opt *AddTorrentOptions) (id string, port int, sto *filestorage.FileStorage, err error) {
+// add reserves an ID and a port for a new torrent and creates its storage.
+// Returned dataDir is the custom data directory of the torrent to be saved in resume db. It is empty if Config.DataDir is used.
+func (s *Session) add(opt *AddTorrentOptions) (id string, port int, sto storage.Storage, dataDir string, err error) {
+	provider, err := s.config.storageProvider(opt.Storage, &s.s3Client, s.log)
+	if err != nil {
+		err = newInputError(err)
+		return
+	}
 	port, err = s.getPort()
 	if err != nil {
 		return
@@ -244,6 +321,11 @@ func (s *Session) add(opt *AddTorrentOptions) (id string, port int, sto *filesto
 		givenID = opt.ID
 	}
 	if givenID != "" {
+		err = validateID(givenID)
+		if err != nil {
+			err = newInputError(err)
+			return
+		}
 		s.mTorrents.RLock()
 		defer s.mTorrents.RUnlock()
 		if _, ok := s.torrents[givenID]; ok {
@@ -259,19 +341,43 @@ func (s *Session) add(opt *AddTorrentOptions) (id string, port int, sto *filesto
 		}
 		id = base64.RawURLEncoding.EncodeToString(u1[:])
 	}
-	var dest string
-	if s.config.DataDirIncludesTorrentID {
-		dest = filepath.Join(s.config.DataDir, id)
-	} else {
-		dest = s.config.DataDir
-	}
-	sto, err = filestorage.New(dest)
-	if err != nil {
-		return
+	if opt.DataDir != "" {
+		dataDir, err = homedir.Expand(opt.DataDir)
+		if err != nil {
+			return
+		}
+		dataDir, err = filepath.Abs(dataDir)
+		if err != nil {
+			return
+		}
 	}
+	sto, err = provider(id, s.dataDir(id, dataDir))
 	return
 }
 
+var errInvalidID = errors.New("invalid torrent id")
+
+// validateID checks that the torrent id given by the user can be used as a file name in data and storage directories.
+func validateID(id string) error {
+	if id == "" || id == "." || strings.Contains(id, "..") || strings.ContainsAny(id, `/\`) {
+		return errInvalidID
+	}
+	return nil
+}
+
+// dataDir returns the directory that the files of the torrent are downloaded into.
+// custom is the data directory given when the torrent is added.
+func (s *Session) dataDir(id, custom string) string {
+	switch {
+	case custom != "":
+		return custom
+	case s.config.DataDirIncludesTorrentID:
+		return filepath.Join(s.config.DataDir, id)
+	default:
+		return s.config.DataDir
+	}
+}
+
 func (s *Session) insertTorrent(t *torrent) *Torrent {
 	t.log.Info("added torrent")
 	t2 := &Torrent{
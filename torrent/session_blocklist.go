@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"github.com/cenkalti/backoff"
-	"go.etcd.io/bbolt"
 )
 
 func (s *Session) startBlocklistReloader() error {
@@ -58,25 +57,15 @@ func (s *Session) startBlocklistReloader() error {
 
 func (s *Session) getBlocklistTimestamp() (time.Time, error) {
 	sum := sha1.Sum([]byte(s.config.BlocklistURL)) // nolint: gosec
-	var t time.Time
-	err := s.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(sessionBucket)
-		val := b.Get(blocklistURLHashKey)
-		if val == nil {
-			return nil
-		}
-		if !bytes.Equal(val, sum[:]) {
-			return nil
-		}
-		val = b.Get(blocklistTimestampKey)
-		if val == nil {
-			return nil
-		}
-		var err2 error
-		t, err2 = time.Parse(time.RFC3339, string(val))
-		return err2
-	})
-	return t, err
+	val, err := s.resumer.ReadSessionValue(blocklistURLHashKey)
+	if err != nil || !bytes.Equal(val, sum[:]) {
+		return time.Time{}, err
+	}
+	val, err = s.resumer.ReadSessionValue(blocklistTimestampKey)
+	if err != nil || val == nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, string(val))
 }
 
 func (s *Session) retryReloadBlocklist() {
@@ -118,9 +107,9 @@ func (s *Session) reloadBlocklist() error {
 	}()
 	req = req.WithContext(ctx)
 
-	client := http.Client{
-		Timeout: s.config.BlocklistUpdateTimeout,
-	}
+	// Blocklist is downloaded through the proxy only in proxy-only mode.
+	client := s.httpClient(false)
+	client.Timeout = s.config.BlocklistUpdateTimeout
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -172,30 +161,23 @@ func (s *Session) reloadBlocklist() error {
 	s.blocklistTimestamp = now
 	s.mBlocklist.Unlock()
 
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(sessionBucket)
-		err2 := b.Put(blocklistKey, buf)
-		if err2 != nil {
-			return err2
-		}
-		sum := sha1.Sum([]byte(s.config.BlocklistURL)) // nolint: gosec
-		err2 = b.Put(blocklistURLHashKey, sum[:])
-		if err2 != nil {
-			return err2
-		}
-		return b.Put(blocklistTimestampKey, []byte(now.Format(time.RFC3339)))
+	sum := sha1.Sum([]byte(s.config.BlocklistURL)) // nolint: gosec
+	return s.resumer.WriteSessionValues(map[string][]byte{
+		blocklistKey:          buf,
+		blocklistURLHashKey:   sum[:],
+		blocklistTimestampKey: []byte(now.Format(time.RFC3339)),
 	})
 }
 
 func (s *Session) loadBlocklistFromDB() error {
-	return s.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(sessionBucket)
-		val := b.Get(blocklistKey)
-		if len(val) == 0 {
-			return errors.New("no blocklist data in db")
-		}
-		return s.loadBlocklistReader(bytes.NewReader(val))
-	})
+	val, err := s.resumer.ReadSessionValue(blocklistKey)
+	if err != nil {
+		return err
+	}
+	if len(val) == 0 {
+		return errors.New("no blocklist data in db")
+	}
+	return s.loadBlocklistReader(bytes.NewReader(val))
 }
 
 func (s *Session) loadBlocklistReader(r io.Reader) error {
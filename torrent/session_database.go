@@ -0,0 +1,33 @@
+package torrent
+
+import (
+	"fmt"
+
+	"github.com/panzarasa/rain/internal/resumer"
+	"github.com/panzarasa/rain/internal/resumer/boltdbresumer"
+	"github.com/panzarasa/rain/internal/resumer/jsonresumer"
+)
+
+// Names of the built-in resume database backends.
+const (
+	// DatabaseBolt keeps resume data in a single Bolt database file. The file is locked while the Session is open.
+	DatabaseBolt = "bolt"
+	// DatabaseJSON keeps resume data in a directory that contains a JSON file for each torrent.
+	DatabaseJSON = "json"
+	// DatabaseSQLite keeps resume data in a SQLite database file.
+	// SQLite driver requires cgo, so the backend is available only in builds with CGO_ENABLED=1.
+	DatabaseSQLite = "sqlite"
+)
+
+// openResumer opens the resume database at Config.Database with the backend in Config.DatabaseBackend.
+func (c *Config) openResumer() (resumer.Resumer, error) {
+	switch c.DatabaseBackend {
+	case "", DatabaseBolt:
+		return boltdbresumer.Open(c.Database)
+	case DatabaseJSON:
+		return jsonresumer.Open(c.Database)
+	case DatabaseSQLite:
+		return openSQLite(c.Database)
+	}
+	return nil, fmt.Errorf("unknown database backend: %q", c.DatabaseBackend)
+}
@@ -0,0 +1,15 @@
+// +build !cgo
+
+package torrent
+
+import (
+	"errors"
+
+	"github.com/panzarasa/rain/internal/resumer"
+)
+
+var errNoSQLite = errors.New("sqlite database backend is not available: program is built without cgo")
+
+func openSQLite(path string) (resumer.Resumer, error) {
+	return nil, errNoSQLite
+}
@@ -0,0 +1,12 @@
+// +build cgo
+
+package torrent
+
+import (
+	"github.com/panzarasa/rain/internal/resumer"
+	"github.com/panzarasa/rain/internal/resumer/sqliteresumer"
+)
+
+func openSQLite(path string) (resumer.Resumer, error) {
+	return sqliteresumer.Open(path)
+}
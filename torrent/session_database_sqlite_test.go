@@ -0,0 +1,40 @@
+// +build cgo
+
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDatabaseBackendSQLite(t *testing.T) {
+	s, closeSession := newTestSessionWithConfig(t, func(cfg *Config) {
+		cfg.Database = filepath.Join(cfg.DataDir, "session.sqlite")
+		cfg.DatabaseBackend = DatabaseSQLite
+	})
+	defer closeSession()
+	cfg := s.config
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tor, err := s.AddTorrent(f, &AddTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.GetTorrent(tor.ID()) == nil {
+		t.Fatal("torrent is not loaded")
+	}
+}
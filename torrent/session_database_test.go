@@ -0,0 +1,105 @@
+package torrent
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fortytw2/leaktest"
+)
+
+func TestDatabaseBackendJSON(t *testing.T) {
+	s, closeSession := newTestSessionWithConfig(t, func(cfg *Config) {
+		cfg.Database = filepath.Join(cfg.DataDir, "session")
+		cfg.DatabaseBackend = DatabaseJSON
+	})
+	defer closeSession()
+	cfg := s.config
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tor, err := s.AddTorrent(f, &AddTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tor.AddTracker("http://127.0.0.1:1/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(cfg.Database, "torrents", tor.ID()+".json")); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Torrent is loaded from the JSON file.
+	s, err = NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	tor = s.GetTorrent(tor.ID())
+	if tor == nil {
+		t.Fatal("torrent is not loaded")
+	}
+	trackers := tor.Trackers()
+	if len(trackers) == 0 || trackers[len(trackers)-1].URL != "http://127.0.0.1:1/announce" {
+		t.Fatalf("added tracker is not loaded: %v", trackers)
+	}
+	err = s.RemoveTorrent(tor.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(cfg.Database, "torrents", tor.ID()+".json")); !os.IsNotExist(err) {
+		t.Fatal("JSON file is not removed")
+	}
+}
+
+func TestDatabaseBackendUnknown(t *testing.T) {
+	tmp, closeTmp := tempdir(t)
+	defer closeTmp()
+	cfg := DefaultConfig
+	cfg.Database = filepath.Join(tmp, "session.db")
+	cfg.DatabaseBackend = "unknown"
+	cfg.DHTEnabled = false
+	cfg.RPCEnabled = false
+	_, err := NewSession(cfg)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDatabaseClosedOnSessionError(t *testing.T) {
+	s, closeSession := newTestSessionWithConfig(t, nil)
+	defer closeSession()
+	cfg := s.config
+	s.Close()
+
+	// Metrics library keeps a global goroutine after the first Session, so the check begins here.
+	defer leaktest.Check(t)()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	failCfg := cfg
+	failCfg.RPCEnabled = true
+	failCfg.RPCHost = "127.0.0.1"
+	failCfg.RPCPort = l.Addr().(*net.TCPAddr).Port
+	_, err = NewSession(failCfg)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Database must be released by the failed Session.
+	s, err = NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
@@ -0,0 +1,109 @@
+package torrent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/panzarasa/rain/internal/dhtitem"
+)
+
+var errDHTItemsDisabled = errors.New("dht items are disabled")
+
+// DHTItem is an arbitrary value stored in DHT. See BEP 44.
+// Items without a public key are immutable and they are addressed by the SHA-1 hash of the value.
+// Mutable items are signed with an ed25519 key and addressed by the SHA-1 hash of the public key and salt.
+type DHTItem struct {
+	// Value is the bencoded value of the item. Must be at most 1000 bytes.
+	Value []byte
+	// PublicKey is set for mutable items only.
+	PublicKey ed25519.PublicKey
+	// Salt allows a key to sign multiple mutable items. Must be at most 64 bytes.
+	Salt []byte
+	// Seq is the sequence number of a mutable item. Nodes do not accept items with a lower sequence number.
+	Seq int64
+	// Signature of a mutable item.
+	Signature []byte
+}
+
+// NewMutableDHTItem returns a new mutable item signed with the private key.
+func NewMutableDHTItem(key ed25519.PrivateKey, salt, value []byte, seq int64) (DHTItem, error) {
+	i, err := dhtitem.NewMutable(key, salt, value, seq)
+	if err != nil {
+		return DHTItem{}, err
+	}
+	return newDHTItem(i), nil
+}
+
+// DHTTarget returns the key of a mutable item in DHT.
+func DHTTarget(publicKey ed25519.PublicKey, salt []byte) [20]byte {
+	return dhtitem.MutableTarget(publicKey, salt)
+}
+
+// Target returns the key of the item in DHT.
+func (i DHTItem) Target() [20]byte {
+	return i.item().Target()
+}
+
+func newDHTItem(i *dhtitem.Item) DHTItem {
+	return DHTItem{
+		Value:     i.Value,
+		PublicKey: i.PublicKey,
+		Salt:      i.Salt,
+		Seq:       i.Seq,
+		Signature: i.Signature,
+	}
+}
+
+func (i DHTItem) item() *dhtitem.Item {
+	return &dhtitem.Item{
+		Value:     i.Value,
+		PublicKey: i.PublicKey,
+		Salt:      i.Salt,
+		Seq:       i.Seq,
+		Signature: i.Signature,
+	}
+}
+
+// DHTPut stores the item in the closest DHT nodes to its target and returns the target.
+// Mutable items must be signed. See NewMutableDHTItem.
+func (s *Session) DHTPut(item DHTItem) ([20]byte, error) {
+	if s.dhtItems == nil {
+		return [20]byte{}, errDHTItemsDisabled
+	}
+	ctx, cancel := s.dhtItemsContext()
+	defer cancel()
+	i := item.item()
+	_, err := s.dhtItems.Put(ctx, i)
+	return i.Target(), err
+}
+
+// DHTGet retrieves the item with the target from DHT.
+// Salt is required for verifying the signature of mutable items. It is ignored for immutable items.
+// If multiple versions of a mutable item are found, the one with the highest sequence number is returned.
+func (s *Session) DHTGet(target [20]byte, salt []byte) (*DHTItem, error) {
+	if s.dhtItems == nil {
+		return nil, errDHTItemsDisabled
+	}
+	ctx, cancel := s.dhtItemsContext()
+	defer cancel()
+	i, err := s.dhtItems.Get(ctx, target, salt)
+	if err != nil {
+		return nil, err
+	}
+	item := newDHTItem(i)
+	return &item, nil
+}
+
+// dhtItemsContext returns a context that is cancelled on timeout or when the Session is closed.
+func (s *Session) dhtItemsContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DHTItemsTimeout)
+	go func() {
+		select {
+		case <-s.closeC:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
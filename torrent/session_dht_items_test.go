@@ -0,0 +1,79 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newDHTItemsTestSession(t *testing.T, bootstrap ...string) (*Session, func()) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := c.LocalAddr().(*net.UDPAddr).Port
+	c.Close()
+	return newTestSessionWithConfig(t, func(cfg *Config) {
+		cfg.DHTEnabled = true
+		cfg.LSDEnabled = false
+		cfg.DHTHost = "127.0.0.1"
+		cfg.DHTItemsPort = uint16(port)
+		cfg.DHTItemsTimeout = 5 * time.Second
+		cfg.DHTBootstrapNodes = bootstrap
+	})
+}
+
+func TestDHTItems(t *testing.T) {
+	s1, close1 := newDHTItemsTestSession(t)
+	defer close1()
+	s2, close2 := newDHTItemsTestSession(t, "127.0.0.1:"+strconv.Itoa(int(s1.config.DHTItemsPort)))
+	defer close2()
+
+	target, err := s2.DHTPut(DHTItem{Value: []byte("5:hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := s1.DHTGet(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(item.Value) != "5:hello" {
+		t.Fatalf("unexpected value: %q", item.Value)
+	}
+
+	publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutable, err := NewMutableDHTItem(key, []byte("salt"), []byte("i42e"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err = s2.DHTPut(mutable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != DHTTarget(publicKey, []byte("salt")) {
+		t.Fatal("unexpected target")
+	}
+	item, err = s1.DHTGet(target, []byte("salt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Seq != 7 || string(item.Value) != "i42e" || !bytes.Equal(item.PublicKey, publicKey) {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+}
+
+func TestDHTItemsDisabled(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+	_, err := s.DHTPut(DHTItem{Value: []byte("5:hello")})
+	if err != errDHTItemsDisabled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package torrent
+
+import (
+	"time"
+
+	"github.com/panzarasa/rain/internal/announcer"
+	"github.com/panzarasa/rain/internal/tracker"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventTorrentAdded is published when a new torrent is added to the Session.
+	EventTorrentAdded EventType = iota
+	// EventTorrentRemoved is published when a torrent is removed from the Session.
+	EventTorrentRemoved
+	// EventTorrentStarted is published when a torrent starts running.
+	EventTorrentStarted
+	// EventTorrentStopped is published when a torrent switches into Stopped state.
+	EventTorrentStopped
+	// EventTorrentCompleted is published when all wanted pieces of a torrent are downloaded.
+	EventTorrentCompleted
+	// EventTorrentError is published when a torrent is stopped because of an error.
+	EventTorrentError
+	// EventMetadataReceived is published when the info dictionary of a torrent added from magnet link is downloaded.
+	EventMetadataReceived
+	// EventTrackerError is published when an announce to a tracker fails.
+	EventTrackerError
+	// EventPieceVerified is published when a downloaded piece passes hash check and written to disk.
+	EventPieceVerified
+)
+
+var eventTypeNames = map[EventType]string{
+	EventTorrentAdded:     "torrent-added",
+	EventTorrentRemoved:   "torrent-removed",
+	EventTorrentStarted:   "torrent-started",
+	EventTorrentStopped:   "torrent-stopped",
+	EventTorrentCompleted: "torrent-completed",
+	EventTorrentError:     "torrent-error",
+	EventMetadataReceived: "metadata-received",
+	EventTrackerError:     "tracker-error",
+	EventPieceVerified:    "piece-verified",
+}
+
+func (t EventType) String() string {
+	return eventTypeNames[t]
+}
+
+// Event is a change in the state of a torrent in Session.
+type Event struct {
+	// ID is a sequence number that is increased for every event in Session.
+	// IDs of a new Session start from a time based value that is greater than the IDs of previous Sessions.
+	ID   uint64
+	Time time.Time
+	Type EventType
+	// ID of the torrent that the event belongs to.
+	TorrentID string
+	// Error message for EventTorrentError and EventTrackerError.
+	Error string
+	// Tracker URL for EventTrackerError.
+	Tracker string
+	// Piece index for EventPieceVerified.
+	Piece uint32
+}
+
+// publishEvent adds the event to the event log of Session, wakes up the callers waiting in Events method
+// and schedules the hooks configured for the event.
+// It does not block and can be called from torrent loops.
+func (s *Session) publishEvent(e Event) {
+	s.mEvents.Lock()
+	defer s.mEvents.Unlock()
+	s.lastEventID++
+	e.ID = s.lastEventID
+	e.Time = time.Now()
+	if s.config.EventBufferSize > 0 {
+		if len(s.events) >= s.config.EventBufferSize {
+			s.events = s.events[1:]
+		}
+		s.events = append(s.events, e)
+	}
+	close(s.eventsC)
+	s.eventsC = make(chan struct{})
+	s.queueHook(e)
+}
+
+// Events returns the events with ID greater than after.
+// If there is no such event, it waits for a new event for at most timeout duration and returns an empty list if none happens.
+// Pass zero as after to get all events kept in memory.
+// At most Config.EventBufferSize events are kept. If the ID of the first returned event is not after+1, some events are missed.
+func (s *Session) Events(after uint64, timeout time.Duration) []Event {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mEvents.Lock()
+		var ret []Event
+		if len(s.events) > 0 {
+			// IDs of events are sequential.
+			i := 0
+			if first := s.events[0].ID; after >= first {
+				i = int(after - first + 1)
+			}
+			if i < len(s.events) {
+				ret = make([]Event, len(s.events)-i)
+				copy(ret, s.events[i:])
+			}
+		}
+		waitC := s.eventsC
+		s.mEvents.Unlock()
+		if len(ret) > 0 {
+			return ret
+		}
+		select {
+		case <-waitC:
+		case <-timer.C:
+			return nil
+		case <-s.closeC:
+			return nil
+		}
+	}
+}
+
+func (t *torrent) publishEvent(typ EventType) {
+	t.session.publishEvent(Event{Type: typ, TorrentID: t.id})
+}
+
+// handleAnnounceError is called from announcer goroutines.
+func (t *torrent) handleAnnounceError(trk tracker.Tracker, err *announcer.AnnounceError) {
+	t.session.publishEvent(Event{
+		Type:      EventTrackerError,
+		TorrentID: t.id,
+		Tracker:   trk.URL(),
+		Error:     err.Message,
+	})
+}
@@ -0,0 +1,54 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionEvents(t *testing.T) {
+	s := &Session{
+		config:  Config{EventBufferSize: 3},
+		eventsC: make(chan struct{}),
+		closeC:  make(chan struct{}),
+	}
+	if events := s.Events(0, 0); len(events) != 0 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	for i := 0; i < 4; i++ {
+		s.publishEvent(Event{Type: EventPieceVerified, Piece: uint32(i)})
+	}
+	events := s.Events(0, 0)
+	if len(events) != 3 {
+		t.Fatalf("unexpected number of events: %d", len(events))
+	}
+	if events[0].ID != 2 || events[0].Piece != 1 {
+		t.Fatalf("unexpected first event: %v", events[0])
+	}
+	if events = s.Events(3, 0); len(events) != 1 || events[0].ID != 4 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.publishEvent(Event{Type: EventTorrentAdded, TorrentID: "foo"})
+	}()
+	events = s.Events(4, time.Minute)
+	if len(events) != 1 || events[0].ID != 5 || events[0].TorrentID != "foo" {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
+
+func TestEventIDsAfterRestart(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	s.publishEvent(Event{Type: EventTorrentAdded, TorrentID: "foo"})
+	last := s.Events(0, 0)[0].ID
+	closeSession()
+
+	s, closeSession = newTestSession(t)
+	defer closeSession()
+	s.publishEvent(Event{Type: EventTorrentAdded, TorrentID: "bar"})
+	events := s.Events(last, 0)
+	if len(events) != 1 || events[0].TorrentID != "bar" {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
@@ -0,0 +1,152 @@
+package torrent
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/panzarasa/rain/internal/peer"
+	"github.com/panzarasa/rain/internal/peerprotocol"
+)
+
+var errExtensionNotSupported = errors.New("peer does not support the extension")
+
+// Extension is a custom message type of the extension protocol (BEP 10).
+// Registered extensions are advertised to all peers in the extension handshake.
+// Handlers are called from the goroutine of the torrent, so they must not block.
+type Extension struct {
+	// Name is the key of the extension in the "m" dictionary of the extension handshake, e.g. "xx_manifest".
+	Name string
+	// HandshakeFields are added to the extension handshake that is sent to peers.
+	// Values must be encodable with bencode. Standard fields of the handshake cannot be overridden.
+	HandshakeFields map[string]interface{}
+	// OnHandshake is called when a peer sends an extension handshake that contains the extension. Optional.
+	OnHandshake func(pe *ExtensionPeer)
+	// OnMessage is called for every message of the extension received from a peer.
+	OnMessage func(pe *ExtensionPeer, payload []byte)
+}
+
+// ExtensionPeer is a peer that a custom extension message is exchanged with.
+type ExtensionPeer struct {
+	torrent *Torrent
+	peer    *peer.Peer
+	// ID of the extension in the handshake of the peer. Zero if the peer does not support the extension.
+	id uint8
+	// Extension handshake of the peer. Nil if it is not received yet.
+	handshake *peerprotocol.ExtensionHandshakeMessage
+}
+
+// Torrent returns the torrent that the peer is connected for.
+func (p *ExtensionPeer) Torrent() *Torrent {
+	return p.torrent
+}
+
+// Addr returns the network address of the peer.
+func (p *ExtensionPeer) Addr() net.Addr {
+	return p.peer.Addr()
+}
+
+// ID returns the peer ID that is sent in BitTorrent handshake.
+func (p *ExtensionPeer) ID() [20]byte {
+	return p.peer.ID
+}
+
+// HandshakeField returns the bencoded value of a field in the extension handshake of the peer.
+func (p *ExtensionPeer) HandshakeField(key string) ([]byte, bool) {
+	if p.handshake == nil {
+		return nil, false
+	}
+	b, ok := p.handshake.Fields[key]
+	return b, ok
+}
+
+// Send a message of the extension to the peer. Safe for concurrent use.
+func (p *ExtensionPeer) Send(payload []byte) error {
+	if p.id == 0 {
+		return errExtensionNotSupported
+	}
+	p.peer.SendMessage(peerprotocol.ExtensionMessage{
+		ExtendedMessageID: p.id,
+		Payload:           peerprotocol.ExtensionRawMessage{ExtendedMessageID: p.id, Data: payload},
+	})
+	return nil
+}
+
+// RegisterExtension adds a custom extension to the Session.
+// Extensions must be registered before adding torrents, peers that are already connected are not notified.
+func (s *Session) RegisterExtension(ext Extension) error {
+	return s.customExtensions.register(ext)
+}
+
+type registeredExtension struct {
+	Extension
+	id uint8
+}
+
+type extensionRegistry struct {
+	m      sync.RWMutex
+	byName map[string]*registeredExtension
+	byID   map[uint8]*registeredExtension
+}
+
+func (r *extensionRegistry) register(ext Extension) error {
+	switch ext.Name {
+	case "":
+		return errors.New("extension name is empty")
+	case peerprotocol.ExtensionKeyMetadata, peerprotocol.ExtensionKeyPEX:
+		return errors.New("extension name is reserved: " + ext.Name)
+	}
+	if ext.OnMessage == nil {
+		return errors.New("extension message handler is nil")
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.byName[ext.Name]; ok {
+		return errors.New("extension is already registered: " + ext.Name)
+	}
+	id := peerprotocol.ExtensionIDFirstCustom + len(r.byName)
+	if id > 255 {
+		return errors.New("too many extensions")
+	}
+	if r.byName == nil {
+		r.byName = make(map[string]*registeredExtension)
+		r.byID = make(map[uint8]*registeredExtension)
+	}
+	re := &registeredExtension{Extension: ext, id: uint8(id)}
+	r.byName[ext.Name] = re
+	r.byID[re.id] = re
+	return nil
+}
+
+func (r *extensionRegistry) get(id uint8) (*registeredExtension, bool) {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	re, ok := r.byID[id]
+	return re, ok
+}
+
+func (r *extensionRegistry) all() []*registeredExtension {
+	r.m.RLock()
+	defer r.m.RUnlock()
+	ret := make([]*registeredExtension, 0, len(r.byID))
+	// Sorted by ID to keep the order of registration.
+	for i := 0; i < len(r.byID); i++ {
+		ret = append(ret, r.byID[uint8(peerprotocol.ExtensionIDFirstCustom+i)])
+	}
+	return ret
+}
+
+// addToHandshake advertises the registered extensions in the handshake message.
+func (r *extensionRegistry) addToHandshake(msg *peerprotocol.ExtensionHandshakeMessage) {
+	for _, re := range r.all() {
+		msg.M[re.Name] = re.id
+		for k, v := range re.HandshakeFields {
+			if msg.Extra == nil {
+				msg.Extra = make(map[string]interface{})
+			}
+			if _, ok := msg.Extra[k]; !ok {
+				msg.Extra[k] = v
+			}
+		}
+	}
+}
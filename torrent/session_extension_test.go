@@ -0,0 +1,116 @@
+package torrent
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/panzarasa/rain/internal/peerprotocol"
+)
+
+func TestRegisterExtension(t *testing.T) {
+	var r extensionRegistry
+	onMessage := func(pe *ExtensionPeer, payload []byte) {}
+	invalid := []Extension{
+		{Name: "", OnMessage: onMessage},
+		{Name: peerprotocol.ExtensionKeyMetadata, OnMessage: onMessage},
+		{Name: peerprotocol.ExtensionKeyPEX, OnMessage: onMessage},
+		{Name: "xx_foo"},
+	}
+	for _, ext := range invalid {
+		if err := r.register(ext); err == nil {
+			t.Errorf("extension must be rejected: %q", ext.Name)
+		}
+	}
+	err := r.register(Extension{Name: "xx_foo", OnMessage: onMessage, HandshakeFields: map[string]interface{}{"foo": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.register(Extension{Name: "xx_bar", OnMessage: onMessage, HandshakeFields: map[string]interface{}{"foo": 2, "bar": "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.register(Extension{Name: "xx_foo", OnMessage: onMessage}); err == nil {
+		t.Fatal("duplicate extension must be rejected")
+	}
+	msg := peerprotocol.NewExtensionHandshake(0, "test", nil, 250)
+	r.addToHandshake(&msg)
+	if msg.M["xx_foo"] != peerprotocol.ExtensionIDFirstCustom || msg.M["xx_bar"] != peerprotocol.ExtensionIDFirstCustom+1 {
+		t.Errorf("unexpected ids: %v", msg.M)
+	}
+	if msg.M[peerprotocol.ExtensionKeyMetadata] != peerprotocol.ExtensionIDMetadata {
+		t.Errorf("standard extension is overridden: %v", msg.M)
+	}
+	if msg.Extra["foo"] != 1 || msg.Extra["bar"] != "x" {
+		t.Errorf("unexpected extra fields: %v", msg.Extra)
+	}
+}
+
+func TestCustomExtension(t *testing.T) {
+	s1, close1 := newTestSession(t)
+	defer close1()
+	s2, close2 := newTestSession(t)
+	defer close2()
+
+	err := s1.RegisterExtension(Extension{
+		Name:            "xx_echo",
+		HandshakeFields: map[string]interface{}{"xx_greeting": "hello"},
+		OnMessage: func(pe *ExtensionPeer, payload []byte) {
+			_ = pe.Send(append([]byte("echo: "), payload...))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	greetings := make(chan string, 1)
+	replies := make(chan string, 1)
+	err = s2.RegisterExtension(Extension{
+		Name: "xx_echo",
+		OnHandshake: func(pe *ExtensionPeer) {
+			b, _ := pe.HandshakeField("xx_greeting")
+			greetings <- string(b)
+			_ = pe.Send([]byte("ping"))
+		},
+		OnMessage: func(pe *ExtensionPeer, payload []byte) {
+			replies <- string(payload)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tor1, err := s1.AddURI(torrentMagnetLink, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var port int
+	select {
+	case port = <-tor1.torrent.NotifyListen():
+	case <-time.After(timeout):
+		t.Fatal("torrent is not listening")
+	}
+	tor2, err := s2.AddURI(torrentMagnetLink, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tor2.AddPeer("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case g := <-greetings:
+		if g != "5:hello" {
+			t.Errorf("unexpected handshake field: %q", g)
+		}
+	case <-time.After(timeout):
+		t.Fatal("extension handshake is not received")
+	}
+	select {
+	case r := <-replies:
+		if r != "echo: ping" {
+			t.Errorf("unexpected reply: %q", r)
+		}
+	case <-time.After(timeout):
+		t.Fatal("reply is not received")
+	}
+}
@@ -0,0 +1,150 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Hooks are run for events in the order they are published, one at a time.
+// If too many events are waiting, new events are dropped.
+const hookQueueSize = 100
+
+// hookPayload is sent as the body of webhook requests.
+type hookPayload struct {
+	Event     string
+	Time      time.Time
+	TorrentID string
+	Name      string
+	InfoHash  string
+	DataDir   string
+	Error     string `json:",omitempty"`
+}
+
+func (s *Session) hooksEnabled() bool {
+	return len(s.config.OnAddCommand) > 0 ||
+		len(s.config.OnCompleteCommand) > 0 ||
+		len(s.config.OnErrorCommand) > 0 ||
+		s.config.WebhookURL != ""
+}
+
+func (s *Session) hookCommand(typ EventType) []string {
+	switch typ {
+	case EventTorrentAdded:
+		return s.config.OnAddCommand
+	case EventTorrentCompleted:
+		return s.config.OnCompleteCommand
+	case EventTorrentError:
+		return s.config.OnErrorCommand
+	}
+	return nil
+}
+
+// queueHook schedules the hooks of the event to be run in background. It does not block.
+func (s *Session) queueHook(e Event) {
+	switch e.Type {
+	case EventTorrentAdded, EventTorrentCompleted, EventTorrentError:
+	default:
+		return
+	}
+	if !s.hooksEnabled() {
+		return
+	}
+	select {
+	case s.hookC <- e:
+	default:
+		s.log.Warningf("hook queue is full, dropping %s event of torrent %s", e.Type, e.TorrentID)
+	}
+}
+
+func (s *Session) hookRunner() {
+	for {
+		select {
+		case e := <-s.hookC:
+			s.runHooks(e)
+		case <-s.closeC:
+			return
+		}
+	}
+}
+
+func (s *Session) runHooks(e Event) {
+	p := hookPayload{
+		Event:     e.Type.String(),
+		Time:      e.Time,
+		TorrentID: e.TorrentID,
+		Error:     e.Error,
+	}
+	if t := s.GetTorrent(e.TorrentID); t != nil {
+		stats := t.Stats()
+		p.Name = stats.Name
+		p.DataDir = stats.DataDir
+		if p.Name == "" {
+			p.Name = t.Name()
+		}
+		p.InfoHash = t.InfoHash().String()
+	}
+	if args := s.hookCommand(e.Type); len(args) > 0 {
+		err := s.runHookCommand(args, p)
+		if err != nil {
+			s.log.Errorf("hook command for %s event of torrent %s failed: %s", e.Type, e.TorrentID, err)
+		}
+	}
+	if s.config.WebhookURL != "" {
+		err := s.sendWebhook(p)
+		if err != nil {
+			s.log.Errorf("webhook for %s event of torrent %s failed: %s", e.Type, e.TorrentID, err)
+		}
+	}
+}
+
+func (s *Session) runHookCommand(args []string, p hookPayload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.HookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...) // nolint: gosec
+	cmd.Env = append(os.Environ(),
+		"RAIN_EVENT="+p.Event,
+		"RAIN_TORRENT_ID="+p.TorrentID,
+		"RAIN_TORRENT_NAME="+p.Name,
+		"RAIN_TORRENT_HASH="+p.InfoHash,
+		"RAIN_TORRENT_DIR="+p.DataDir,
+		"RAIN_ERROR="+p.Error,
+	)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		s.log.Debugf("output of hook command for torrent %s: %s", p.TorrentID, out)
+	}
+	return err
+}
+
+func (s *Session) sendWebhook(p hookPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.HookTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, s.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	// Webhooks are sent through the proxy only in proxy-only mode.
+	client := s.httpClient(false)
+	client.Timeout = s.config.HookTimeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package torrent
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/panzarasa/rain/internal/logger"
+	"github.com/panzarasa/rain/internal/proxy"
+)
+
+func TestWebhook(t *testing.T) {
+	payloads := make(chan hookPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p hookPayload
+		err := json.NewDecoder(r.Body).Decode(&p)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		payloads <- p
+	}))
+	defer srv.Close()
+
+	s := &Session{
+		config: Config{
+			WebhookURL:  srv.URL,
+			HookTimeout: time.Second,
+		},
+		log:      logger.New("session"),
+		torrents: make(map[string]*Torrent),
+	}
+	s.runHooks(Event{Type: EventTorrentError, TorrentID: "foo", Error: "disk full"})
+	select {
+	case p := <-payloads:
+		if p.Event != "torrent-error" || p.TorrentID != "foo" || p.Error != "disk full" {
+			t.Fatalf("unexpected payload: %+v", p)
+		}
+	default:
+		t.Fatal("webhook is not called")
+	}
+}
+
+func TestWebhookProxyOnly(t *testing.T) {
+	payloads := make(chan hookPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p hookPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		payloads <- p
+	}))
+	defer srv.Close()
+
+	// HTTP proxy that supports the CONNECT method only.
+	connects := make(chan string, 1)
+	psrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		connects <- r.Host
+		dst, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer dst.Close()
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+		go io.Copy(dst, brw) // nolint: errcheck
+		_, _ = io.Copy(conn, dst)
+	}))
+	defer psrv.Close()
+
+	px, err := proxy.New(psrv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Session{
+		config: Config{
+			WebhookURL:  srv.URL,
+			HookTimeout: time.Second,
+			ProxyOnly:   true,
+		},
+		log:            logger.New("session"),
+		torrents:       make(map[string]*Torrent),
+		proxy:          px,
+		proxyTransport: newProxyTransport(px),
+	}
+	defer s.proxyTransport.CloseIdleConnections()
+	s.runHooks(Event{Type: EventTorrentCompleted, TorrentID: "foo"})
+	select {
+	case host := <-connects:
+		if host != srv.Listener.Addr().String() {
+			t.Fatalf("unexpected host: %s", host)
+		}
+	default:
+		t.Fatal("webhook is not sent through the proxy")
+	}
+	select {
+	case <-payloads:
+	default:
+		t.Fatal("webhook is not called")
+	}
+}
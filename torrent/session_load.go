@@ -2,14 +2,11 @@ package torrent
 
 import (
 	"errors"
-	"path/filepath"
 
 	"github.com/panzarasa/rain/internal/bitfield"
 	"github.com/panzarasa/rain/internal/metainfo"
 	"github.com/panzarasa/rain/internal/resumer"
-	"github.com/panzarasa/rain/internal/storage/filestorage"
 	"github.com/panzarasa/rain/internal/webseedsource"
-	"go.etcd.io/bbolt"
 )
 
 var errTooManyPieces = errors.New("too many pieces")
@@ -33,16 +30,21 @@ func (s *Session) loadExistingTorrents(ids []string) {
 	s.log.Infof("loaded %d existing torrents", loaded)
 	if s.config.ResumeOnStartup {
 		for _, t := range started {
-			t.torrent.Start()
+			s.setQueued(t.torrent, true)
 		}
+		s.processQueue()
 	}
 }
 
-func (s *Session) parseInfo(b []byte) (*metainfo.Info, error) {
+func (s *Session) parseInfo(b, pieceLayers []byte) (*metainfo.Info, error) {
 	i, err := metainfo.NewInfo(b)
 	if err != nil {
 		return nil, err
 	}
+	err = i.SetPieceLayers(pieceLayers)
+	if err != nil {
+		return nil, err
+	}
 	if i.NumPieces > s.config.MaxPieces {
 		return nil, errTooManyPieces
 	}
@@ -59,7 +61,7 @@ func (s *Session) loadExistingTorrent(id string) (tt *Torrent, hasStarted bool,
 	var bf *bitfield.Bitfield
 	var private bool
 	if len(spec.Info) > 0 {
-		info2, err2 := s.parseInfo(spec.Info)
+		info2, err2 := s.parseInfo(spec.Info, spec.PieceLayers)
 		if err2 != nil {
 			return nil, spec.Started, err2
 		}
@@ -73,13 +75,7 @@ func (s *Session) loadExistingTorrent(id string) (tt *Torrent, hasStarted bool,
 			bf = bf3
 		}
 	}
-	var dest string
-	if s.config.DataDirIncludesTorrentID {
-		dest = filepath.Join(s.config.DataDir, id)
-	} else {
-		dest = s.config.DataDir
-	}
-	sto, err := filestorage.New(dest)
+	sto, err := s.newStorage(spec.Storage, id, spec.DataDir)
 	if err != nil {
 		return
 	}
@@ -103,33 +99,60 @@ func (s *Session) loadExistingTorrent(id string) (tt *Torrent, hasStarted bool,
 		},
 		webseedsource.NewList(spec.URLList),
 		spec.StopAfterDownload,
+		filePrioritiesFromSpec(spec.FilePriorities),
+		spec.Sequential,
+		seedLimits{
+			Ratio:       spec.SeedRatioLimit,
+			RatioAction: SeedAction(spec.SeedRatioAction),
+			Time:        spec.SeedTimeLimit,
+			TimeAction:  SeedAction(spec.SeedTimeAction),
+		},
 	)
 	if err != nil {
 		return
 	}
+	s.setQueuePositionFromSpec(t, spec.QueuePosition)
+	if spec.PreviousVersionDir != "" && len(spec.Bitfield) == 0 {
+		// Files of the previous version are not reused yet.
+		t.previousVersion = &previousVersion{dir: spec.PreviousVersionDir}
+	}
+	t.downloadLimiter.SetRate(spec.SpeedLimitDownload * 1024)
+	t.uploadLimiter.SetRate(spec.SpeedLimitUpload * 1024)
 	go s.checkTorrent(t)
 	delete(s.availablePorts, spec.Port)
 
 	tt = s.insertTorrent(t)
+	if spec.PublicKey != nil {
+		s.setMutableTorrent(id, &mutableTorrent{publicKey: spec.PublicKey, salt: spec.Salt, seq: spec.Seq})
+	}
 	return
 }
 
 // CleanDatabase removes invalid records in the database.
 // Normally you don't need to call this.
 func (s *Session) CleanDatabase() error {
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(torrentsBucket)
-		for _, id := range s.invalidTorrentIDs {
-			err := b.DeleteBucket([]byte(id))
-			if err != nil {
-				return err
-			}
+	for _, id := range s.invalidTorrentIDs {
+		err := s.resumer.Delete(id)
+		if err != nil {
+			return err
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 	s.invalidTorrentIDs = nil
 	return nil
 }
+
+// filePrioritiesFromSpec converts the priorities in resume database.
+// Invalid values are replaced with PriorityNormal.
+func filePrioritiesFromSpec(a []int) []FilePriority {
+	if a == nil {
+		return nil
+	}
+	priorities := make([]FilePriority, len(a))
+	for i, p := range a {
+		priorities[i] = FilePriority(p)
+		if priorities[i] < PrioritySkip || priorities[i] > PriorityHigh {
+			priorities[i] = PriorityNormal
+		}
+	}
+	return priorities
+}
@@ -0,0 +1,14 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilePrioritiesFromSpec(t *testing.T) {
+	assert.Nil(t, filePrioritiesFromSpec(nil))
+	assert.Equal(t,
+		[]FilePriority{PrioritySkip, PriorityHigh, PriorityNormal, PriorityNormal},
+		filePrioritiesFromSpec([]int{0, 3, -1, 4}))
+}
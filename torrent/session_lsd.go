@@ -0,0 +1,27 @@
+package torrent
+
+import (
+	"net"
+
+	"github.com/nictuku/dht"
+)
+
+func (s *Session) processLSDResults() {
+	for {
+		select {
+		case p := <-s.lsd.PeersC:
+			s.mTorrents.RLock()
+			torrents := append([]*Torrent(nil), s.torrentsByInfoHash[dht.InfoHash(p.InfoHash[:])]...)
+			s.mTorrents.RUnlock()
+			for _, t := range torrents {
+				select {
+				case t.torrent.lsdPeersC <- []*net.TCPAddr{p.Addr}:
+				case <-t.torrent.closeC:
+				default:
+				}
+			}
+		case <-s.closeC:
+			return
+		}
+	}
+}
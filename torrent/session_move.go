@@ -0,0 +1,628 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1" // nolint: gosec
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/panzarasa/rain/internal/allocator"
+	"github.com/panzarasa/rain/internal/bitfield"
+	"github.com/panzarasa/rain/internal/metainfo"
+	"github.com/panzarasa/rain/internal/piece"
+	"github.com/panzarasa/rain/internal/resumer"
+	"github.com/panzarasa/rain/storage"
+)
+
+// A torrent is moved to another Session in three steps over the RPC server of the target Session:
+//   1. "/move-torrent/begin" sends the resume spec. Target replies with the pieces that it has already received.
+//   2. "/move-torrent/pieces" sends the data of a range of pieces. Target verifies each piece before writing it.
+//   3. "/move-torrent/commit" adds the torrent to the target Session.
+// If the connection is lost, the transfer is continued from the pieces that are not received by the target.
+// Target drops the moves that are not continued in Config.RPCMoveTimeout. Pieces that are already written to storage are verified when the move begins again.
+
+// moveMaxRetries is the number of consecutive failed attempts that do not transfer any piece before the move is given up.
+const moveMaxRetries = 10
+
+var errMoveNotFound = errors.New("torrent is not being moved")
+
+type moveBeginResponse struct {
+	// Committed is true if the torrent has already been added to the target Session by a previous move.
+	Committed bool
+	// Bitfield of the pieces that are received and verified by the target Session.
+	Bitfield []byte
+}
+
+// moveError is returned when the target Session responds with an error.
+type moveError struct {
+	StatusCode int
+	Message    string
+	// Permanent errors are not retried.
+	Permanent bool
+}
+
+func (e *moveError) Error() string {
+	return fmt.Sprintf("http error: %d %s", e.StatusCode, e.Message)
+}
+
+// Move torrent to another Session.
+// target must be the RPC server address of the other Session in http://host:port form.
+// token is sent in "Authorization: Bearer <token>" header if the RPC server of the target requires authentication.
+// The target verifies the pieces against the hashes in torrent before adding the torrent.
+// If the connection is lost, the transfer is resumed from the pieces that are not received by the target yet.
+// The torrent is removed from this Session after the target confirms that the torrent is added.
+// If the move fails, the torrent is started again if it was started before.
+func (t *Torrent) Move(target, token string) (err error) {
+	// Torrent is removed from queue so it is not started again while it is being moved.
+	t.torrent.session.setQueued(t.torrent, false)
+	t.torrent.Stop()
+	spec, err := t.torrent.session.resumer.Read(t.torrent.id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil && spec.Started {
+			if err2 := t.Start(); err2 != nil {
+				t.torrent.log.Errorln("cannot start torrent after failed move:", err2)
+			}
+		}
+	}()
+	// Bitfield in resume database may be older than the one in memory.
+	t.torrent.mBitfield.RLock()
+	if t.torrent.bitfield != nil {
+		spec.Bitfield = append([]byte(nil), t.torrent.bitfield.Bytes()...)
+	}
+	t.torrent.mBitfield.RUnlock()
+
+	m := &outgoingMove{
+		target: strings.TrimSuffix(target, "/"),
+		id:     t.torrent.id,
+		token:  token,
+		spec:   spec,
+		client: &t.torrent.session.moveClient,
+	}
+	if len(spec.Info) > 0 {
+		m.info, err = t.torrent.session.parseInfo(spec.Info, spec.PieceLayers)
+		if err != nil {
+			return err
+		}
+		var files []allocator.File
+		files, _, err = openFiles(m.info, t.torrent.getStorage())
+		if err != nil {
+			return err
+		}
+		defer closeFiles(files)
+		m.pieces = piece.NewPieces(m.info, files)
+		m.have = bitfield.New(m.info.NumPieces)
+		if len(spec.Bitfield) > 0 {
+			m.have, err = bitfield.NewBytes(spec.Bitfield, m.info.NumPieces)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxElapsedTime = 0
+	var failures int
+	for {
+		var progress bool
+		progress, err = m.run()
+		if err == nil {
+			break
+		}
+		if e, ok := err.(*moveError); ok && e.Permanent {
+			return err
+		}
+		if progress {
+			failures = 0
+			bo.Reset()
+		}
+		failures++
+		if failures > moveMaxRetries {
+			return err
+		}
+		d := bo.NextBackOff()
+		t.torrent.log.Warningf("move is interrupted, resuming in %s: %s", d, err)
+		time.Sleep(d)
+	}
+	return t.torrent.session.RemoveTorrent(t.torrent.id)
+}
+
+// outgoingMove sends a torrent to another Session.
+type outgoingMove struct {
+	target string
+	token  string
+	id     string
+	spec   *resumer.Spec
+	client *http.Client
+
+	// Fields below are nil if the torrent does not have info yet.
+	info   *metainfo.Info
+	pieces []piece.Piece
+	have   *bitfield.Bitfield
+
+	// Number of pieces that the target had at the last attempt.
+	received uint32
+}
+
+// run makes a single attempt to move the torrent.
+// progress is true if the target has received more pieces since the previous attempt.
+func (m *outgoingMove) run() (progress bool, err error) {
+	body, err := json.Marshal(m.spec)
+	if err != nil {
+		return
+	}
+	var resp moveBeginResponse
+	err = m.post("begin", nil, bytes.NewReader(body), &resp)
+	if err != nil {
+		return
+	}
+	if resp.Committed {
+		return
+	}
+	if m.info != nil {
+		var received *bitfield.Bitfield
+		received, err = bitfield.NewBytes(resp.Bitfield, m.info.NumPieces)
+		if err != nil {
+			return
+		}
+		if n := received.Count(); n > m.received {
+			m.received = n
+			progress = true
+		}
+		// Send consecutive runs of pieces that the target does not have.
+		for begin := uint32(0); begin < m.info.NumPieces; begin++ {
+			if !m.have.Test(begin) || received.Test(begin) {
+				continue
+			}
+			end := begin + 1
+			for end < m.info.NumPieces && m.have.Test(end) && !received.Test(end) {
+				end++
+			}
+			err = m.sendPieces(begin, end)
+			if err != nil {
+				return
+			}
+			begin = end
+		}
+	}
+	err = m.post("commit", nil, nil, nil)
+	return
+}
+
+func (m *outgoingMove) sendPieces(begin, end uint32) error {
+	pr, pw := io.Pipe()
+	go func() {
+		buf := make([]byte, m.info.PieceLength)
+		for _, p := range m.pieces[begin:end] {
+			b := buf[:p.Length]
+			_, err := p.Data.ReadAt(b, 0)
+			if err == nil {
+				_, err = pw.Write(b)
+			}
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+		}
+		_ = pw.Close()
+	}()
+	query := url.Values{
+		"begin": {strconv.FormatUint(uint64(begin), 10)},
+		"end":   {strconv.FormatUint(uint64(end), 10)},
+	}
+	return m.post("pieces", query, pr, nil)
+}
+
+// post sends a request to the move endpoint of the target and decodes the JSON response into v if it is not nil.
+func (m *outgoingMove) post(step string, query url.Values, body io.Reader, v interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("id", m.id)
+	req, err := http.NewRequest(http.MethodPost, m.target+"/move-torrent/"+step+"?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+	if m.token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.token)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		// Missing move on target means it is restarted. Move begins again on next attempt.
+		// Missing pieces are sent on next attempt.
+		permanent := resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusConflict &&
+			(resp.StatusCode != http.StatusNotFound || step == "begin")
+		return &moveError{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(b)),
+			Permanent:  permanent,
+		}
+	}
+	if v != nil {
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+	return nil
+}
+
+// incomingMove is a torrent that is being moved into the Session from another Session.
+type incomingMove struct {
+	spec *resumer.Spec
+	// Fields below are nil if the torrent does not have info yet.
+	info    *metainfo.Info
+	storage storage.Storage
+
+	mBitfield sync.Mutex
+	// Pieces that are verified and written to storage.
+	bitfield *bitfield.Bitfield
+
+	// Fields below are guarded by Session.mMoves.
+	// Time of the last request from the source.
+	updatedAt time.Time
+	// Number of "/move-torrent/pieces" requests in progress.
+	active int
+}
+
+// beginIncomingMove starts receiving a torrent or continues an interrupted one with the same info hash.
+// Data of the torrent that is left in storage from a dropped move is verified before responding.
+func (s *Session) beginIncomingMove(id string, spec *resumer.Spec) (*moveBeginResponse, error) {
+	err := validateID(id)
+	if err != nil {
+		return nil, newInputError(err)
+	}
+	s.mMoves.Lock()
+	resp, err := s.continueIncomingMove(id, spec)
+	s.mMoves.Unlock()
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	m, err := s.newIncomingMove(id, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mMoves.Lock()
+	defer s.mMoves.Unlock()
+	// Source may have begun the same move again while the data is being verified.
+	resp, err = s.continueIncomingMove(id, spec)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	m.updatedAt = time.Now()
+	s.moves[id] = m
+	s.log.Infof("receiving torrent %s", id)
+	return &moveBeginResponse{Bitfield: m.bitfieldBytes()}, nil
+}
+
+// continueIncomingMove returns the response for a move that is already begun or committed.
+// Returns nil response if there is no move of the torrent. s.mMoves must be held.
+func (s *Session) continueIncomingMove(id string, spec *resumer.Spec) (*moveBeginResponse, error) {
+	if t := s.GetTorrent(id); t != nil {
+		if _, ok := s.moves[id]; !ok && bytes.Equal(t.torrent.InfoHash(), spec.InfoHash) {
+			return &moveBeginResponse{Committed: true}, nil
+		}
+		return nil, newInputError(errors.New("duplicate torrent id"))
+	}
+	if m, ok := s.moves[id]; ok && bytes.Equal(m.spec.InfoHash, spec.InfoHash) {
+		// Stats in spec may have changed since the previous attempt.
+		m.spec = spec
+		m.updatedAt = time.Now()
+		return &moveBeginResponse{Bitfield: m.bitfieldBytes()}, nil
+	}
+	return nil, nil
+}
+
+// newIncomingMove creates the storage of the torrent in spec and verifies the data that already exists in it.
+func (s *Session) newIncomingMove(id string, spec *resumer.Spec) (*incomingMove, error) {
+	m := &incomingMove{spec: spec}
+	if len(spec.Info) == 0 {
+		return m, nil
+	}
+	var err error
+	m.info, err = s.parseInfo(spec.Info, spec.PieceLayers)
+	if err != nil {
+		return nil, newInputError(err)
+	}
+	if len(spec.Bitfield) > 0 {
+		_, err = bitfield.NewBytes(spec.Bitfield, m.info.NumPieces)
+		if err != nil {
+			return nil, newInputError(err)
+		}
+	}
+	_, err = s.config.storageProvider(spec.Storage, &s.s3Client, s.log)
+	if err != nil {
+		return nil, newInputError(err)
+	}
+	m.storage, err = s.newStorage(spec.Storage, id, "")
+	if err != nil {
+		return nil, err
+	}
+	m.bitfield = bitfield.New(m.info.NumPieces)
+	err = m.verifyPieces()
+	if err != nil {
+		return nil, err
+	}
+	if n := m.bitfield.Count(); n > 0 {
+		s.log.Infof("found %d pieces of torrent %s in storage", n, id)
+	}
+	return m, nil
+}
+
+// verifyPieces sets the bits of the pieces that are already in storage.
+func (m *incomingMove) verifyPieces() error {
+	files, hasExisting, err := openFiles(m.info, m.storage)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+	if !hasExisting {
+		return nil
+	}
+	pieces := piece.NewPieces(m.info, files)
+	buf := make([]byte, m.info.PieceLength)
+	hash := sha1.New() // nolint: gosec
+	for i := range pieces {
+		p := &pieces[i]
+		b := buf[:p.Length]
+		_, err = p.Data.ReadAt(b, 0)
+		if err != nil {
+			return err
+		}
+		hash.Reset()
+		if p.VerifyHash(b, hash) {
+			m.bitfield.Set(p.Index)
+		}
+	}
+	return nil
+}
+
+func (m *incomingMove) bitfieldBytes() []byte {
+	if m.bitfield == nil {
+		return nil
+	}
+	m.mBitfield.Lock()
+	defer m.mBitfield.Unlock()
+	return append([]byte(nil), m.bitfield.Bytes()...)
+}
+
+// receivePieces reads the data of pieces in range [begin, end) from r.
+// Each piece is written to storage after its hash is verified.
+// Pieces that are received before an error are kept, so the transfer can be resumed.
+func (s *Session) receivePieces(id string, begin, end uint32, r io.Reader) error {
+	s.mMoves.Lock()
+	m, ok := s.moves[id]
+	if ok {
+		m.active++
+	}
+	s.mMoves.Unlock()
+	if !ok {
+		return errMoveNotFound
+	}
+	defer func() {
+		s.mMoves.Lock()
+		m.active--
+		m.updatedAt = time.Now()
+		s.mMoves.Unlock()
+	}()
+	if m.info == nil || begin >= end || end > m.info.NumPieces {
+		return newInputError(errors.New("invalid piece range"))
+	}
+	files, _, err := openFiles(m.info, m.storage)
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+	pieces := piece.NewPieces(m.info, files)
+	buf := make([]byte, m.info.PieceLength)
+	hash := sha1.New() // nolint: gosec
+	for i := begin; i < end; i++ {
+		p := &pieces[i]
+		b := buf[:p.Length]
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return err
+		}
+		hash.Reset()
+		if !p.VerifyHash(b, hash) {
+			return newInputError(fmt.Errorf("hash mismatch for piece #%d", i))
+		}
+		_, err = p.Data.Write(b)
+		if err != nil {
+			return err
+		}
+		m.mBitfield.Lock()
+		m.bitfield.Set(i)
+		m.mBitfield.Unlock()
+	}
+	return nil
+}
+
+// movePiecesMissingError is returned from commit if the target does not have all the pieces that the source has.
+type movePiecesMissingError struct {
+	index uint32
+}
+
+func (e *movePiecesMissingError) Error() string {
+	return fmt.Sprintf("piece #%d is not received", e.index)
+}
+
+// commitIncomingMove adds the received torrent into the Session.
+// Committing a torrent that has already been committed does nothing, so the source can retry if the response is lost.
+func (s *Session) commitIncomingMove(id string) error {
+	s.mMoves.Lock()
+	defer s.mMoves.Unlock()
+	m, ok := s.moves[id]
+	if !ok {
+		if s.GetTorrent(id) != nil {
+			return nil
+		}
+		return errMoveNotFound
+	}
+	spec := *m.spec
+	if m.info != nil {
+		m.mBitfield.Lock()
+		received := m.bitfield.Copy()
+		m.mBitfield.Unlock()
+		if len(spec.Bitfield) > 0 {
+			want, err := bitfield.NewBytes(spec.Bitfield, m.info.NumPieces)
+			if err != nil {
+				return newInputError(err)
+			}
+			for i := uint32(0); i < m.info.NumPieces; i++ {
+				if want.Test(i) && !received.Test(i) {
+					return &movePiecesMissingError{index: i}
+				}
+			}
+		}
+		spec.Bitfield = received.Bytes()
+	}
+	port, err := s.getPort()
+	if err != nil {
+		return err
+	}
+	spec.Port = port
+	// Data directory in the other Session is not valid here.
+	spec.DataDir = ""
+	err = s.resumer.Write(id, &spec)
+	if err != nil {
+		s.releasePort(port)
+		return err
+	}
+	t, started, err := s.loadExistingTorrent(id)
+	if err != nil {
+		s.releasePort(port)
+		_ = s.resumer.Delete(id)
+		return err
+	}
+	delete(s.moves, id)
+	s.log.Infof("received torrent %s", id)
+	if started {
+		return t.Start()
+	}
+	return nil
+}
+
+// moveExpirer drops the incoming moves that are not continued by the source in Config.RPCMoveTimeout.
+func (s *Session) moveExpirer() {
+	ticker := time.NewTicker(s.config.RPCMoveTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.dropStaleMoves()
+		case <-s.closeC:
+			return
+		}
+	}
+}
+
+func (s *Session) dropStaleMoves() {
+	s.mMoves.Lock()
+	defer s.mMoves.Unlock()
+	for id, m := range s.moves {
+		if m.active == 0 && time.Since(m.updatedAt) > s.config.RPCMoveTimeout {
+			delete(s.moves, id)
+			s.log.Infof("dropped incoming move of torrent %s", id)
+		}
+	}
+}
+
+// openFiles opens the files of the torrent in sto.
+// hasExisting is true if any of the files already exists in storage.
+func openFiles(info *metainfo.Info, sto storage.Storage) (files []allocator.File, hasExisting bool, err error) {
+	a := allocator.New()
+	progressC := make(chan allocator.Progress)
+	resultC := make(chan *allocator.Allocator, 1)
+	go a.Run(info, sto, progressC, resultC)
+	for {
+		select {
+		case <-progressC:
+		case a = <-resultC:
+			return a.Files, a.HasExisting, a.Error
+		}
+	}
+}
+
+func closeFiles(files []allocator.File) {
+	for _, f := range files {
+		_ = f.Storage.Close()
+	}
+}
+
+func (h *rpcHandler) handleMoveBegin(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id required", http.StatusBadRequest)
+		return
+	}
+	var spec resumer.Spec
+	err := json.NewDecoder(r.Body).Decode(&spec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := h.session.beginIncomingMove(id, &spec)
+	if err != nil {
+		h.moveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *rpcHandler) handleMovePieces(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	begin, err := strconv.ParseUint(query.Get("begin"), 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	end, err := strconv.ParseUint(query.Get("end"), 10, 32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.session.receivePieces(query.Get("id"), uint32(begin), uint32(end), r.Body)
+	if err != nil {
+		h.moveError(w, err)
+	}
+}
+
+func (h *rpcHandler) handleMoveCommit(w http.ResponseWriter, r *http.Request) {
+	err := h.session.commitIncomingMove(r.URL.Query().Get("id"))
+	if err != nil {
+		h.moveError(w, err)
+	}
+}
+
+func (h *rpcHandler) moveError(w http.ResponseWriter, err error) {
+	h.session.log.Errorln("cannot receive torrent:", err)
+	code := http.StatusInternalServerError
+	switch err.(type) {
+	case *InputError:
+		code = http.StatusBadRequest
+	case *movePiecesMissingError:
+		code = http.StatusConflict
+	}
+	if err == errMoveNotFound {
+		code = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), code)
+}
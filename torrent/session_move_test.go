@@ -0,0 +1,268 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/panzarasa/rain/internal/bitfield"
+	"github.com/panzarasa/rain/internal/metainfo"
+)
+
+// newMoveTest returns a seeding torrent with multiple pieces in source Session and the RPC address of the target Session.
+// Data of the torrent is in "files" directory under the data directory of source Session.
+// f is called with the config of the target Session if it is not nil.
+func newMoveTest(t *testing.T, f func(cfg *Config)) (src *Session, tor *Torrent, dst *Session, dstURL string, closeFunc func()) {
+	src, closeSrc := newTestSessionWithConfig(t, nil)
+	dst, closeDst := newTestSessionWithConfig(t, func(cfg *Config) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.RPCEnabled = true
+		cfg.RPCPort = l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		dstURL = "http://127.0.0.1:" + strconv.Itoa(cfg.RPCPort)
+		if f != nil {
+			f(cfg)
+		}
+	})
+
+	dir := filepath.Join(src.config.DataDir, "files")
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 100*1024)
+	_, err = rand.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "file.bin"), data, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := metainfo.NewInfoBytes(filepath.Join(dir, "file.bin"), false, 16*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := metainfo.NewBytes(info, nil, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, err = src.AddTorrent(bytes.NewReader(b), &AddTorrentOptions{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, tor, Seeding)
+	if tor.torrent.info.NumPieces < 3 {
+		t.Fatal("torrent must have multiple pieces")
+	}
+	return src, tor, dst, dstURL, func() {
+		closeSrc()
+		closeDst()
+	}
+}
+
+func TestMoveResume(t *testing.T) {
+	src, tor, dst, dstURL, closeFunc := newMoveTest(t, nil)
+	defer closeFunc()
+
+	// Proxy drops the connection in the middle of the second piece in first transfer.
+	target, err := url.Parse(dstURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorLog = nil
+	var m sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/move-torrent/pieces" {
+			m.Lock()
+			ranges = append(ranges, r.URL.Query().Get("begin")+"-"+r.URL.Query().Get("end"))
+			first := len(ranges) == 1
+			m.Unlock()
+			if first {
+				r.Body = ioutil.NopCloser(io.MultiReader(io.LimitReader(r.Body, 16*1024+100), errorReader{}))
+				r.ContentLength = -1
+			}
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	id := tor.ID()
+	err = tor.Move(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.GetTorrent(id) != nil {
+		t.Fatal("torrent is not removed from source")
+	}
+	numPieces := strconv.Itoa(int(tor.torrent.info.NumPieces))
+	if len(ranges) != 2 || ranges[0] != "0-"+numPieces || ranges[1] != "1-"+numPieces {
+		t.Fatalf("unexpected piece ranges: %v", ranges)
+	}
+	moved := dst.GetTorrent(id)
+	if moved == nil {
+		t.Fatal("torrent is not added to target")
+	}
+	waitStatus(t, moved, Seeding)
+	expected, err := ioutil.ReadFile(filepath.Join(src.config.DataDir, "files", "file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dst.config.DataDir, id, "file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(expected, got) {
+		t.Fatal("moved file is different")
+	}
+}
+
+func TestMoveCorrupt(t *testing.T) {
+	src, tor, dst, dstURL, closeFunc := newMoveTest(t, nil)
+	defer closeFunc()
+
+	// Data is changed after the pieces are verified in source.
+	f, err := os.OpenFile(filepath.Join(tor.Stats().DataDir, "file.bin"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte("corrupt"), 50*1024)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tor.Move(dstURL, "")
+	if err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if src.GetTorrent(tor.ID()) == nil {
+		t.Fatal("torrent must be kept in source")
+	}
+	if dst.GetTorrent(tor.ID()) != nil {
+		t.Fatal("torrent must not be added to target")
+	}
+}
+
+func TestMoveToken(t *testing.T) {
+	src, tor, dst, dstURL, closeFunc := newMoveTest(t, func(cfg *Config) {
+		cfg.RPCAdminTokens = []string{"secret"}
+	})
+	defer closeFunc()
+
+	err := tor.Move(dstURL, "invalid")
+	if e, ok := err.(*moveError); !ok || e.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Torrent is started again after the failed move.
+	waitStatus(t, tor, Seeding)
+	err = tor.Move(dstURL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.GetTorrent(tor.ID()) != nil {
+		t.Fatal("torrent is not removed from source")
+	}
+	if dst.GetTorrent(tor.ID()) == nil {
+		t.Fatal("torrent is not added to target")
+	}
+}
+
+func TestMoveDropped(t *testing.T) {
+	src, tor, dst, _, closeFunc := newMoveTest(t, nil)
+	defer closeFunc()
+
+	id := tor.ID()
+	spec, err := src.resumer.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dst.beginIncomingMove(id, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(src.config.DataDir, "files", "file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = dst.receivePieces(id, 0, 1, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move is kept while the source continues it.
+	dst.dropStaleMoves()
+	if _, ok := dst.moves[id]; !ok {
+		t.Fatal("move is dropped")
+	}
+	dst.moves[id].updatedAt = time.Now().Add(-dst.config.RPCMoveTimeout - time.Second)
+	dst.dropStaleMoves()
+	if _, ok := dst.moves[id]; ok {
+		t.Fatal("move is not dropped")
+	}
+	err = dst.commitIncomingMove(id)
+	if err != errMoveNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Piece in storage is found when the move begins again.
+	resp, err := dst.beginIncomingMove(id, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := bitfield.NewBytes(resp.Bitfield, tor.torrent.info.NumPieces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Count() != 1 || !received.Test(0) {
+		t.Fatalf("unexpected bitfield: %s", received.Hex())
+	}
+}
+
+func TestMoveInvalidID(t *testing.T) {
+	src, tor, dst, _, closeFunc := newMoveTest(t, nil)
+	defer closeFunc()
+
+	spec, err := src.resumer.Read(tor.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"..", "../other", "a/b", `a\b`} {
+		_, err = dst.beginIncomingMove(id, spec)
+		if _, ok := err.(*InputError); !ok {
+			t.Fatalf("unexpected error for id %q: %v", id, err)
+		}
+		_, err = dst.AddURI(torrentMagnetLink, &AddTorrentOptions{ID: id, Stopped: true})
+		if _, ok := err.(*InputError); !ok {
+			t.Fatalf("unexpected error for id %q: %v", id, err)
+		}
+	}
+	if len(dst.moves) != 0 || len(dst.ListTorrents()) != 0 {
+		t.Fatal("torrent with invalid id must not be added")
+	}
+}
+
+type errorReader struct{}
+
+func (errorReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection lost")
+}
@@ -0,0 +1,159 @@
+package torrent
+
+import (
+	"errors"
+	"time"
+
+	"github.com/panzarasa/rain/internal/magnet"
+	"github.com/zeebo/bencode"
+)
+
+// mutableTorrent is the DHT item that points to the latest version of a torrent. See BEP 46.
+type mutableTorrent struct {
+	publicKey []byte
+	salt      []byte
+	// Sequence number of the item that the torrent is added from.
+	seq int64
+}
+
+// mutableTorrentValue is the value of the DHT item.
+type mutableTorrentValue struct {
+	InfoHash []byte `bencode:"ih"`
+}
+
+// resolveMutableTorrent returns the info hash of the latest version of the mutable torrent from DHT.
+func (s *Session) resolveMutableTorrent(publicKey, salt []byte) (infoHash [20]byte, seq int64, err error) {
+	item, err := s.DHTGet(DHTTarget(publicKey, salt), salt)
+	if err != nil {
+		return
+	}
+	var v mutableTorrentValue
+	err = bencode.DecodeBytes(item.Value, &v)
+	if err != nil {
+		return
+	}
+	if len(v.InfoHash) != len(infoHash) {
+		err = errors.New("invalid info hash in mutable torrent item")
+		return
+	}
+	copy(infoHash[:], v.InfoHash)
+	return infoHash, item.Seq, nil
+}
+
+// setMutableTorrent sets the DHT item that is watched for the torrent. Nil value stops watching.
+func (s *Session) setMutableTorrent(id string, mt *mutableTorrent) {
+	s.mMutableTorrents.Lock()
+	defer s.mMutableTorrents.Unlock()
+	if mt == nil {
+		delete(s.mutableTorrents, id)
+	} else {
+		s.mutableTorrents[id] = mt
+	}
+}
+
+func (s *Session) mutableTorrentUpdater() {
+	ticker := time.NewTicker(s.config.DHTMutableTorrentInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.updateMutableTorrents()
+		case <-s.closeC:
+			return
+		}
+	}
+}
+
+// updateMutableTorrents checks DHT for new versions of the mutable torrents and adds them to the Session.
+func (s *Session) updateMutableTorrents() {
+	s.mMutableTorrents.Lock()
+	torrents := make(map[string]*mutableTorrent, len(s.mutableTorrents))
+	for id, mt := range s.mutableTorrents {
+		torrents[id] = mt
+	}
+	s.mMutableTorrents.Unlock()
+
+	for id, mt := range torrents {
+		infoHash, seq, err := s.resolveMutableTorrent(mt.publicKey, mt.salt)
+		if err != nil {
+			s.log.Debugf("cannot get latest version of mutable torrent %s: %s", id, err)
+			continue
+		}
+		if seq <= mt.seq {
+			continue
+		}
+		t := s.GetTorrent(id)
+		if t == nil {
+			continue
+		}
+		if infoHash == t.torrent.infoHash {
+			s.setMutableTorrent(id, &mutableTorrent{publicKey: mt.publicKey, salt: mt.salt, seq: seq})
+			if err = s.resumer.WriteMutable(id, mt.publicKey, mt.salt, seq); err != nil {
+				s.log.Error(err)
+			}
+			continue
+		}
+		t2, err := s.addNewVersion(t, mt, infoHash, seq)
+		if err != nil {
+			s.log.Errorf("cannot add new version of mutable torrent %s: %s", id, err)
+			continue
+		}
+		s.log.Infof("new version of mutable torrent %s is added as %s", id, t2.ID())
+	}
+}
+
+// addNewVersion adds the new version of the mutable torrent with the same options of the previous version.
+// Previous version is stopped and it is not watched for updates anymore.
+func (s *Session) addNewVersion(t *Torrent, mt *mutableTorrent, infoHash [20]byte, seq int64) (*Torrent, error) {
+	spec, err := s.resumer.Read(t.ID())
+	if err != nil {
+		return nil, err
+	}
+	prev := &previousVersion{dir: s.dataDir(t.ID(), spec.DataDir)}
+	if len(spec.Info) > 0 {
+		prev.info, err = s.parseInfo(spec.Info, spec.PieceLayers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Seed limits in resume db are already resolved and zero means disabled.
+	// Zero value in AddTorrentOptions uses session defaults, so disabled limits are passed as negative.
+	seedRatioLimit, seedTimeLimit := spec.SeedRatioLimit, spec.SeedTimeLimit
+	if seedRatioLimit == 0 {
+		seedRatioLimit = -1
+	}
+	if seedTimeLimit == 0 {
+		seedTimeLimit = -1
+	}
+	opt := &AddTorrentOptions{
+		Stopped:           !spec.Started,
+		StopAfterDownload: spec.StopAfterDownload,
+		Sequential:        spec.Sequential,
+		DataDir:           spec.DataDir,
+		Storage:           spec.Storage,
+		SeedRatioLimit:    seedRatioLimit,
+		SeedRatioAction:   SeedAction(spec.SeedRatioAction),
+		SeedTimeLimit:     seedTimeLimit,
+		SeedTimeAction:    SeedAction(spec.SeedTimeAction),
+	}
+	ma := &magnet.Magnet{
+		InfoHash:  infoHash,
+		Trackers:  spec.Trackers,
+		PublicKey: mt.publicKey,
+		Salt:      mt.salt,
+	}
+	t2, err := s.addMagnetVersion(ma, opt, seq, prev)
+	if err != nil {
+		return nil, err
+	}
+	s.setMutableTorrent(t.ID(), nil)
+	err = s.resumer.WriteMutable(t.ID(), nil, nil, 0)
+	if err != nil {
+		s.log.Error(err)
+	}
+	err = t.Stop()
+	if err != nil {
+		s.log.Error(err)
+	}
+	return t2, nil
+}
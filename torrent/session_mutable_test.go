@@ -0,0 +1,216 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/panzarasa/rain/internal/logger"
+	"github.com/panzarasa/rain/internal/magnet"
+	"github.com/panzarasa/rain/internal/metainfo"
+	"github.com/panzarasa/rain/storage/filestorage"
+	"github.com/zeebo/bencode"
+)
+
+func putMutableTorrent(t *testing.T, s *Session, key ed25519.PrivateKey, salt []byte, infoHash string, seq int64) {
+	ih, _ := hex.DecodeString(infoHash)
+	value, err := bencode.EncodeBytes(map[string][]byte{"ih": ih})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := NewMutableDHTItem(key, salt, value, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.DHTPut(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutableTorrent(t *testing.T) {
+	node, close1 := newDHTItemsTestSession(t)
+	defer close1()
+	bootstrap := "127.0.0.1:" + strconv.Itoa(int(node.config.DHTItemsPort))
+	publisher, close2 := newDHTItemsTestSession(t, bootstrap)
+	defer close2()
+	s, close3 := newDHTItemsTestSession(t, bootstrap)
+	defer close3()
+
+	publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("release")
+	const ih1 = "1111111111111111111111111111111111111111"
+	const ih2 = "2222222222222222222222222222222222222222"
+	putMutableTorrent(t, publisher, key, salt, ih1, 1)
+
+	link := "magnet:?xs=urn:btpk:" + hex.EncodeToString(publicKey) + "&s=" + hex.EncodeToString(salt)
+	s.defaultSeedLimits.Ratio = 2
+	tor, err := s.AddURI(link, &AddTorrentOptions{Stopped: true, SeedRatioLimit: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tor.InfoHash().String() != ih1 {
+		t.Fatalf("unexpected info hash: %s", tor.InfoHash())
+	}
+
+	// No new version
+	s.updateMutableTorrents()
+	if n := len(s.ListTorrents()); n != 1 {
+		t.Fatalf("unexpected number of torrents: %d", n)
+	}
+
+	putMutableTorrent(t, publisher, key, salt, ih2, 2)
+	s.updateMutableTorrents()
+	torrents := s.ListTorrents()
+	if len(torrents) != 2 {
+		t.Fatalf("unexpected number of torrents: %d", len(torrents))
+	}
+	var tor2 *Torrent
+	for _, t2 := range torrents {
+		if t2.ID() != tor.ID() {
+			tor2 = t2
+		}
+	}
+	if tor2.InfoHash().String() != ih2 {
+		t.Fatalf("unexpected info hash: %s", tor2.InfoHash())
+	}
+	s.mMutableTorrents.Lock()
+	_, oldWatched := s.mutableTorrents[tor.ID()]
+	mt := s.mutableTorrents[tor2.ID()]
+	s.mMutableTorrents.Unlock()
+	if oldWatched {
+		t.Fatal("previous version must not be watched")
+	}
+	if mt == nil || mt.seq != 2 {
+		t.Fatal("new version must be watched")
+	}
+	if r := tor2.torrent.seedLimits.Ratio; r != 0 {
+		t.Fatalf("disabled seed ratio limit is not kept: %f", r)
+	}
+	spec, err := s.resumer.Read(tor2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Seq != 2 || hex.EncodeToString(spec.PublicKey) != hex.EncodeToString(publicKey) {
+		t.Fatalf("unexpected spec: seq=%d public_key=%x", spec.Seq, spec.PublicKey)
+	}
+}
+
+func TestReuseFilesOfPreviousVersion(t *testing.T) {
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	mi, err := metainfo.New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevDir, closePrevDir := tempdir(t)
+	defer closePrevDir()
+	err = CopyDir(filepath.Join(torrentDataDir, torrentName), filepath.Join(prevDir, torrentName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(prevDir, torrentName, "data", "zero.bin"), make([]byte, 10<<20), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Last piece contains the end of zero.bin and the files in folder.
+	err = ioutil.WriteFile(filepath.Join(prevDir, torrentName, "folder", "file2.txt"), []byte("changed"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, closeDir := tempdir(t)
+	defer closeDir()
+	sto, err := filestorage.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pv := &previousVersion{info: &mi.Info, dir: prevDir}
+	pv.reuseFiles(&mi.Info, sto, logger.New("test"))
+	for _, name := range []string{"data/file1.bin", "data/file2.bin"} {
+		fi1, err := os.Stat(filepath.Join(prevDir, torrentName, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi2, err := os.Stat(filepath.Join(dir, torrentName, name))
+		if err != nil {
+			t.Fatalf("file is not reused: %s", name)
+		}
+		if os.SameFile(fi1, fi2) {
+			t.Fatalf("file is linked: %s", name)
+		}
+		b1, err := ioutil.ReadFile(filepath.Join(prevDir, torrentName, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b2, err := ioutil.ReadFile(filepath.Join(dir, torrentName, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b1, b2) {
+			t.Fatalf("file is not copied: %s", name)
+		}
+	}
+	for _, name := range []string{"data/zero.bin", "folder/file1.txt", "folder/file2.txt", "README"} {
+		if _, err = os.Stat(filepath.Join(dir, torrentName, name)); !os.IsNotExist(err) {
+			t.Fatalf("file in unverified piece is reused: %s", name)
+		}
+	}
+
+	// Files are not reused if lengths in previous version are unknown.
+	dir2, closeDir2 := tempdir(t)
+	defer closeDir2()
+	sto, err = filestorage.New(dir2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pv = &previousVersion{dir: prevDir}
+	pv.reuseFiles(&mi.Info, sto, logger.New("test"))
+	if _, err = os.Stat(filepath.Join(dir2, torrentName)); !os.IsNotExist(err) {
+		t.Fatal("files must not be reused")
+	}
+}
+
+func TestPreviousVersionSaved(t *testing.T) {
+	s, closeSession := newTestSessionWithConfig(t, nil)
+	defer closeSession()
+	ma, err := magnet.New(torrentMagnetLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevDir := filepath.Join(s.config.DataDir, "previous")
+	tor, err := s.addMagnetVersion(ma, &AddTorrentOptions{Stopped: true}, 2, &previousVersion{dir: prevDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := tor.ID()
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are reused after restart if the info is not received before.
+	s, err = NewSession(s.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	tor = s.GetTorrent(id)
+	if tor == nil {
+		t.Fatal("torrent is not loaded")
+	}
+	if pv := tor.torrent.previousVersion; pv == nil || pv.dir != prevDir {
+		t.Fatalf("previous version is not loaded: %#v", pv)
+	}
+}
@@ -0,0 +1,179 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// torrentMetric is a series that is exported for each torrent in Session.
+type torrentMetric struct {
+	name  string
+	help  string
+	typ   string
+	value func(s *Stats) int64
+}
+
+var torrentMetrics = []torrentMetric{
+	{"speed_download", "Download speed from peers in bytes/s.", "gauge", func(s *Stats) int64 { return int64(s.Speed.Download) }},
+	{"speed_upload", "Upload speed to peers in bytes/s.", "gauge", func(s *Stats) int64 { return int64(s.Speed.Upload) }},
+	{"bytes_completed", "Bytes that are downloaded and passed hash check.", "gauge", func(s *Stats) int64 { return s.Bytes.Completed }},
+	{"bytes_incomplete", "Bytes that are needed to complete all missing pieces.", "gauge", func(s *Stats) int64 { return s.Bytes.Incomplete }},
+	{"bytes_total", "Total bytes of files in torrent.", "gauge", func(s *Stats) int64 { return s.Bytes.Total }},
+	{"bytes_downloaded", "Bytes downloaded from swarm.", "counter", func(s *Stats) int64 { return s.Bytes.Downloaded }},
+	{"bytes_uploaded", "Bytes uploaded to swarm.", "counter", func(s *Stats) int64 { return s.Bytes.Uploaded }},
+	{"bytes_wasted", "Bytes downloaded due to duplicate or non-requested pieces.", "counter", func(s *Stats) int64 { return s.Bytes.Wasted }},
+	{"bytes_allocated", "Bytes allocated on storage.", "gauge", func(s *Stats) int64 { return s.Bytes.Allocated }},
+	{"peers", "Number of connected peers.", "gauge", func(s *Stats) int64 { return int64(s.Peers.Total) }},
+	{"peers_incoming", "Number of peers that have connected to us.", "gauge", func(s *Stats) int64 { return int64(s.Peers.Incoming) }},
+	{"peers_outgoing", "Number of peers that we have connected to.", "gauge", func(s *Stats) int64 { return int64(s.Peers.Outgoing) }},
+	{"pieces_have", "Number of pieces that are downloaded and verified.", "gauge", func(s *Stats) int64 { return int64(s.Pieces.Have) }},
+	{"pieces_missing", "Number of pieces that need to be downloaded.", "gauge", func(s *Stats) int64 { return int64(s.Pieces.Missing) }},
+	{"pieces_available", "Number of unique pieces available on swarm.", "gauge", func(s *Stats) int64 { return int64(s.Pieces.Available) }},
+	{"pieces_total", "Number of total pieces in torrent.", "gauge", func(s *Stats) int64 { return int64(s.Pieces.Total) }},
+}
+
+// handleMetrics writes Session and torrent metrics in Prometheus text exposition format.
+func (h *rpcHandler) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	h.session.writePrometheusMetrics(&b)
+	w.Header().Set("Content-Type", prometheusContentType)
+	_, _ = w.Write(b.Bytes())
+}
+
+func (s *Session) writePrometheusMetrics(b *bytes.Buffer) {
+	s.metrics.writePrometheus(b)
+
+	torrents := s.ListTorrents()
+	sort.Slice(torrents, func(i, j int) bool { return torrents[i].ID() < torrents[j].ID() })
+	stats := make([]Stats, len(torrents))
+	trackers := make([][]Tracker, len(torrents))
+	for i, t := range torrents {
+		stats[i] = t.Stats()
+		trackers[i] = t.Trackers()
+	}
+	labels := func(i int, extra ...string) string {
+		return prometheusLabels(append([]string{"id", torrents[i].ID(), "name", stats[i].Name}, extra...)...)
+	}
+
+	writePrometheusHeader(b, "rain_torrent_status", "Status of the torrent. Value is 1 for the current status.", "gauge")
+	for i := range torrents {
+		writePrometheusSample(b, "rain_torrent_status", labels(i, "status", stats[i].Status.String()), 1)
+	}
+	for _, m := range torrentMetrics {
+		name := "rain_torrent_" + m.name
+		writePrometheusHeader(b, name, m.help, m.typ)
+		for i := range torrents {
+			writePrometheusSample(b, name, labels(i), float64(m.value(&stats[i])))
+		}
+	}
+	// Trackers are identified by their index in the torrent because the label does not contain the full URL.
+	trackerLabels := func(i, j int, extra ...string) string {
+		return labels(i, append([]string{"url", trackerLabel(trackers[i][j].URL), "index", strconv.Itoa(j)}, extra...)...)
+	}
+	writePrometheusHeader(b, "rain_torrent_tracker_status", "Status of the tracker. Value is 1 for the current status.", "gauge")
+	for i := range torrents {
+		for j, tr := range trackers[i] {
+			writePrometheusSample(b, "rain_torrent_tracker_status", trackerLabels(i, j, "status", trackerStatusToString(tr.Status)), 1)
+		}
+	}
+	writePrometheusHeader(b, "rain_torrent_tracker_seeders", "Number of seeders reported by the tracker.", "gauge")
+	for i := range torrents {
+		for j, tr := range trackers[i] {
+			writePrometheusSample(b, "rain_torrent_tracker_seeders", trackerLabels(i, j), float64(tr.Seeders))
+		}
+	}
+	writePrometheusHeader(b, "rain_torrent_tracker_leechers", "Number of leechers reported by the tracker.", "gauge")
+	for i := range torrents {
+		for j, tr := range trackers[i] {
+			writePrometheusSample(b, "rain_torrent_tracker_leechers", trackerLabels(i, j), float64(tr.Leechers))
+		}
+	}
+}
+
+// trackerLabel returns only the scheme and host of the tracker URL.
+// Path and query are not exported because private trackers put the passkey of the user there.
+func trackerLabel(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// writePrometheus writes the metrics in the registry.
+// Meters are exported as a gauge of 1-minute rate and a counter of total count.
+func (m *sessionMetrics) writePrometheus(b *bytes.Buffer) {
+	var names []string
+	values := make(map[string]interface{})
+	m.registry.Each(func(name string, i interface{}) {
+		names = append(names, name)
+		values[name] = i
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		metric := "rain_session_" + name
+		switch v := values[name].(type) {
+		case metrics.Gauge:
+			writePrometheusHeader(b, metric, "", "gauge")
+			writePrometheusSample(b, metric, "", float64(v.Value()))
+		case metrics.GaugeFloat64:
+			writePrometheusHeader(b, metric, "", "gauge")
+			writePrometheusSample(b, metric, "", v.Value())
+		case metrics.Counter:
+			writePrometheusHeader(b, metric, "", "gauge")
+			writePrometheusSample(b, metric, "", float64(v.Count()))
+		case metrics.Meter:
+			snap := v.Snapshot()
+			writePrometheusHeader(b, metric, "", "gauge")
+			writePrometheusSample(b, metric, "", snap.Rate1())
+			writePrometheusHeader(b, metric+"_total", "", "counter")
+			writePrometheusSample(b, metric+"_total", "", float64(snap.Count()))
+		}
+	}
+}
+
+func writePrometheusHeader(b *bytes.Buffer, name, help, typ string) {
+	if help != "" {
+		fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	}
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, typ)
+}
+
+func writePrometheusSample(b *bytes.Buffer, name, labels string, value float64) {
+	b.WriteString(name)
+	b.WriteString(labels)
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('\n')
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusLabels formats the key-value pairs as a label set.
+func prometheusLabels(kv ...string) string {
+	if len(kv) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(kv[i])
+		sb.WriteString(`="`)
+		sb.WriteString(prometheusLabelEscaper.Replace(kv[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
@@ -0,0 +1,98 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/panzarasa/rain/internal/btconn"
+	"github.com/panzarasa/rain/internal/proxy"
+)
+
+// newProxy returns the proxy dialer in config. Returns nil if proxy is not configured.
+// Features that cannot work through the proxy are disabled in config if proxy-only mode is set.
+func newProxy(cfg *Config) (*proxy.Dialer, error) {
+	if cfg.ProxyURL == "" {
+		if cfg.ProxyOnly {
+			return nil, errors.New("proxy-only mode is set but proxy url is empty")
+		}
+		return nil, nil
+	}
+	px, err := proxy.New(cfg.ProxyURL)
+	if err != nil {
+		return nil, errors.New("invalid proxy url: " + err.Error())
+	}
+	if cfg.ProxyOnly {
+		cfg.DHTEnabled = false
+		cfg.UTPEnabled = false
+		cfg.LSDEnabled = false
+	}
+	return px, nil
+}
+
+// proxyFor returns the proxy dialer if it must be used for the type of connection that is enabled in config.
+func proxyFor(px *proxy.Dialer, cfg *Config, enabled bool) *proxy.Dialer {
+	if px == nil || !(enabled || cfg.ProxyOnly) {
+		return nil
+	}
+	return px
+}
+
+// peerDialer returns the function for opening TCP connections to peers.
+func peerDialer(px *proxy.Dialer) btconn.DialFunc {
+	if px == nil {
+		return btconn.DialTCP
+	}
+	return func(ctx context.Context, addr net.Addr) (net.Conn, error) {
+		return px.DialContext(ctx, "tcp", addr.String())
+	}
+}
+
+// proxyPeers returns true if connections to peers are made through the proxy.
+func (s *Session) proxyPeers() bool {
+	return proxyFor(s.proxy, &s.config, s.config.ProxyPeers) != nil
+}
+
+// newProxyTransport returns the HTTP transport that makes connections through the proxy.
+// It is created once per Session so idle connections can be reused. Returns nil if proxy is not configured.
+func newProxyTransport(px *proxy.Dialer) *http.Transport {
+	if px == nil {
+		return nil
+	}
+	return &http.Transport{
+		DialContext:           px.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          10,
+	}
+}
+
+// newHTTPTransport returns a HTTP transport with connection timeouts. Connections are made through px if it is not nil.
+// There is no timeout for whole requests because they may transfer large amounts of data.
+func newHTTPTransport(px *proxy.Dialer) *http.Transport {
+	t := &http.Transport{
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   4,
+	}
+	if px != nil {
+		t.DialContext = px.DialContext
+	} else {
+		d := net.Dialer{Timeout: 30 * time.Second}
+		t.DialContext = d.DialContext
+	}
+	return t
+}
+
+// httpClient returns a HTTP client that makes connections through the proxy if useProxy is set in config.
+func (s *Session) httpClient(useProxy bool) http.Client {
+	var client http.Client
+	if proxyFor(s.proxy, &s.config, useProxy) != nil {
+		client.Transport = s.proxyTransport
+	}
+	return client
+}
@@ -0,0 +1,214 @@
+package torrent
+
+import (
+	"errors"
+	"sort"
+)
+
+// Torrents that are started by the user are put into a queue.
+// Queue manager runs the torrents in the queue in order of their queue positions,
+// without exceeding Config.QueueMaxActiveDownloads and Config.QueueMaxActiveSeeds.
+// Started flag in the resume database means that the torrent is queued.
+
+type queueStateRequest struct {
+	Response chan queueState
+}
+
+type queueState struct {
+	Status    Status
+	Completed bool
+}
+
+func (t *torrent) queueState() queueState {
+	var resp queueState
+	req := queueStateRequest{Response: make(chan queueState, 1)}
+	select {
+	case t.queueStateCommandC <- req:
+	case <-t.closeC:
+	}
+	select {
+	case resp = <-req.Response:
+	case <-t.closeC:
+	}
+	return resp
+}
+
+func (t *torrent) getQueueState() queueState {
+	return queueState{
+		Status:    t.status(),
+		Completed: t.completed || (t.bitfield != nil && t.haveAllWantedPieces()),
+	}
+}
+
+// isQueued returns true if the torrent is waiting in queue or started by the queue manager.
+func (t *torrent) isQueued() bool {
+	t.session.mQueue.Lock()
+	defer t.session.mQueue.Unlock()
+	return t.queued
+}
+
+func (t *torrent) getQueuePosition() int {
+	t.session.mQueue.Lock()
+	defer t.session.mQueue.Unlock()
+	return t.queuePosition
+}
+
+// setQueued adds or removes the torrent from the queue. It does not start or stop the torrent.
+func (s *Session) setQueued(t *torrent, value bool) {
+	s.mQueue.Lock()
+	t.queued = value
+	s.mQueue.Unlock()
+}
+
+// nextQueuePosition returns the queue position for a new torrent that puts it at the end of the queue.
+func (s *Session) nextQueuePosition() int {
+	s.mQueue.Lock()
+	defer s.mQueue.Unlock()
+	s.lastQueuePosition++
+	return s.lastQueuePosition
+}
+
+// setQueuePositionFromSpec must be called for existing torrents while loading them from the database.
+func (s *Session) setQueuePositionFromSpec(t *torrent, pos int) {
+	s.mQueue.Lock()
+	t.queuePosition = pos
+	if pos > s.lastQueuePosition {
+		s.lastQueuePosition = pos
+	}
+	s.mQueue.Unlock()
+}
+
+// sortedByQueuePosition returns the torrents in order of their queue positions.
+// Session.mQueue must be held while calling this function.
+func sortedByQueuePosition(torrents map[string]*Torrent) []*Torrent {
+	ret := make([]*Torrent, 0, len(torrents))
+	for _, t := range torrents {
+		ret = append(ret, t)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i].torrent, ret[j].torrent
+		if a.queuePosition != b.queuePosition {
+			return a.queuePosition < b.queuePosition
+		}
+		return a.addedAt.Before(b.addedAt)
+	})
+	return ret
+}
+
+// SetQueuePosition moves the torrent to position pos in the queue. Position 0 is the head of the queue.
+// Positions of other torrents are shifted.
+func (t *Torrent) SetQueuePosition(pos int) error {
+	s := t.torrent.session
+	s.mTorrents.RLock()
+	s.mQueue.Lock()
+	torrents := sortedByQueuePosition(s.torrents)
+	s.mQueue.Unlock()
+	s.mTorrents.RUnlock()
+
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(torrents)-1 {
+		pos = len(torrents) - 1
+	}
+	found := false
+	for i, t2 := range torrents {
+		if t2 == t {
+			torrents = append(torrents[:i], torrents[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errors.New("torrent is removed")
+	}
+	torrents = append(torrents[:pos], append([]*Torrent{t}, torrents[pos:]...)...)
+
+	positions := make(map[string]int)
+	s.mQueue.Lock()
+	for i, t2 := range torrents {
+		if t2.torrent.queuePosition != i {
+			t2.torrent.queuePosition = i
+			positions[t2.torrent.id] = i
+		}
+	}
+	s.lastQueuePosition = len(torrents) - 1
+	s.mQueue.Unlock()
+
+	for id, pos := range positions {
+		err := s.resumer.WriteQueuePosition(id, pos)
+		if err != nil {
+			return err
+		}
+	}
+	s.processQueue()
+	return nil
+}
+
+// triggerQueue makes queue manager process the queue in background. It does not block.
+func (s *Session) triggerQueue() {
+	select {
+	case s.queueC <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Session) queueManager() {
+	for {
+		select {
+		case <-s.queueC:
+			s.processQueue()
+		case <-s.closeC:
+			return
+		}
+	}
+}
+
+// processQueue starts and stops the queued torrents according to queue limits.
+// It must not be called from the torrent loop.
+func (s *Session) processQueue() {
+	s.mProcessQueue.Lock()
+	defer s.mProcessQueue.Unlock()
+
+	s.mTorrents.RLock()
+	s.mQueue.Lock()
+	torrents := sortedByQueuePosition(s.torrents)
+	queued := torrents[:0]
+	for _, t := range torrents {
+		if t.torrent.queued {
+			queued = append(queued, t)
+		}
+	}
+	s.mQueue.Unlock()
+	s.mTorrents.RUnlock()
+
+	var downloads, seeds int
+	for _, t := range queued {
+		state := t.torrent.queueState()
+		if state.Status == Stopping {
+			// Queue is processed again after the torrent is stopped.
+			continue
+		}
+		running := state.Status != Stopped
+		var allowed bool
+		if state.Completed {
+			allowed = s.config.QueueMaxActiveSeeds <= 0 || seeds < s.config.QueueMaxActiveSeeds
+			if allowed {
+				seeds++
+			}
+		} else {
+			allowed = s.config.QueueMaxActiveDownloads <= 0 || downloads < s.config.QueueMaxActiveDownloads
+			if allowed {
+				downloads++
+			}
+		}
+		switch {
+		case allowed && !running:
+			t.torrent.log.Debug("starting queued torrent")
+			t.torrent.Start()
+		case !allowed && running:
+			t.torrent.log.Debug("stopping torrent because of queue limits")
+			t.torrent.Stop()
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// rpcRole is the permission level of a RPC client.
+type rpcRole int
+
+const (
+	rpcRoleNone rpcRole = iota
+	rpcRoleReadOnly
+	rpcRoleAdmin
+)
+
+// readOnlyRPCMethods can be called by clients having read-only role.
+var readOnlyRPCMethods = map[string]struct{}{
+	"Session.Version":            {},
+	"Session.ListTorrents":       {},
+	"Session.GetMagnet":          {},
+	"Session.GetTorrent":         {},
+	"Session.GetSessionStats":    {},
+	"Session.GetEvents":          {},
+	"Session.GetTorrentStats":    {},
+	"Session.GetTorrentTrackers": {},
+	"Session.GetTorrentPeers":    {},
+	"Session.GetTorrentWebseeds": {},
+	"Session.GetTorrentFiles":    {},
+	"Session.DHTGet":             {},
+}
+
+// maxRPCRequestSize limits the size of JSON-RPC requests that are read for checking method names.
+const maxRPCRequestSize = 100 << 20
+
+type rpcAuth struct {
+	adminTokens    []string
+	readOnlyTokens []string
+	username       string
+	password       string
+}
+
+func (a *rpcAuth) enabled() bool {
+	return len(a.adminTokens) > 0 || len(a.readOnlyTokens) > 0 || a.username != ""
+}
+
+// role returns the role of the client that sent the request.
+func (a *rpcAuth) role(r *http.Request) rpcRole {
+	if !a.enabled() {
+		return rpcRoleAdmin
+	}
+	if user, pass, ok := r.BasicAuth(); ok {
+		if a.username != "" && secureEqual(user, a.username) && secureEqual(pass, a.password) {
+			return rpcRoleAdmin
+		}
+		return rpcRoleNone
+	}
+	h := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(h, prefix) {
+		return rpcRoleNone
+	}
+	token := h[len(prefix):]
+	if containsToken(a.adminTokens, token) {
+		return rpcRoleAdmin
+	}
+	if containsToken(a.readOnlyTokens, token) {
+		return rpcRoleReadOnly
+	}
+	return rpcRoleNone
+}
+
+// handler wraps h to allow only the clients that have at least the given role.
+func (a *rpcAuth) handler(role rpcRole, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := a.role(r)
+		if got == rpcRoleNone {
+			a.unauthorized(w)
+			return
+		}
+		if got < role {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// rpcHandler wraps the JSON-RPC handler h to allow read-only clients to call only read-only methods.
+func (a *rpcAuth) rpcHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch a.role(r) {
+		case rpcRoleNone:
+			a.unauthorized(w)
+			return
+		case rpcRoleReadOnly:
+			b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRPCRequestSize))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !isReadOnlyRPCRequest(b) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (a *rpcAuth) unauthorized(w http.ResponseWriter) {
+	if a.username != "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="rain"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// isReadOnlyRPCRequest returns true if all calls in the JSON-RPC request (single or batch) are read-only methods.
+func isReadOnlyRPCRequest(b []byte) bool {
+	type call struct {
+		Method string `json:"method"`
+	}
+	var calls []call
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		if err := json.Unmarshal(b, &calls); err != nil {
+			return false
+		}
+	} else {
+		var c call
+		if err := json.Unmarshal(b, &c); err != nil {
+			return false
+		}
+		calls = append(calls, c)
+	}
+	for _, c := range calls {
+		if _, ok := readOnlyRPCMethods[c.Method]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func containsToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if secureEqual(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
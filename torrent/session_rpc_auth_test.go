@@ -0,0 +1,87 @@
+package torrent
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReadOnlyRPCRequest(t *testing.T) {
+	assert.True(t, isReadOnlyRPCRequest([]byte(`{"jsonrpc":"2.0","method":"Session.ListTorrents","id":1}`)))
+	assert.False(t, isReadOnlyRPCRequest([]byte(`{"jsonrpc":"2.0","method":"Session.RemoveTorrent","id":1}`)))
+	assert.True(t, isReadOnlyRPCRequest([]byte(` [{"method":"Session.Version"},{"method":"Session.GetTorrentStats"}]`)))
+	assert.False(t, isReadOnlyRPCRequest([]byte(`[{"method":"Session.Version"},{"method":"Session.MoveTorrent"}]`)))
+	assert.False(t, isReadOnlyRPCRequest([]byte(`invalid`)))
+}
+
+func TestRPCAuth(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	_, closeSession := newTestSessionWithConfig(t, func(cfg *Config) {
+		cfg.RPCEnabled = true
+		cfg.RPCPort = port
+		cfg.RPCAdminTokens = []string{"admin-token"}
+		cfg.RPCReadOnlyTokens = []string{"read-token"}
+		cfg.RPCUsername = "user"
+		cfg.RPCPassword = "pass"
+	})
+	defer closeSession()
+
+	url := "http://127.0.0.1:" + strconv.Itoa(port)
+	call := func(method string, setAuth func(*http.Request)) int {
+		body := `{"jsonrpc":"2.0","method":"` + method + `","params":[{}],"id":1}`
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		setAuth(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(user, pass string) func(*http.Request) {
+		return func(req *http.Request) { req.SetBasicAuth(user, pass) }
+	}
+	allowed := func(code int) bool { return code != http.StatusUnauthorized && code != http.StatusForbidden }
+
+	assert.Equal(t, http.StatusUnauthorized, call("Session.ListTorrents", func(*http.Request) {}))
+	assert.Equal(t, http.StatusUnauthorized, call("Session.ListTorrents", bearer("invalid")))
+
+	assert.True(t, allowed(call("Session.ListTorrents", bearer("read-token"))))
+	assert.Equal(t, http.StatusForbidden, call("Session.RemoveTorrent", bearer("read-token")))
+	assert.Equal(t, http.StatusForbidden, call("Session.MoveTorrent", bearer("read-token")))
+
+	assert.True(t, allowed(call("Session.ListTorrents", bearer("admin-token"))))
+	assert.True(t, allowed(call("Session.RemoveTorrent", bearer("admin-token"))))
+
+	assert.True(t, allowed(call("Session.RemoveTorrent", basic("user", "pass"))))
+	assert.Equal(t, http.StatusUnauthorized, call("Session.ListTorrents", basic("user", "wrong")))
+
+	req, err := http.NewRequest(http.MethodPost, url+"/move-torrent/begin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer("read-token")(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
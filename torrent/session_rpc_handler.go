@@ -1,20 +1,12 @@
 package torrent
 
 import (
-	"archive/tar"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/panzarasa/rain/internal/resumer/boltdbresumer"
 	"github.com/panzarasa/rain/internal/rpctypes"
 	"github.com/powerman/rpc-codec/jsonrpc2"
 )
@@ -41,9 +33,9 @@ func (h *rpcHandler) ListTorrents(args *rpctypes.ListTorrentsRequest, reply *rpc
 
 func (h *rpcHandler) AddTorrent(args *rpctypes.AddTorrentRequest, reply *rpctypes.AddTorrentResponse) error {
 	r := base64.NewDecoder(base64.StdEncoding, strings.NewReader(args.Torrent))
-	opt := &AddTorrentOptions{
-		Stopped: args.AddTorrentOptions.Stopped,
-		ID:      args.AddTorrentOptions.ID,
+	opt, err := newAddTorrentOptions(args.AddTorrentOptions)
+	if err != nil {
+		return jsonrpc2.NewError(2, err.Error())
 	}
 	t, err := h.session.AddTorrent(r, opt)
 	var e *InputError
@@ -58,9 +50,9 @@ func (h *rpcHandler) AddTorrent(args *rpctypes.AddTorrentRequest, reply *rpctype
 }
 
 func (h *rpcHandler) AddURI(args *rpctypes.AddURIRequest, reply *rpctypes.AddURIResponse) error {
-	opt := &AddTorrentOptions{
-		Stopped: args.AddTorrentOptions.Stopped,
-		ID:      args.AddTorrentOptions.ID,
+	opt, err := newAddTorrentOptions(args.AddTorrentOptions)
+	if err != nil {
+		return jsonrpc2.NewError(2, err.Error())
 	}
 	t, err := h.session.AddURI(args.URI, opt)
 	var e *InputError
@@ -74,6 +66,28 @@ func (h *rpcHandler) AddURI(args *rpctypes.AddURIRequest, reply *rpctypes.AddURI
 	return nil
 }
 
+func newAddTorrentOptions(o rpctypes.AddTorrentOptions) (*AddTorrentOptions, error) {
+	opt := &AddTorrentOptions{
+		Stopped:        o.Stopped,
+		ID:             o.ID,
+		Sequential:     o.Sequential,
+		SeedRatioLimit: o.SeedRatioLimit,
+		SeedTimeLimit:  time.Duration(o.SeedTimeLimit) * time.Second,
+		DataDir:        o.DataDir,
+		Storage:        o.Storage,
+	}
+	var err error
+	opt.SeedRatioAction, err = ParseSeedAction(o.SeedRatioAction)
+	if err != nil {
+		return nil, err
+	}
+	opt.SeedTimeAction, err = ParseSeedAction(o.SeedTimeAction)
+	if err != nil {
+		return nil, err
+	}
+	return opt, nil
+}
+
 func newTorrent(t *Torrent) rpctypes.Torrent {
 	return rpctypes.Torrent{
 		ID:       t.ID(),
@@ -146,6 +160,40 @@ func (h *rpcHandler) GetSessionStats(args *rpctypes.GetSessionStatsRequest, repl
 		SpeedUpload:   s.SpeedUpload,
 		SpeedRead:     s.SpeedRead,
 		SpeedWrite:    s.SpeedWrite,
+
+		SpeedLimitDownload: s.SpeedLimitDownload,
+		SpeedLimitUpload:   s.SpeedLimitUpload,
+	}
+	return nil
+}
+
+func (h *rpcHandler) SetSpeedLimits(args *rpctypes.SetSpeedLimitsRequest, reply *rpctypes.SetSpeedLimitsResponse) error {
+	return h.session.SetSpeedLimits(args.Download, args.Upload)
+}
+
+// maxEventsTimeout limits the time that a GetEvents call can hold the connection.
+const maxEventsTimeout = time.Minute
+
+func (h *rpcHandler) GetEvents(args *rpctypes.GetEventsRequest, reply *rpctypes.GetEventsResponse) error {
+	timeout := time.Duration(args.Timeout) * time.Second
+	if timeout < 0 {
+		timeout = 0
+	}
+	if timeout > maxEventsTimeout {
+		timeout = maxEventsTimeout
+	}
+	events := h.session.Events(args.After, timeout)
+	reply.Events = make([]rpctypes.Event, len(events))
+	for i, e := range events {
+		reply.Events[i] = rpctypes.Event{
+			ID:        e.ID,
+			Time:      rpctypes.Time{Time: e.Time},
+			Type:      e.Type.String(),
+			TorrentID: e.TorrentID,
+			Error:     e.Error,
+			Tracker:   e.Tracker,
+			Piece:     e.Piece,
+		}
 	}
 	return nil
 }
@@ -213,11 +261,13 @@ func (h *rpcHandler) GetTorrentStats(args *rpctypes.GetTorrentStatsRequest, repl
 			Tracker int
 			DHT     int
 			PEX     int
+			LSD     int
 		}{
 			Total:   s.Addresses.Total,
 			Tracker: s.Addresses.Tracker,
 			DHT:     s.Addresses.DHT,
 			PEX:     s.Addresses.PEX,
+			LSD:     s.Addresses.LSD,
 		},
 		Downloads: struct {
 			Total   int
@@ -250,6 +300,15 @@ func (h *rpcHandler) GetTorrentStats(args *rpctypes.GetTorrentStatsRequest, repl
 			Download: s.Speed.Download,
 			Upload:   s.Speed.Upload,
 		},
+		SpeedLimit: struct {
+			Download int64
+			Upload   int64
+		}{
+			Download: s.SpeedLimit.Download,
+			Upload:   s.SpeedLimit.Upload,
+		},
+		QueuePosition: s.QueuePosition,
+		DataDir:       s.DataDir,
 	}
 	if s.Error != nil {
 		reply.Stats.Error = s.Error.Error()
@@ -271,11 +330,12 @@ func (h *rpcHandler) GetTorrentTrackers(args *rpctypes.GetTorrentTrackersRequest
 	reply.Trackers = make([]rpctypes.Tracker, len(trackers))
 	for i, t := range trackers {
 		reply.Trackers[i] = rpctypes.Tracker{
-			URL:      t.URL,
-			Status:   trackerStatusToString(t.Status),
-			Leechers: t.Leechers,
-			Seeders:  t.Seeders,
-			Warning:  t.Warning,
+			URL:       t.URL,
+			Status:    trackerStatusToString(t.Status),
+			Leechers:  t.Leechers,
+			Seeders:   t.Seeders,
+			Completed: t.Completed,
+			Warning:   t.Warning,
 		}
 		if t.Error != nil {
 			reply.Trackers[i].Error = t.Error.Error()
@@ -288,6 +348,9 @@ func (h *rpcHandler) GetTorrentTrackers(args *rpctypes.GetTorrentTrackersRequest
 		if !t.NextAnnounce.IsZero() {
 			reply.Trackers[i].NextAnnounce = rpctypes.Time{Time: t.NextAnnounce}
 		}
+		if !t.LastScrape.IsZero() {
+			reply.Trackers[i].LastScrape = rpctypes.Time{Time: t.LastScrape}
+		}
 	}
 	return nil
 }
@@ -312,14 +375,26 @@ func (h *rpcHandler) GetTorrentPeers(args *rpctypes.GetTorrentPeersRequest, repl
 			source = "INCOMING"
 		case SourceManual:
 			source = "MANUAL"
+		case SourceLSD:
+			source = "LSD"
 		default:
 			panic("unhandled peer source")
 		}
+		var transport string
+		switch p.Transport {
+		case TransportTCP:
+			transport = "TCP"
+		case TransportUTP:
+			transport = "UTP"
+		default:
+			panic("unhandled peer transport")
+		}
 		reply.Peers[i] = rpctypes.Peer{
 			ID:                 hex.EncodeToString(p.ID[:]),
 			Client:             p.Client,
 			Addr:               p.Addr.String(),
 			Source:             source,
+			Transport:          transport,
 			ConnectedAt:        rpctypes.Time{Time: p.ConnectedAt},
 			Downloading:        p.Downloading,
 			ClientInterested:   p.ClientInterested,
@@ -356,6 +431,78 @@ func (h *rpcHandler) GetTorrentWebseeds(args *rpctypes.GetTorrentWebseedsRequest
 	return nil
 }
 
+func (h *rpcHandler) GetTorrentFiles(args *rpctypes.GetTorrentFilesRequest, reply *rpctypes.GetTorrentFilesResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	files, err := t.Files()
+	if err != nil {
+		return jsonrpc2.NewError(2, err.Error())
+	}
+	reply.Files = make([]rpctypes.File, len(files))
+	for i, f := range files {
+		reply.Files[i] = rpctypes.File{
+			Path:     f.Path,
+			Length:   f.Length,
+			Priority: f.Priority.String(),
+		}
+	}
+	return nil
+}
+
+func (h *rpcHandler) SetFilePriorities(args *rpctypes.SetFilePrioritiesRequest, reply *rpctypes.SetFilePrioritiesResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	priorities := make([]FilePriority, len(args.Priorities))
+	for i, s := range args.Priorities {
+		p, err := ParseFilePriority(s)
+		if err != nil {
+			return jsonrpc2.NewError(2, err.Error())
+		}
+		priorities[i] = p
+	}
+	err := t.SetFilePriorities(priorities)
+	if err != nil {
+		return jsonrpc2.NewError(2, err.Error())
+	}
+	return nil
+}
+
+func (h *rpcHandler) SetSequential(args *rpctypes.SetSequentialRequest, reply *rpctypes.SetSequentialResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	return t.SetSequential(args.Sequential)
+}
+
+func (h *rpcHandler) SetTorrentSpeedLimit(args *rpctypes.SetTorrentSpeedLimitRequest, reply *rpctypes.SetTorrentSpeedLimitResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	return t.SetSpeedLimit(args.Download, args.Upload)
+}
+
+func (h *rpcHandler) SetTorrentQueuePosition(args *rpctypes.SetTorrentQueuePositionRequest, reply *rpctypes.SetTorrentQueuePositionResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	return t.SetQueuePosition(args.Position)
+}
+
+func (h *rpcHandler) RelocateTorrent(args *rpctypes.RelocateTorrentRequest, reply *rpctypes.RelocateTorrentResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	return t.Relocate(args.DataDir)
+}
+
 func (h *rpcHandler) StartTorrent(args *rpctypes.StartTorrentRequest, reply *rpctypes.StartTorrentResponse) error {
 	t := h.session.GetTorrent(args.ID)
 	if t == nil {
@@ -418,161 +565,44 @@ func (h *rpcHandler) MoveTorrent(args *rpctypes.MoveTorrentRequest, reply *rpcty
 	if t == nil {
 		return errTorrentNotFound
 	}
-	return t.Move(args.Target)
+	return t.Move(args.Target, args.Token)
 }
 
-func (h *rpcHandler) handleMoveTorrent(w http.ResponseWriter, r *http.Request) {
-	port, err := h.session.getPort()
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	var success bool
-	defer func() {
-		if !success {
-			h.session.releasePort(port)
-		}
-	}()
-
-	mr, err := r.MultipartReader()
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	// case "id":
-	p, err := mr.NextPart()
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if p.FormName() != "id" {
-		http.Error(w, "id expected in multipart form", http.StatusBadRequest)
-		return
-	}
-	b, err := ioutil.ReadAll(p)
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	id := string(b)
-	if id == "" {
-		http.Error(w, "id required", http.StatusBadRequest)
-		return
-	}
-	if _, ok := h.session.torrents[id]; ok {
-		h.session.log.Warningln("duplicate torrent id, removing existing one:", id)
-		t, err := h.session.removeTorrentFromClient(id)
-		if err != nil {
-			h.session.log.Error(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		err = h.session.stopAndRemoveData(t)
-		if err != nil {
-			h.session.log.Error(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-	// case "metadata":
-	p, err = mr.NextPart()
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if p.FormName() != "metadata" {
-		http.Error(w, "metadata expected in multipart form", http.StatusBadRequest)
-		return
-	}
-	var s boltdbresumer.Spec
-	err = json.NewDecoder(p).Decode(&s)
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	s.Port = port
-	spec := &s
-	// case "data":
-	p, err = mr.NextPart()
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if p.FormName() != "data" {
-		http.Error(w, "data expected in multipart form", http.StatusBadRequest)
-		return
+func (h *rpcHandler) DHTPut(args *rpctypes.DHTPutRequest, reply *rpctypes.DHTPutResponse) error {
+	item := DHTItem{
+		Value:     args.Item.Value,
+		Salt:      args.Item.Salt,
+		Seq:       args.Item.Seq,
+		Signature: args.Item.Signature,
 	}
-	var dest string
-	if h.session.config.DataDirIncludesTorrentID {
-		dest = filepath.Join(h.session.config.DataDir, id)
-	} else {
-		dest = h.session.config.DataDir
+	if len(args.Item.PublicKey) > 0 {
+		item.PublicKey = args.Item.PublicKey
 	}
-	err = readData(p, dest)
+	target, err := h.session.DHTPut(item)
 	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
-	err = h.session.resumer.Write(id, spec)
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	reply.Target = hex.EncodeToString(target[:])
+	return nil
+}
+
+func (h *rpcHandler) DHTGet(args *rpctypes.DHTGetRequest, reply *rpctypes.DHTGetResponse) error {
+	var target [20]byte
+	b, err := hex.DecodeString(args.Target)
+	if err != nil || len(b) != len(target) {
+		return jsonrpc2.NewError(2, "invalid target")
 	}
-	t, started, err := h.session.loadExistingTorrent(id)
+	copy(target[:], b)
+	item, err := h.session.DHTGet(target, args.Salt)
 	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if started {
-		err = t.Start()
-		if err != nil {
-			h.session.log.Error(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		return err
 	}
-	success = true
-}
-
-func readData(r io.Reader, dir string) error {
-	tr := tar.NewReader(r)
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		name := filepath.Join(dir, hdr.Name)
-		err = os.MkdirAll(filepath.Dir(name), os.ModeDir|0750)
-		if err != nil {
-			return err
-		}
-		f, err := os.Create(name)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(f, tr)
-		if err != nil {
-			return err
-		}
-		err = f.Sync()
-		if err != nil {
-			return err
-		}
-		_ = f.Close()
+	reply.Item = rpctypes.DHTItem{
+		Value:     item.Value,
+		PublicKey: item.PublicKey,
+		Salt:      item.Salt,
+		Seq:       item.Seq,
+		Signature: item.Signature,
 	}
 	return nil
 }
@@ -2,6 +2,7 @@ package torrent
 
 import (
 	"context"
+	"crypto/tls"
 	"expvar"
 	"net"
 	"net/http"
@@ -16,6 +17,8 @@ import (
 type rpcServer struct {
 	rpcServer  *rpc.Server
 	httpServer http.Server
+	certFile   string
+	keyFile    string
 	log        logger.Logger
 }
 
@@ -24,28 +27,53 @@ func newRPCServer(ses *Session) *rpcServer {
 	srv := rpc.NewServer()
 	_ = srv.RegisterName("Session", h)
 
+	auth := &rpcAuth{
+		adminTokens:    ses.config.RPCAdminTokens,
+		readOnlyTokens: ses.config.RPCReadOnlyTokens,
+		username:       ses.config.RPCUsername,
+		password:       ses.config.RPCPassword,
+	}
+
 	mux := http.NewServeMux()
-	mux.Handle("/debug/vars", expvar.Handler())
-	mux.HandleFunc("/move-torrent", h.handleMoveTorrent)
-	mux.Handle("/", jsonrpc2.HTTPHandler(srv))
+	mux.Handle("/debug/vars", auth.handler(rpcRoleReadOnly, expvar.Handler()))
+	mux.Handle("/metrics", auth.handler(rpcRoleReadOnly, http.HandlerFunc(h.handleMetrics)))
+	mux.Handle("/move-torrent/begin", auth.handler(rpcRoleAdmin, http.HandlerFunc(h.handleMoveBegin)))
+	mux.Handle("/move-torrent/pieces", auth.handler(rpcRoleAdmin, http.HandlerFunc(h.handleMovePieces)))
+	mux.Handle("/move-torrent/commit", auth.handler(rpcRoleAdmin, http.HandlerFunc(h.handleMoveCommit)))
+	mux.Handle("/", auth.rpcHandler(jsonrpc2.HTTPHandler(srv)))
 
 	return &rpcServer{
 		rpcServer: srv,
 		httpServer: http.Server{
 			Handler: mux,
 		},
-		log: logger.New("rpc server"),
+		certFile: ses.config.RPCTLSCertFile,
+		keyFile:  ses.config.RPCTLSKeyFile,
+		log:      logger.New("rpc server"),
 	}
 }
 
 func (s *rpcServer) Start(host string, port int) error {
+	var tlsConfig *tls.Config
+	if s.certFile != "" || s.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
 	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
-
-	s.log.Infoln("RPC server is listening on", listener.Addr().String())
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		s.log.Infoln("RPC server is listening on", listener.Addr().String(), "with TLS")
+	} else {
+		s.log.Infoln("RPC server is listening on", listener.Addr().String())
+	}
 
 	go func() {
 		err := s.httpServer.Serve(listener)
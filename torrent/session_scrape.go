@@ -0,0 +1,118 @@
+package torrent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/panzarasa/rain/internal/tracker"
+)
+
+// scrapeKey identifies the scrape result of a torrent from a tracker.
+type scrapeKey struct {
+	URL      string
+	InfoHash [20]byte
+}
+
+type scrapeResult struct {
+	tracker.ScrapeResult
+	Time time.Time
+}
+
+// scrapeGroup contains the info hashes to be scraped from a single tracker in a batch.
+type scrapeGroup struct {
+	tracker    tracker.Tracker
+	infoHashes [][20]byte
+	seen       map[[20]byte]struct{}
+}
+
+func (s *Session) scraper() {
+	ticker := time.NewTicker(s.config.TrackerScrapeInterval)
+	defer ticker.Stop()
+	for {
+		s.scrapeStoppedTorrents()
+		select {
+		case <-ticker.C:
+		case <-s.closeC:
+			return
+		}
+	}
+}
+
+// scrapeStoppedTorrents scrapes the trackers of stopped torrents so their swarm statistics can be shown without announcing.
+// Running torrents get the same statistics from announce responses.
+func (s *Session) scrapeStoppedTorrents() {
+	groups := make(map[string]*scrapeGroup)
+	known := make(map[[20]byte]struct{})
+	for _, t := range s.ListTorrents() {
+		known[t.torrent.infoHash] = struct{}{}
+		stats := t.Stats()
+		if stats.Status != Stopped {
+			continue
+		}
+		for _, tr := range t.Trackers() {
+			g, ok := groups[tr.URL]
+			if !ok {
+				trk, err := s.trackerManager.Get(tr.URL, s.config.TrackerHTTPTimeout, s.getTrackerUserAgent(stats.Private), int64(s.config.TrackerHTTPMaxResponseSize))
+				if err != nil {
+					continue
+				}
+				g = &scrapeGroup{tracker: trk, seen: make(map[[20]byte]struct{})}
+				groups[tr.URL] = g
+			}
+			if _, ok := g.seen[t.torrent.infoHash]; ok {
+				continue
+			}
+			g.seen[t.torrent.infoHash] = struct{}{}
+			g.infoHashes = append(g.infoHashes, t.torrent.infoHash)
+		}
+	}
+
+	// Forget results of removed torrents.
+	s.mScrapes.Lock()
+	for key := range s.scrapes {
+		if _, ok := known[key.InfoHash]; !ok {
+			delete(s.scrapes, key)
+		}
+	}
+	s.mScrapes.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.TrackerScrapeTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.closeC:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	var wg sync.WaitGroup
+	for url, g := range groups {
+		wg.Add(1)
+		go func(url string, g *scrapeGroup) {
+			defer wg.Done()
+			results, err := g.tracker.Scrape(ctx, g.infoHashes)
+			if err == tracker.ErrScrapeNotSupported {
+				return
+			}
+			if err != nil {
+				s.log.Debugf("cannot scrape tracker %s: %s", url, err)
+				return
+			}
+			now := time.Now()
+			s.mScrapes.Lock()
+			for ih, res := range results {
+				s.scrapes[scrapeKey{URL: url, InfoHash: ih}] = scrapeResult{ScrapeResult: res, Time: now}
+			}
+			s.mScrapes.Unlock()
+		}(url, g)
+	}
+	wg.Wait()
+}
+
+func (s *Session) getScrapeResult(url string, infoHash [20]byte) (scrapeResult, bool) {
+	s.mScrapes.RLock()
+	defer s.mScrapes.RUnlock()
+	res, ok := s.scrapes[scrapeKey{URL: url, InfoHash: infoHash}]
+	return res, ok
+}
@@ -0,0 +1,49 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+func TestScrapeStoppedTorrents(t *testing.T) {
+	const infoHash = "0123456789abcdef0123456789abcdef01234567"
+	ih, _ := hex.DecodeString(infoHash)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" || r.URL.Query().Get("info_hash") != string(ih) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		type file struct {
+			Complete   int32 `bencode:"complete"`
+			Incomplete int32 `bencode:"incomplete"`
+			Downloaded int32 `bencode:"downloaded"`
+		}
+		_ = bencode.NewEncoder(w).Encode(map[string]interface{}{
+			"files": map[string]file{string(ih): {Complete: 3, Incomplete: 2, Downloaded: 10}},
+		})
+	}))
+	defer srv.Close()
+
+	s, closeSession := newTestSessionWithConfig(t, func(cfg *Config) {
+		cfg.TrackerScrapeInterval = 0
+	})
+	defer closeSession()
+
+	tor, err := s.AddURI("magnet:?xt=urn:btih:"+infoHash+"&tr="+srv.URL+"/announce", &AddTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.scrapeStoppedTorrents()
+	trackers := tor.Trackers()
+	if len(trackers) != 1 {
+		t.Fatalf("unexpected number of trackers: %d", len(trackers))
+	}
+	tr := trackers[0]
+	if tr.Seeders != 3 || tr.Leechers != 2 || tr.Completed != 10 || tr.LastScrape.IsZero() {
+		t.Fatalf("unexpected tracker: %+v", tr)
+	}
+}
@@ -0,0 +1,171 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SpeedLimitRule overrides global speed limits of the Session during certain times of day.
+type SpeedLimitRule struct {
+	// Days of week that the rule is active, e.g. ["mon", "tue"]. Empty means every day.
+	Days []string
+	// Begin and End are times of day in "15:04" format in local time zone.
+	// If End is before Begin, the rule is active until End on the next day.
+	Begin string
+	End   string
+	// Download speed limit in KiB/s while the rule is active. Zero value does not change the global limit.
+	Download int64
+	// Upload speed limit in KiB/s while the rule is active. Zero value does not change the global limit.
+	Upload int64
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// speedLimitRule is the parsed form of SpeedLimitRule.
+type speedLimitRule struct {
+	days       map[time.Weekday]struct{}
+	begin, end time.Duration // since midnight
+	download   int64
+	upload     int64
+}
+
+func parseSpeedLimitSchedule(rules []SpeedLimitRule) ([]speedLimitRule, error) {
+	ret := make([]speedLimitRule, 0, len(rules))
+	for i, r := range rules {
+		pr, err := parseSpeedLimitRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid speed limit rule #%d: %s", i, err)
+		}
+		ret = append(ret, pr)
+	}
+	return ret, nil
+}
+
+func parseSpeedLimitRule(r SpeedLimitRule) (speedLimitRule, error) {
+	var pr speedLimitRule
+	var err error
+	pr.begin, err = parseTimeOfDay(r.Begin)
+	if err != nil {
+		return pr, err
+	}
+	pr.end, err = parseTimeOfDay(r.End)
+	if err != nil {
+		return pr, err
+	}
+	if pr.begin == pr.end {
+		return pr, errors.New("begin and end times are equal")
+	}
+	if r.Download < 0 || r.Upload < 0 {
+		return pr, errors.New("speed limit cannot be negative")
+	}
+	if len(r.Days) > 0 {
+		pr.days = make(map[time.Weekday]struct{}, len(r.Days))
+		for _, s := range r.Days {
+			d, ok := weekdays[strings.ToLower(s)]
+			if !ok {
+				return pr, fmt.Errorf("invalid day: %q", s)
+			}
+			pr.days[d] = struct{}{}
+		}
+	}
+	pr.download = r.Download
+	pr.upload = r.Upload
+	return pr, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// active returns true if the rule applies at time t.
+func (r *speedLimitRule) active(t time.Time) bool {
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	day := t.Weekday()
+	if r.begin < r.end {
+		return r.hasDay(day) && sinceMidnight >= r.begin && sinceMidnight < r.end
+	}
+	// Rule spans midnight. Part after midnight belongs to the rule that began on previous day.
+	if sinceMidnight >= r.begin {
+		return r.hasDay(day)
+	}
+	if sinceMidnight < r.end {
+		return r.hasDay((day + 6) % 7)
+	}
+	return false
+}
+
+func (r *speedLimitRule) hasDay(d time.Weekday) bool {
+	if r.days == nil {
+		return true
+	}
+	_, ok := r.days[d]
+	return ok
+}
+
+// SetSpeedLimits changes the global download and upload speed limits of the Session in KiB/s.
+// Zero value means unlimited. A rule in Config.SpeedLimitSchedule overrides these values while it is active.
+// Changes are not saved. Values in Config are used again when a new Session is created.
+func (s *Session) SetSpeedLimits(download, upload int64) error {
+	if download < 0 || upload < 0 {
+		return errors.New("speed limit cannot be negative")
+	}
+	s.mSpeedLimits.Lock()
+	s.speedLimitDownload = download * 1024
+	s.speedLimitUpload = upload * 1024
+	s.mSpeedLimits.Unlock()
+	s.applySpeedLimits(time.Now())
+	return nil
+}
+
+// applySpeedLimits sets the rates of session limiters from global limits and the active rule in schedule at time now.
+func (s *Session) applySpeedLimits(now time.Time) {
+	s.mSpeedLimits.Lock()
+	defer s.mSpeedLimits.Unlock()
+	download, upload := s.speedLimitDownload, s.speedLimitUpload
+	for _, r := range s.speedLimitSchedule {
+		if !r.active(now) {
+			continue
+		}
+		if r.download > 0 {
+			download = r.download * 1024
+		}
+		if r.upload > 0 {
+			upload = r.upload * 1024
+		}
+		break
+	}
+	// SetRate creates a new full bucket. Setting the same rate on every tick would allow a burst and drop the debt.
+	if download != s.limiterDownload.Rate() {
+		s.limiterDownload.SetRate(download)
+	}
+	if upload != s.limiterUpload.Rate() {
+		s.limiterUpload.SetRate(upload)
+	}
+}
+
+func (s *Session) speedLimitScheduler() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.applySpeedLimits(now)
+		case <-s.closeC:
+			return
+		}
+	}
+}
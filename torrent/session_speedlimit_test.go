@@ -0,0 +1,88 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpeedLimitRuleActive(t *testing.T) {
+	r, err := parseSpeedLimitRule(SpeedLimitRule{Days: []string{"mon", "Fri"}, Begin: "22:00", End: "06:30", Upload: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		time   string
+		active bool
+	}{
+		{"2019-07-01 21:59", false}, // Monday
+		{"2019-07-01 22:00", true},
+		{"2019-07-02 06:29", true}, // Tuesday morning
+		{"2019-07-02 06:30", false},
+		{"2019-07-02 23:00", false},
+		{"2019-07-06 01:00", true}, // Saturday morning
+		{"2019-07-07 01:00", false},
+	}
+	for _, c := range cases {
+		now, err := time.ParseInLocation("2006-01-02 15:04", c.time, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.active(now) != c.active {
+			t.Errorf("active(%s) must be %v", c.time, c.active)
+		}
+	}
+}
+
+func TestParseSpeedLimitSchedule(t *testing.T) {
+	invalid := []SpeedLimitRule{
+		{Begin: "9:00", End: "9:00"},
+		{Begin: "25:00", End: "09:00"},
+		{Begin: "09:00", End: "17:00", Days: []string{"monday"}},
+		{Begin: "09:00", End: "17:00", Download: -1},
+	}
+	for _, r := range invalid {
+		if _, err := parseSpeedLimitSchedule([]SpeedLimitRule{r}); err == nil {
+			t.Errorf("rule must be invalid: %+v", r)
+		}
+	}
+}
+
+func TestApplySpeedLimitsKeepsBucket(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	err := s.SetSpeedLimits(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.limiterDownload.Take(1024)
+	s.limiterDownload.Take(1024)
+	// Applying the same limits again must not reset the bucket.
+	s.applySpeedLimits(time.Now())
+	if d := s.limiterDownload.Take(1024); d < time.Second {
+		t.Fatalf("unexpected wait: %s", d)
+	}
+}
+
+func TestConfigSpeedLimitsInBytes(t *testing.T) {
+	s, closeSession := newTestSessionWithConfig(t, func(cfg *Config) {
+		cfg.SpeedLimitDownload = 1000
+		cfg.SpeedLimitUpload = 2048
+	})
+	defer closeSession()
+
+	if r := s.limiterDownload.Rate(); r != 1000 {
+		t.Fatalf("unexpected download rate: %d", r)
+	}
+	if r := s.limiterUpload.Rate(); r != 2048 {
+		t.Fatalf("unexpected upload rate: %d", r)
+	}
+	// Runtime limits are in KiB/s.
+	err := s.SetSpeedLimits(3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := s.limiterDownload.Rate(); r != 3*1024 {
+		t.Fatalf("unexpected download rate: %d", r)
+	}
+}
@@ -1,11 +1,9 @@
 package torrent
 
 import (
-	"strconv"
 	"time"
 
-	"github.com/panzarasa/rain/internal/resumer/boltdbresumer"
-	"go.etcd.io/bbolt"
+	"github.com/panzarasa/rain/internal/resumer"
 )
 
 // SessionStats contains statistics about Session.
@@ -64,6 +62,11 @@ type SessionStats struct {
 	SpeedRead int
 	// Write speed to disk in bytes/s.
 	SpeedWrite int
+
+	// Current global download speed limit in KiB/s. Zero means unlimited.
+	SpeedLimitDownload int64
+	// Current global upload speed limit in KiB/s. Zero means unlimited.
+	SpeedLimitUpload int64
 }
 
 // Stats returns current statistics about the Session.
@@ -97,6 +100,10 @@ func (s *Session) Stats() SessionStats {
 		SpeedUpload:   int(s.metrics.SpeedUpload.Rate1()),
 		SpeedRead:     int(s.metrics.SpeedRead.Rate1()),
 		SpeedWrite:    int(s.metrics.SpeedWrite.Rate1()),
+
+		// Rounded up, so limits below 1 KiB/s are not shown as unlimited.
+		SpeedLimitDownload: (s.limiterDownload.Rate() + 1023) / 1024,
+		SpeedLimitUpload:   (s.limiterUpload.Rate() + 1023) / 1024,
 	}
 }
 
@@ -115,26 +122,25 @@ func (s *Session) updateStatsLoop() {
 
 func (s *Session) updateStats() {
 	s.mTorrents.RLock()
-	defer s.mTorrents.RUnlock()
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		mb := tx.Bucket(torrentsBucket)
-		for _, t := range s.torrents {
-			b := mb.Bucket([]byte(t.torrent.id))
-			_ = b.Put(boltdbresumer.Keys.BytesDownloaded, []byte(strconv.FormatInt(t.torrent.bytesDownloaded.Count(), 10)))
-			_ = b.Put(boltdbresumer.Keys.BytesUploaded, []byte(strconv.FormatInt(t.torrent.bytesUploaded.Count(), 10)))
-			_ = b.Put(boltdbresumer.Keys.BytesWasted, []byte(strconv.FormatInt(t.torrent.bytesWasted.Count(), 10)))
-			_ = b.Put(boltdbresumer.Keys.SeededFor, []byte(time.Duration(t.torrent.seededFor.Count()).String()))
-
-			t.torrent.mBitfield.RLock()
-			if t.torrent.bitfield != nil {
-				_ = b.Put(boltdbresumer.Keys.Bitfield, t.torrent.bitfield.Bytes())
-			}
-		}
-		return nil
-	})
+	stats := make(map[string]resumer.StatsUpdate, len(s.torrents))
 	for _, t := range s.torrents {
+		u := resumer.StatsUpdate{
+			Stats: resumer.Stats{
+				BytesDownloaded: t.torrent.bytesDownloaded.Count(),
+				BytesUploaded:   t.torrent.bytesUploaded.Count(),
+				BytesWasted:     t.torrent.bytesWasted.Count(),
+				SeededFor:       t.torrent.seededFor.Count(),
+			},
+		}
+		t.torrent.mBitfield.RLock()
+		if t.torrent.bitfield != nil {
+			u.Bitfield = append([]byte(nil), t.torrent.bitfield.Bytes()...)
+		}
 		t.torrent.mBitfield.RUnlock()
+		stats[t.torrent.id] = u
 	}
+	s.mTorrents.RUnlock()
+	err := s.resumer.WriteStats(stats)
 	if err != nil {
 		s.log.Errorln("cannot update stats:", err.Error())
 	}
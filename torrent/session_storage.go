@@ -0,0 +1,88 @@
+package torrent
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/panzarasa/rain/internal/logger"
+
+	"github.com/panzarasa/rain/storage"
+	"github.com/panzarasa/rain/storage/blobstorage"
+	"github.com/panzarasa/rain/storage/filestorage"
+	"github.com/panzarasa/rain/storage/memstorage"
+	"github.com/panzarasa/rain/storage/s3storage"
+)
+
+// StorageProvider creates the Storage for a torrent.
+// id is the ID of the torrent and dir is the data directory of the torrent.
+type StorageProvider func(id, dir string) (storage.Storage, error)
+
+// Names of the built-in storage backends.
+const (
+	// StorageFile saves each file of the torrent as a regular file under the data directory.
+	StorageFile = "file"
+	// StorageMemory keeps files in memory. Contents are lost when the Session is closed.
+	StorageMemory = "memory"
+	// StorageBlob packs all files of the torrent into a single sparse file named "<id>.blob" in the data directory.
+	StorageBlob = "blob"
+	// StorageS3 keeps files as objects in the bucket at Config.S3Endpoint.
+	// Existing objects are read with ranged requests. Written files are staged in the data directory and uploaded in background when the torrent is stopped.
+	StorageS3 = "s3"
+)
+
+// storageProvider returns the provider of the storage backend with name.
+// Empty name means Config.Storage.
+// "s3" storage makes requests with s3Client and logs upload errors to l.
+func (c *Config) storageProvider(name string, s3Client *http.Client, l logger.Logger) (StorageProvider, error) {
+	if name == "" {
+		name = c.Storage
+	}
+	if name == "" {
+		name = StorageFile
+	}
+	if p, ok := c.StorageProviders[name]; ok {
+		return p, nil
+	}
+	switch name {
+	case StorageFile:
+		return func(id, dir string) (storage.Storage, error) {
+			return filestorage.New(dir)
+		}, nil
+	case StorageMemory:
+		return func(id, dir string) (storage.Storage, error) {
+			return memstorage.New(), nil
+		}, nil
+	case StorageBlob:
+		return func(id, dir string) (storage.Storage, error) {
+			return blobstorage.New(filepath.Join(dir, id+".blob"))
+		}, nil
+	case StorageS3:
+		return func(id, dir string) (storage.Storage, error) {
+			return s3storage.New(s3storage.Config{
+				Endpoint:   c.S3Endpoint,
+				Region:     c.S3Region,
+				Bucket:     c.S3Bucket,
+				Prefix:     c.S3Prefix,
+				AccessKey:  c.S3AccessKey,
+				SecretKey:  c.S3SecretKey,
+				StagingDir: dir,
+				HTTPClient: s3Client,
+				OnUploadError: func(name string, err error) {
+					l.Errorf("cannot upload %q of torrent %s: %s", name, id, err)
+				},
+			})
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown storage: %q", name)
+}
+
+// newStorage creates the storage of the torrent.
+// name is the storage backend given when the torrent is added and custom is the data directory given when the torrent is added.
+func (s *Session) newStorage(name, id, custom string) (storage.Storage, error) {
+	p, err := s.config.storageProvider(name, &s.s3Client, s.log)
+	if err != nil {
+		return nil, err
+	}
+	return p(id, s.dataDir(id, custom))
+}
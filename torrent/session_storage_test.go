@@ -0,0 +1,81 @@
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/panzarasa/rain/storage/blobstorage"
+	"github.com/panzarasa/rain/storage/memstorage"
+)
+
+func TestStorageBackends(t *testing.T) {
+	s, closeSession := newTestSessionWithConfig(t, func(cfg *Config) {
+		cfg.Storage = StorageMemory
+	})
+	defer closeSession()
+	tmp := s.config.DataDir
+
+	add := func(storage string) *Torrent {
+		f, err := os.Open(torrentFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		tor, err := s.AddTorrent(f, &AddTorrentOptions{Storage: storage})
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-tor.torrent.NotifyListen():
+		case err = <-tor.torrent.NotifyError():
+			t.Fatal(err)
+		case <-time.After(timeout):
+			t.Fatal("torrent is not allocated")
+		}
+		return tor
+	}
+	mem := add("")
+	if _, ok := mem.torrent.storage.(*memstorage.MemStorage); !ok {
+		t.Fatalf("unexpected storage: %T", mem.torrent.storage)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, mem.ID())); !os.IsNotExist(err) {
+		t.Fatal("memory storage must not create files")
+	}
+	blob := add(StorageBlob)
+	fi, err := os.Stat(filepath.Join(tmp, blob.ID(), blob.ID()+".blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != blob.Stats().Bytes.Total {
+		t.Fatalf("unexpected blob size: %d", fi.Size())
+	}
+
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = s.AddTorrent(f, &AddTorrentOptions{Storage: "unknown"})
+	if _, ok := err.(*InputError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Storage backends are restored when the Session is loaded again.
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewSession(s.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := s.GetTorrent(mem.ID()).torrent.storage.(*memstorage.MemStorage); !ok {
+		t.Fatal("memory storage is not restored")
+	}
+	if _, ok := s.GetTorrent(blob.ID()).torrent.storage.(*blobstorage.BlobStorage); !ok {
+		t.Fatal("blob storage is not restored")
+	}
+}
@@ -1,20 +1,13 @@
 package torrent
 
 import (
-	"archive/tar"
 	"encoding/hex"
-	"encoding/json"
-	"fmt"
-	"io"
-	"mime/multipart"
-	"net/http"
-	"os"
+	"errors"
 	"path/filepath"
 	"time"
 
-	"github.com/panzarasa/rain/internal/resumer/boltdbresumer"
+	"github.com/mitchellh/go-homedir"
 	"github.com/panzarasa/rain/internal/tracker"
-	"go.etcd.io/bbolt"
 )
 
 // Torrent is created from a torrent file or a magnet link.
@@ -87,6 +80,61 @@ func (t *Torrent) Webseeds() []Webseed {
 	return t.torrent.Webseeds()
 }
 
+// Files returns the list of files in the torrent with their download priorities.
+// Returns error if torrent has no metadata yet.
+func (t *Torrent) Files() ([]File, error) {
+	return t.torrent.Files()
+}
+
+// SetFilePriorities sets the download priority of each file in the torrent.
+// The length of priorities must be equal to the number of files.
+// Files with PrioritySkip are not downloaded and the torrent is considered complete when all other files are downloaded.
+// Returns error if torrent has no metadata yet.
+func (t *Torrent) SetFilePriorities(priorities []FilePriority) error {
+	err := t.torrent.SetFilePriorities(priorities)
+	if err != nil {
+		return err
+	}
+	value := make([]int, len(priorities))
+	for i, p := range priorities {
+		value[i] = int(p)
+	}
+	return t.torrent.session.resumer.WriteFilePriorities(t.torrent.id, value)
+}
+
+// SetSequential enables or disables downloading pieces in order.
+// In sequential mode, pieces with the same priority are downloaded in order of their indexes instead of rarest first.
+func (t *Torrent) SetSequential(value bool) error {
+	err := t.torrent.session.resumer.WriteSequential(t.torrent.id, value)
+	if err != nil {
+		return err
+	}
+	t.torrent.SetSequential(value)
+	return nil
+}
+
+// SetSpeedLimit sets the download and upload speed limits of the torrent in KiB/s.
+// Zero value means unlimited. Session-wide speed limits are still applied to the torrent.
+func (t *Torrent) SetSpeedLimit(download, upload int64) error {
+	if download < 0 || upload < 0 {
+		return errors.New("speed limit cannot be negative")
+	}
+	err := t.torrent.session.resumer.WriteSpeedLimit(t.torrent.id, download, upload)
+	if err != nil {
+		return err
+	}
+	t.torrent.downloadLimiter.SetRate(download * 1024)
+	t.torrent.uploadLimiter.SetRate(upload * 1024)
+	return nil
+}
+
+// NewFileReader returns a new FileReader for reading the file at index while the torrent is being downloaded.
+// Index is the position of the file in the list returned from Files method.
+// Returns error if torrent has no metadata yet.
+func (t *Torrent) NewFileReader(index int) (*FileReader, error) {
+	return t.torrent.NewFileReader(index)
+}
+
 // Port returns the TCP port number that the torrent is listening peers.
 func (t *Torrent) Port() int {
 	return t.torrent.port
@@ -121,21 +169,11 @@ func (t *Torrent) AddTracker(uri string) error {
 	if err != nil {
 		return err
 	}
-	err = t.torrent.session.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(torrentsBucket).Bucket([]byte(t.torrent.id))
-		value := b.Get(boltdbresumer.Keys.Trackers)
-		var trackers [][]string
-		err = json.Unmarshal(value, &trackers)
-		if err != nil {
-			return err
-		}
-		trackers = append(trackers, []string{uri})
-		value, err = json.Marshal(trackers)
-		if err != nil {
-			return err
-		}
-		return b.Put(boltdbresumer.Keys.Trackers, value)
-	})
+	spec, err := t.torrent.session.resumer.Read(t.torrent.id)
+	if err != nil {
+		return err
+	}
+	err = t.torrent.session.resumer.WriteTrackers(t.torrent.id, append(spec.Trackers, []string{uri}))
 	if err != nil {
 		return err
 	}
@@ -144,12 +182,14 @@ func (t *Torrent) AddTracker(uri string) error {
 }
 
 // Start downloading the torrent. If all pieces are completed, starts seeding them.
+// If queue limits in Config are reached, the torrent waits in queue until its turn comes.
 func (t *Torrent) Start() error {
 	err := t.torrent.session.resumer.WriteStarted(t.torrent.id, true)
 	if err != nil {
 		return err
 	}
-	t.torrent.Start()
+	t.torrent.session.setQueued(t.torrent, true)
+	t.torrent.session.processQueue()
 	return nil
 }
 
@@ -161,6 +201,7 @@ func (t *Torrent) Stop() error {
 	if err != nil {
 		return err
 	}
+	t.torrent.session.setQueued(t.torrent, false)
 	t.torrent.Stop()
 	return nil
 }
@@ -174,10 +215,7 @@ func (t *Torrent) Announce() {
 // After Verify called, the torrent is stopped, then verification starts and the torrent switches into Verifying state.
 // The torrent stays stopped after verification finishes.
 func (t *Torrent) Verify() error {
-	err := t.torrent.session.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(torrentsBucket).Bucket([]byte(t.torrent.id))
-		return b.Delete([]byte("bitfield"))
-	})
+	err := t.torrent.session.resumer.WriteBitfield(t.torrent.id, nil)
 	if err != nil {
 		return err
 	}
@@ -185,131 +223,31 @@ func (t *Torrent) Verify() error {
 	return nil
 }
 
-// Move torrent to another Session.
-// target must be the RPC server address in host:port form.
-func (t *Torrent) Move(target string) error {
-	t.torrent.Stop()
-	spec, err := t.torrent.session.resumer.Read(t.torrent.id)
-	if err != nil {
-		return err
-	}
-
-	pr, pw := io.Pipe()
-	mw := multipart.NewWriter(pw)
-	go t.prepareBody(pw, mw, spec)
-
-	req, err := http.NewRequest(http.MethodPost, target+"/move-torrent?id="+t.torrent.id, pr)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", mw.FormDataContentType())
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("http error: %d", resp.StatusCode)
-	}
-	defer resp.Body.Close()
-	return t.torrent.session.RemoveTorrent(t.torrent.id)
-}
-
-func (t *Torrent) prepareBody(pw *io.PipeWriter, mw *multipart.Writer, spec *boltdbresumer.Spec) {
-	var err error
-	defer func() { _ = pw.CloseWithError(err) }()
-
-	iw, err := mw.CreateFormField("id")
-	if err != nil {
-		t.torrent.log.Errorln("cannot create id form filed:", err)
-		return
-	}
-	_, err = iw.Write([]byte(t.torrent.id))
-	if err != nil {
-		t.torrent.log.Errorln("cannot write id:", err)
-		return
-	}
-	fw, err := mw.CreateFormField("metadata")
-	if err != nil {
-		t.torrent.log.Errorln("cannot create metadata form filed:", err)
-		return
-	}
-	err = json.NewEncoder(fw).Encode(spec)
-	if err != nil {
-		t.torrent.log.Errorln("cannot encode resumer spec:", err)
-		return
-	}
-	dw, err := mw.CreateFormField("data")
-	if err != nil {
-		t.torrent.log.Errorln("cannot create data form filed:", err)
-		return
-	}
-	tpr, tpw := io.Pipe()
-	go t.generateTar(tpw)
-	_, err = io.Copy(dw, tpr)
-	if err != nil {
-		t.torrent.log.Errorln("error copying pipe:", err)
-		return
-	}
-	err = mw.Close()
-	if err != nil {
-		t.torrent.log.Errorln("cannot close multipart writer:", err)
-		return
-	}
-}
-
-func (t *Torrent) generateTar(pw *io.PipeWriter) {
-	var err error
-	defer func() { _ = pw.CloseWithError(err) }()
-
-	tw := tar.NewWriter(pw)
-	var root string
-	if t.torrent.session.config.DataDirIncludesTorrentID {
-		root = filepath.Join(t.torrent.session.config.DataDir, t.torrent.id)
-	} else {
-		root = t.torrent.session.config.DataDir
-	}
-	walkFunc := func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		hdr := &tar.Header{
-			Name: path[len(root)+1:],
-			Mode: 0600,
-			Size: info.Size(),
-		}
-		err = tw.WriteHeader(hdr)
-		if err != nil {
-			t.torrent.log.Errorln("cannot write tar header:", err)
-			return err
-		}
-		f, err := os.Open(path)
+// Relocate moves the files of the torrent into dataDir on the local disk.
+// The torrent is stopped while the files are being moved and it is put back into queue afterwards if it was started.
+// Empty dataDir moves the files back to the default location in Config.DataDir.
+func (t *Torrent) Relocate(dataDir string) error {
+	s := t.torrent.session
+	if dataDir != "" {
+		var err error
+		dataDir, err = homedir.Expand(dataDir)
 		if err != nil {
-			t.torrent.log.Errorln("cannot open file:", err)
 			return err
 		}
-		_, err = io.Copy(tw, f)
-		f.Close()
+		dataDir, err = filepath.Abs(dataDir)
 		if err != nil {
-			t.torrent.log.Errorln("cannot copy storage file to tar writer:", err)
 			return err
 		}
-		return nil
 	}
-	err = filepath.Walk(root, walkFunc)
-	if os.IsNotExist(err) {
-		err = nil
-		return
+	queued := t.torrent.isQueued()
+	s.setQueued(t.torrent, false)
+	err := t.torrent.relocate(s.dataDir(t.torrent.id, dataDir))
+	if err == nil {
+		err = s.resumer.WriteDataDir(t.torrent.id, dataDir)
 	}
-	if err != nil {
-		t.torrent.log.Errorln("error walking files:", err)
-		return
-	}
-	err = tw.Close()
-	if err != nil {
-		t.torrent.log.Errorln("cannot close tar writer:", err)
-		return
+	if queued {
+		s.setQueued(t.torrent, true)
+		s.processQueue()
 	}
+	return err
 }
@@ -0,0 +1,132 @@
+package torrent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/panzarasa/rain/internal/dirwatcher"
+)
+
+// WatchDir is a directory that is watched for new .torrent files.
+// Found files are added to the Session and renamed or moved after they are processed.
+type WatchDir struct {
+	// Path of the directory to watch.
+	Path string
+	// If set, processed files are moved into this directory.
+	// Otherwise, ".added" suffix is appended to the names of processed files.
+	// Files that cannot be added get ".invalid" suffix in both cases.
+	ProcessedDir string
+	// Options for adding the torrents found in this directory. See AddTorrentOptions.
+	Stopped           bool
+	StopAfterDownload bool
+	Sequential        bool
+	DataDir           string
+}
+
+func (d *WatchDir) addTorrentOptions() *AddTorrentOptions {
+	return &AddTorrentOptions{
+		Stopped:           d.Stopped,
+		StopAfterDownload: d.StopAfterDownload,
+		Sequential:        d.Sequential,
+		DataDir:           d.DataDir,
+	}
+}
+
+// prepareWatchDirs returns the watch directories in Config with expanded paths. Missing directories are created.
+func prepareWatchDirs(cfg *Config) ([]WatchDir, error) {
+	dirs := make([]WatchDir, 0, len(cfg.WatchDirs))
+	for _, d := range cfg.WatchDirs {
+		var err error
+		d.Path, err = homedir.Expand(d.Path)
+		if err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(d.Path, 0750)
+		if err != nil {
+			return nil, err
+		}
+		if d.ProcessedDir != "" {
+			d.ProcessedDir, err = homedir.Expand(d.ProcessedDir)
+			if err != nil {
+				return nil, err
+			}
+			err = os.MkdirAll(d.ProcessedDir, 0750)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+func (s *Session) startWatchers() {
+	for _, d := range s.config.WatchDirs {
+		w := dirwatcher.New(d.Path, ".torrent", s.config.WatchPollInterval, s.log)
+		s.watchers = append(s.watchers, w)
+		go w.Run()
+		s.watchWG.Add(1)
+		go s.watchDir(w, d)
+	}
+}
+
+func (s *Session) stopWatchers() {
+	for _, w := range s.watchers {
+		w.Close()
+	}
+	s.watchWG.Wait()
+}
+
+func (s *Session) watchDir(w *dirwatcher.Watcher, d WatchDir) {
+	defer s.watchWG.Done()
+	for {
+		select {
+		case path := <-w.Files():
+			s.addWatchedFile(path, d)
+		case <-s.closeC:
+			return
+		}
+	}
+}
+
+func (s *Session) addWatchedFile(path string, d WatchDir) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// File is already processed.
+		return
+	}
+	if err != nil {
+		s.log.Errorf("cannot open watched file %s: %s", path, err)
+		return
+	}
+	t, err := s.AddTorrent(f, d.addTorrentOptions())
+	f.Close()
+	var inputErr *InputError
+	switch {
+	case errors.As(err, &inputErr):
+		s.log.Errorf("cannot add torrent from watched file %s: %s", path, err)
+		s.renameWatchedFile(path, path+".invalid")
+		return
+	case err != nil && t == nil:
+		// Error is not about the file. It will be tried again when the session is restarted.
+		s.log.Errorf("cannot add torrent from watched file %s: %s", path, err)
+		return
+	case err != nil:
+		s.log.Errorf("cannot start torrent from watched file %s: %s", path, err)
+	}
+	s.log.Infof("added torrent from watched file %s", path)
+	if d.ProcessedDir != "" {
+		s.renameWatchedFile(path, filepath.Join(d.ProcessedDir, filepath.Base(path)))
+	} else {
+		s.renameWatchedFile(path, path+".added")
+	}
+}
+
+func (s *Session) renameWatchedFile(oldpath, newpath string) {
+	err := os.Rename(oldpath, newpath)
+	if err != nil {
+		s.log.Errorf("cannot rename watched file %s: %s", oldpath, err)
+	}
+}
@@ -0,0 +1,76 @@
+package torrent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchDir(t *testing.T) {
+	var watchDir string
+	s, closeSession := newTestSessionWithConfig(t, func(cfg *Config) {
+		watchDir = filepath.Join(cfg.DataDir, "watch")
+		cfg.WatchDirs = []WatchDir{{Path: watchDir, Stopped: true}}
+		cfg.WatchPollInterval = 100 * time.Millisecond
+	})
+	defer closeSession()
+
+	b, err := ioutil.ReadFile(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(watchDir, "foo.torrent"), b, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(watchDir, "bar.torrent"), []byte("invalid"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err1 := os.Stat(filepath.Join(watchDir, "foo.torrent.added"))
+		_, err2 := os.Stat(filepath.Join(watchDir, "bar.torrent.invalid"))
+		if err1 == nil && err2 == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("files are not processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	torrents := s.ListTorrents()
+	if len(torrents) != 1 {
+		t.Fatalf("unexpected number of torrents: %d", len(torrents))
+	}
+	if torrents[0].Stats().Status != Stopped {
+		t.Fatal("torrent is not stopped")
+	}
+}
+
+func TestWatchDirInvalid(t *testing.T) {
+	tmp, closeTmp := tempdir(t)
+	defer closeTmp()
+	file := filepath.Join(tmp, "file")
+	err := ioutil.WriteFile(file, nil, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig
+	cfg.Database = filepath.Join(tmp, "session.db")
+	cfg.DataDir = filepath.Join(tmp, "data")
+	cfg.DHTEnabled = false
+	cfg.RPCEnabled = false
+	cfg.WatchDirs = []WatchDir{{Path: filepath.Join(file, "watch")}}
+	_, err = NewSession(cfg)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	// Error must be returned before the database is created.
+	if _, err = os.Stat(cfg.Database); !os.IsNotExist(err) {
+		t.Fatal("database is created")
+	}
+}
@@ -26,15 +26,18 @@ import (
 	"github.com/panzarasa/rain/internal/pexlist"
 	"github.com/panzarasa/rain/internal/piece"
 	"github.com/panzarasa/rain/internal/piecedownloader"
+	"github.com/panzarasa/rain/internal/piecelayerdownloader"
 	"github.com/panzarasa/rain/internal/piecepicker"
 	"github.com/panzarasa/rain/internal/piecewriter"
 	"github.com/panzarasa/rain/internal/resumer"
-	"github.com/panzarasa/rain/internal/storage"
+	"github.com/panzarasa/rain/internal/speedlimiter"
 	"github.com/panzarasa/rain/internal/suspendchan"
 	"github.com/panzarasa/rain/internal/tracker"
 	"github.com/panzarasa/rain/internal/unchoker"
+	"github.com/panzarasa/rain/internal/utp"
 	"github.com/panzarasa/rain/internal/verifier"
 	"github.com/panzarasa/rain/internal/webseedsource"
+	"github.com/panzarasa/rain/storage"
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -59,6 +62,9 @@ type torrent struct {
 	// Storage implementation to save the files in torrent.
 	storage storage.Storage
 
+	// Protects storage writing from torrent loop after relocation and reading from other goroutines.
+	mStorage sync.RWMutex
+
 	// TCP Port to listen for peer connections.
 	port int
 
@@ -77,6 +83,21 @@ type torrent struct {
 	files  []allocator.File
 	pieces []piece.Piece
 
+	// Download priorities of files in torrent. Nil means all files have normal priority.
+	filePriorities []FilePriority
+
+	// Download priorities of pieces calculated from filePriorities. Nil means all pieces are wanted.
+	piecePriorities []int
+
+	// If true, pieces with the same priority are downloaded in order instead of rarest first.
+	sequential bool
+
+	// Open file readers and the range of pieces they want to be downloaded next.
+	fileReaders map[*FileReader]readAheadWindow
+
+	// File readers waiting for pieces to be downloaded.
+	pieceWaiters []pieceWaiter
+
 	piecePicker *piecepicker.PiecePicker
 
 	// Peers are sent to this channel when they are disconnected.
@@ -113,6 +134,11 @@ type torrent struct {
 	infoDownloaders        map[*peer.Peer]*infodownloader.InfoDownloader
 	infoDownloadersSnubbed map[*peer.Peer]*infodownloader.InfoDownloader
 
+	// Piece layers of v2 only torrents are requested from peers after the info is received.
+	// Info is kept in pendingInfo until all layers are received.
+	pendingInfo          *metainfo.Info
+	pieceLayerDownloader *piecelayerdownloader.PieceLayerDownloader
+
 	pieceWriterResultC chan *piecewriter.PieceWriter
 
 	// This channel is closed once all pieces are downloaded and verified.
@@ -149,6 +175,15 @@ type torrent struct {
 	notifyListenCommandC chan notifyListenCommand // NotifyListen()
 	addPeersCommandC     chan []*net.TCPAddr      // AddPeers()
 	addTrackersCommandC  chan []tracker.Tracker   // AddTrackers()
+	filesCommandC        chan filesRequest        // Files()
+
+	setFilePrioritiesCommandC  chan setFilePrioritiesRequest  // SetFilePriorities()
+	setSequentialCommandC      chan bool                      // SetSequential()
+	newFileReaderCommandC      chan newFileReaderRequest      // NewFileReader()
+	fileReaderPositionCommandC chan fileReaderPositionRequest // FileReader.Read() and FileReader.Seek()
+	closeFileReaderCommandC    chan *FileReader               // FileReader.Close()
+	queueStateCommandC         chan queueStateRequest         // queueState()
+	relocateCommandC           chan relocateRequest           // relocate()
 
 	// Trackers send announce responses to this channel.
 	addrsFromTrackers chan []*net.TCPAddr
@@ -165,9 +200,17 @@ type torrent struct {
 	// Listens for incoming peer connections.
 	acceptor *acceptor.Acceptor
 
+	// uTP connections are accepted from and dialed over this socket.
+	utpSocket   *utp.Socket
+	utpAcceptor *acceptor.Acceptor
+
 	// Special hash of info hash for encypted connection handshake.
 	sKeyHash [20]byte
 
+	// Hybrid torrents accept incoming connections with truncated v2 info hash too.
+	infoHashV2 [20]byte
+	sKeyHashV2 [20]byte
+
 	// Announces the status of torrent to trackers to get peer addresses periodically.
 	announcers []*announcer.PeriodicalAnnouncer
 
@@ -179,6 +222,11 @@ type torrent struct {
 	dhtAnnouncer *announcer.DHTAnnouncer
 	dhtPeersC    chan []*net.TCPAddr
 
+	// If not nil, torrent is announced to local network periodically.
+	// DHTAnnouncer is used for scheduling the announces.
+	lsdAnnouncer *announcer.DHTAnnouncer
+	lsdPeersC    chan []*net.TCPAddr
+
 	// List of peers in handshake state.
 	incomingHandshakers map[*incominghandshaker.IncomingHandshaker]struct{}
 	outgoingHandshakers map[*outgoinghandshaker.OutgoingHandshaker]struct{}
@@ -199,6 +247,10 @@ type torrent struct {
 	allocatorResultC   chan *allocator.Allocator
 	bytesAllocated     int64
 
+	// Pending request while the files are being moved to another directory.
+	relocating      *relocateRequest
+	relocateResultC chan relocateResult
+
 	// A worker that does hash check of files on the disk.
 	verifier          *verifier.Verifier
 	verifierProgressC chan verifier.Progress
@@ -222,6 +274,9 @@ type torrent struct {
 	// Peers that are sending corrupt data are banned.
 	bannedPeerIPs map[string]struct{}
 
+	// Peers of custom extensions by extension name. Created once after the extension handshake of the peer is received.
+	extensionPeers map[*peer.Peer]map[string]*ExtensionPeer
+
 	// A signal sent to run() loop when announcers are stopped.
 	announcersStoppedC chan struct{}
 
@@ -247,6 +302,21 @@ type torrent struct {
 	// If true, the torrent is stopped automatically when all pieces are downloaded.
 	stopAfterDownload bool
 
+	// Previous version of a mutable torrent. Its files are reused if they are identical. See BEP 46.
+	previousVersion *previousVersion
+
+	// Torrent is stopped or removed when one of these limits is reached while seeding.
+	seedLimits seedLimits
+
+	// Torrent is in queue if it is started by the user. Queue manager runs the torrent when its turn comes.
+	// Protected by Session.mQueue.
+	queued        bool
+	queuePosition int
+
+	// Speed limiters of the torrent. Session-wide limiters are their parents.
+	downloadLimiter *speedlimiter.Limiter
+	uploadLimiter   *speedlimiter.Limiter
+
 	log logger.Logger
 }
 
@@ -265,6 +335,9 @@ func newTorrent2(
 	stats resumer.Stats, // initial stats from previous run
 	ws []*webseedsource.WebseedSource,
 	stopAfterDownload bool,
+	filePriorities []FilePriority,
+	sequential bool,
+	seedLimits seedLimits,
 ) (*torrent, error) {
 	if len(infoHash) != 20 {
 		return nil, errors.New("invalid infoHash (must be 20 bytes)")
@@ -273,76 +346,92 @@ func newTorrent2(
 	var ih [20]byte
 	copy(ih[:], infoHash)
 	t := &torrent{
-		session:                   s,
-		id:                        id,
-		addedAt:                   addedAt,
-		infoHash:                  ih,
-		trackers:                  trackers,
-		fixedPeers:                fixedPeers,
-		name:                      name,
-		storage:                   sto,
-		port:                      port,
-		info:                      info,
-		bitfield:                  bf,
-		log:                       logger.New("torrent " + id),
-		peerDisconnectedC:         make(chan *peer.Peer),
-		messages:                  make(chan peer.Message),
-		pieceMessagesC:            suspendchan.New(0),
-		peers:                     make(map[*peer.Peer]struct{}),
-		incomingPeers:             make(map[*peer.Peer]struct{}),
-		outgoingPeers:             make(map[*peer.Peer]struct{}),
-		pieceDownloaders:          make(map[*peer.Peer]*piecedownloader.PieceDownloader),
-		pieceDownloadersSnubbed:   make(map[*peer.Peer]*piecedownloader.PieceDownloader),
-		pieceDownloadersChoked:    make(map[*peer.Peer]*piecedownloader.PieceDownloader),
-		peerSnubbedC:              make(chan *peer.Peer),
-		infoDownloaders:           make(map[*peer.Peer]*infodownloader.InfoDownloader),
-		infoDownloadersSnubbed:    make(map[*peer.Peer]*infodownloader.InfoDownloader),
-		pieceWriterResultC:        make(chan *piecewriter.PieceWriter),
-		completeC:                 make(chan struct{}),
-		closeC:                    make(chan chan struct{}),
-		startCommandC:             make(chan struct{}),
-		stopCommandC:              make(chan struct{}),
-		announceCommandC:          make(chan struct{}),
-		verifyCommandC:            make(chan struct{}),
-		statsCommandC:             make(chan statsRequest),
-		trackersCommandC:          make(chan trackersRequest),
-		peersCommandC:             make(chan peersRequest),
-		webseedsCommandC:          make(chan webseedsRequest),
-		notifyErrorCommandC:       make(chan notifyErrorCommand),
-		notifyListenCommandC:      make(chan notifyListenCommand),
-		addPeersCommandC:          make(chan []*net.TCPAddr),
-		addTrackersCommandC:       make(chan []tracker.Tracker),
-		addrsFromTrackers:         make(chan []*net.TCPAddr),
-		peerIDs:                   make(map[[20]byte]struct{}),
-		incomingConnC:             make(chan net.Conn),
-		sKeyHash:                  mse.HashSKey(ih[:]),
-		infoDownloaderResultC:     make(chan *infodownloader.InfoDownloader),
-		incomingHandshakers:       make(map[*incominghandshaker.IncomingHandshaker]struct{}),
-		outgoingHandshakers:       make(map[*outgoinghandshaker.OutgoingHandshaker]struct{}),
-		incomingHandshakerResultC: make(chan *incominghandshaker.IncomingHandshaker),
-		outgoingHandshakerResultC: make(chan *outgoinghandshaker.OutgoingHandshaker),
-		allocatorProgressC:        make(chan allocator.Progress),
-		allocatorResultC:          make(chan *allocator.Allocator),
-		verifierProgressC:         make(chan verifier.Progress),
-		verifierResultC:           make(chan *verifier.Verifier),
-		connectedPeerIPs:          make(map[string]struct{}),
-		bannedPeerIPs:             make(map[string]struct{}),
-		announcersStoppedC:        make(chan struct{}),
-		dhtPeersC:                 make(chan []*net.TCPAddr, 1),
-		externalIP:                externalip.FirstExternalIP(),
-		downloadSpeed:             metrics.NilMeter{},
-		uploadSpeed:               metrics.NilMeter{},
-		bytesDownloaded:           metrics.NewCounter(),
-		bytesUploaded:             metrics.NewCounter(),
-		bytesWasted:               metrics.NewCounter(),
-		seededFor:                 metrics.NewCounter(),
-		ramNotifyC:                make(chan interface{}),
-		webseedClient:             &s.webseedClient,
-		webseedSources:            ws,
-		webseedPieceResultC:       suspendchan.New(0),
-		webseedRetryC:             make(chan *webseedsource.WebseedSource),
-		doneC:                     make(chan struct{}),
-		stopAfterDownload:         stopAfterDownload,
+		session:                    s,
+		id:                         id,
+		addedAt:                    addedAt,
+		infoHash:                   ih,
+		trackers:                   trackers,
+		fixedPeers:                 fixedPeers,
+		name:                       name,
+		storage:                    sto,
+		port:                       port,
+		info:                       info,
+		bitfield:                   bf,
+		log:                        logger.New("torrent " + id),
+		peerDisconnectedC:          make(chan *peer.Peer),
+		messages:                   make(chan peer.Message),
+		pieceMessagesC:             suspendchan.New(0),
+		peers:                      make(map[*peer.Peer]struct{}),
+		incomingPeers:              make(map[*peer.Peer]struct{}),
+		outgoingPeers:              make(map[*peer.Peer]struct{}),
+		pieceDownloaders:           make(map[*peer.Peer]*piecedownloader.PieceDownloader),
+		pieceDownloadersSnubbed:    make(map[*peer.Peer]*piecedownloader.PieceDownloader),
+		pieceDownloadersChoked:     make(map[*peer.Peer]*piecedownloader.PieceDownloader),
+		peerSnubbedC:               make(chan *peer.Peer),
+		infoDownloaders:            make(map[*peer.Peer]*infodownloader.InfoDownloader),
+		infoDownloadersSnubbed:     make(map[*peer.Peer]*infodownloader.InfoDownloader),
+		pieceWriterResultC:         make(chan *piecewriter.PieceWriter),
+		completeC:                  make(chan struct{}),
+		closeC:                     make(chan chan struct{}),
+		startCommandC:              make(chan struct{}),
+		stopCommandC:               make(chan struct{}),
+		announceCommandC:           make(chan struct{}),
+		verifyCommandC:             make(chan struct{}),
+		statsCommandC:              make(chan statsRequest),
+		trackersCommandC:           make(chan trackersRequest),
+		peersCommandC:              make(chan peersRequest),
+		webseedsCommandC:           make(chan webseedsRequest),
+		notifyErrorCommandC:        make(chan notifyErrorCommand),
+		notifyListenCommandC:       make(chan notifyListenCommand),
+		addPeersCommandC:           make(chan []*net.TCPAddr),
+		addTrackersCommandC:        make(chan []tracker.Tracker),
+		filesCommandC:              make(chan filesRequest),
+		setFilePrioritiesCommandC:  make(chan setFilePrioritiesRequest),
+		setSequentialCommandC:      make(chan bool),
+		newFileReaderCommandC:      make(chan newFileReaderRequest),
+		fileReaderPositionCommandC: make(chan fileReaderPositionRequest),
+		closeFileReaderCommandC:    make(chan *FileReader),
+		queueStateCommandC:         make(chan queueStateRequest),
+		relocateCommandC:           make(chan relocateRequest),
+		relocateResultC:            make(chan relocateResult, 1),
+		addrsFromTrackers:          make(chan []*net.TCPAddr),
+		peerIDs:                    make(map[[20]byte]struct{}),
+		incomingConnC:              make(chan net.Conn),
+		sKeyHash:                   mse.HashSKey(ih[:]),
+		infoDownloaderResultC:      make(chan *infodownloader.InfoDownloader),
+		incomingHandshakers:        make(map[*incominghandshaker.IncomingHandshaker]struct{}),
+		outgoingHandshakers:        make(map[*outgoinghandshaker.OutgoingHandshaker]struct{}),
+		incomingHandshakerResultC:  make(chan *incominghandshaker.IncomingHandshaker),
+		outgoingHandshakerResultC:  make(chan *outgoinghandshaker.OutgoingHandshaker),
+		allocatorProgressC:         make(chan allocator.Progress),
+		allocatorResultC:           make(chan *allocator.Allocator),
+		verifierProgressC:          make(chan verifier.Progress),
+		verifierResultC:            make(chan *verifier.Verifier),
+		connectedPeerIPs:           make(map[string]struct{}),
+		bannedPeerIPs:              make(map[string]struct{}),
+		extensionPeers:             make(map[*peer.Peer]map[string]*ExtensionPeer),
+		announcersStoppedC:         make(chan struct{}),
+		dhtPeersC:                  make(chan []*net.TCPAddr, 1),
+		lsdPeersC:                  make(chan []*net.TCPAddr, 1),
+		externalIP:                 externalip.FirstExternalIP(),
+		downloadSpeed:              metrics.NilMeter{},
+		uploadSpeed:                metrics.NilMeter{},
+		bytesDownloaded:            metrics.NewCounter(),
+		bytesUploaded:              metrics.NewCounter(),
+		bytesWasted:                metrics.NewCounter(),
+		seededFor:                  metrics.NewCounter(),
+		ramNotifyC:                 make(chan interface{}),
+		webseedClient:              &s.webseedClient,
+		webseedSources:             ws,
+		webseedPieceResultC:        suspendchan.New(0),
+		webseedRetryC:              make(chan *webseedsource.WebseedSource),
+		doneC:                      make(chan struct{}),
+		stopAfterDownload:          stopAfterDownload,
+		sequential:                 sequential,
+		seedLimits:                 seedLimits,
+		fileReaders:                make(map[*FileReader]readAheadWindow),
+		downloadLimiter:            speedlimiter.New(0, s.limiterDownload),
+		uploadLimiter:              speedlimiter.New(0, s.limiterUpload),
 	}
 	if len(t.webseedSources) > s.config.WebseedMaxSources {
 		t.webseedSources = t.webseedSources[:10]
@@ -357,7 +446,16 @@ func newTorrent2(
 	}
 	t.addrList = addrlist.New(cfg.MaxPeerAddresses, blocklistForOutgoingConns, port, &t.externalIP)
 	if t.info != nil {
+		if t.info.IsHybrid() {
+			copy(t.infoHashV2[:], t.info.HashV2[:])
+			t.sKeyHashV2 = mse.HashSKey(t.infoHashV2[:])
+		}
 		t.piecePool = bufferpool.New(int(t.info.PieceLength))
+		if len(filePriorities) == len(t.info.Files) {
+			t.filePriorities = filePriorities
+		}
+		// Needed for telling whether the torrent is complete before it is started.
+		t.updatePiecePriorities()
 	}
 	n := t.copyPeerIDPrefix()
 	_, err := rand.Read(t.peerID[n:]) // nolint: gosec
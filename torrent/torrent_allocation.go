@@ -6,7 +6,6 @@ import (
 	"github.com/panzarasa/rain/internal/allocator"
 	"github.com/panzarasa/rain/internal/bitfield"
 	"github.com/panzarasa/rain/internal/piece"
-	"github.com/panzarasa/rain/internal/piecepicker"
 )
 
 func (t *torrent) handleAllocationDone(al *allocator.Allocator) {
@@ -34,6 +33,7 @@ func (t *torrent) handleAllocationDone(al *allocator.Allocator) {
 		return
 	}
 	t.pieces = pieces
+	t.updatePiecePriorities()
 
 	for pe := range t.peers {
 		pe.GenerateAndSendAllowedFastMessages(t.session.config.AllowedFastSet, t.info.NumPieces, t.infoHash, t.pieces)
@@ -42,7 +42,7 @@ func (t *torrent) handleAllocationDone(al *allocator.Allocator) {
 	if t.piecePicker != nil {
 		panic("piece picker exists")
 	}
-	t.piecePicker = piecepicker.New(t.pieces, t.session.config.EndgameMaxDuplicateDownloads, t.webseedSources)
+	t.piecePicker = t.newPiecePicker()
 
 	for pe := range t.peers {
 		pe.Bitfield = bitfield.New(t.info.NumPieces)
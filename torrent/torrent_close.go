@@ -37,10 +37,15 @@ func (t *torrent) closePeer(pe *peer.Peer) {
 	delete(t.outgoingPeers, pe)
 	delete(t.peerIDs, pe.ID)
 	delete(t.connectedPeerIPs, pe.Conn.IP())
+	delete(t.extensionPeers, pe)
 	if t.piecePicker != nil {
 		t.piecePicker.HandleDisconnect(pe)
 	}
 	t.unchoker.HandleDisconnect(pe)
+	if t.pieceLayerDownloader != nil {
+		t.pieceLayerDownloader.Disconnected(pe)
+		t.requestPieceLayers()
+	}
 	t.pexDropPeer(pe.Addr())
 	t.dialAddresses()
 	t.session.metrics.Peers.Dec(1)
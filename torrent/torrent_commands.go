@@ -106,7 +106,7 @@ func (t *torrent) Torrent() ([]byte, error) {
 	for i, ws := range t.webseedSources {
 		webseeds[i] = ws.URL
 	}
-	return metainfo.NewBytes(t.info.Bytes, t.getTieredTrackers(), webseeds, "")
+	return metainfo.NewBytes(t.info.Bytes, t.info.PieceLayers, t.getTieredTrackers(), webseeds, "")
 }
 
 func (t *torrent) getTieredTrackers() [][]string {
@@ -183,15 +183,18 @@ func trackerStatusToString(s TrackerStatus) string {
 }
 
 // Tracker is a server that tracks the peers of torrents.
+// Completed and LastScrape fields are set after the tracker is scraped.
 type Tracker struct {
 	URL          string
 	Status       TrackerStatus
 	Leechers     int
 	Seeders      int
+	Completed    int
 	Error        *AnnounceError
 	Warning      string
 	LastAnnounce time.Time
 	NextAnnounce time.Time
+	LastScrape   time.Time
 }
 
 type trackersRequest struct {
@@ -218,6 +221,7 @@ type Peer struct {
 	Client             string
 	Addr               net.Addr
 	Source             PeerSource
+	Transport          PeerTransport
 	ConnectedAt        time.Time
 	Downloading        bool
 	ClientInterested   bool
@@ -246,6 +250,18 @@ const (
 	SourceIncoming
 	// SourceManual indicates that the peer is added manually via AddPeer method.
 	SourceManual
+	// SourceLSD indicates that the peer is found in local network via Local Service Discovery.
+	SourceLSD
+)
+
+// PeerTransport indicates the protocol that the peer is connected with.
+type PeerTransport int
+
+const (
+	// TransportTCP indicates that the peer is connected over TCP.
+	TransportTCP PeerTransport = iota
+	// TransportUTP indicates that the peer is connected over uTP.
+	TransportUTP
 )
 
 type peersRequest struct {
@@ -3,6 +3,7 @@ package torrent
 import (
 	"net"
 
+	"github.com/panzarasa/rain/internal/btconn"
 	"github.com/panzarasa/rain/internal/handshaker/incominghandshaker"
 )
 
@@ -12,7 +13,13 @@ func (t *torrent) handleNewConnection(conn net.Conn) {
 		conn.Close()
 		return
 	}
-	ip := conn.RemoteAddr().(*net.TCPAddr).IP
+	addr, err := btconn.PeerAddr(conn.RemoteAddr())
+	if err != nil {
+		t.log.Debugln("cannot get peer address:", err)
+		conn.Close()
+		return
+	}
+	ip := addr.IP
 	ipstr := ip.String()
 	if t.session.config.BlocklistEnabledForIncomingConnections && t.session.blocklist != nil && t.session.blocklist.Blocked(ip) {
 		t.log.Debugln("peer is blocked:", conn.RemoteAddr().String())
@@ -29,7 +36,7 @@ func (t *torrent) handleNewConnection(conn net.Conn) {
 		conn.Close()
 		return
 	}
-	h := incominghandshaker.New(conn)
+	h := incominghandshaker.New(conn, addr)
 	t.incomingHandshakers[h] = struct{}{}
 	t.connectedPeerIPs[ipstr] = struct{}{}
 	go h.Run(
@@ -0,0 +1,53 @@
+package torrent
+
+import (
+	"github.com/panzarasa/rain/internal/peer"
+	"github.com/panzarasa/rain/internal/peerprotocol"
+)
+
+// extensionPeer returns the ExtensionPeer of pe for the extension with name.
+// It is reused for all messages because the extension handshake of a peer does not change after it is received.
+func (t *torrent) extensionPeer(pe *peer.Peer, name string) *ExtensionPeer {
+	if ep, ok := t.extensionPeers[pe][name]; ok {
+		return ep
+	}
+	ep := &ExtensionPeer{
+		torrent:   &Torrent{torrent: t},
+		peer:      pe,
+		handshake: pe.ExtensionHandshake,
+	}
+	if ep.handshake == nil {
+		return ep
+	}
+	ep.id = ep.handshake.M[name]
+	m, ok := t.extensionPeers[pe]
+	if !ok {
+		m = make(map[string]*ExtensionPeer)
+		t.extensionPeers[pe] = m
+	}
+	m[name] = ep
+	return ep
+}
+
+// handleCustomExtensionHandshake notifies the registered extensions that the peer supports.
+func (t *torrent) handleCustomExtensionHandshake(pe *peer.Peer, msg peerprotocol.ExtensionHandshakeMessage) {
+	for _, re := range t.session.customExtensions.all() {
+		if re.OnHandshake == nil {
+			continue
+		}
+		// Zero ID means the extension is disabled by the peer.
+		if id := msg.M[re.Name]; id != 0 {
+			re.OnHandshake(t.extensionPeer(pe, re.Name))
+		}
+	}
+}
+
+func (t *torrent) handleCustomExtensionMessage(pe *peer.Peer, msg peerprotocol.ExtensionRawMessage) {
+	re, ok := t.session.customExtensions.get(msg.ExtendedMessageID)
+	if !ok {
+		pe.Logger().Errorln("peer sent invalid extension message id:", msg.ExtendedMessageID)
+		t.closePeer(pe)
+		return
+	}
+	re.OnMessage(t.extensionPeer(pe, re.Name), msg.Data)
+}
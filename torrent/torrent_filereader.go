@@ -0,0 +1,284 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/panzarasa/rain/storage"
+)
+
+// Pieces in read-ahead window of file readers are downloaded before the pieces of files with any priority.
+const readAheadPriority = int(PriorityHigh) + 1
+
+var errFileReaderClosed = errors.New("file reader is closed")
+
+// FileReader reads a single file in a torrent while the torrent is being downloaded.
+// Read blocks until the pieces covering the read position are downloaded and verified.
+// Pieces right after the read position are downloaded before other pieces in the torrent.
+// FileReader must be closed after use.
+type FileReader struct {
+	torrent *torrent
+
+	// Length of the file in torrent.
+	length int64
+
+	// Offset of the file in torrent data.
+	offset int64
+
+	pieceLength int64
+
+	// Current read position in file.
+	pos int64
+
+	// Opened in torrent loop when the reader is created.
+	file storage.File
+
+	closeC    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ io.ReadSeeker = (*FileReader)(nil)
+
+// readAheadWindow is the range of pieces [Begin, End) that is prioritized for a file reader.
+type readAheadWindow struct {
+	Begin, End uint32
+}
+
+// pieceWaiter is notified by closing C when the piece at Index is downloaded and verified.
+type pieceWaiter struct {
+	Reader *FileReader
+	Index  uint32
+	C      chan struct{}
+}
+
+type newFileReaderRequest struct {
+	Index    int
+	Response chan newFileReaderResponse
+}
+
+type newFileReaderResponse struct {
+	Reader *FileReader
+	Error  error
+}
+
+type fileReaderPositionRequest struct {
+	Reader   *FileReader
+	Position int64
+	// If true, a channel is returned in Response that is closed when the piece at Position is downloaded.
+	Wait     bool
+	Response chan chan struct{}
+}
+
+func (t *torrent) NewFileReader(index int) (*FileReader, error) {
+	var resp newFileReaderResponse
+	req := newFileReaderRequest{Index: index, Response: make(chan newFileReaderResponse, 1)}
+	select {
+	case t.newFileReaderCommandC <- req:
+	case <-t.closeC:
+		return nil, errClosed
+	}
+	select {
+	case resp = <-req.Response:
+	case <-t.closeC:
+		return nil, errClosed
+	}
+	return resp.Reader, resp.Error
+}
+
+// setFileReaderPosition updates the read-ahead window of the file reader.
+// If wait is true, returned channel is closed when the piece at the position is downloaded.
+func (t *torrent) setFileReaderPosition(r *FileReader, pos int64, wait bool) (chan struct{}, error) {
+	req := fileReaderPositionRequest{Reader: r, Position: pos, Wait: wait, Response: make(chan chan struct{}, 1)}
+	select {
+	case t.fileReaderPositionCommandC <- req:
+	case <-r.closeC:
+		return nil, errFileReaderClosed
+	case <-t.closeC:
+		return nil, errClosed
+	}
+	select {
+	case waitC := <-req.Response:
+		return waitC, nil
+	case <-t.closeC:
+		return nil, errClosed
+	}
+}
+
+func (t *torrent) closeFileReader(r *FileReader) {
+	select {
+	case t.closeFileReaderCommandC <- r:
+	case <-t.closeC:
+	}
+}
+
+func (t *torrent) handleNewFileReader(req newFileReaderRequest) {
+	if t.info == nil {
+		req.Response <- newFileReaderResponse{Error: errors.New("torrent metadata not ready")}
+		return
+	}
+	if req.Index < 0 || req.Index >= len(t.info.Files) {
+		req.Response <- newFileReaderResponse{Error: fmt.Errorf("invalid file index: %d", req.Index)}
+		return
+	}
+	var offset int64
+	for _, f := range t.info.Files[:req.Index] {
+		offset += f.Length
+	}
+	f := t.info.Files[req.Index]
+	// Storage may be replaced by relocation in torrent loop, so the file is opened here.
+	file, _, err := t.storage.Open(f.Path, f.Length)
+	if err != nil {
+		req.Response <- newFileReaderResponse{Error: err}
+		return
+	}
+	req.Response <- newFileReaderResponse{Reader: &FileReader{
+		torrent:     t,
+		length:      f.Length,
+		offset:      offset,
+		pieceLength: int64(t.info.PieceLength),
+		file:        file,
+		closeC:      make(chan struct{}),
+	}}
+}
+
+func (t *torrent) handleFileReaderPosition(req fileReaderPositionRequest) {
+	r := req.Reader
+	if req.Position >= r.length {
+		// Nothing to read after end of file.
+		req.Response <- nil
+		if _, ok := t.fileReaders[r]; ok {
+			delete(t.fileReaders, r)
+			t.applyPiecePriorities()
+		}
+		return
+	}
+	begin := r.offset + req.Position
+	end := begin + t.session.config.FileReaderReadAhead
+	if fileEnd := r.offset + r.length; end > fileEnd {
+		end = fileEnd
+	}
+	w := readAheadWindow{
+		Begin: uint32(begin / r.pieceLength),
+		End:   uint32((end-1)/r.pieceLength) + 1,
+	}
+	if req.Wait {
+		waiter := pieceWaiter{Reader: r, Index: w.Begin, C: make(chan struct{})}
+		if t.bitfield != nil && t.bitfield.Test(waiter.Index) {
+			close(waiter.C)
+		} else {
+			t.pieceWaiters = append(t.pieceWaiters, waiter)
+		}
+		req.Response <- waiter.C
+	} else {
+		req.Response <- nil
+	}
+	if old, ok := t.fileReaders[r]; ok && old == w {
+		return
+	}
+	t.fileReaders[r] = w
+	t.applyPiecePriorities()
+}
+
+func (t *torrent) handleCloseFileReader(r *FileReader) {
+	waiters := t.pieceWaiters[:0]
+	for _, w := range t.pieceWaiters {
+		if w.Reader != r {
+			waiters = append(waiters, w)
+		}
+	}
+	t.pieceWaiters = waiters
+	if _, ok := t.fileReaders[r]; !ok {
+		return
+	}
+	delete(t.fileReaders, r)
+	t.applyPiecePriorities()
+}
+
+// notifyPieceWaiters must be called after new pieces are set in the bitfield.
+func (t *torrent) notifyPieceWaiters() {
+	waiters := t.pieceWaiters[:0]
+	for _, w := range t.pieceWaiters {
+		if t.bitfield.Test(w.Index) {
+			close(w.C)
+		} else {
+			waiters = append(waiters, w)
+		}
+	}
+	t.pieceWaiters = waiters
+}
+
+// Read reads up to len(p) bytes from the file.
+// It blocks until the piece at the read position is downloaded and verified.
+// Read may return fewer bytes than len(p) if the read crosses a piece boundary.
+func (r *FileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.length {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	waitC, err := r.torrent.setFileReaderPosition(r, r.pos, true)
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case <-waitC:
+	case <-r.closeC:
+		return 0, errFileReaderClosed
+	case <-r.torrent.closeC:
+		return 0, errClosed
+	}
+	// Do not read past the end of current piece because the next piece may not be downloaded yet.
+	off := r.offset + r.pos
+	pieceEnd := (off/r.pieceLength + 1) * r.pieceLength
+	n := int64(len(p))
+	if n > pieceEnd-off {
+		n = pieceEnd - off
+	}
+	if n > r.length-r.pos {
+		n = r.length - r.pos
+	}
+	m, err := r.file.ReadAt(p[:n], r.pos)
+	r.pos += int64(m)
+	if err == io.EOF && r.pos < r.length {
+		err = io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		err = nil
+	}
+	return m, err
+}
+
+// Seek sets the read position of the file.
+// Pieces after the new position are prioritized for downloading.
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.pos + offset
+	case io.SeekEnd:
+		pos = r.length + offset
+	default:
+		return r.pos, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return r.pos, errors.New("negative position")
+	}
+	r.pos = pos
+	_, err := r.torrent.setFileReaderPosition(r, pos, false)
+	return pos, err
+}
+
+// Close the reader. Pending reads are unblocked and return error.
+func (r *FileReader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.closeC)
+		r.torrent.closeFileReader(r)
+		err = r.file.Close()
+	})
+	return err
+}
@@ -0,0 +1,293 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/panzarasa/rain/internal/piecedownloader"
+	"github.com/panzarasa/rain/internal/piecepicker"
+)
+
+// FilePriority determines which files in a torrent are downloaded first.
+type FilePriority int
+
+const (
+	// PrioritySkip indicates that the file is not going to be downloaded.
+	PrioritySkip FilePriority = iota
+	// PriorityLow indicates that the file is downloaded after files with higher priority.
+	PriorityLow
+	// PriorityNormal is the default priority of files.
+	PriorityNormal
+	// PriorityHigh indicates that the file is downloaded before files with lower priority.
+	PriorityHigh
+)
+
+var filePriorityNames = map[FilePriority]string{
+	PrioritySkip:   "skip",
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+func (p FilePriority) String() string {
+	return filePriorityNames[p]
+}
+
+// ParseFilePriority returns the FilePriority for its name: "skip", "low", "normal" or "high".
+func ParseFilePriority(s string) (FilePriority, error) {
+	for p, name := range filePriorityNames {
+		if name == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid file priority: %q", s)
+}
+
+// File is a file in a torrent.
+type File struct {
+	// Path of the file. For multi-file torrents the path starts with the torrent name.
+	Path string
+	// Length of the file in bytes.
+	Length int64
+	// Download priority of the file.
+	Priority FilePriority
+}
+
+type filesRequest struct {
+	Response chan filesResponse
+}
+
+type filesResponse struct {
+	Files []File
+	Error error
+}
+
+func (t *torrent) Files() ([]File, error) {
+	var resp filesResponse
+	req := filesRequest{Response: make(chan filesResponse, 1)}
+	select {
+	case t.filesCommandC <- req:
+	case <-t.closeC:
+	}
+	select {
+	case resp = <-req.Response:
+	case <-t.closeC:
+	}
+	return resp.Files, resp.Error
+}
+
+type setFilePrioritiesRequest struct {
+	Priorities []FilePriority
+	Response   chan error
+}
+
+func (t *torrent) SetFilePriorities(priorities []FilePriority) error {
+	var err error
+	req := setFilePrioritiesRequest{Priorities: priorities, Response: make(chan error, 1)}
+	select {
+	case t.setFilePrioritiesCommandC <- req:
+	case <-t.closeC:
+		return errClosed
+	}
+	select {
+	case err = <-req.Response:
+	case <-t.closeC:
+		return errClosed
+	}
+	return err
+}
+
+func (t *torrent) SetSequential(value bool) {
+	select {
+	case t.setSequentialCommandC <- value:
+	case <-t.closeC:
+	}
+}
+
+func (t *torrent) handleSetSequential(value bool) {
+	t.sequential = value
+	if t.piecePicker != nil {
+		t.piecePicker.SetSequential(value)
+	}
+}
+
+func (t *torrent) getFiles() filesResponse {
+	if t.info == nil {
+		return filesResponse{Error: errors.New("torrent metadata not ready")}
+	}
+	files := make([]File, len(t.info.Files))
+	for i, f := range t.info.Files {
+		files[i] = File{
+			Path:     f.Path,
+			Length:   f.Length,
+			Priority: t.filePriority(i),
+		}
+	}
+	return filesResponse{Files: files}
+}
+
+func (t *torrent) filePriority(i int) FilePriority {
+	if t.filePriorities == nil {
+		return PriorityNormal
+	}
+	return t.filePriorities[i]
+}
+
+// updatePiecePriorities calculates the priority of each piece from the priorities of files.
+// A piece gets the highest priority of the files that it belongs to.
+// Pieces in read-ahead windows of file readers get a priority higher than all files.
+func (t *torrent) updatePiecePriorities() {
+	if t.filePriorities == nil && len(t.fileReaders) == 0 {
+		t.piecePriorities = nil
+		return
+	}
+	t.piecePriorities = make([]int, t.info.NumPieces)
+	var offset int64
+	for i, f := range t.info.Files {
+		begin := offset
+		offset += f.Length
+		if f.Length == 0 {
+			continue
+		}
+		p := int(t.filePriority(i))
+		first := uint32(begin / int64(t.info.PieceLength))
+		last := uint32((offset - 1) / int64(t.info.PieceLength))
+		for j := first; j <= last; j++ {
+			if p > t.piecePriorities[j] {
+				t.piecePriorities[j] = p
+			}
+		}
+	}
+	for _, w := range t.fileReaders {
+		for j := w.Begin; j < w.End; j++ {
+			t.piecePriorities[j] = readAheadPriority
+		}
+	}
+}
+
+// wantPiece returns true if the piece at index belongs to a file that is selected for download.
+func (t *torrent) wantPiece(i uint32) bool {
+	return t.piecePriorities == nil || t.piecePriorities[i] > 0
+}
+
+// haveAllWantedPieces returns true if all pieces of files that are selected for download are downloaded.
+func (t *torrent) haveAllWantedPieces() bool {
+	if t.piecePriorities == nil {
+		return t.bitfield.All()
+	}
+	for i := uint32(0); i < t.bitfield.Len(); i++ {
+		if !t.bitfield.Test(i) && t.piecePriorities[i] > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *torrent) newPiecePicker() *piecepicker.PiecePicker {
+	pp := piecepicker.New(t.pieces, t.session.config.EndgameMaxDuplicateDownloads, t.webseedSources)
+	pp.SetSequential(t.sequential)
+	t.setPiecePickerPriorities(pp)
+	return pp
+}
+
+func (t *torrent) setPiecePickerPriorities(pp *piecepicker.PiecePicker) {
+	for i := range t.pieces {
+		p := int(PriorityNormal)
+		if t.piecePriorities != nil {
+			p = t.piecePriorities[i]
+		}
+		pp.SetPriority(uint32(i), p)
+	}
+}
+
+func (t *torrent) handleSetFilePriorities(req setFilePrioritiesRequest) {
+	if t.info == nil {
+		req.Response <- errors.New("torrent metadata not ready")
+		return
+	}
+	if len(req.Priorities) != len(t.info.Files) {
+		req.Response <- fmt.Errorf("number of priorities (%d) does not match the number of files (%d)", len(req.Priorities), len(t.info.Files))
+		return
+	}
+	for _, p := range req.Priorities {
+		if p < PrioritySkip || p > PriorityHigh {
+			req.Response <- fmt.Errorf("invalid file priority: %d", p)
+			return
+		}
+	}
+	t.filePriorities = make([]FilePriority, len(req.Priorities))
+	copy(t.filePriorities, req.Priorities)
+	req.Response <- nil
+	t.applyPiecePriorities()
+}
+
+// applyPiecePriorities must be called after file priorities or read-ahead windows of file readers are changed.
+func (t *torrent) applyPiecePriorities() {
+	t.updatePiecePriorities()
+
+	// Priorities are applied to pieces when files are allocated if the torrent is not running.
+	if t.pieces == nil {
+		return
+	}
+
+	// Bitfield is not ready yet. Completion is checked after verification is done.
+	if t.bitfield == nil {
+		return
+	}
+
+	if t.completed {
+		if !t.haveAllWantedPieces() {
+			t.resumeDownload()
+		}
+		return
+	}
+
+	if t.piecePicker != nil {
+		t.setPiecePickerPriorities(t.piecePicker)
+		var skipped []*piecedownloader.PieceDownloader
+		for _, pd := range t.pieceDownloaders {
+			if !t.wantPiece(pd.Piece.Index) {
+				skipped = append(skipped, pd)
+			}
+		}
+		for _, pd := range skipped {
+			t.log.Debugf("cancelling download of skipped piece #%d", pd.Piece.Index)
+			t.closePieceDownloader(pd)
+			pd.CancelPending()
+		}
+	}
+	for pe := range t.peers {
+		t.updateInterestedState(pe)
+	}
+
+	if t.checkCompletion() {
+		t.log.Info("download completed")
+		err := t.writeBitfield()
+		if err != nil {
+			t.stop(err)
+		} else if t.stopAfterDownload {
+			t.stop(nil)
+		}
+		return
+	}
+	t.startPieceDownloaders()
+}
+
+// resumeDownload switches a completed torrent back into downloading state
+// after new files are selected for download or a file reader needs missing pieces.
+func (t *torrent) resumeDownload() {
+	t.log.Info("resuming download of missing pieces")
+	t.completed = false
+	t.completeC = make(chan struct{})
+	t.piecePicker = t.newPiecePicker()
+	for pe := range t.peers {
+		for i := uint32(0); i < pe.Bitfield.Len(); i++ {
+			if pe.Bitfield.Test(i) {
+				t.piecePicker.HandleHave(pe, i)
+			}
+		}
+		t.updateInterestedState(pe)
+	}
+	t.setNeedMorePeers(true)
+	t.startPieceDownloaders()
+}
@@ -1,8 +1,6 @@
 package torrent
 
 import (
-	"net"
-
 	"github.com/panzarasa/rain/internal/handshaker/incominghandshaker"
 	"github.com/panzarasa/rain/internal/handshaker/outgoinghandshaker"
 	"github.com/panzarasa/rain/internal/peersource"
@@ -12,20 +10,23 @@ func (t *torrent) getSKey(sKeyHash [20]byte) []byte {
 	if sKeyHash == t.sKeyHash {
 		return t.infoHash[:]
 	}
+	if t.infoHashV2 != [20]byte{} && sKeyHash == t.sKeyHashV2 {
+		return t.infoHashV2[:]
+	}
 	return nil
 }
 
 func (t *torrent) checkInfoHash(infoHash [20]byte) bool {
-	return infoHash == t.infoHash
+	return infoHash == t.infoHash || (t.infoHashV2 != [20]byte{} && infoHash == t.infoHashV2)
 }
 
 func (t *torrent) handleIncomingHandshakeDone(ih *incominghandshaker.IncomingHandshaker) {
 	delete(t.incomingHandshakers, ih)
 	if ih.Error != nil {
-		delete(t.connectedPeerIPs, ih.Conn.RemoteAddr().(*net.TCPAddr).IP.String())
+		delete(t.connectedPeerIPs, ih.Addr.IP.String())
 		return
 	}
-	t.startPeer(ih.Conn, peersource.Incoming, t.incomingPeers, ih.PeerID, ih.Extensions, ih.Cipher)
+	t.startPeer(ih.Conn, ih.Addr, peersource.Incoming, t.incomingPeers, ih.PeerID, ih.Extensions, ih.Cipher)
 }
 
 func (t *torrent) handleOutgoingHandshakeDone(oh *outgoinghandshaker.OutgoingHandshaker) {
@@ -35,5 +36,5 @@ func (t *torrent) handleOutgoingHandshakeDone(oh *outgoinghandshaker.OutgoingHan
 		t.dialAddresses()
 		return
 	}
-	t.startPeer(oh.Conn, oh.Source, t.outgoingPeers, oh.PeerID, oh.Extensions, oh.Cipher)
+	t.startPeer(oh.Conn, oh.Addr, oh.Source, t.outgoingPeers, oh.PeerID, oh.Extensions, oh.Cipher)
 }
@@ -0,0 +1,139 @@
+package torrent
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/panzarasa/rain/internal/merkle"
+	"github.com/panzarasa/rain/internal/metainfo"
+	"github.com/panzarasa/rain/internal/peer"
+	"github.com/panzarasa/rain/internal/peerprotocol"
+	"github.com/panzarasa/rain/internal/piecelayerdownloader"
+)
+
+// maxHashRequestLength is the maximum number of hashes that can be requested in a single "hash request" message.
+const maxHashRequestLength = 512
+
+// handleHashRequest serves the hashes in piece layers of v2 torrents. See BEP 52.
+// Only requests having the piece layer as base layer can be served because lower layers are not stored.
+func (t *torrent) handleHashRequest(pe *peer.Peer, msg peerprotocol.HashRequestMessage) {
+	hashes, ok := t.hashesFor(msg)
+	if !ok {
+		pe.SendMessage(peerprotocol.HashRejectMessage{HashRequestMessage: msg})
+		return
+	}
+	pe.SendMessage(&peerprotocol.HashesMessage{HashRequestMessage: msg, Hashes: hashes})
+}
+
+func (t *torrent) hashesFor(msg peerprotocol.HashRequestMessage) ([]byte, bool) {
+	if t.info == nil {
+		return nil, false
+	}
+	layer, ok := t.info.PieceLayer(msg.PiecesRoot)
+	if !ok {
+		return nil, false
+	}
+	blocksPerPiece := int(t.info.PieceLength / merkle.BlockSize)
+	if msg.BaseLayer != uint32(bits.TrailingZeros(uint(blocksPerPiece))) {
+		return nil, false
+	}
+	numLeaves := merkle.NumLeaves(len(layer) / merkle.HashSize)
+	length := int(msg.Length)
+	index := int(msg.Index)
+	if length < 2 || length > maxHashRequestLength || length&(length-1) != 0 {
+		return nil, false
+	}
+	if index%length != 0 || index+length > numLeaves {
+		return nil, false
+	}
+	layers := merkle.Layers(layer, numLeaves, merkle.PadHash(blocksPerPiece))
+	level := bits.TrailingZeros(uint(length))
+	// ProofLayers is chosen by the peer. It cannot be more than the number of layers above the requested hashes.
+	if msg.ProofLayers > uint32(len(layers)-1-level) {
+		return nil, false
+	}
+	ret := make([]byte, 0, (length+int(msg.ProofLayers))*merkle.HashSize)
+	ret = append(ret, layers[0][index*merkle.HashSize:(index+length)*merkle.HashSize]...)
+	// Uncle hashes for proving the subtree root that covers the requested hashes.
+	i := index / length
+	for n := uint32(0); n < msg.ProofLayers; n++ {
+		sibling := i ^ 1
+		ret = append(ret, layers[level][sibling*merkle.HashSize:(sibling+1)*merkle.HashSize]...)
+		i /= 2
+		level++
+	}
+	return ret, true
+}
+
+// startPieceLayerDownload starts requesting the piece layers of a v2 only torrent from peers.
+// Info is saved after all piece layers are received.
+func (t *torrent) startPieceLayerDownload(info *metainfo.Info) {
+	t.log.Debugln("downloading piece layers")
+	t.pendingInfo = info
+	t.pieceLayerDownloader = piecelayerdownloader.New(info.PieceLength, info.MissingPieceLayers())
+	t.requestPieceLayers()
+}
+
+func (t *torrent) stopPieceLayerDownload() {
+	t.pendingInfo = nil
+	t.pieceLayerDownloader = nil
+}
+
+func (t *torrent) requestPieceLayers() {
+	if t.pieceLayerDownloader == nil {
+		return
+	}
+	for pe := range t.peers {
+		if !pe.V2Enabled {
+			continue
+		}
+		if t.pieceLayerDownloader.RequestHashes(pe) > 0 {
+			pe.ResetSnubTimer()
+		}
+	}
+}
+
+func (t *torrent) handleHashes(pe *peer.Peer, msg peerprotocol.HashesMessage) {
+	d := t.pieceLayerDownloader
+	if d == nil {
+		return
+	}
+	err := d.GotHashes(pe, msg)
+	if err != nil {
+		pe.Logger().Error(err)
+		t.closePeer(pe)
+		return
+	}
+	if d.Pending(pe) > 0 {
+		pe.ResetSnubTimer()
+	} else {
+		pe.StopSnubTimer()
+	}
+	if !d.Done() {
+		return
+	}
+	info := t.pendingInfo
+	pieceLayers, err := d.PieceLayers()
+	t.stopPieceLayerDownload()
+	if err == nil {
+		err = info.SetPieceLayers(pieceLayers)
+	}
+	if err != nil {
+		t.stop(fmt.Errorf("cannot set piece layers: %s", err))
+		return
+	}
+	t.setInfo(info)
+}
+
+func (t *torrent) handleHashReject(pe *peer.Peer, msg peerprotocol.HashRejectMessage) {
+	d := t.pieceLayerDownloader
+	if d == nil {
+		return
+	}
+	pe.Logger().Debugln("hash request rejected for index:", msg.Index, "length:", msg.Length)
+	d.Rejected(pe, msg.HashRequestMessage)
+	if d.Pending(pe) == 0 {
+		pe.StopSnubTimer()
+	}
+	t.requestPieceLayers()
+}
@@ -0,0 +1,60 @@
+package torrent
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/panzarasa/rain/internal/merkle"
+	"github.com/panzarasa/rain/internal/metainfo"
+	"github.com/panzarasa/rain/internal/peerprotocol"
+	"github.com/zeebo/bencode"
+)
+
+func TestHashesForProofLayers(t *testing.T) {
+	tmp, closeTmp := tempdir(t)
+	defer closeTmp()
+	path := filepath.Join(tmp, "file.bin")
+	err := ioutil.WriteFile(path, make([]byte, 16*merkle.BlockSize), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, layers, err := metainfo.NewInfoBytesV2(path, false, merkle.BlockSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := metainfo.NewInfo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = info.SetPieceLayers(layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string][]byte
+	err = bencode.DecodeBytes(layers, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root [32]byte
+	for k := range m {
+		copy(root[:], k)
+	}
+
+	tor := &torrent{info: info}
+	// 16 leaves, so 3 layers are above a subtree of 2 hashes.
+	req := peerprotocol.HashRequestMessage{PiecesRoot: root, Length: 2, ProofLayers: 3}
+	hashes, ok := tor.hashesFor(req)
+	if !ok {
+		t.Fatal("request must be accepted")
+	}
+	if len(hashes) != 5*merkle.HashSize {
+		t.Fatalf("unexpected length: %d", len(hashes))
+	}
+	for _, n := range []uint32{4, 1 << 31} {
+		req.ProofLayers = n
+		if _, ok = tor.hashesFor(req); ok {
+			t.Fatalf("request with %d proof layers must be rejected", n)
+		}
+	}
+}
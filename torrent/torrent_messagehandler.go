@@ -128,6 +128,8 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 		// pe.Logger().Debug("Peer ", pe.String(), " has piece #", pi.Index)
 		if t.piecePicker != nil {
 			t.piecePicker.HandleHave(pe, msg.Index)
+		} else {
+			pe.Bitfield.Set(msg.Index)
 		}
 		t.updateInterestedState(pe)
 		t.startPieceDownloaderFor(pe)
@@ -148,11 +150,14 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 			break
 		}
 		pe.Logger().Debugln("Received bitfield:", bf.Hex())
-		if t.piecePicker != nil {
-			for i := uint32(0); i < bf.Len(); i++ {
-				if bf.Test(i) {
-					t.piecePicker.HandleHave(pe, i)
-				}
+		for i := uint32(0); i < bf.Len(); i++ {
+			if !bf.Test(i) {
+				continue
+			}
+			if t.piecePicker != nil {
+				t.piecePicker.HandleHave(pe, i)
+			} else {
+				pe.Bitfield.Set(i)
 			}
 		}
 		t.updateInterestedState(pe)
@@ -162,9 +167,11 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 			pe.Messages = append(pe.Messages, msg)
 			break
 		}
-		if t.piecePicker != nil {
-			for _, pi := range t.pieces {
+		for _, pi := range t.pieces {
+			if t.piecePicker != nil {
 				t.piecePicker.HandleHave(pe, pi.Index)
+			} else {
+				pe.Bitfield.Set(pi.Index)
 			}
 		}
 		t.updateInterestedState(pe)
@@ -301,6 +308,12 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 				Length: msg.Length,
 			}})
 		}
+	case peerprotocol.HashRequestMessage:
+		t.handleHashRequest(pe, msg)
+	case peerprotocol.HashesMessage:
+		t.handleHashes(pe, msg)
+	case peerprotocol.HashRejectMessage:
+		t.handleHashReject(pe, msg)
 	case peerprotocol.PortMessage:
 		if t.session.dht != nil {
 			t.session.dht.AddNode(fmt.Sprintf("%s:%d", pe.IP(), msg.Port))
@@ -331,29 +344,36 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 				}
 			}
 		}
+		t.handleCustomExtensionHandshake(pe, msg)
 	case peerprotocol.ExtensionMetadataMessage:
 		t.handleMetadataMessage(pe, msg)
 	case peerprotocol.ExtensionPEXMessage:
 		if !t.session.config.PEXEnabled {
 			break
 		}
-		addrs, err := tracker.DecodePeersCompact([]byte(msg.Added))
-		if err != nil {
-			t.log.Error(err)
-			break
-		}
-		t.handleNewPeers(addrs, peersource.PEX)
-		addrs, err = tracker.DecodePeersCompact([]byte(msg.Dropped))
-		if err != nil {
-			t.log.Error(err)
-			break
-		}
-		t.handleNewPeers(addrs, peersource.PEX)
+		t.handlePEXPeers(msg.Added, tracker.DecodePeersCompact)
+		t.handlePEXPeers(msg.Dropped, tracker.DecodePeersCompact)
+		t.handlePEXPeers(msg.Added6, tracker.DecodePeersCompact6)
+		t.handlePEXPeers(msg.Dropped6, tracker.DecodePeersCompact6)
+	case peerprotocol.ExtensionRawMessage:
+		t.handleCustomExtensionMessage(pe, msg)
 	default:
 		panic(fmt.Sprintf("unhandled peer message type: %T", msg))
 	}
 }
 
+func (t *torrent) handlePEXPeers(peers string, decode func([]byte) ([]*net.TCPAddr, error)) {
+	if len(peers) == 0 {
+		return
+	}
+	addrs, err := decode([]byte(peers))
+	if err != nil {
+		t.log.Error(err)
+		return
+	}
+	t.handleNewPeers(addrs, peersource.PEX)
+}
+
 func (t *torrent) updateInterestedState(pe *peer.Peer) {
 	if t.pieces == nil || t.bitfield == nil {
 		return
@@ -363,7 +383,7 @@ func (t *torrent) updateInterestedState(pe *peer.Peer) {
 		for i := uint32(0); i < t.bitfield.Len(); i++ {
 			weHave := t.bitfield.Test(i)
 			peerHave := pe.Bitfield.Test(i)
-			if !weHave && peerHave {
+			if !weHave && peerHave && t.wantPiece(i) {
 				interested = true
 				break
 			}
@@ -2,11 +2,11 @@ package torrent
 
 import (
 	"bytes"
-	"crypto/sha1" // nolint: gosec
 	"errors"
 	"fmt"
 
 	"github.com/panzarasa/rain/internal/bufferpool"
+	"github.com/panzarasa/rain/internal/metainfo"
 	"github.com/panzarasa/rain/internal/peer"
 	"github.com/panzarasa/rain/internal/peerprotocol"
 )
@@ -71,9 +71,14 @@ func (t *torrent) handleMetadataMessage(pe *peer.Peer, msg peerprotocol.Extensio
 		}
 		pe.StopSnubTimer()
 
-		hash := sha1.New()                              // nolint: gosec
-		_, _ = hash.Write(id.Bytes)                     // nolint: gosec
-		if !bytes.Equal(hash.Sum(nil), t.infoHash[:]) { // nolint: gosec
+		info, err := metainfo.NewInfo(id.Bytes)
+		if err != nil {
+			pe.Logger().Errorln("cannot parse info bytes:", err)
+			t.closePeer(id.Peer.(*peer.Peer))
+			t.startInfoDownloaders()
+			break
+		}
+		if !t.infoHashMatches(info) {
 			pe.Logger().Errorln("received info does not match with hash")
 			t.closePeer(id.Peer.(*peer.Peer))
 			t.startInfoDownloaders()
@@ -81,23 +86,20 @@ func (t *torrent) handleMetadataMessage(pe *peer.Peer, msg peerprotocol.Extensio
 		}
 		t.stopInfoDownloaders()
 
-		info, err := t.session.parseInfo(id.Bytes)
-		if err != nil {
-			t.stop(fmt.Errorf("cannot parse info bytes: %s", err))
+		if info.NumPieces > t.session.config.MaxPieces {
+			t.stop(errTooManyPieces)
 			break
 		}
 		if info.Private {
 			t.stop(errors.New("private torrent from magnet"))
 			break
 		}
-		t.info = info
-		t.piecePool = bufferpool.New(int(info.PieceLength))
-		err = t.session.resumer.WriteInfo(t.id, t.info.Bytes)
-		if err != nil {
-			t.stop(fmt.Errorf("cannot write resume info: %s", err))
+		if info.IsV2Only() && len(info.MissingPieceLayers()) > 0 {
+			// Piece hashes of v2 only torrents are not in the info dict.
+			t.startPieceLayerDownload(info)
 			break
 		}
-		t.startAllocator()
+		t.setInfo(info)
 	case peerprotocol.ExtensionMetadataMessageTypeReject:
 		id, ok := t.infoDownloaders[pe]
 		if ok {
@@ -107,6 +109,29 @@ func (t *torrent) handleMetadataMessage(pe *peer.Peer, msg peerprotocol.Extensio
 	}
 }
 
+// infoHashMatches returns true if the info that is received from peers belongs to the torrent.
+// Hash of v2 only torrents is the truncated SHA-256 hash of the info dict.
+// Hybrid torrents may be added with either the SHA-1 hash or the truncated SHA-256 hash.
+func (t *torrent) infoHashMatches(info *metainfo.Info) bool {
+	if info.Hash == t.infoHash {
+		return true
+	}
+	return info.MetaVersion == 2 && bytes.Equal(info.HashV2[:len(t.infoHash)], t.infoHash[:])
+}
+
+// setInfo saves the info that is received from peers and starts allocating files.
+func (t *torrent) setInfo(info *metainfo.Info) {
+	t.info = info
+	t.piecePool = bufferpool.New(int(info.PieceLength))
+	err := t.session.resumer.WriteInfo(t.id, info.Bytes, info.PieceLayers)
+	if err != nil {
+		t.stop(fmt.Errorf("cannot write resume info: %s", err))
+		return
+	}
+	t.publishEvent(EventMetadataReceived)
+	t.startAllocator()
+}
+
 func (t *torrent) sendMetadataReject(pe *peer.Peer, i uint32, msgID uint8) {
 	dataMsg := peerprotocol.ExtensionMetadataMessage{
 		Type:  peerprotocol.ExtensionMetadataMessageTypeReject,
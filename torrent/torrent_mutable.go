@@ -0,0 +1,147 @@
+package torrent
+
+import (
+	"crypto/sha1" // nolint: gosec
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/panzarasa/rain/internal/allocator"
+	"github.com/panzarasa/rain/internal/logger"
+	"github.com/panzarasa/rain/internal/metainfo"
+	"github.com/panzarasa/rain/internal/piece"
+	"github.com/panzarasa/rain/storage"
+	"github.com/panzarasa/rain/storage/filestorage"
+)
+
+// previousVersion of a mutable torrent that is replaced by a new version.
+type previousVersion struct {
+	// Info of the previous version. It is nil if the previous version is loaded from the resume db, then files are not reused.
+	info *metainfo.Info
+	dir  string
+}
+
+// reuseFiles copies the files of the previous version that have the same path and length into the directory of the new version.
+// Files are copied, not linked, because the previous version may still be seeding its own files.
+// A file is reused only if all pieces of the new version that contain data of the file are verified against the files of the previous version.
+func (pv *previousVersion) reuseFiles(info *metainfo.Info, sto storage.Storage, l logger.Logger) {
+	fs, ok := sto.(*filestorage.FileStorage)
+	if !ok {
+		return
+	}
+	dir := fs.Dest()
+	if filepath.Clean(dir) == filepath.Clean(pv.dir) {
+		// Files are already in place.
+		return
+	}
+	if pv.info == nil {
+		// Lengths of the files in the previous version are unknown.
+		l.Debugln("info of previous version is unknown, files are not reused")
+		return
+	}
+	lengths := make(map[string]int64, len(pv.info.Files))
+	for _, f := range pv.info.Files {
+		if !f.Padding {
+			lengths[filepath.Clean(f.Path)] = f.Length
+		}
+	}
+	files := make([]allocator.File, len(info.Files))
+	// Modes of files that can be reused by path.
+	candidates := make(map[string]os.FileMode)
+	for i, f := range info.Files {
+		files[i] = allocator.File{Storage: missingFile{}, Name: f.Path}
+		if f.Padding {
+			files[i].Storage = zeroFile{}
+			continue
+		}
+		if f.Length == 0 {
+			continue
+		}
+		name := filepath.Clean(f.Path)
+		if length, ok := lengths[name]; !ok || length != f.Length {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			continue
+		}
+		src, err := os.Open(filepath.Join(pv.dir, name))
+		if err != nil {
+			continue
+		}
+		defer src.Close()
+		fi, err := src.Stat()
+		if err != nil || fi.Size() != f.Length {
+			continue
+		}
+		files[i].Storage = src
+		candidates[f.Path] = fi.Mode()
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	pieces := piece.NewPieces(info, files)
+	buf := make([]byte, info.PieceLength)
+	hash := sha1.New() // nolint: gosec
+	for _, p := range pieces {
+		var found bool
+		for _, sec := range p.Data {
+			if _, ok := candidates[sec.Name]; ok {
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+		buf = buf[:p.Length]
+		hash.Reset()
+		_, err := p.Data.ReadAt(buf, 0)
+		if err == nil && p.VerifyHash(buf, hash) {
+			continue
+		}
+		for _, sec := range p.Data {
+			delete(candidates, sec.Name)
+		}
+	}
+	for _, f := range info.Files {
+		mode, ok := candidates[f.Path]
+		if !ok {
+			continue
+		}
+		name := filepath.Clean(f.Path)
+		src := filepath.Join(pv.dir, name)
+		dst := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0750)
+		if err == nil {
+			err = copyFile(src, dst, mode)
+		}
+		if err != nil {
+			l.Warningf("cannot reuse file %s of previous version: %s", name, err)
+			_ = os.Remove(dst)
+			continue
+		}
+		l.Debugf("reused file of previous version: %s", name)
+	}
+}
+
+var errMissingFile = errors.New("file of previous version is missing")
+
+// missingFile is used in place of files that do not exist in the previous version. Pieces containing them cannot be verified.
+type missingFile struct{}
+
+func (missingFile) ReadAt(p []byte, off int64) (int, error)  { return 0, errMissingFile }
+func (missingFile) WriteAt(p []byte, off int64) (int, error) { return 0, errMissingFile }
+func (missingFile) Close() error                             { return nil }
+
+// zeroFile is used in place of padding files. Reads return zeros.
+type zeroFile struct{}
+
+func (zeroFile) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (zeroFile) WriteAt(p []byte, off int64) (int, error) { return len(p), nil }
+
+func (zeroFile) Close() error { return nil }
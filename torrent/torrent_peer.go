@@ -21,6 +21,9 @@ func (t *torrent) setNeedMorePeers(val bool) {
 	if t.dhtAnnouncer != nil {
 		t.dhtAnnouncer.NeedMorePeers(val)
 	}
+	if t.lsdAnnouncer != nil {
+		t.lsdAnnouncer.NeedMorePeers(val)
+	}
 }
 
 func (t *torrent) addPeerString(addr string) error {
@@ -56,7 +59,7 @@ func (t *torrent) resolveAndAddPeer(host string, port int) {
 		}
 		cancel()
 	}()
-	ip, err := resolver.ResolveIPv4(ctx, t.session.config.DNSResolveTimeout, host)
+	ip, err := resolver.ResolveIP(ctx, t.session.config.DNSResolveTimeout, host)
 	if err != nil {
 		return
 	}
@@ -104,7 +107,12 @@ func (t *torrent) dialAddresses() {
 		if _, ok := t.connectedPeerIPs[ip]; ok {
 			continue
 		}
-		h := outgoinghandshaker.New(addr, src)
+		utpSocket := t.utpSocket
+		if t.session.proxyPeers() {
+			// uTP cannot go through the proxy.
+			utpSocket = nil
+		}
+		h := outgoinghandshaker.New(addr, src, utpSocket, t.session.peerDial)
 		t.outgoingHandshakers[h] = struct{}{}
 		t.connectedPeerIPs[ip] = struct{}{}
 		go h.Run(
@@ -122,13 +130,13 @@ func (t *torrent) dialAddresses() {
 
 func (t *torrent) startPeer(
 	conn net.Conn,
+	addr *net.TCPAddr,
 	source peersource.Source,
 	peers map[*peer.Peer]struct{},
 	peerID [20]byte,
 	extensions [8]byte,
 	cipher mse.CryptoMethod,
 ) {
-	addr := conn.RemoteAddr().(*net.TCPAddr)
 	t.pexAddPeer(addr)
 	_, ok := t.peerIDs[peerID]
 	if ok {
@@ -140,7 +148,7 @@ func (t *torrent) startPeer(
 	}
 	t.peerIDs[peerID] = struct{}{}
 
-	pe := peer.New(conn, source, peerID, extensions, cipher, t.session.config.PieceReadTimeout, t.session.config.RequestTimeout, t.session.config.MaxRequestsIn, t.session.bucketDownload, t.session.bucketUpload)
+	pe := peer.New(conn, addr, source, peerID, extensions, cipher, t.session.config.PieceReadTimeout, t.session.config.RequestTimeout, t.session.config.MaxRequestsIn, t.downloadLimiter, t.uploadLimiter)
 	t.peers[pe] = struct{}{}
 	peers[pe] = struct{}{}
 	if t.info != nil {
@@ -150,6 +158,7 @@ func (t *torrent) startPeer(
 	t.session.metrics.Peers.Inc(1)
 	t.sendFirstMessage(pe)
 	t.recentlySeen.Add(pe.Addr())
+	t.requestPieceLayers()
 }
 
 func (t *torrent) sendFirstMessage(p *peer.Peer) {
@@ -173,6 +182,7 @@ func (t *torrent) sendFirstMessage(p *peer.Peer) {
 	}
 	if p.ExtensionsEnabled {
 		extHandshakeMsg := peerprotocol.NewExtensionHandshake(metadataSize, t.getClientVersion(), p.Addr().IP, t.session.config.MaxRequestsIn)
+		t.session.customExtensions.addToHandshake(&extHandshakeMsg)
 		msg := peerprotocol.ExtensionMessage{
 			ExtendedMessageID: peerprotocol.ExtensionIDHandshake,
 			Payload:           extHandshakeMsg,
@@ -223,5 +233,9 @@ func (t *torrent) handlePeerSnubbed(pe *peer.Peer) {
 		pe.Snubbed = true
 		t.infoDownloadersSnubbed[pe] = id
 		t.startInfoDownloaders()
+	} else if t.pieceLayerDownloader != nil && t.pieceLayerDownloader.Pending(pe) > 0 {
+		// Requests are sent to other peers.
+		t.pieceLayerDownloader.Snubbed(pe)
+		t.requestPieceLayers()
 	}
 }
@@ -20,11 +20,14 @@ func (t *torrent) checkCompletion() bool {
 	if t.completed {
 		return true
 	}
-	if !t.bitfield.All() {
+	if !t.haveAllWantedPieces() {
 		return false
 	}
 	t.completed = true
 	close(t.completeC)
+	t.publishEvent(EventTorrentCompleted)
+	// Next torrent in queue may start downloading.
+	t.session.triggerQueue()
 	for h := range t.outgoingHandshakers {
 		h.Close()
 	}
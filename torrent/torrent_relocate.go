@@ -0,0 +1,179 @@
+package torrent
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/panzarasa/rain/storage"
+	"github.com/panzarasa/rain/storage/filestorage"
+)
+
+type relocateRequest struct {
+	DataDir  string
+	Response chan error
+}
+
+type relocateResult struct {
+	Storage *filestorage.FileStorage
+	Error   error
+}
+
+func (t *torrent) relocate(dataDir string) error {
+	var err error
+	req := relocateRequest{DataDir: dataDir, Response: make(chan error, 1)}
+	select {
+	case t.relocateCommandC <- req:
+	case <-t.closeC:
+		return errClosed
+	}
+	select {
+	case err = <-req.Response:
+	case <-t.closeC:
+		return errClosed
+	}
+	return err
+}
+
+// handleRelocate stops the torrent and starts moving its files in background.
+// Response of the request is sent after the files are moved.
+func (t *torrent) handleRelocate(req relocateRequest) {
+	if t.relocating != nil {
+		req.Response <- errors.New("torrent is already being relocated")
+		return
+	}
+	src, ok := t.storage.(*filestorage.FileStorage)
+	if !ok {
+		req.Response <- errors.New("storage does not support relocation")
+		return
+	}
+	dst, err := filestorage.New(req.DataDir)
+	if err != nil {
+		req.Response <- err
+		return
+	}
+	if dst.Dest() == src.Dest() {
+		req.Response <- nil
+		return
+	}
+	// Stopping the torrent closes open files.
+	t.stop(nil)
+	t.relocating = &req
+	var name string
+	if t.info != nil {
+		name = t.info.Name
+	}
+	removeSrc := t.session.isTorrentIDDir(t.id, src.Dest())
+	t.log.Infof("relocating files from %s to %s", src.Dest(), dst.Dest())
+	go func() {
+		err := moveTorrentData(src.Dest(), dst.Dest(), name, removeSrc)
+		t.relocateResultC <- relocateResult{Storage: dst, Error: err}
+	}()
+}
+
+func (t *torrent) handleRelocateDone(res relocateResult) {
+	req := t.relocating
+	t.relocating = nil
+	if res.Error != nil {
+		t.log.Errorln("cannot relocate files:", res.Error)
+	} else {
+		t.mStorage.Lock()
+		t.storage = res.Storage
+		t.mStorage.Unlock()
+		t.log.Info("files are relocated")
+	}
+	req.Response <- res.Error
+}
+
+// getStorage returns the current storage of the torrent. It is safe to call outside of the torrent loop.
+func (t *torrent) getStorage() storage.Storage {
+	t.mStorage.RLock()
+	defer t.mStorage.RUnlock()
+	return t.storage
+}
+
+// isTorrentIDDir returns true if dir is the directory under Config.DataDir that is named with the torrent ID.
+// Such directory contains only the files of that torrent.
+func (s *Session) isTorrentIDDir(id, dir string) bool {
+	if !s.config.DataDirIncludesTorrentID {
+		return false
+	}
+	idDir, err := filepath.Abs(filepath.Join(s.config.DataDir, id))
+	return err == nil && dir == idDir
+}
+
+// moveTorrentData moves the top level file or directory of a torrent from src dir to dst dir.
+// If removeSrc is true, src dir is removed if it becomes empty.
+func moveTorrentData(src, dst, name string, removeSrc bool) error {
+	if name == "" {
+		// Metadata is not downloaded yet, there are no files.
+		return nil
+	}
+	from := filepath.Join(src, name)
+	to := filepath.Join(dst, name)
+	_, err := os.Lstat(from)
+	if os.IsNotExist(err) {
+		// Files are not allocated yet.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = os.Lstat(to)
+	if err == nil {
+		return errors.New("target already exists: " + to)
+	}
+	err = os.MkdirAll(dst, os.ModeDir|0750)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(from, to)
+	if err != nil {
+		// Rename does not work between different file systems.
+		err = copyTree(from, to)
+		if err != nil {
+			_ = os.RemoveAll(to)
+			return err
+		}
+		err = os.RemoveAll(from)
+		if err != nil {
+			return err
+		}
+	}
+	if removeSrc {
+		_ = os.Remove(src)
+	}
+	return nil
+}
+
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, path[len(src):])
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModeDir|0750)
+		}
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
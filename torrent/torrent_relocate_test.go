@@ -0,0 +1,68 @@
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRelocate(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+	tmp, closeTmp := tempdir(t)
+	defer closeTmp()
+
+	src := filepath.Join(tmp, "src")
+	err := os.MkdirAll(src, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CopyDir(filepath.Join(torrentDataDir, torrentName), filepath.Join(src, torrentName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tor, err := s.AddTorrent(f, &AddTorrentOptions{DataDir: src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, tor, Seeding)
+
+	dst := filepath.Join(tmp, "dst")
+	err = tor.Relocate(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dst, torrentName, "README")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(src, torrentName)); !os.IsNotExist(err) {
+		t.Fatal("files are not removed from source directory")
+	}
+	if dir := tor.Stats().DataDir; dir != dst {
+		t.Fatalf("unexpected data dir: %s", dir)
+	}
+	spec, err := s.resumer.Read(tor.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.DataDir != dst {
+		t.Fatalf("unexpected data dir in resume db: %s", spec.DataDir)
+	}
+	waitStatus(t, tor, Seeding)
+}
+
+func waitStatus(t *testing.T, tor *Torrent, status Status) {
+	deadline := time.Now().Add(timeout)
+	for tor.Stats().Status != status {
+		if time.Now().After(deadline) {
+			t.Fatalf("torrent status is not %s", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
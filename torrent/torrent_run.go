@@ -60,8 +60,31 @@ func (t *torrent) run() {
 			t.handleNewPeers(addrs, peersource.Manual)
 		case addrs := <-t.dhtPeersC:
 			t.handleNewPeers(addrs, peersource.DHT)
+		case addrs := <-t.lsdPeersC:
+			// Private torrents must not use local service discovery.
+			if t.info == nil || !t.info.Private {
+				t.handleNewPeers(addrs, peersource.LSD)
+			}
 		case trackers := <-t.addTrackersCommandC:
 			t.handleNewTrackers(trackers)
+		case req := <-t.filesCommandC:
+			req.Response <- t.getFiles()
+		case req := <-t.setFilePrioritiesCommandC:
+			t.handleSetFilePriorities(req)
+		case value := <-t.setSequentialCommandC:
+			t.handleSetSequential(value)
+		case req := <-t.newFileReaderCommandC:
+			t.handleNewFileReader(req)
+		case req := <-t.fileReaderPositionCommandC:
+			t.handleFileReaderPosition(req)
+		case r := <-t.closeFileReaderCommandC:
+			t.handleCloseFileReader(r)
+		case req := <-t.queueStateCommandC:
+			req.Response <- t.getQueueState()
+		case req := <-t.relocateCommandC:
+			t.handleRelocate(req)
+		case res := <-t.relocateResultC:
+			t.handleRelocateDone(res)
 		case conn := <-t.incomingConnC:
 			t.handleNewConnection(conn)
 		case res := <-t.webseedPieceResultC.ReceiveC():
@@ -72,6 +95,7 @@ func (t *torrent) run() {
 			t.handlePieceWriteDone(pw)
 		case now := <-t.seedDurationTicker.C:
 			t.updateSeedDuration(now)
+			t.checkSeedLimits()
 		case pe := <-t.peerSnubbedC:
 			t.handlePeerSnubbed(pe)
 		case <-t.unchokeTicker.C:
@@ -0,0 +1,156 @@
+package torrent
+
+import (
+	"fmt"
+	"time"
+)
+
+// SeedAction is the action taken when a torrent reaches its seed ratio or seed time limit.
+type SeedAction int
+
+const (
+	// SeedActionDefault uses the action in Config.
+	SeedActionDefault SeedAction = iota
+	// SeedActionStop stops the torrent.
+	SeedActionStop
+	// SeedActionRemove removes the torrent from Session but keeps downloaded files.
+	SeedActionRemove
+	// SeedActionRemoveData removes the torrent from Session and deletes downloaded files.
+	SeedActionRemoveData
+)
+
+var seedActionNames = map[SeedAction]string{
+	SeedActionDefault:    "",
+	SeedActionStop:       "stop",
+	SeedActionRemove:     "remove",
+	SeedActionRemoveData: "remove-data",
+}
+
+func (a SeedAction) String() string {
+	return seedActionNames[a]
+}
+
+// ParseSeedAction returns the SeedAction for its name: "stop", "remove" or "remove-data".
+// Empty string returns SeedActionDefault.
+func ParseSeedAction(s string) (SeedAction, error) {
+	for a, name := range seedActionNames {
+		if name == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid seed action: %q", s)
+}
+
+// seedLimits contains the conditions for ending seeding of a torrent.
+// Zero limit means the limit is disabled.
+type seedLimits struct {
+	Ratio       float64
+	RatioAction SeedAction
+	Time        time.Duration
+	TimeAction  SeedAction
+}
+
+// defaultSeedLimits returns the seed limits in Config that are applied to new torrents.
+func defaultSeedLimits(cfg *Config) (seedLimits, error) {
+	l := seedLimits{
+		Ratio: cfg.SeedRatioLimit,
+		Time:  cfg.SeedTimeLimit,
+	}
+	var err error
+	l.RatioAction, err = ParseSeedAction(cfg.SeedRatioAction)
+	if err != nil {
+		return l, err
+	}
+	l.TimeAction, err = ParseSeedAction(cfg.SeedTimeAction)
+	if err != nil {
+		return l, err
+	}
+	if l.RatioAction == SeedActionDefault {
+		l.RatioAction = SeedActionStop
+	}
+	if l.TimeAction == SeedActionDefault {
+		l.TimeAction = SeedActionStop
+	}
+	if l.Ratio < 0 {
+		l.Ratio = 0
+	}
+	if l.Time < 0 {
+		l.Time = 0
+	}
+	return l, nil
+}
+
+// seedLimits returns seed limits of a new torrent by filling unset options from session defaults.
+func (s *Session) seedLimits(opt *AddTorrentOptions) seedLimits {
+	l := s.defaultSeedLimits
+	switch {
+	case opt.SeedRatioLimit < 0:
+		l.Ratio = 0
+	case opt.SeedRatioLimit > 0:
+		l.Ratio = opt.SeedRatioLimit
+	}
+	switch {
+	case opt.SeedTimeLimit < 0:
+		l.Time = 0
+	case opt.SeedTimeLimit > 0:
+		l.Time = opt.SeedTimeLimit
+	}
+	if opt.SeedRatioAction != SeedActionDefault {
+		l.RatioAction = opt.SeedRatioAction
+	}
+	if opt.SeedTimeAction != SeedActionDefault {
+		l.TimeAction = opt.SeedTimeAction
+	}
+	return l
+}
+
+// seedRatio returns the ratio of uploaded bytes to downloaded bytes.
+// If the torrent is not downloaded in this client, the size of completed data is used instead.
+func (t *torrent) seedRatio() float64 {
+	downloaded := t.bytesDownloaded.Count()
+	if downloaded == 0 && t.info != nil {
+		downloaded = t.bytesComplete()
+	}
+	if downloaded == 0 {
+		return 0
+	}
+	return float64(t.bytesUploaded.Count()) / float64(downloaded)
+}
+
+// checkSeedLimits stops or removes the torrent if it has reached one of its seed limits.
+func (t *torrent) checkSeedLimits() {
+	if t.status() != Seeding {
+		return
+	}
+	if t.seedLimits.Ratio > 0 {
+		if ratio := t.seedRatio(); ratio >= t.seedLimits.Ratio {
+			t.log.Infof("seed ratio limit is reached: %.2f", ratio)
+			t.doSeedAction(t.seedLimits.RatioAction)
+			return
+		}
+	}
+	if t.seedLimits.Time > 0 {
+		if seededFor := time.Duration(t.seededFor.Count()); seededFor >= t.seedLimits.Time {
+			t.log.Infof("seed time limit is reached: %s", seededFor.Truncate(time.Second))
+			t.doSeedAction(t.seedLimits.TimeAction)
+			return
+		}
+	}
+}
+
+func (t *torrent) doSeedAction(action SeedAction) {
+	t.session.setQueued(t, false)
+	t.stop(nil)
+	switch action {
+	case SeedActionStop:
+		err := t.session.resumer.WriteStarted(t.id, false)
+		if err != nil {
+			t.log.Errorln("cannot write start status to resume db:", err)
+		}
+	case SeedActionRemove:
+		// Removing the torrent waits for the torrent loop to exit.
+		go func() { _ = t.session.removeTorrent(t.id, false) }()
+	case SeedActionRemoveData:
+		go func() { _ = t.session.removeTorrent(t.id, true) }()
+	}
+}
@@ -0,0 +1,32 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionSeedLimits(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.SeedRatioLimit = 2
+	cfg.SeedTimeAction = "remove-data"
+	def, err := defaultSeedLimits(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Session{defaultSeedLimits: def}
+
+	l := s.seedLimits(&AddTorrentOptions{})
+	if l.Ratio != 2 || l.RatioAction != SeedActionStop || l.Time != 0 || l.TimeAction != SeedActionRemoveData {
+		t.Errorf("unexpected default limits: %+v", l)
+	}
+
+	l = s.seedLimits(&AddTorrentOptions{SeedRatioLimit: -1, SeedTimeLimit: time.Hour, SeedTimeAction: SeedActionRemove})
+	if l.Ratio != 0 || l.Time != time.Hour || l.TimeAction != SeedActionRemove {
+		t.Errorf("unexpected torrent limits: %+v", l)
+	}
+
+	cfg.SeedRatioAction = "delete"
+	if _, err = defaultSeedLimits(&cfg); err == nil {
+		t.Error("invalid action must return error")
+	}
+}
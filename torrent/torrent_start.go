@@ -6,13 +6,16 @@ import (
 	"github.com/panzarasa/rain/internal/acceptor"
 	"github.com/panzarasa/rain/internal/allocator"
 	"github.com/panzarasa/rain/internal/announcer"
+	"github.com/panzarasa/rain/internal/metainfo"
 	"github.com/panzarasa/rain/internal/peer"
 	"github.com/panzarasa/rain/internal/piecedownloader"
 	"github.com/panzarasa/rain/internal/piecepicker"
 	"github.com/panzarasa/rain/internal/tracker"
 	"github.com/panzarasa/rain/internal/urldownloader"
+	"github.com/panzarasa/rain/internal/utp"
 	"github.com/panzarasa/rain/internal/verifier"
 	"github.com/panzarasa/rain/internal/webseedsource"
+	"github.com/panzarasa/rain/storage"
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -21,6 +24,11 @@ func (t *torrent) start() {
 	if t.errC != nil {
 		return
 	}
+	// Files are being moved. Torrent can be started after relocation is done.
+	if t.relocating != nil {
+		return
+	}
+	t.publishEvent(EventTorrentStarted)
 
 	// Stop announcing Stopped event if in "Stopping" state.
 	if t.stoppedEventAnnouncer != nil {
@@ -72,6 +80,15 @@ func (t *torrent) startAllocator() {
 		panic("allocator exists")
 	}
 	t.allocator = allocator.New()
+	if pv := t.previousVersion; pv != nil {
+		// Files are reused only once, when the info of the new version is available.
+		t.previousVersion = nil
+		go func(al *allocator.Allocator, info *metainfo.Info, sto storage.Storage) {
+			pv.reuseFiles(info, sto, t.log)
+			al.Run(info, sto, t.allocatorProgressC, t.allocatorResultC)
+		}(t.allocator, t.info, t.storage)
+		return
+	}
 	go t.allocator.Run(t.info, t.storage, t.allocatorProgressC, t.allocatorResultC)
 }
 
@@ -91,6 +108,12 @@ func (t *torrent) startAnnouncers() {
 		t.dhtAnnouncer = announcer.NewDHTAnnouncer()
 		go t.dhtAnnouncer.Run(t.announceDHT, t.session.config.DHTAnnounceInterval, t.session.config.DHTMinAnnounceInterval, t.log)
 	}
+	if t.lsdAnnouncer == nil && t.session.lsd != nil && (t.info == nil || !t.info.Private) {
+		t.lsdAnnouncer = announcer.NewDHTAnnouncer()
+		infoHash, port := t.infoHash, t.port
+		announce := func() { t.session.lsd.Announce(infoHash, port) }
+		go t.lsdAnnouncer.Run(announce, t.session.config.LSDAnnounceInterval, t.session.config.LSDMinAnnounceInterval, t.log)
+	}
 }
 
 func (t *torrent) startNewAnnouncer(tr tracker.Tracker) {
@@ -101,6 +124,7 @@ func (t *torrent) startNewAnnouncer(tr tracker.Tracker) {
 		t.announcerFields,
 		t.completeC,
 		t.addrsFromTrackers,
+		t.handleAnnounceError,
 		t.log,
 	)
 	t.announcers = append(t.announcers, an)
@@ -111,7 +135,11 @@ func (t *torrent) startAcceptor() {
 	if t.acceptor != nil {
 		return
 	}
-	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{Port: t.port})
+	if t.session.config.ProxyOnly {
+		// Incoming connections are not accepted in proxy-only mode.
+		return
+	}
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: t.port})
 	if err != nil {
 		t.log.Warningf("cannot listen port %d: %s", t.port, err)
 	} else {
@@ -121,10 +149,21 @@ func (t *torrent) startAcceptor() {
 		t.acceptor = acceptor.New(listener, t.incomingConnC, t.log)
 		go t.acceptor.Run()
 	}
+	if t.session.config.UTPEnabled && t.utpSocket == nil {
+		socket, err := utp.Listen("udp", &net.UDPAddr{Port: t.port}, t.log)
+		if err != nil {
+			t.log.Warningf("cannot listen utp on port %d: %s", t.port, err)
+			return
+		}
+		t.log.Info("Listening peers on utp://" + socket.Addr().String())
+		t.utpSocket = socket
+		t.utpAcceptor = acceptor.New(socket, t.incomingConnC, t.log)
+		go t.utpAcceptor.Run()
+	}
 }
 
 func (t *torrent) startInfoDownloaders() {
-	if t.info != nil {
+	if t.info != nil || t.pendingInfo != nil {
 		return
 	}
 	for len(t.infoDownloaders)-len(t.infoDownloadersSnubbed) < t.session.config.ParallelMetadataDownloads {
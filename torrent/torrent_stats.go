@@ -1,11 +1,14 @@
 package torrent
 
 import (
+	"path/filepath"
 	"time"
 
 	"github.com/panzarasa/rain/internal/mse"
 	"github.com/panzarasa/rain/internal/peersource"
 	"github.com/panzarasa/rain/internal/stringutil"
+	"github.com/panzarasa/rain/storage/blobstorage"
+	"github.com/panzarasa/rain/storage/filestorage"
 )
 
 // Stats contains statistics about Torrent.
@@ -74,6 +77,8 @@ type Stats struct {
 		DHT int
 		// Peers found via peer exchange.
 		PEX int
+		// Peers found via local service discovery.
+		LSD int
 	}
 	Downloads struct {
 		// Number of active piece downloads.
@@ -108,6 +113,16 @@ type Stats struct {
 		// Uploaded bytes per second.
 		Upload int
 	}
+	// Speed limits of the torrent in KiB/s. Zero means unlimited.
+	// Session-wide limits are applied in addition to these.
+	SpeedLimit struct {
+		Download int64
+		Upload   int64
+	}
+	// Position of the torrent in queue. Torrents with lower positions are started first.
+	QueuePosition int
+	// Directory that the files are downloaded into.
+	DataDir string
 	// Time remaining to complete download. nil value means infinity.
 	ETA *time.Duration
 }
@@ -119,11 +134,25 @@ func (t *torrent) stats() Stats {
 	s.InfoHash = t.infoHash
 	s.Port = t.port
 	s.Status = t.status()
+	if s.Status == Stopped && t.lastError == nil && t.isQueued() {
+		s.Status = Queued
+	}
+	if t.relocating != nil {
+		s.Status = Relocating
+	}
+	switch sto := t.storage.(type) {
+	case *filestorage.FileStorage:
+		s.DataDir = sto.Dest()
+	case *blobstorage.BlobStorage:
+		s.DataDir = filepath.Dir(sto.Path())
+	}
+	s.QueuePosition = t.getQueuePosition()
 	s.Error = t.lastError
 	s.Addresses.Total = t.addrList.Len()
 	s.Addresses.Tracker = t.addrList.LenSource(peersource.Tracker)
 	s.Addresses.DHT = t.addrList.LenSource(peersource.DHT)
 	s.Addresses.PEX = t.addrList.LenSource(peersource.PEX)
+	s.Addresses.LSD = t.addrList.LenSource(peersource.LSD)
 	s.Handshakes.Incoming = len(t.incomingHandshakers)
 	s.Handshakes.Outgoing = len(t.outgoingHandshakers)
 	s.Handshakes.Total = len(t.incomingHandshakers) + len(t.outgoingHandshakers)
@@ -146,6 +175,8 @@ func (t *torrent) stats() Stats {
 	s.Pieces.Checked = t.checkedPieces
 	s.Speed.Download = int(t.downloadSpeed.Rate1())
 	s.Speed.Upload = int(t.uploadSpeed.Rate1())
+	s.SpeedLimit.Download = t.downloadLimiter.Rate() / 1024
+	s.SpeedLimit.Upload = t.uploadLimiter.Rate() / 1024
 
 	if t.info != nil {
 		s.Bytes.Total = t.info.Length
@@ -212,6 +243,20 @@ func (t *torrent) bytesComplete() int64 {
 }
 
 func (t *torrent) getTrackers() []Tracker {
+	if len(t.announcers) == 0 {
+		// Torrent is not running. Trackers are listed with the statistics from the last scrape.
+		trackers := make([]Tracker, len(t.trackers))
+		for i, tr := range t.trackers {
+			trackers[i] = Tracker{URL: tr.URL()}
+			if res, ok := t.session.getScrapeResult(tr.URL(), t.infoHash); ok {
+				trackers[i].Seeders = int(res.Seeders)
+				trackers[i].Leechers = int(res.Leechers)
+				trackers[i].Completed = int(res.Completed)
+				trackers[i].LastScrape = res.Time
+			}
+		}
+		return trackers
+	}
 	trackers := make([]Tracker, len(t.announcers))
 	for i, an := range t.announcers {
 		st := an.Stats()
@@ -227,6 +272,10 @@ func (t *torrent) getTrackers() []Tracker {
 		if st.Error != nil {
 			trackers[i].Error = &AnnounceError{st.Error}
 		}
+		if res, ok := t.session.getScrapeResult(trackers[i].URL, t.infoHash); ok {
+			trackers[i].Completed = int(res.Completed)
+			trackers[i].LastScrape = res.Time
+		}
 	}
 	return trackers
 }
@@ -244,9 +293,20 @@ func (t *torrent) getPeers() []Peer {
 			source = SourcePEX
 		case peersource.Incoming:
 			source = SourceIncoming
+		case peersource.LSD:
+			source = SourceLSD
 		default:
 			panic("unhandled peer source")
 		}
+		var transport PeerTransport
+		switch pe.Transport {
+		case peersource.TCP:
+			transport = TransportTCP
+		case peersource.UTP:
+			transport = TransportUTP
+		default:
+			panic("unhandled peer transport")
+		}
 		p := Peer{
 			ID:                 pe.ID,
 			Client:             pe.Client(),
@@ -262,6 +322,7 @@ func (t *torrent) getPeers() []Peer {
 			EncryptedHandshake: pe.EncryptionCipher != 0,
 			EncryptedStream:    pe.EncryptionCipher == mse.RC4,
 			Source:             source,
+			Transport:          transport,
 			DownloadSpeed:      pe.DownloadSpeed(),
 			UploadSpeed:        pe.UploadSpeed(),
 		}
@@ -20,6 +20,12 @@ const (
 	Seeding
 	// Stopping the torrent. This is the status after Stop() is called. All peers are disconnected and files are closed. A stop event sent to all trackers. After trackers responded the torrent switches into Stopped state.
 	Stopping
+	// Queued indicates that the torrent is started but waiting in queue for other torrents to finish.
+	// Only reported in Stats. Torrent is not running in this state.
+	Queued
+	// Relocating indicates that the files of the torrent are being moved to another directory.
+	// Only reported in Stats. Torrent is not running in this state.
+	Relocating
 )
 
 func (s Status) String() string {
@@ -31,6 +37,8 @@ func (s Status) String() string {
 		Downloading:         "Downloading",
 		Seeding:             "Seeding",
 		Stopping:            "Stopping",
+		Queued:              "Queued",
+		Relocating:          "Relocating",
 	}
 	return m[s]
 }
@@ -18,6 +18,8 @@ func (t *torrent) handleStopped() {
 		t.start()
 	} else {
 		t.log.Info("torrent has stopped")
+		t.publishEvent(EventTorrentStopped)
+		t.session.triggerQueue()
 	}
 }
 
@@ -31,9 +33,13 @@ func (t *torrent) stop(err error) {
 	t.lastError = err
 	if err != nil && err != errClosed {
 		t.log.Error(err)
+		t.session.publishEvent(Event{Type: EventTorrentError, TorrentID: t.id, Error: err.Error()})
+		// Do not restart the failed torrent from queue.
+		t.session.setQueued(t, false)
 	}
 
 	t.stopAcceptor()
+	t.stopPieceLayerDownload()
 	t.stopPeers()
 	t.stopPiecedownloaders()
 	t.stopInfoDownloaders()
@@ -148,6 +154,10 @@ func (t *torrent) stopPeriodicalAnnouncers() {
 		t.dhtAnnouncer.Close()
 		t.dhtAnnouncer = nil
 	}
+	if t.lsdAnnouncer != nil {
+		t.lsdAnnouncer.Close()
+		t.lsdAnnouncer = nil
+	}
 }
 
 func (t *torrent) stopAcceptor() {
@@ -156,6 +166,11 @@ func (t *torrent) stopAcceptor() {
 		t.acceptor.Close()
 	}
 	t.acceptor = nil
+	if t.utpAcceptor != nil {
+		t.utpAcceptor.Close()
+	}
+	t.utpAcceptor = nil
+	t.utpSocket = nil
 }
 
 func (t *torrent) stopPeers() {
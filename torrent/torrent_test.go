@@ -1,6 +1,9 @@
 package torrent
 
 import (
+	"bytes"
+	"encoding/hex"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -13,6 +16,7 @@ import (
 
 	"github.com/cenkalti/log"
 	"github.com/panzarasa/rain/internal/logger"
+	"github.com/panzarasa/rain/internal/metainfo"
 	"github.com/panzarasa/rain/internal/webseedsource"
 	"github.com/fortytw2/leaktest"
 )
@@ -31,6 +35,13 @@ func init() {
 }
 
 func newTestSession(t *testing.T) (*Session, func()) {
+	return newTestSessionWithConfig(t, nil)
+}
+
+// newTestSessionWithConfig creates a Session in a temporary directory with DHT, PEX and RPC disabled.
+// f is called with the config to change it before the Session is created.
+// Returned function closes the Session if it is not closed by the test and removes the temporary directory.
+func newTestSessionWithConfig(t *testing.T, f func(cfg *Config)) (*Session, func()) {
 	tmp, closeTmp := tempdir(t)
 	cfg := DefaultConfig
 	cfg.Database = filepath.Join(tmp, "session.db")
@@ -38,14 +49,22 @@ func newTestSession(t *testing.T) (*Session, func()) {
 	cfg.DHTEnabled = false
 	cfg.PEXEnabled = false
 	cfg.RPCEnabled = false
+	if f != nil {
+		f(&cfg)
+	}
 	s, err := NewSession(cfg)
 	if err != nil {
+		closeTmp()
 		t.Fatal(err)
 	}
 	return s, func() {
-		err := s.Close()
-		if err != nil {
-			t.Fatal(err)
+		select {
+		case <-s.closeC:
+		default:
+			err := s.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
 		}
 		closeTmp()
 	}
@@ -120,6 +139,72 @@ func TestDownloadMagnet(t *testing.T) {
 	assertCompleted(t, tor)
 }
 
+func TestDownloadMagnetV2(t *testing.T) {
+	src, closeSrc := newTestSession(t)
+	defer closeSrc()
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	// File must be larger than a piece to have a piece layer.
+	dir := filepath.Join(src.config.DataDir, "files")
+	err := os.Mkdir(dir, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("rain"), 50*1024)
+	err = ioutil.WriteFile(filepath.Join(dir, "file.bin"), data, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, layers, err := metainfo.NewInfoBytesV2(filepath.Join(dir, "file.bin"), false, 32*1024, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := metainfo.NewBytes(info, layers, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := src.AddTorrent(bytes.NewReader(b), &AddTorrentOptions{DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var port int
+	select {
+	case port = <-seed.torrent.NotifyListen():
+	case <-time.After(timeout):
+		t.Fatal("seeder is not ready")
+	}
+	waitStatus(t, seed, Seeding)
+
+	hash := seed.torrent.info.HashV2
+	link := "magnet:?xt=urn:btmh:1220" + hex.EncodeToString(hash[:]) + "&x.pe=127.0.0.1:" + strconv.Itoa(port)
+	tor, err := s.AddURI(link, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-tor.torrent.NotifyComplete():
+	case err = <-tor.torrent.NotifyError():
+		t.Fatal(err)
+	case <-time.After(timeout):
+		t.Fatal("download did not finish")
+	}
+	got, err := ioutil.ReadFile(filepath.Join(s.config.DataDir, tor.ID(), "file.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, got) {
+		t.Fatal("downloaded file is different")
+	}
+	spec, err := s.resumer.Read(tor.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.PieceLayers) == 0 {
+		t.Fatal("piece layers are not saved")
+	}
+}
+
 func webseed(t *testing.T) (port int, c func()) {
 	l, err := net.Listen("tcp4", "127.0.0.1:0")
 	if err != nil {
@@ -172,6 +257,62 @@ func TestDownloadWebseed(t *testing.T) {
 	assertCompleted(t, tor)
 }
 
+func TestFileReader(t *testing.T) {
+	defer leaktest.Check(t)()
+	addr, cl := seeder(t)
+	defer cl()
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	opt := &AddTorrentOptions{Stopped: true, Sequential: true}
+	tor, err := s.AddTorrent(f, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := tor.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := -1
+	for i, file := range files {
+		if file.Path == filepath.Join(torrentName, "data", "file2.bin") {
+			index = i
+		}
+	}
+	if index == -1 {
+		t.Fatal("file not found in torrent")
+	}
+	r, err := tor.NewFileReader(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	tor.Start()
+	tor.AddPeer(addr)
+
+	expected, err := ioutil.ReadFile(filepath.Join(torrentDataDir, torrentName, "data", "file2.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Seek(10, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, expected[10:]) {
+		t.Fatal("invalid file content")
+	}
+}
+
 func assertCompleted(t *testing.T, tor *Torrent) {
 	t2 := tor.torrent
 	select {
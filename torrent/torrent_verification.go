@@ -8,6 +8,10 @@ import (
 )
 
 func (t *torrent) handleVerifyCommand() {
+	if t.relocating != nil {
+		t.log.Warning("cannot verify while files are being relocated")
+		return
+	}
 	t.log.Info("verifying")
 	t.doVerify = true
 	if t.status() == Stopped {
@@ -33,6 +37,7 @@ func (t *torrent) handleVerificationDone(ve *verifier.Verifier) {
 	t.mBitfield.Lock()
 	t.bitfield = ve.Bitfield
 	t.mBitfield.Unlock()
+	t.notifyPieceWaiters()
 
 	// Save the bitfield to resume db.
 	err := t.writeBitfield()
@@ -52,7 +57,7 @@ func (t *torrent) handleVerificationDone(ve *verifier.Verifier) {
 	}
 
 	// We may detect missing pieces after verification. Then, status must be set from Seeding to Downloading.
-	if !t.bitfield.All() {
+	if !t.haveAllWantedPieces() {
 		t.completed = false
 		t.completeC = make(chan struct{})
 	}
@@ -46,6 +46,8 @@ func (t *torrent) handlePieceWriteDone(pw *piecewriter.PieceWriter) {
 	t.mBitfield.Lock()
 	t.bitfield.Set(pw.Piece.Index)
 	t.mBitfield.Unlock()
+	t.notifyPieceWaiters()
+	t.session.publishEvent(Event{Type: EventPieceVerified, TorrentID: t.id, Piece: pw.Piece.Index})
 
 	if t.piecePicker != nil {
 		_, ok := pw.Source.(*urldownloader.URLDownloader)